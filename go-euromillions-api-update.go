@@ -1,21 +1,51 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
 	"database/sql"
+	_ "embed"
 	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"maps"
 	"math/rand"
+	"mime"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/smtp"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	// Europe/Paris must load on hosts without a zone database (scratch
+	// containers, Windows): the zone data is built into the binary.
+	_ "time/tzdata"
+	"unicode/utf8"
 
+	"github.com/BurntSushi/toml"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -28,24 +58,394 @@ var userAgents = []string{
 	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Safari/537.36 Edg/123.0.2420.81",
 }
 
+// Version metadata, set at build time like those of the server:
+// -ldflags "-X main.version=1.3 -X main.commit=... -X main.buildDate=...".
 var (
+	version   = "1.2"
+	commit    = ""
+	buildDate = ""
+)
+
+var (
+	versionFlag  bool
 	verboseFlag  bool
 	outputFile   string
 	databasePath string
 	siteIDStr    string
+	busyTimeout  time.Duration
+	gameID       string
+	jsonOutput   bool
+	publishDelay time.Duration
+)
+
+// updateCmd fetches the latest draw and stores it. It is the default command.
+var updateCmd = newCommand("update", "Fetch the latest draw and store it in the database (default)")
+
+// verifyCmd compares a stored draw with the archives.
+var verifyCmd = newCommand("verify", "Compare a stored draw with the archives and repair discrepancies")
+
+// backfillCmd fills in missing draws from the yearly archives.
+var backfillCmd = newCommand("backfill", "Insert the draws missing from the database from the yearly archives")
+
+// importFDJCmd imports the Française des Jeux history archives.
+var importFDJCmd = newCommand("import-fdj", "Import EuroMillions history from the FDJ open-data ZIP/CSV archives")
+
+// daemonCmd runs the update periodically, with the watchdog.
+var daemonCmd = newCommand("daemon", "Run the update periodically and alert when a draw is missing")
+
+// mirrorCmd copies the draws of another instance of the API; the server runs
+// it in mirror mode.
+var mirrorCmd = newCommand("mirror", "Copy the draws of an upstream instance of the API from its /sync endpoint")
+
+// importCmd merges the draws of another instance of the API.
+var importCmd = newCommand("import", "Merge the draws of another instance of the API from its JSON results")
+
+// digestCmd sends the weekly or monthly statistics digest to the
+// subscriptions, from cron.
+var digestCmd = newCommand("digest", "Send the weekly or monthly statistics digest to the subscriptions")
+
+// setCmd and deleteCmd edit draws by hand; the server's admin area runs them.
+var setCmd = newCommand("set", "Insert or correct a draw by hand")
+var deleteCmd = newCommand("delete", "Delete a stored draw")
+
+// completionCmd and manCmd are generated from the commands, so they list
+// exactly the commands and flags the binary has.
+var completionCmd = newCommand("completion", "Print a shell completion script: bash, zsh or fish")
+var manCmd = newCommand("man", "Print the manual page (troff)")
+
+// commands are the subcommands of the updater; the first one is the default.
+var commands = []*command{updateCmd, verifyCmd, backfillCmd, importFDJCmd, daemonCmd, mirrorCmd, importCmd, digestCmd, setCmd, deleteCmd, completionCmd, manCmd}
+
+var (
+	updateInterval time.Duration
+	alertAfter     time.Duration
+	alertWebhook   string
+	smtpServer     string
+	smtpUser       string
+	alertFrom      string
+	alertTo        string
+	confirmURL     string
+)
+
+var (
+	// minInterval is the minimum time between two requests to the same host.
+	minInterval time.Duration
+
+	userAgentsFile string
+	honestHosts    string
+	contactURL     string
+
+	pageCacheDir string
+	pageCacheTTL time.Duration
+
+	sitesFile string
+
+	pushgatewayURL string
+
+	spoolDir string
+
+	siteTimeout time.Duration
+
+	sentryDSN string
+	tracker   *sentryClient
+
+	upstreamURL string
+	upstreamKey string
+	fromURL     string
+
+	takeover        bool
+	takeoverTimeout time.Duration
+	lockFile        *os.File
+)
+
+// addLockFlags registers the flags of the commands that take the update lock,
+// see lockUpdates.
+func addLockFlags(c *command) {
+	c.flags.BoolVar(&takeover, "takeover", false, "When another updater is fetching the game, stop it and take its place instead of exiting.")
+	c.flags.DurationVar(&takeoverTimeout, "takeover-timeout", 30*time.Second, "How long --takeover waits for the other updater to stop.")
+}
+
+// addFetchFlags registers the flags that control how the sites are fetched.
+func addFetchFlags(c *command) {
+	c.flags.DurationVar(&siteTimeout, "timeout", 2*time.Minute, "Maximum time spent on one site, including robots.txt and waiting for --min-interval (0 = no limit).")
+	c.flags.DurationVar(&minInterval, "min-interval", 10*time.Second, "Minimum time between two requests to the same host, across runs.")
+	c.flags.StringVar(&userAgentsFile, "user-agents", "", "File with the User-Agents to rotate through, one per line, instead of the built-in list.")
+	c.flags.StringVar(&honestHosts, "honest", "", "Comma-separated hosts (or 'all') that get a User-Agent identifying the updater instead of a browser one.")
+	c.flags.StringVar(&contactURL, "contact-url", "https://github.com/nfcg/Go-EuroMillions-API", "Contact URL sent in the identifying User-Agent.")
+	c.flags.StringVar(&spoolDir, "spool", "", "Directory where valid draws that could not be stored wait for the next run (default: the database path with .spool appended).")
+	c.flags.StringVar(&sitesFile, "sites", "", "TOML file with the site URLs, regular expressions and date formats (default: the built-in sites.toml).")
+	c.flags.StringVar(&pageCacheDir, "page-cache", defaultPageCacheDir(), "Directory where fetched pages are cached.")
+	c.flags.DurationVar(&pageCacheTTL, "page-cache-ttl", 2*time.Minute, "How long a cached page is reused (0 disables the page cache).")
+	c.flags.StringVar(&sentryDSN, "sentry-dsn", os.Getenv("SENTRY_DSN"), "Sentry DSN that failed scrapes are reported to (default: $SENTRY_DSN).")
+}
+
+// addMailFlags registers the SMTP settings, used for the alerts and the
+// email subscriptions.
+func addMailFlags(c *command) {
+	c.flags.StringVar(&smtpServer, "smtp-server", "", "SMTP server (host:port) used to email alerts and notifications.")
+	c.flags.StringVar(&smtpUser, "smtp-user", "", "SMTP user name; the password is read from SMTP_PASSWORD.")
+	c.flags.StringVar(&alertFrom, "alert-from", "", "Sender address of alert and notification emails.")
+	c.flags.StringVar(&confirmURL, "confirm-url", "", "Public URL of the API (e.g., https://api.example.com), for the links that confirm email subscriptions.")
+}
+
+// defaultPageCacheDir returns the page cache directory under the user's cache directory.
+func defaultPageCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "go-euromillions-api", "pages")
+}
+
+// loadUserAgents replaces the rotation list with the User-Agents listed in
+// --user-agents. Blank lines and lines starting with # are skipped.
+func loadUserAgents() error {
+	if userAgentsFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(userAgentsFile)
+	if err != nil {
+		return err
+	}
+	var list []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			list = append(list, line)
+		}
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("no User-Agents in %s", userAgentsFile)
+	}
+	userAgents = list
+	return nil
+}
+
+// userAgentFor returns the User-Agent sent to host: the identifying one when
+// the host is selected with --honest, otherwise a random one from the rotation list.
+func userAgentFor(host string) string {
+	for _, h := range strings.Split(honestHosts, ",") {
+		if h = strings.TrimSpace(h); h == "all" || strings.EqualFold(h, host) {
+			return fmt.Sprintf("%s/%s (+%s)", robotsAgent, version, contactURL)
+		}
+	}
+	return userAgents[rand.Intn(len(userAgents))]
+}
+
+var (
+	backfillYears string
+	dryRun        bool
+	replaceFlag   bool
 )
 
+var (
+	verifyDate string
+	repairFlag bool
+	minAgree   int
+)
+
+var digestPeriod string
+
+var (
+	drawDate    string
+	setNumbers  string
+	setStars    string
+	specialFlag bool
+)
+
+// auditSource is the command being run and auditActor who ran it (--actor),
+// as recorded in the audit log.
+var auditSource, auditActor string
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 
-	flag.StringVar(&databasePath, "database", "", "Path to the SQLite database file.")
-	flag.StringVar(&databasePath, "d", "", "Path to the SQLite database file. (shorthand)")
-	flag.StringVar(&siteIDStr, "site", "", "The site ID to update (1, 2, 3, 4, 5) or 'all' to run all.")
-	flag.StringVar(&siteIDStr, "s", "", "The site ID to update (1, 2, 3, 4, 5) or 'all' to run all. (shorthand)")
-	flag.BoolVar(&verboseFlag, "verbose", false, "Enable verbose logging.")
-	flag.BoolVar(&verboseFlag, "v", false, "Enable verbose logging. (shorthand)")
-	flag.StringVar(&outputFile, "output", "", "Path to a log file. Output is to console by default.")
-	flag.StringVar(&outputFile, "o", "", "Path to a log file. Output is to console by default. (shorthand)")
+	updateCmd.run = cmdUpdate
+	updateCmd.flags.Usage = func() {
+		updateCmd.printHelp()
+		fmt.Println()
+		printCommands(commands)
+	}
+	fs := updateCmd.flags
+	fs.StringVar(&databasePath, "database", "", "Path to the SQLite database file.")
+	updateCmd.alias("database", "d")
+	fs.StringVar(&siteIDStr, "site", "", "The site ID to update (1, 2, 3, 4, 5) or 'all' to run all.")
+	updateCmd.alias("site", "s")
+	fs.BoolVar(&verboseFlag, "verbose", false, "Enable verbose logging.")
+	updateCmd.alias("verbose", "v")
+	fs.BoolVar(&versionFlag, "version", false, "Show the updater version.")
+	updateCmd.alias("version", "V")
+	fs.StringVar(&outputFile, "output", "", "Path to a log file. Output is to console by default.")
+	updateCmd.alias("output", "o")
+	fs.DurationVar(&busyTimeout, "busy-timeout", 5*time.Second, "How long to wait for a locked database before failing.")
+	fs.StringVar(&gameID, "game", "euromillions", "The game to update: euromillions (sites 1-5) or thunderball (site 1).")
+	updateCmd.alias("game", "g")
+	fs.DurationVar(&publishDelay, "publish-delay", 30*time.Minute, "How long after the draw time a new result may be inserted.")
+	fs.BoolVar(&jsonOutput, "json", false, "Print a JSON summary of the run to stdout (logs stay on stderr).")
+	fs.StringVar(&pushgatewayURL, "pushgateway", "", "Prometheus Pushgateway URL that run metrics are pushed to (e.g., http://localhost:9091).")
+	addFetchFlags(updateCmd)
+	addLockFlags(updateCmd)
+	addMailFlags(updateCmd)
+
+	verifyCmd.run = cmdVerify
+	fs = verifyCmd.flags
+	fs.StringVar(&verifyDate, "date", "", "Date of the draw to verify (YYYY-MM-DD).")
+	fs.BoolVar(&repairFlag, "repair", false, "Store the draw the archives agree on when it differs from the stored one.")
+	fs.IntVar(&minAgree, "min-agree", 2, "Number of archives that must agree on a draw before it is trusted.")
+	fs.StringVar(&databasePath, "database", "", "Path to the SQLite database file.")
+	verifyCmd.alias("database", "d")
+	fs.StringVar(&gameID, "game", "euromillions", "The game to verify: euromillions or thunderball.")
+	verifyCmd.alias("game", "g")
+	fs.BoolVar(&verboseFlag, "verbose", false, "Enable verbose logging.")
+	verifyCmd.alias("verbose", "v")
+	fs.StringVar(&outputFile, "output", "", "Path to a log file. Output is to console by default.")
+	verifyCmd.alias("output", "o")
+	fs.DurationVar(&busyTimeout, "busy-timeout", 5*time.Second, "How long to wait for a locked database before failing.")
+	fs.BoolVar(&jsonOutput, "json", false, "Print the report as JSON.")
+	fs.StringVar(&auditActor, "actor", "", "Who requested the repair, recorded in the audit log.")
+	addFetchFlags(verifyCmd)
+
+	backfillCmd.run = cmdBackfill
+	fs = backfillCmd.flags
+	fs.StringVar(&backfillYears, "years", "", "Years to backfill: a year, a range such as 2004-2012, or a comma-separated list (default: every year since the first draw).")
+	fs.BoolVar(&dryRun, "dry-run", false, "Report the missing draws without inserting them.")
+	fs.StringVar(&databasePath, "database", "", "Path to the SQLite database file.")
+	backfillCmd.alias("database", "d")
+	fs.StringVar(&gameID, "game", "euromillions", "The game to backfill (only euromillions has yearly archives).")
+	backfillCmd.alias("game", "g")
+	fs.BoolVar(&verboseFlag, "verbose", false, "Enable verbose logging.")
+	backfillCmd.alias("verbose", "v")
+	fs.StringVar(&outputFile, "output", "", "Path to a log file. Output is to console by default.")
+	backfillCmd.alias("output", "o")
+	fs.DurationVar(&busyTimeout, "busy-timeout", 5*time.Second, "How long to wait for a locked database before failing.")
+	addFetchFlags(backfillCmd)
+
+	importFDJCmd.run = cmdImportFDJ
+	fs = importFDJCmd.flags
+	fs.BoolVar(&replaceFlag, "replace", false, "Overwrite stored draws that differ from the archives.")
+	fs.BoolVar(&dryRun, "dry-run", false, "Report what would change without writing.")
+	fs.StringVar(&databasePath, "database", "", "Path to the SQLite database file.")
+	importFDJCmd.alias("database", "d")
+	fs.BoolVar(&verboseFlag, "verbose", false, "Enable verbose logging.")
+	importFDJCmd.alias("verbose", "v")
+	fs.StringVar(&outputFile, "output", "", "Path to a log file. Output is to console by default.")
+	importFDJCmd.alias("output", "o")
+	fs.DurationVar(&busyTimeout, "busy-timeout", 5*time.Second, "How long to wait for a locked database before failing.")
+	importFDJCmd.flags.Usage = func() {
+		importFDJCmd.printHelp()
+		fmt.Println("\nArguments: the downloaded FDJ .zip or .csv files (e.g., euromillions_202002.zip).")
+	}
+
+	daemonCmd.run = cmdDaemon
+	fs = daemonCmd.flags
+	fs.StringVar(&databasePath, "database", "", "Path to the SQLite database file.")
+	daemonCmd.alias("database", "d")
+	fs.StringVar(&siteIDStr, "site", "all", "The site ID to update (1, 2, 3, 4, 5) or 'all' to run all.")
+	daemonCmd.alias("site", "s")
+	fs.StringVar(&gameID, "game", "euromillions", "The game to update: euromillions (sites 1-5) or thunderball (site 1).")
+	daemonCmd.alias("game", "g")
+	fs.BoolVar(&verboseFlag, "verbose", false, "Enable verbose logging.")
+	daemonCmd.alias("verbose", "v")
+	fs.StringVar(&outputFile, "output", "", "Path to a log file. Output is to console by default.")
+	daemonCmd.alias("output", "o")
+	fs.DurationVar(&busyTimeout, "busy-timeout", 5*time.Second, "How long to wait for a locked database before failing.")
+	fs.DurationVar(&publishDelay, "publish-delay", 30*time.Minute, "How long after the draw time a new result may be inserted.")
+	fs.DurationVar(&updateInterval, "interval", 15*time.Minute, "Time between two update runs.")
+	fs.DurationVar(&alertAfter, "alert-after", 3*time.Hour, "Alert when a draw is still missing this long after its draw time.")
+	fs.StringVar(&alertWebhook, "alert-webhook", "", "URL that alerts are POSTed to as JSON.")
+	fs.StringVar(&alertTo, "alert-email", "", "Comma-separated recipients of alert emails.")
+	addMailFlags(daemonCmd)
+	fs.StringVar(&pushgatewayURL, "pushgateway", "", "Prometheus Pushgateway URL that run metrics are pushed to (e.g., http://localhost:9091).")
+	addFetchFlags(daemonCmd)
+	addLockFlags(daemonCmd)
+
+	setCmd.run = cmdSet
+	fs = setCmd.flags
+	fs.StringVar(&drawDate, "date", "", "Date of the draw (YYYY-MM-DD).")
+	fs.StringVar(&setNumbers, "numbers", "", "Comma-separated numbers, in the order they were drawn if known.")
+	fs.StringVar(&setStars, "stars", "", "Comma-separated stars (the Thunderball for Thunderball).")
+	fs.BoolVar(&specialFlag, "special", false, "Flag the draw as a special draw (Superdraw/event).")
+	fs.StringVar(&databasePath, "database", "", "Path to the SQLite database file.")
+	setCmd.alias("database", "d")
+	fs.StringVar(&gameID, "game", "euromillions", "The game of the draw: euromillions or thunderball.")
+	setCmd.alias("game", "g")
+	fs.BoolVar(&verboseFlag, "verbose", false, "Enable verbose logging.")
+	setCmd.alias("verbose", "v")
+	fs.StringVar(&outputFile, "output", "", "Path to a log file. Output is to console by default.")
+	setCmd.alias("output", "o")
+	fs.DurationVar(&busyTimeout, "busy-timeout", 5*time.Second, "How long to wait for a locked database before failing.")
+	fs.BoolVar(&jsonOutput, "json", false, "Print the outcome as JSON.")
+	fs.StringVar(&auditActor, "actor", "", "Who made the change, recorded in the audit log.")
+
+	mirrorCmd.run = cmdMirror
+	fs = mirrorCmd.flags
+	fs.StringVar(&upstreamURL, "upstream", "", "Base URL of the upstream API, e.g. https://api.example.com.")
+	fs.StringVar(&upstreamKey, "upstream-key", os.Getenv("UPSTREAM_API_KEY"), "API key sent to the upstream in X-API-Key (default $UPSTREAM_API_KEY).")
+	fs.StringVar(&databasePath, "database", "", "Path to the SQLite database file.")
+	mirrorCmd.alias("database", "d")
+	fs.StringVar(&gameID, "game", "euromillions", "The game to mirror: euromillions or thunderball.")
+	mirrorCmd.alias("game", "g")
+	fs.BoolVar(&verboseFlag, "verbose", false, "Enable verbose logging.")
+	mirrorCmd.alias("verbose", "v")
+	fs.StringVar(&outputFile, "output", "", "Path to a log file. Output is to console by default.")
+	mirrorCmd.alias("output", "o")
+	fs.DurationVar(&busyTimeout, "busy-timeout", 5*time.Second, "How long to wait for a locked database before failing.")
+	fs.DurationVar(&siteTimeout, "timeout", time.Minute, "How long each request to the upstream may take.")
+	fs.BoolVar(&jsonOutput, "json", false, "Print a JSON summary of the run to stdout (logs stay on stderr).")
+	addLockFlags(mirrorCmd)
+
+	importCmd.run = cmdImport
+	fs = importCmd.flags
+	fs.StringVar(&fromURL, "from-url", "", "URL of the draws to import, e.g. https://other-instance/results?format=json.")
+	fs.StringVar(&upstreamKey, "upstream-key", os.Getenv("UPSTREAM_API_KEY"), "API key sent to the other instance in X-API-Key (default $UPSTREAM_API_KEY).")
+	fs.BoolVar(&replaceFlag, "replace", false, "Overwrite stored draws that differ from the imported ones.")
+	fs.BoolVar(&dryRun, "dry-run", false, "Report what would change without writing.")
+	fs.StringVar(&databasePath, "database", "", "Path to the SQLite database file.")
+	importCmd.alias("database", "d")
+	fs.StringVar(&gameID, "game", "euromillions", "The game of the imported draws: euromillions or thunderball.")
+	importCmd.alias("game", "g")
+	fs.BoolVar(&verboseFlag, "verbose", false, "Enable verbose logging.")
+	importCmd.alias("verbose", "v")
+	fs.StringVar(&outputFile, "output", "", "Path to a log file. Output is to console by default.")
+	importCmd.alias("output", "o")
+	fs.DurationVar(&busyTimeout, "busy-timeout", 5*time.Second, "How long to wait for a locked database before failing.")
+	fs.DurationVar(&siteTimeout, "timeout", time.Minute, "How long the download may take.")
+	fs.BoolVar(&jsonOutput, "json", false, "Print the report, with the conflicts, as JSON to stdout.")
+
+	deleteCmd.run = cmdDelete
+	fs = deleteCmd.flags
+	fs.StringVar(&drawDate, "date", "", "Date of the draw to delete (YYYY-MM-DD).")
+	fs.StringVar(&databasePath, "database", "", "Path to the SQLite database file.")
+	deleteCmd.alias("database", "d")
+	fs.StringVar(&gameID, "game", "euromillions", "The game of the draw: euromillions or thunderball.")
+	deleteCmd.alias("game", "g")
+	fs.BoolVar(&verboseFlag, "verbose", false, "Enable verbose logging.")
+	deleteCmd.alias("verbose", "v")
+	fs.StringVar(&outputFile, "output", "", "Path to a log file. Output is to console by default.")
+	deleteCmd.alias("output", "o")
+	fs.DurationVar(&busyTimeout, "busy-timeout", 5*time.Second, "How long to wait for a locked database before failing.")
+	fs.BoolVar(&jsonOutput, "json", false, "Print the outcome as JSON.")
+	fs.StringVar(&auditActor, "actor", "", "Who made the change, recorded in the audit log.")
+
+	digestCmd.run = cmdDigest
+	fs = digestCmd.flags
+	fs.StringVar(&digestPeriod, "period", "weekly", "weekly (the 7 days before today) or monthly (the previous calendar month).")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the digest without sending it.")
+	fs.StringVar(&databasePath, "database", "", "Path to the SQLite database file.")
+	digestCmd.alias("database", "d")
+	fs.StringVar(&gameID, "game", "euromillions", "The game of the digest: euromillions or thunderball.")
+	digestCmd.alias("game", "g")
+	fs.BoolVar(&verboseFlag, "verbose", false, "Enable verbose logging.")
+	digestCmd.alias("verbose", "v")
+	fs.StringVar(&outputFile, "output", "", "Path to a log file. Output is to console by default.")
+	digestCmd.alias("output", "o")
+	fs.DurationVar(&busyTimeout, "busy-timeout", 5*time.Second, "How long to wait for a locked database before failing.")
+	addMailFlags(digestCmd)
+
+	completionCmd.run = runCompletion
+	completionCmd.flags.Usage = printCompletionHelp
+	completionCmd.args = shells
+	manCmd.run = runMan
 }
 
 func getBetween(s, start, end string) string {
@@ -61,324 +461,4038 @@ func getBetween(s, start, end string) string {
 	return s[initialPos : initialPos+endPos]
 }
 
-func getWebPage(url string) (string, error) {
+func getWebPage(ctx context.Context, url string) (string, error) {
 	if verboseFlag {
 		log.Printf("Fetching URL: %s", url)
 	}
+	return fetchURL(ctx, url, map[string]string{"Referer": "https://www.bing.com/?cc=pt"})
+}
+
+func getCSV(ctx context.Context, url string) (string, error) {
+	if verboseFlag {
+		log.Printf("Fetching CSV from URL: %s", url)
+	}
+	return fetchURL(ctx, url, nil)
+}
+
+// robotsAgent is the product token matched against the User-agent lines of robots.txt,
+// besides the "*" group.
+const robotsAgent = "go-euromillions-api"
+
+// fetchLog is the database where the time of the last request to each host is kept,
+// so the minimum interval holds across runs. It is set by setup.
+var fetchLog *sql.DB
 
-	client := &http.Client{Timeout: 120 * time.Second}
-	req, err := http.NewRequest("GET", url, nil)
+// fetchURL downloads a page with the given extra headers. It refuses paths that
+// the site's robots.txt disallows and waits until --min-interval (or the site's
+// Crawl-delay, if longer) has passed since the last request to the same host.
+func fetchURL(ctx context.Context, rawURL string, headers map[string]string) (string, error) {
+	u, err := url.Parse(rawURL)
 	if err != nil {
 		return "", err
 	}
 
-	randomUserAgent := userAgents[rand.Intn(len(userAgents))]
-	req.Header.Set("User-Agent", randomUserAgent)
-	req.Header.Set("Referer", "https://www.bing.com/?cc=pt")
+	if body, ok := cachedPage(rawURL); ok {
+		if verboseFlag {
+			log.Printf("Using cached copy of %s", rawURL)
+		}
+		return body, nil
+	}
 
-	resp, err := client.Do(req)
+	rules, err := robotsFor(ctx, u)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
+	if !rules.allowed(u.EscapedPath()) {
+		return "", fmt.Errorf("%s is disallowed by %s/robots.txt", u.Path, u.Host)
+	}
+	if err := waitForHost(ctx, u.Host, max(minInterval, rules.crawlDelay)); err != nil {
+		return "", err
+	}
+
+	body, status, err := httpGet(ctx, rawURL, headers)
+	if err == nil && status == http.StatusOK {
+		storePage(rawURL, body)
+	}
+	return body, err
+}
+
+// pageCachePath returns the file caching the page at rawURL, or "" when the
+// page cache is disabled.
+func pageCachePath(rawURL string) string {
+	if pageCacheTTL <= 0 || pageCacheDir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(pageCacheDir, hex.EncodeToString(sum[:]))
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+// cachedPage returns the cached copy of the page at rawURL if it is younger than --page-cache-ttl.
+func cachedPage(rawURL string) (string, bool) {
+	path := pageCachePath(rawURL)
+	if path == "" {
+		return "", false
+	}
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > pageCacheTTL {
+		return "", false
+	}
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return "", err
+		return "", false
 	}
-	return string(body), nil
+	return string(data), true
 }
 
-func getCSV(url string) (string, error) {
-	if verboseFlag {
-		log.Printf("Fetching CSV from URL: %s", url)
+// storePage writes the page at rawURL to the page cache. Failures are only logged.
+func storePage(rawURL, body string) {
+	path := pageCachePath(rawURL)
+	if path == "" {
+		return
 	}
+	if err := os.MkdirAll(pageCacheDir, 0755); err != nil {
+		log.Printf("Failed to create the page cache: %v", err)
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(body), 0644); err != nil {
+		log.Printf("Failed to cache %s: %v", rawURL, err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("Failed to cache %s: %v", rawURL, err)
+	}
+}
 
-	client := &http.Client{Timeout: 120 * time.Second}
-	req, err := http.NewRequest("GET", url, nil)
+// httpGet sends a GET request with the User-Agent chosen for the host and returns
+// the body and the status code.
+func httpGet(ctx context.Context, rawURL string, headers map[string]string) (string, int, error) {
+	client := &http.Client{}
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
-	randomUserAgent := userAgents[rand.Intn(len(userAgents))]
-	req.Header.Set("User-Agent", randomUserAgent)
+	req.Header.Set("User-Agent", userAgentFor(req.URL.Host))
+	// Asking for an encoding explicitly turns off the transport's transparent
+	// gzip handling, so decodeBody deals with both encodings.
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := decodeBody(resp)
 	if err != nil {
-		return "", err
+		return "", resp.StatusCode, err
 	}
-	return string(body), nil
+	return body, resp.StatusCode, nil
 }
 
-func runUpdate(db *sql.DB, siteID int) error {
-	var (
-		url     string
-		newDate string
-		numbers []string
-		err     error
-	)
-
-	log.Printf("Executing option for Site ID: %d", siteID)
-	
-	var oldDate string
-	err = db.QueryRow("SELECT date FROM results ORDER BY date DESC LIMIT 1").Scan(&oldDate)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("database query error: %v", err)
+// decodeBody reads a response body, uncompressing gzip and deflate bodies and
+// converting ISO-8859-1 and Windows-1252 text to UTF-8, so the scrapers'
+// regular expressions always see UTF-8.
+func decodeBody(resp *http.Response) (string, error) {
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
 	}
 
-	if verboseFlag {
-		log.Printf("Last date in database for this run: %s", oldDate)
-	}
-	
-	switch siteID {
-	case 1:
-		url = "https://www.euromilhoes.com/"
-		var response string
-		response, err = getWebPage(url)
+	var r io.ReadCloser
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(raw))
+	case "deflate":
+		// "deflate" is meant to be zlib-wrapped, but some servers send raw deflate.
+		r, err = zlib.NewReader(bytes.NewReader(raw))
 		if err != nil {
-			return fmt.Errorf("failed to fetch page: %v", err)
+			r, err = flate.NewReader(bytes.NewReader(raw)), nil
 		}
-		full := getBetween(response, "last-results-container", "selector-wrapper")
-		dataStr := getBetween(full, "<span>", "</span>")
-		var t time.Time
-		t, err = time.Parse("02.01.2006", dataStr)
-		if err != nil {
-			return fmt.Errorf("date parsing error: %v", err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to uncompress body: %v", err)
+	}
+	if r != nil {
+		defer r.Close()
+		if raw, err = ioutil.ReadAll(r); err != nil {
+			return "", fmt.Errorf("failed to uncompress body: %v", err)
 		}
-		newDate = t.Format("2006-01-02")
-		numFull := getBetween(full, `<ul class="results">`, `</ul>`)
-		re := regexp.MustCompile(`>(\d+)<`)
-		matches := re.FindAllStringSubmatch(numFull, -1)
-		for _, match := range matches {
-			numbers = append(numbers, match[1])
+	}
+
+	switch bodyCharset(resp.Header.Get("Content-Type"), raw) {
+	case "iso-8859-1", "latin1", "windows-1252", "cp1252", "us-ascii":
+		return windows1252ToUTF8(raw), nil
+	default:
+		if !utf8.Valid(raw) {
+			// Undeclared legacy encoding: Windows-1252 is the usual culprit.
+			return windows1252ToUTF8(raw), nil
 		}
-	case 2:
-		url = "https://www.euro-millions.com/results"
-		var response string
-		response, err = getWebPage(url)
-		if err != nil {
-			return fmt.Errorf("failed to fetch page: %v", err)
+		return string(raw), nil
+	}
+}
+
+// metaCharset finds the charset declared in an HTML <meta> tag.
+var metaCharset = regexp.MustCompile(`(?i)<meta[^>]+charset=["']?([\w-]+)`)
+
+// bodyCharset returns the lower-case charset of a body, from the Content-Type
+// header or else from the HTML <meta> tags.
+func bodyCharset(contentType string, body []byte) string {
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		return strings.ToLower(params["charset"])
+	}
+	head := body[:min(len(body), 2048)]
+	if m := metaCharset.FindSubmatch(head); m != nil {
+		return strings.ToLower(string(m[1]))
+	}
+	return ""
+}
+
+// windows1252High maps the bytes 0x80-0x9F of Windows-1252 to Unicode. The other
+// bytes have the same value as in ISO-8859-1, which Windows-1252 extends.
+var windows1252High = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// windows1252ToUTF8 converts Windows-1252 (and so ISO-8859-1) text to UTF-8.
+func windows1252ToUTF8(b []byte) string {
+	var sb strings.Builder
+	sb.Grow(len(b))
+	for _, c := range b {
+		switch {
+		case c < 0x80:
+			sb.WriteByte(c)
+		case c < 0xA0:
+			sb.WriteRune(windows1252High[c-0x80])
+		default:
+			sb.WriteRune(rune(c))
 		}
-		full := getBetween(response, `<ul class="balls">`, `</ul>`)
-		dataStr := getBetween(response, `<li><a href="/results/`, `"`)
-		var t time.Time
-		t, err = time.Parse("02-01-2006", dataStr)
-		if err != nil {
-			return fmt.Errorf("date parsing error: %v", err)
+	}
+	return sb.String()
+}
+
+// waitForHost sleeps until interval has passed since the last request to host,
+// then records the current time as the last request. It returns early with the
+// context's error when ctx is done.
+func waitForHost(ctx context.Context, host string, interval time.Duration) error {
+	if fetchLog == nil {
+		return nil
+	}
+	var last string
+	err := fetchLog.QueryRowContext(ctx, "SELECT last_fetch FROM fetch_log WHERE host = ?", host).Scan(&last)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to read the last request time of %s: %v", host, err)
+	}
+	if t, err := time.Parse(time.RFC3339Nano, last); err == nil {
+		if wait := time.Until(t.Add(interval)); wait > 0 {
+			if verboseFlag {
+				log.Printf("Waiting %s before the next request to %s", wait.Round(time.Second), host)
+			}
+			if err := sleep(ctx, wait); err != nil {
+				return err
+			}
 		}
-		newDate = t.Format("2006-01-02")
-		re := regexp.MustCompile(`>(\d+)<`)
-		matches := re.FindAllStringSubmatch(full, -1)
-		for _, match := range matches {
-			numbers = append(numbers, match[1])
+	}
+	_, err = fetchLog.ExecContext(ctx, "INSERT INTO fetch_log (host, last_fetch) VALUES (?, ?) ON CONFLICT(host) DO UPDATE SET last_fetch = excluded.last_fetch",
+		host, time.Now().UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		log.Printf("Failed to record the request time of %s: %v", host, err)
+	}
+	return nil
+}
+
+// withTimeout bounds the work on one site with --timeout.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if siteTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, siteTimeout)
+}
+
+// sleep waits for d, or until ctx is done, in which case it returns the context's error.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// robotsRules are the rules of the robots.txt group that applies to the updater.
+type robotsRules struct {
+	allow      []string
+	disallow   []string
+	crawlDelay time.Duration
+}
+
+// allowed reports whether path may be fetched: the longest matching rule wins,
+// and Allow wins a tie.
+func (r *robotsRules) allowed(path string) bool {
+	longest := func(rules []string) int {
+		n := -1
+		for _, rule := range rules {
+			if robotsMatch(rule, path) && len(rule) > n {
+				n = len(rule)
+			}
 		}
-	case 3:
-		url = "https://www.jogossantacasa.pt/web/SCCartazResult/"
-		response, err := getWebPage(url)
-		if err != nil {
-			return fmt.Errorf("failed to fetch page: %v", err)
+		return n
+	}
+	return longest(r.allow) >= longest(r.disallow)
+}
+
+// robotsMatch matches a robots.txt path pattern, with "*" wildcards and a
+// trailing "$" anchor, against path.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for _, part := range parts[1:] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
 		}
+		rest = rest[i+len(part):]
+	}
+	return !anchored || rest == "" || strings.HasSuffix(pattern, "*")
+}
 
-		dateRegex := regexp.MustCompile(`Data do Sorteio - (\d{2}\/\d{2}\/\d{4})`)
-		dateMatches := dateRegex.FindStringSubmatch(response)
-		if len(dateMatches) < 2 {
-			return fmt.Errorf("could not find the date in the page content")
+// parseRobots returns the rules of the group for robotsAgent, or of the "*"
+// group when there is none.
+func parseRobots(body string) *robotsRules {
+	var own, wildcard *robotsRules
+	var current []*robotsRules
+	inRules := false
+	for _, line := range strings.Split(body, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
 		}
-		dataStr := dateMatches[1]
-		
-		var t time.Time
-		t, err = time.Parse("02/01/2006", dataStr)
-		if err != nil {
-			return fmt.Errorf("error parsing date from website: %v", err)
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if key == "user-agent" {
+			// Consecutive User-agent lines share the rules that follow them.
+			if inRules {
+				current, inRules = nil, false
+			}
+			rules := &robotsRules{}
+			current = append(current, rules)
+			switch agent := strings.ToLower(value); {
+			case agent == "*":
+				wildcard = rules
+			case strings.Contains(robotsAgent, agent):
+				own = rules
+			}
+			continue
+		}
+		inRules = true
+		for _, rules := range current {
+			switch key {
+			case "allow":
+				if value != "" {
+					rules.allow = append(rules.allow, value)
+				}
+			case "disallow":
+				if value != "" {
+					rules.disallow = append(rules.disallow, value)
+				}
+			case "crawl-delay":
+				if secs, err := strconv.ParseFloat(value, 64); err == nil {
+					rules.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
 		}
-		newDate = t.Format("2006-01-02")
+	}
+	switch {
+	case own != nil:
+		return own
+	case wildcard != nil:
+		return wildcard
+	default:
+		return &robotsRules{}
+	}
+}
 
-		numRegex := regexp.MustCompile(`<li>(\d{1,2})\s+(\d{1,2})\s+(\d{1,2})\s+(\d{1,2})\s+(\d{1,2})\s+\+\s+(\d{1,2})\s+(\d{1,2})`)
-		numMatches := numRegex.FindAllStringSubmatch(response, -1)
+var (
+	robotsMu    sync.Mutex
+	robotsCache = map[string]*robotsRules{}
+)
 
-		if len(numMatches) < 1 || len(numMatches[0]) != 8 {
-			return fmt.Errorf("expected 7 numbers, found %d", len(numMatches))
-		}
+// robotsFor returns the robots.txt rules of the URL's host, fetched once per run.
+// A missing robots.txt allows everything; an unreachable one blocks the host.
+func robotsFor(ctx context.Context, u *url.URL) (*robotsRules, error) {
+	robotsMu.Lock()
+	defer robotsMu.Unlock()
+	if rules, ok := robotsCache[u.Host]; ok {
+		return rules, nil
+	}
 
-		for i := 1; i <= 7; i++ {
-			numbers = append(numbers, numMatches[0][i])
+	robotsURL := u.Scheme + "://" + u.Host + "/robots.txt"
+	if verboseFlag {
+		log.Printf("Fetching %s", robotsURL)
+	}
+	body, status, err := httpGet(ctx, robotsURL, nil)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("failed to fetch %s: %v", robotsURL, err)
+	case status >= 500:
+		return nil, fmt.Errorf("failed to fetch %s: status %d", robotsURL, status)
+	case status >= 400:
+		body = ""
+	}
+	rules := parseRobots(body)
+	robotsCache[u.Host] = rules
+	return rules, nil
+}
+
+// specialDrawKeywords are the announcements sources use for Superdraws and other event draws.
+var specialDrawKeywords = []string{"superdraw", "super draw", "event draw", "super sorteio", "super jackpot", "super tirage"}
+
+// isSpecialDraw reports whether a scraped page section announces a special draw.
+// It is only given the markup of the draw itself: a whole page also carries
+// promotions of upcoming Superdraws and links to past ones.
+func isSpecialDraw(content string) bool {
+	content = strings.ToLower(content)
+	for _, keyword := range specialDrawKeywords {
+		if strings.Contains(content, keyword) {
+			return true
 		}
-		
-	case 4:
-		url = "https://www.euromilhoes.com/"
-		response, err := getWebPage(url)
+	}
+	return false
+}
+
+// migrations are the schema changes applied on top of the original 'results' table,
+// in order. The applied level is tracked with PRAGMA user_version.
+// Keep this list identical to the one in go-euromillions-api.go: both tools migrate on startup.
+var migrations = []string{
+	// 1: flag Superdraws and other special event draws.
+	"ALTER TABLE results ADD COLUMN special INTEGER NOT NULL DEFAULT 0",
+	// 2: Thunderball draws (5 numbers + 1 Thunderball stored as star_1).
+	`CREATE TABLE IF NOT EXISTS results_thunderball (
+		date TEXT PRIMARY KEY,
+		number_1 INTEGER NOT NULL, number_2 INTEGER NOT NULL, number_3 INTEGER NOT NULL,
+		number_4 INTEGER NOT NULL, number_5 INTEGER NOT NULL,
+		star_1 INTEGER NOT NULL,
+		special INTEGER NOT NULL DEFAULT 0
+	)`,
+	// 3, 4: order in which the balls were drawn, when the source publishes it.
+	"ALTER TABLE results ADD COLUMN drawn_order TEXT",
+	"ALTER TABLE results_thunderball ADD COLUMN drawn_order TEXT",
+	// 5: time of the updater's last request to each host, for rate limiting.
+	"CREATE TABLE IF NOT EXISTS fetch_log (host TEXT PRIMARY KEY, last_fetch TEXT NOT NULL)",
+	// 6: the original 'results' table has no key; every lookup is by date.
+	// (results_thunderball is indexed by its primary key.)
+	"CREATE INDEX IF NOT EXISTS results_date ON results (date)",
+	// 7-12: year and month of each draw, for indexed year and month queries.
+	// The updater sets them on insert; existing rows are filled in here.
+	"ALTER TABLE results ADD COLUMN year INTEGER",
+	"ALTER TABLE results ADD COLUMN month INTEGER",
+	"UPDATE results SET year = CAST(substr(date, 1, 4) AS INTEGER), month = CAST(substr(date, 6, 2) AS INTEGER)",
+	"CREATE INDEX IF NOT EXISTS results_year_month ON results (year, month, date)",
+	"ALTER TABLE results_thunderball ADD COLUMN year INTEGER",
+	"ALTER TABLE results_thunderball ADD COLUMN month INTEGER",
+	"UPDATE results_thunderball SET year = CAST(substr(date, 1, 4) AS INTEGER), month = CAST(substr(date, 6, 2) AS INTEGER)",
+	"CREATE INDEX IF NOT EXISTS results_thunderball_year_month ON results_thunderball (year, month, date)",
+	// 13-18: statistics of every game, kept up to date by the updater:
+	// how many draws each ball (kind 'number' or 'star') appeared in and when
+	// it was last drawn, and how many draws each pair of numbers appeared in.
+	`CREATE TABLE IF NOT EXISTS stats_balls (
+		game TEXT NOT NULL, kind TEXT NOT NULL, ball INTEGER NOT NULL,
+		draws INTEGER NOT NULL, last_seen TEXT NOT NULL,
+		PRIMARY KEY (game, kind, ball)
+	)`,
+	`CREATE TABLE IF NOT EXISTS stats_pairs (
+		game TEXT NOT NULL, first INTEGER NOT NULL, second INTEGER NOT NULL,
+		draws INTEGER NOT NULL,
+		PRIMARY KEY (game, first, second)
+	)`,
+	`INSERT INTO stats_balls (game, kind, ball, draws, last_seen)
+	SELECT 'euromillions', kind, ball, COUNT(*), MAX(date) FROM (
+		SELECT date, 'number' AS kind, number_1 AS ball FROM results UNION ALL
+		SELECT date, 'number', number_2 FROM results UNION ALL
+		SELECT date, 'number', number_3 FROM results UNION ALL
+		SELECT date, 'number', number_4 FROM results UNION ALL
+		SELECT date, 'number', number_5 FROM results UNION ALL
+		SELECT date, 'star', star_1 FROM results UNION ALL
+		SELECT date, 'star', star_2 FROM results
+	) GROUP BY kind, ball`,
+	`WITH balls (date, ball) AS (
+		SELECT date, number_1 FROM results UNION ALL
+		SELECT date, number_2 FROM results UNION ALL
+		SELECT date, number_3 FROM results UNION ALL
+		SELECT date, number_4 FROM results UNION ALL
+		SELECT date, number_5 FROM results
+	)
+	INSERT INTO stats_pairs (game, first, second, draws)
+	SELECT 'euromillions', a.ball, b.ball, COUNT(*) FROM balls a JOIN balls b ON a.date = b.date AND a.ball < b.ball
+	GROUP BY a.ball, b.ball`,
+	`INSERT INTO stats_balls (game, kind, ball, draws, last_seen)
+	SELECT 'thunderball', kind, ball, COUNT(*), MAX(date) FROM (
+		SELECT date, 'number' AS kind, number_1 AS ball FROM results_thunderball UNION ALL
+		SELECT date, 'number', number_2 FROM results_thunderball UNION ALL
+		SELECT date, 'number', number_3 FROM results_thunderball UNION ALL
+		SELECT date, 'number', number_4 FROM results_thunderball UNION ALL
+		SELECT date, 'number', number_5 FROM results_thunderball UNION ALL
+		SELECT date, 'star', star_1 FROM results_thunderball
+	) GROUP BY kind, ball`,
+	`WITH balls (date, ball) AS (
+		SELECT date, number_1 FROM results_thunderball UNION ALL
+		SELECT date, number_2 FROM results_thunderball UNION ALL
+		SELECT date, number_3 FROM results_thunderball UNION ALL
+		SELECT date, number_4 FROM results_thunderball UNION ALL
+		SELECT date, number_5 FROM results_thunderball
+	)
+	INSERT INTO stats_pairs (game, first, second, draws)
+	SELECT 'thunderball', a.ball, b.ball, COUNT(*) FROM balls a JOIN balls b ON a.date = b.date AND a.ball < b.ball
+	GROUP BY a.ball, b.ball`,
+	// 19, 20: API keys of the server's --auth=apikey mode (only a SHA-256
+	// hash of each key is stored) and their requests per UTC day.
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY, key_hash TEXT NOT NULL UNIQUE, name TEXT NOT NULL,
+		daily_quota INTEGER NOT NULL DEFAULT 0, created TEXT NOT NULL, revoked TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_key_usage (
+		key_id TEXT NOT NULL, day TEXT NOT NULL, requests INTEGER NOT NULL,
+		PRIMARY KEY (key_id, day)
+	)`,
+	// 21, 22: outcome of every site fetched by the updater, for the admin area.
+	`CREATE TABLE IF NOT EXISTS scrape_log (
+		time TEXT NOT NULL, game TEXT NOT NULL, source INTEGER NOT NULL,
+		draw_date TEXT, inserted INTEGER NOT NULL DEFAULT 0, error TEXT
+	)`,
+	"CREATE INDEX IF NOT EXISTS scrape_log_time ON scrape_log (time)",
+	// 23: every change to the draws: action insert, update or delete, the
+	// updater command (source) and who ran it (actor), and the draw before
+	// and after as JSON.
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY, time TEXT NOT NULL, game TEXT NOT NULL, date TEXT NOT NULL,
+		action TEXT NOT NULL, source TEXT NOT NULL, actor TEXT, before TEXT, after TEXT
+	)`,
+	// 24-26: earlier values of corrected and deleted draws (the current value
+	// stays in the game's table), with when they were superseded and by what
+	// (update or delete). Corrections already in the audit log are kept.
+	`CREATE TABLE IF NOT EXISTS results_history (
+		id INTEGER PRIMARY KEY, game TEXT NOT NULL, date TEXT NOT NULL,
+		draw TEXT NOT NULL, superseded TEXT NOT NULL, reason TEXT NOT NULL
+	)`,
+	"CREATE INDEX IF NOT EXISTS results_history_date ON results_history (game, date)",
+	`INSERT INTO results_history (game, date, draw, superseded, reason)
+	SELECT game, date, before, time, action FROM audit_log
+	WHERE action IN ('update', 'delete') AND before IS NOT NULL AND before IS NOT after
+	ORDER BY id`,
+	// 27-34: revision of the whole dataset, bumped by triggers on every row
+	// inserted, updated or deleted in the games' tables, whoever writes it.
+	`CREATE TABLE IF NOT EXISTS dataset_revision (
+		id INTEGER PRIMARY KEY CHECK (id = 1), revision INTEGER NOT NULL, updated TEXT NOT NULL
+	)`,
+	"INSERT OR IGNORE INTO dataset_revision (id, revision, updated) VALUES (1, 1, strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))",
+	`CREATE TRIGGER IF NOT EXISTS results_revision_insert AFTER INSERT ON results BEGIN
+		UPDATE dataset_revision SET revision = revision + 1, updated = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS results_revision_update AFTER UPDATE ON results BEGIN
+		UPDATE dataset_revision SET revision = revision + 1, updated = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS results_revision_delete AFTER DELETE ON results BEGIN
+		UPDATE dataset_revision SET revision = revision + 1, updated = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS results_thunderball_revision_insert AFTER INSERT ON results_thunderball BEGIN
+		UPDATE dataset_revision SET revision = revision + 1, updated = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS results_thunderball_revision_update AFTER UPDATE ON results_thunderball BEGIN
+		UPDATE dataset_revision SET revision = revision + 1, updated = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS results_thunderball_revision_delete AFTER DELETE ON results_thunderball BEGIN
+		UPDATE dataset_revision SET revision = revision + 1, updated = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+	END`,
+	// 35, 36: notification subscriptions of the API keys (owner): a channel
+	// (webhook, ntfy or email) and its target, the events (draw, win) and,
+	// for win, the line checked. The updater notifies them of new draws.
+	`CREATE TABLE IF NOT EXISTS subscriptions (
+		id TEXT PRIMARY KEY, owner TEXT NOT NULL, game TEXT NOT NULL,
+		channel TEXT NOT NULL, target TEXT NOT NULL, events TEXT NOT NULL,
+		numbers TEXT, stars TEXT, created TEXT NOT NULL
+	)`,
+	"CREATE INDEX IF NOT EXISTS subscriptions_owner ON subscriptions (owner)",
+	// 37, 38: admins' annotations of draws, e.g. a correction or a Superdraw.
+	`CREATE TABLE IF NOT EXISTS notes (
+		id INTEGER PRIMARY KEY, game TEXT NOT NULL, date TEXT NOT NULL,
+		text TEXT NOT NULL, created TEXT NOT NULL
+	)`,
+	"CREATE INDEX IF NOT EXISTS notes_game_date ON notes (game, date)",
+	// 39: the dataset version of the upstream each game was last mirrored from.
+	`CREATE TABLE IF NOT EXISTS mirror_state (
+		game TEXT PRIMARY KEY, upstream TEXT NOT NULL, version TEXT NOT NULL,
+		synced TEXT NOT NULL
+	)`,
+	// 40-44: double opt-in of email subscriptions: the token of the link the
+	// updater emails to the address, when it sent it and when the link was
+	// followed. Only confirmed subscriptions are notified; the other channels
+	// need no confirmation.
+	"ALTER TABLE subscriptions ADD COLUMN confirm_token TEXT",
+	"ALTER TABLE subscriptions ADD COLUMN confirm_sent TEXT",
+	"ALTER TABLE subscriptions ADD COLUMN confirmed TEXT",
+	"UPDATE subscriptions SET confirmed = created WHERE channel <> 'email'",
+	"UPDATE subscriptions SET confirm_token = lower(hex(randomblob(16))) WHERE channel = 'email'",
+}
+
+// migrateDB applies the pending migrations, each one in its own transaction.
+func migrateDB(db *sql.DB) error {
+	var level int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&level); err != nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+
+	for i := level; i < len(migrations); i++ {
+		tx, err := db.Begin()
 		if err != nil {
-			return fmt.Errorf("failed to fetch page: %v", err)
+			return err
 		}
-
-		dateSection := getBetween(response, `<section class="last-results">`, `</section>`)
-		if verboseFlag {
-			log.Printf("Raw HTML snippet for date search: %s", dateSection)
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %v", i+1, err)
 		}
-		dateRegex := regexp.MustCompile(`<span>(\d{2}\.\d{2}\.\d{4})</span>`)
-		dateMatches := dateRegex.FindStringSubmatch(dateSection)
-		
-		if len(dateMatches) < 2 {
-			return fmt.Errorf("could not find the date in the page content")
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error updating schema version: %v", err)
 		}
-		dataStr := dateMatches[1]
-		var t time.Time
-		t, err = time.Parse("02.01.2006", dataStr)
-		if err != nil {
-			return fmt.Errorf("date parsing error: %v", err)
+		if err := tx.Commit(); err != nil {
+			return err
 		}
-		newDate = t.Format("2006-01-02")
+		log.Printf("Applied database migration %d", i+1)
+	}
+	return nil
+}
 
-		numSection := getBetween(response, `<ul class="results">`, `</ul>`)
-		if numSection == "" {
-			return fmt.Errorf("could not find the numbers section")
-		}
+// drawHour is the local hour (Europe/Paris) at which EuroMillions draws take place.
+const drawHour = 21
 
-		if verboseFlag {
-			log.Printf("Raw HTML snippet for numbers search: %s", numSection)
-		}
+// drawLocation is the time zone of the draw, loaded in main.
+var drawLocation *time.Location
 
-		numRegex := regexp.MustCompile(`>(\d+)<`)
-		matches := numRegex.FindAllStringSubmatch(numSection, -1)
-		
-		if verboseFlag {
-			log.Printf("Numbers found by regex: %v", matches)
-		}
+// drawTime returns the timestamp of the draw held on the given YYYY-MM-DD date.
+func drawTime(date string) (time.Time, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, drawLocation)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.Add(drawHour * time.Hour), nil
+}
 
-		if len(matches) < 7 {
-			return fmt.Errorf("invalid number of results for insertion. Expected 7, got: %d", len(matches))
-		}
-		for _, match := range matches {
-			numbers = append(numbers, match[1])
-		}
+// nationalLotteryDate is the date layout of the UK National Lottery draw-history CSVs.
+const nationalLotteryDate = "02-Jan-2006"
 
-	case 5:
-		url = "https://www.national-lottery.co.uk/results/euromillions/draw-history/csv"
-		csvData, err := getCSV(url)
-		if err != nil {
-			return fmt.Errorf("failed to fetch CSV: %v", err)
-		}
+// readNationalLotteryCSV reads all the draws of a UK National Lottery draw-history CSV, latest first.
+// The first column is the draw date (in the dateFormat layout), followed by count ball columns
+// (main numbers, then stars/bonus balls).
+func readNationalLotteryCSV(ctx context.Context, url string, count int, dateFormat string) ([]scrapedDraw, error) {
+	csvData, err := getCSV(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch CSV: %v", err)
+	}
 
-		r := csv.NewReader(strings.NewReader(csvData))
-		
-		_, err = r.Read()
-		if err != nil {
-			return fmt.Errorf("failed to read CSV header: %v", err)
-		}
+	r := csv.NewReader(strings.NewReader(csvData))
+
+	_, err = r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
 
+	var draws []scrapedDraw
+	for {
 		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			if err == io.EOF {
-				return fmt.Errorf("no data found in CSV")
-			}
-			return fmt.Errorf("failed to read CSV record: %v", err)
+			return nil, fmt.Errorf("failed to read CSV record: %v", err)
 		}
 
-		if len(record) < 8 {
-			return fmt.Errorf("invalid CSV format. Expected at least 8 columns, got %d", len(record))
+		if len(record) < count+1 {
+			return nil, fmt.Errorf("invalid CSV format. Expected at least %d columns, got %d", count+1, len(record))
 		}
 
-		var t time.Time
-		t, err = time.Parse("02-Jan-2006", record[0])
+		t, err := time.Parse(dateFormat, record[0])
 		if err != nil {
-			return fmt.Errorf("date parsing error: %v", err)
+			return nil, fmt.Errorf("date parsing error: %v", err)
 		}
-		newDate = t.Format("2006-01-02")
+		d := scrapedDraw{Date: t.Format("2006-01-02")}
 
-		numbers = []string{
-			record[1], // Ball 1
-			record[2], // Ball 2
-			record[3], // Ball 3
-			record[4], // Ball 4
-			record[5], // Ball 5
-			record[6], // Lucky Star 1
-			record[7], // Lucky Star 2
-		}
+		// Balls follow the date column: main numbers first, then the stars/bonus balls.
+		d.Numbers = record[1 : count+1]
 
-		for i, num := range numbers {
+		for i, num := range d.Numbers {
 			if _, err := strconv.Atoi(num); err != nil {
-				return fmt.Errorf("invalid number at position %d: %s", i+1, num)
+				return nil, fmt.Errorf("invalid number at position %d: %s", i+1, num)
 			}
 		}
-
-	default:
-		return fmt.Errorf("unsupported site ID: %d", siteID)
+		draws = append(draws, d)
 	}
-
-	if newDate == oldDate {
-		log.Printf("Exiting. The date is the same: %s", newDate)
-		return nil
+	if len(draws) == 0 {
+		return nil, fmt.Errorf("no data found in CSV")
 	}
-	if newDate > oldDate {
-		log.Printf("OK. New date: %s", newDate)
-		log.Printf("Numbers: %s", strings.Join(numbers, ", "))
 
-		if len(numbers) != 7 {
-			return fmt.Errorf("invalid number of results for insertion. Expected 7, got: %d", len(numbers))
-		}
+	return draws, nil
+}
 
-		stmt, err := db.Prepare("INSERT INTO results (date, number_1, number_2, number_3, number_4, number_5, star_1, star_2) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+// nationalLotteryArchive returns an archive that looks a date up in a draw-history CSV.
+// The CSV only covers the last months of draws.
+func nationalLotteryArchive(url string, count int) func(ctx context.Context, date string) (scrapedDraw, error) {
+	return func(ctx context.Context, date string) (scrapedDraw, error) {
+		draws, err := readNationalLotteryCSV(ctx, url, count, nationalLotteryDate)
 		if err != nil {
-			return fmt.Errorf("failed to prepare SQL statement: %v", err)
+			return scrapedDraw{}, err
 		}
-		defer stmt.Close()
-
-		_, err = stmt.Exec(newDate, numbers[0], numbers[1], numbers[2], numbers[3], numbers[4], numbers[5], numbers[6])
-		if err != nil {
-			return fmt.Errorf("failed to execute SQL statement: %v", err)
+		for _, d := range draws {
+			if d.Date == date {
+				return d, nil
+			}
 		}
-		log.Println("Data inserted successfully.")
-	} else {
-		log.Println("Exiting. The old date is more recent than the new one.")
+		return scrapedDraw{}, fmt.Errorf("no draw on %s in the CSV", date)
 	}
-	
-	return nil
 }
 
-func main() {
-	flag.Parse()
+// historyDrawLink marks each draw in the euro-millions.com yearly history pages.
+var historyDrawLink = regexp.MustCompile(`href="/results/(\d{2}-\d{2}-\d{4})"`)
+
+// fetchEuroMillionsHistory reads all the EuroMillions draws of a year from the
+// euro-millions.com history page of that year, oldest first.
+func fetchEuroMillionsHistory(ctx context.Context, year int) ([]scrapedDraw, error) {
+	response, err := getWebPage(ctx, fmt.Sprintf("https://www.euro-millions.com/results-history-%d", year))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch page: %v", err)
+	}
+
+	// Each draw row starts with a link to the draw's page, followed by its balls.
+	links := historyDrawLink.FindAllStringSubmatchIndex(response, -1)
+	seen := map[string]bool{}
+	ballRe := regexp.MustCompile(`>(\d+)<`)
+	var draws []scrapedDraw
+	for i, link := range links {
+		end := len(response)
+		if i+1 < len(links) {
+			end = links[i+1][0]
+		}
+		row := response[link[0]:end]
+		balls := getBetween(row, `<ul class="balls`, `</ul>`)
+		if balls == "" {
+			continue
+		}
+		if i+1 == len(links) {
+			// The last row runs to the end of the page: stop it after its balls.
+			start := strings.Index(row, `<ul class="balls`)
+			row = row[:start+strings.Index(row[start:], `</ul>`)]
+		}
+
+		t, err := time.Parse("02-01-2006", response[link[2]:link[3]])
+		if err != nil {
+			return nil, fmt.Errorf("date parsing error: %v", err)
+		}
+		d := scrapedDraw{Date: t.Format("2006-01-02"), Special: isSpecialDraw(row)}
+		if seen[d.Date] || t.Year() != year {
+			continue
+		}
+		seen[d.Date] = true
+		for _, match := range ballRe.FindAllStringSubmatch(balls, -1) {
+			d.Numbers = append(d.Numbers, match[1])
+		}
+		draws = append(draws, d)
+	}
+	if len(draws) == 0 {
+		return nil, fmt.Errorf("no draws found on the %d history page", year)
+	}
+	slices.SortFunc(draws, func(a, b scrapedDraw) int { return strings.Compare(a.Date, b.Date) })
+	return draws, nil
+}
+
+// fetchEuroMillionsArchive reads the EuroMillions draw of a date from the results page
+// euro-millions.com keeps for every draw.
+func fetchEuroMillionsArchive(ctx context.Context, date string) (scrapedDraw, error) {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return scrapedDraw{}, err
+	}
+	response, err := getWebPage(ctx, "https://www.euro-millions.com/results/"+t.Format("02-01-2006"))
+	if err != nil {
+		return scrapedDraw{}, fmt.Errorf("failed to fetch page: %v", err)
+	}
+	full := getBetween(response, `<ul class="balls">`, `</ul>`)
+	if full == "" {
+		return scrapedDraw{}, fmt.Errorf("no draw on %s on the page", date)
+	}
+	// The heading names the draw, e.g. "EuroMillions Superdraw Results".
+	d := scrapedDraw{Date: date, Special: isSpecialDraw(getBetween(response, "<h1", "</h1>"))}
+	re := regexp.MustCompile(`>(\d+)<`)
+	for _, match := range re.FindAllStringSubmatch(full, -1) {
+		d.Numbers = append(d.Numbers, match[1])
+	}
+	return d, nil
+}
+
+// game describes a lottery game handled by the updater. Each game keeps its draws in its
+// own table with the same shape as 'results' (see the games list in go-euromillions-api.go).
+type game struct {
+	id      string
+	name    string
+	table   string
+	numbers int
+	stars   int
+	// maxNumber and maxStar are the highest numbers and stars.
+	maxNumber int
+	maxStar   int
+
+	// sites are the sources of the latest draw, from the site configuration.
+	sites []*siteConfig
+
+	// archives look up the draw of a given date, for the verify command.
+	archives []archive
+
+	// history returns all the draws of a year, for the backfill command,
+	// and firstYear is the year of the first draw.
+	history   func(ctx context.Context, year int) ([]scrapedDraw, error)
+	firstYear int
+
+	// drawDays are the weekdays the game is drawn on, for the watchdog.
+	drawDays []time.Weekday
+
+	// tiers are the prize tiers as matched numbers and stars, best first.
+	tiers [][2]int
+}
+
+// archive is a source that can look up past draws by date.
+type archive struct {
+	name  string
+	fetch func(ctx context.Context, date string) (scrapedDraw, error)
+}
+
+// games lists the games the updater can scrape.
+var games = []*game{
+	{id: "euromillions", name: "EuroMillions", table: "results", numbers: 5, stars: 2, maxNumber: 50, maxStar: 12,
+		drawDays: []time.Weekday{time.Tuesday, time.Friday},
+		tiers:    [][2]int{{5, 2}, {5, 1}, {5, 0}, {4, 2}, {4, 1}, {3, 2}, {4, 0}, {2, 2}, {3, 1}, {3, 0}, {1, 2}, {2, 1}, {2, 0}},
+		history:  fetchEuroMillionsHistory, firstYear: 2004,
+		archives: []archive{
+			{name: "euro-millions.com", fetch: fetchEuroMillionsArchive},
+			{name: "national-lottery.co.uk", fetch: nationalLotteryArchive("https://www.national-lottery.co.uk/results/euromillions/draw-history/csv", 7)},
+		}},
+	{id: "thunderball", name: "Thunderball", table: "results_thunderball", numbers: 5, stars: 1, maxNumber: 39, maxStar: 14,
+		drawDays: []time.Weekday{time.Tuesday, time.Wednesday, time.Friday, time.Saturday},
+		tiers:    [][2]int{{5, 1}, {5, 0}, {4, 1}, {4, 0}, {3, 1}, {3, 0}, {2, 1}, {1, 1}, {0, 1}},
+		archives: []archive{
+			{name: "national-lottery.co.uk", fetch: nationalLotteryArchive("https://www.national-lottery.co.uk/results/thunderball/draw-history/csv", 6)},
+		}},
+}
+
+// findGame returns the game with the given ID, or nil.
+func findGame(id string) *game {
+	for _, g := range games {
+		if g.id == id {
+			return g
+		}
+	}
+	return nil
+}
+
+// defaultSites is the built-in site configuration.
+//
+//go:embed sites.toml
+var defaultSites string
+
+// siteConfig describes how the latest draw is read from a site. See sites.toml.
+type siteConfig struct {
+	Game string `toml:"game"`
+	ID   int    `toml:"id"`
+	Type string `toml:"type"`
+	URL  string `toml:"url"`
+
+	DateStart  string `toml:"date_start"`
+	DateEnd    string `toml:"date_end"`
+	DateRegex  string `toml:"date_regex"`
+	DateFormat string `toml:"date_format"`
+
+	NumbersStart string `toml:"numbers_start"`
+	NumbersEnd   string `toml:"numbers_end"`
+	NumbersRegex string `toml:"numbers_regex"`
+
+	SpecialStart string `toml:"special_start"`
+	SpecialEnd   string `toml:"special_end"`
+
+	dateRe    *regexp.Regexp
+	numbersRe *regexp.Regexp
+}
+
+// loadSites reads the site configuration, from --sites or the built-in one,
+// and attaches each site to its game.
+func loadSites() error {
+	data := defaultSites
+	if sitesFile != "" {
+		b, err := os.ReadFile(sitesFile)
+		if err != nil {
+			return err
+		}
+		data = string(b)
+	}
+
+	var cfg struct {
+		Site []*siteConfig `toml:"site"`
+	}
+	md, err := toml.Decode(data, &cfg)
+	if err != nil {
+		return err
+	}
+	if keys := md.Undecoded(); len(keys) > 0 {
+		return fmt.Errorf("unknown setting %s", keys[0])
+	}
+
+	for _, g := range games {
+		g.sites = nil
+	}
+	for _, s := range cfg.Site {
+		g := findGame(s.Game)
+		if g == nil {
+			return fmt.Errorf("site %d: unknown game %q", s.ID, s.Game)
+		}
+		if g.site(s.ID) != nil {
+			return fmt.Errorf("site %d of %s is defined twice", s.ID, s.Game)
+		}
+		switch s.Type {
+		case "", "html":
+			if s.dateRe, err = regexp.Compile(s.DateRegex); err != nil {
+				return fmt.Errorf("site %d of %s: date_regex: %v", s.ID, s.Game, err)
+			}
+			if s.dateRe.NumSubexp() < 1 {
+				return fmt.Errorf("site %d of %s: date_regex needs a capture group", s.ID, s.Game)
+			}
+			if s.numbersRe, err = regexp.Compile(s.NumbersRegex); err != nil {
+				return fmt.Errorf("site %d of %s: numbers_regex: %v", s.ID, s.Game, err)
+			}
+			if s.numbersRe.NumSubexp() < 1 {
+				return fmt.Errorf("site %d of %s: numbers_regex needs a capture group", s.ID, s.Game)
+			}
+		case "csv":
+		default:
+			return fmt.Errorf("site %d of %s: unknown type %q", s.ID, s.Game, s.Type)
+		}
+		g.sites = append(g.sites, s)
+	}
+	return nil
+}
+
+// site returns the site with the given ID, or nil.
+func (g *game) site(id int) *siteConfig {
+	for _, s := range g.sites {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// siteIDList returns the IDs of the game's sites.
+func (g *game) siteIDList() []int {
+	var ids []int
+	for _, s := range g.sites {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+// fetch reads the latest draw of the game from one of its sites.
+func (g *game) fetch(ctx context.Context, siteID int) (scrapedDraw, error) {
+	s := g.site(siteID)
+	if s == nil {
+		return scrapedDraw{}, fmt.Errorf("unsupported site ID: %d", siteID)
+	}
+	if s.Type == "csv" {
+		draws, err := readNationalLotteryCSV(ctx, s.URL, g.numbers+g.stars, s.DateFormat)
+		if err != nil {
+			return scrapedDraw{}, err
+		}
+		return draws[0], nil
+	}
+	return s.scrape(ctx)
+}
+
+// within returns the text of page between start and end, or the whole page
+// when no start marker is set.
+func within(page, start, end string) string {
+	if start == "" {
+		return page
+	}
+	return getBetween(page, start, end)
+}
+
+// scrape reads the latest draw from an HTML site.
+func (s *siteConfig) scrape(ctx context.Context) (scrapedDraw, error) {
+	var d scrapedDraw
+
+	response, err := getWebPage(ctx, s.URL)
+	if err != nil {
+		return d, fmt.Errorf("failed to fetch page: %v", err)
+	}
+
+	// Without a special section the draw is not flagged: the rest of the page
+	// announces other draws too.
+	if s.SpecialStart != "" {
+		d.Special = isSpecialDraw(getBetween(response, s.SpecialStart, s.SpecialEnd))
+	}
+
+	dateSection := within(response, s.DateStart, s.DateEnd)
+	if verboseFlag {
+		log.Printf("Raw HTML snippet for date search: %s", dateSection)
+	}
+	dateMatches := s.dateRe.FindStringSubmatch(dateSection)
+	if dateMatches == nil {
+		return d, fmt.Errorf("could not find the date in the page content")
+	}
+	t, err := time.Parse(s.DateFormat, dateMatches[1])
+	if err != nil {
+		return d, fmt.Errorf("date parsing error: %v", err)
+	}
+	d.Date = t.Format("2006-01-02")
+
+	numSection := within(response, s.NumbersStart, s.NumbersEnd)
+	if numSection == "" {
+		return d, fmt.Errorf("could not find the numbers section")
+	}
+	if verboseFlag {
+		log.Printf("Raw HTML snippet for numbers search: %s", numSection)
+	}
+	matches := s.numbersRe.FindAllStringSubmatch(numSection, -1)
+	if verboseFlag {
+		log.Printf("Numbers found by regex: %v", matches)
+	}
+	if len(matches) == 0 {
+		return d, fmt.Errorf("could not find the numbers in the page content")
+	}
+	if s.numbersRe.NumSubexp() > 1 {
+		// One match holds all the balls.
+		d.Numbers = matches[0][1:]
+	} else {
+		for _, match := range matches {
+			d.Numbers = append(d.Numbers, match[1])
+		}
+	}
+
+	return d, nil
+}
+
+// scrapedDraw is a draw as read from a source, before validation and insertion.
+type scrapedDraw struct {
+	Date    string
+	Numbers []string
+	Special bool
+}
+
+// Exit codes of the update command, for cron wrappers and monitoring.
+const (
+	exitUpToDate   = 0  // no newer draw was found
+	exitInserted   = 1  // a new draw was inserted
+	exitScrape     = 2  // the site could not be fetched or parsed
+	exitValidation = 3  // the fetched draw failed validation
+	exitDB         = 4  // the database could not be read or written
+	exitUsage      = 64 // invalid flags or configuration
+	exitLocked     = 75 // another updater is already fetching the game
+)
+
+// updateError is an update failure together with the exit code it maps to.
+type updateError struct {
+	code int
+	err  error
+}
+
+func (e *updateError) Error() string { return e.err.Error() }
+func (e *updateError) Unwrap() error { return e.err }
+
+// failure returns an updateError with the given exit code and message.
+func failure(code int, format string, args ...any) error {
+	return &updateError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// exitCode returns the exit code for an update error. Errors that were not
+// classified are treated as scrape failures.
+func exitCode(err error) int {
+	var ue *updateError
+	if errors.As(err, &ue) {
+		return ue.code
+	}
+	return exitScrape
+}
+
+// versionString formats the version with the commit and build date, taken
+// from the VCS stamp of go build when they were not set with -ldflags.
+func versionString() string {
+	rev, date := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && rev == "":
+				rev = s.Value[:min(len(s.Value), 12)]
+			case s.Key == "vcs.time" && date == "":
+				date = s.Value
+			}
+		}
+	}
+	s := "v" + version
+	if rev != "" {
+		s += " (commit " + rev
+		if date != "" {
+			s += ", built " + date
+		}
+		s += ")"
+	} else if date != "" {
+		s += " (built " + date + ")"
+	}
+	return s
+}
+
+// fatal logs the message and exits with the given code.
+func fatal(code int, format string, args ...any) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+// ballColumns returns the number and star columns of the game's table.
+func (g *game) ballColumns() []string {
+	var columns []string
+	for i := 1; i <= g.numbers; i++ {
+		columns = append(columns, fmt.Sprintf("number_%d", i))
+	}
+	for i := 1; i <= g.stars; i++ {
+		columns = append(columns, fmt.Sprintf("star_%d", i))
+	}
+	return columns
+}
+
+// normalizeBalls parses the scraped balls and sorts the numbers and the stars
+// in ascending order. When the source listed them in another order, which is
+// the order they were drawn in, that order is returned as a comma-separated
+// list; otherwise drawnOrder is nil.
+func (g *game) normalizeBalls(scraped []string) (balls []int, drawnOrder any, err error) {
+	balls = make([]int, len(scraped))
+	for i, s := range scraped {
+		balls[i], err = strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%q is not a number", s)
+		}
+	}
+	original := slices.Clone(balls)
+	slices.Sort(balls[:g.numbers])
+	slices.Sort(balls[g.numbers:])
+	if !slices.Equal(balls, original) {
+		parts := make([]string, len(original))
+		for i, n := range original {
+			parts[i] = strconv.Itoa(n)
+		}
+		drawnOrder = strings.Join(parts, ",")
+	}
+	return balls, drawnOrder, nil
+}
+
+// sameDraw reports whether two draws have the same numbers and stars, in any
+// order and regardless of leading zeros.
+func (g *game) sameDraw(a, b []string) bool {
+	norm := func(balls []string) []int {
+		out := make([]int, len(balls))
+		for i, s := range balls {
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				return nil
+			}
+			out[i] = n
+		}
+		slices.Sort(out[:g.numbers])
+		slices.Sort(out[g.numbers:])
+		return out
+	}
+	x, y := norm(a), norm(b)
+	return x != nil && y != nil && slices.Equal(x, y)
+}
+
+// siteRun is the outcome of fetching one site, as printed by --json.
+type siteRun struct {
+	Source   int      `json:"source"`
+	Date     string   `json:"date,omitempty"`
+	Numbers  []string `json:"numbers,omitempty"`
+	Special  bool     `json:"special"`
+	Inserted bool     `json:"inserted"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// updateSummary is the JSON summary of an update run.
+type updateSummary struct {
+	Game     string    `json:"game"`
+	Inserted bool      `json:"inserted"`
+	Runs     []siteRun `json:"runs"`
+}
+
+// runUpdate fetches the latest draw of g from a site and inserts it when it is
+// newer than the latest stored draw. run records what was fetched and whether it
+// was inserted.
+func runUpdate(ctx context.Context, db *sql.DB, g *game, siteID int, run *siteRun) error {
+	log.Printf("Executing option for %s Site ID: %d", g.name, siteID)
+	run.Source = siteID
+
+	var oldDate string
+	oldBalls := make([]string, g.numbers+g.stars)
+	dest := []any{&oldDate}
+	for i := range oldBalls {
+		dest = append(dest, &oldBalls[i])
+	}
+	err := db.QueryRowContext(ctx, "SELECT date, "+strings.Join(g.ballColumns(), ", ")+" FROM "+g.table+" ORDER BY date DESC LIMIT 1").Scan(dest...)
+	if err != nil && err != sql.ErrNoRows {
+		return failure(exitDB, "database query error: %v", err)
+	}
+
+	if verboseFlag {
+		log.Printf("Last date in database for this run: %s", oldDate)
+	}
+
+	d, err := g.fetch(ctx, siteID)
+	if err != nil {
+		return &updateError{code: exitScrape, err: err}
+	}
+	newDate, numbers, special := d.Date, d.Numbers, d.Special
+	run.Date, run.Numbers, run.Special = newDate, numbers, special
+
+	newTime, err := drawTime(newDate)
+	if err != nil {
+		return failure(exitValidation, "invalid draw date %q: %v", newDate, err)
+	}
+	// An empty database has no previous draw; the zero time is older than any draw.
+	var oldTime time.Time
+	if oldDate != "" {
+		oldTime, err = drawTime(oldDate)
+		if err != nil {
+			return failure(exitDB, "invalid date in database %q: %v", oldDate, err)
+		}
+	}
+
+	if newTime.Equal(oldTime) {
+		log.Printf("Exiting. The date is the same: %s", newDate)
+		return nil
+	}
+	if newTime.After(oldTime) {
+		log.Printf("OK. New date: %s", newDate)
+		log.Printf("Numbers: %s", strings.Join(numbers, ", "))
+		if special {
+			log.Println("Draw is flagged as a special draw (Superdraw/event).")
+		}
+
+		if len(numbers) != g.numbers+g.stars {
+			return failure(exitValidation, "invalid number of results for insertion. Expected %d, got: %d", g.numbers+g.stars, len(numbers))
+		}
+
+		// Some sites show the new date before the draw with the previous
+		// numbers still in place. Nothing is inserted before the results can
+		// have been published, and a "new" draw repeating the previous one is
+		// treated as placeholder data.
+		if publishAt := newTime.Add(publishDelay); time.Now().Before(publishAt) {
+			return failure(exitValidation, "site %d reports the %s draw before its publication at %s; ignoring possible placeholder data", siteID, newDate, publishAt.Format(time.RFC3339))
+		}
+		if oldDate != "" && g.sameDraw(numbers, oldBalls) {
+			return failure(exitValidation, "site %d reports the %s draw with the numbers of the %s draw; ignoring placeholder data", siteID, newDate, oldDate)
+		}
+
+		balls, drawnOrder, err := g.normalizeBalls(numbers)
+		if err != nil {
+			return failure(exitValidation, "invalid numbers for insertion: %v", err)
+		}
+
+		if err := storeDraws(ctx, db, g, []pendingDraw{{date: newDate, balls: balls, special: special, drawnOrder: drawnOrder}}, nil); err != nil {
+			// The draw is valid: keep it for the next run rather than
+			// scraping the site again.
+			entry := spoolEntry{Game: g.id, Date: newDate, Balls: balls, Special: special, DrawnOrder: drawnOrder, Source: siteID}
+			if serr := spoolDraw(entry); serr != nil {
+				log.Printf("Failed to spool the %s draw: %v", newDate, serr)
+			} else {
+				log.Printf("Spooled the %s draw for the next run", newDate)
+			}
+			return failure(exitDB, "failed to execute SQL statement: %v", err)
+		}
+		log.Println("Data inserted successfully.")
+		run.Inserted = true
+	} else {
+		log.Println("Exiting. The old date is more recent than the new one.")
+	}
+	
+	return nil
+}
+
+func main() {
+	runCommand(commands, os.Args[1:])
+}
+
+// command is a subcommand of the tool with its own flag set and help.
+type command struct {
+	name    string
+	summary string
+	flags   *flag.FlagSet
+	short   map[string]string // long flag name -> one-letter shorthand
+	aliases map[string]bool   // flag names hidden from the help
+	args    []string          // the arguments offered by the shell completion
+	run     func(args []string)
+}
+
+func newCommand(name, summary string) *command {
+	c := &command{
+		name:    name,
+		summary: summary,
+		flags:   flag.NewFlagSet(name, flag.ContinueOnError),
+		short:   map[string]string{},
+		aliases: map[string]bool{},
+	}
+	c.flags.Usage = c.printHelp
+	return c
+}
+
+// alias registers another name for the flag long. One-letter aliases are
+// listed as its shorthand.
+func (c *command) alias(long, name string) {
+	f := c.flags.Lookup(long)
+	c.flags.Var(f.Value, name, f.Usage)
+	c.aliases[name] = true
+	if len(name) == 1 {
+		c.short[long] = name
+	}
+}
+
+// printHelp is the default help of a command: its summary and its flags.
+func (c *command) printHelp() {
+	fmt.Printf("%s\n\nUsage:\n  %s %s [options]\n\nOptions:\n", c.summary, filepath.Base(os.Args[0]), c.name)
+	c.printFlags()
+}
+
+// printFlags lists the flags of the command as "-d, --database string".
+func (c *command) printFlags() {
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if c.aliases[f.Name] {
+			return
+		}
+		name := "    --" + f.Name
+		if s, ok := c.short[f.Name]; ok {
+			name = "-" + s + ", --" + f.Name
+		}
+		typ, usage := flag.UnquoteUsage(f)
+		if typ != "" {
+			name += " " + typ
+		}
+		switch f.DefValue {
+		case "", "false", "0", "0s":
+		default:
+			usage += fmt.Sprintf(" (default %s)", f.DefValue)
+		}
+		fmt.Printf("  %-34s %s\n", name, usage)
+	})
+	fmt.Printf("  %-34s %s\n", "-h, --help", "Show this help message")
+}
+
+// isBool reports whether the flag name is a boolean flag of the command.
+func (c *command) isBool(name string) bool {
+	f := c.flags.Lookup(name)
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// expandShorthands splits grouped one-letter flags such as -vV into -v -V.
+// Every letter but the last must be a boolean flag; the last one may take a
+// value from the next argument. Arguments after "--" are left untouched.
+func (c *command) expandShorthands(args []string) []string {
+	var out []string
+	for i, arg := range args {
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		if len(arg) < 3 || arg[0] != '-' || arg[1] == '-' || strings.Contains(arg, "=") || c.flags.Lookup(arg[1:]) != nil {
+			out = append(out, arg)
+			continue
+		}
+		letters := arg[1:]
+		group := true
+		for j := range letters {
+			name := letters[j : j+1]
+			if c.flags.Lookup(name) == nil || (j < len(letters)-1 && !c.isBool(name)) {
+				group = false
+				break
+			}
+		}
+		if !group {
+			out = append(out, arg)
+			continue
+		}
+		for j := range letters {
+			out = append(out, "-"+letters[j:j+1])
+		}
+	}
+	return out
+}
+
+// runCommand runs the command named by the first argument, or the first
+// command when the arguments start with a flag. "help [command]" shows the
+// help of a command.
+func runCommand(commands []*command, args []string) {
+	c := commands[0]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name := args[0]
+		args = args[1:]
+		if name == "help" {
+			if len(args) > 0 {
+				name = args[0]
+			} else {
+				name = c.name
+			}
+			args = []string{"--help"}
+		}
+		c = nil
+		for _, cmd := range commands {
+			if cmd.name == name {
+				c = cmd
+			}
+		}
+		if c == nil {
+			fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", name)
+			printCommands(commands)
+			os.Exit(exitUsage)
+		}
+	}
+	if err := c.flags.Parse(c.expandShorthands(args)); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(exitUsage)
+	}
+	auditSource = c.name
+	c.run(c.flags.Args())
+}
+
+// printCommands lists the available commands.
+func printCommands(commands []*command) {
+	fmt.Println("Commands:")
+	for _, c := range commands {
+		fmt.Printf("  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Printf("  %-12s %s\n", "help", "Show the help of a command (e.g., help "+commands[0].name+")")
+}
+
+// shells are the shells the completion command writes scripts for.
+var shells = []string{"bash", "zsh", "fish"}
+
+// printCompletionHelp is the help of the completion command.
+func printCompletionHelp() {
+	prog := filepath.Base(os.Args[0])
+	fmt.Printf("%s\n\nUsage:\n  %s completion %s\n\n", completionCmd.summary, prog, strings.Join(shells, "|"))
+	fmt.Println("To load the completions:")
+	fmt.Printf("  bash: source <(%s completion bash)\n", prog)
+	fmt.Printf("  zsh:  %s completion zsh > \"${fpath[1]}/_%s\"\n", prog, prog)
+	fmt.Printf("  fish: %s completion fish > ~/.config/fish/completions/%s.fish\n", prog, prog)
+}
+
+// runCompletion prints the completion script of a shell, generated from the
+// commands and their flags.
+func runCompletion(args []string) {
+	if len(args) != 1 {
+		printCompletionHelp()
+		os.Exit(exitUsage)
+	}
+	prog := filepath.Base(os.Args[0])
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion(prog, commands))
+	case "zsh":
+		fmt.Print(zshCompletion(prog, commands))
+	case "fish":
+		fmt.Print(fishCompletion(prog, commands))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown shell: %s (use %s)\n", args[0], strings.Join(shells, ", "))
+		os.Exit(exitUsage)
+	}
+}
+
+// visibleFlags returns the flags of the command listed in its help, without
+// the aliases.
+func (c *command) visibleFlags() []*flag.Flag {
+	var flags []*flag.Flag
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if !c.aliases[f.Name] {
+			flags = append(flags, f)
+		}
+	})
+	return flags
+}
+
+// options returns the spellings of the flags of the command, --long and -s.
+func (c *command) options() []string {
+	var opts []string
+	for _, f := range c.visibleFlags() {
+		opts = append(opts, "--"+f.Name)
+		if s, ok := c.short[f.Name]; ok {
+			opts = append(opts, "-"+s)
+		}
+	}
+	return opts
+}
+
+// completionFunc is the name of the shell function completing prog.
+func completionFunc(prog string) string {
+	return "_" + strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, prog)
+}
+
+// bashCompletion returns the bash completion script of prog.
+func bashCompletion(prog string, commands []*command) string {
+	var names, valueOpts []string
+	for _, c := range commands {
+		names = append(names, c.name)
+		for _, f := range c.visibleFlags() {
+			if !c.isBool(f.Name) {
+				valueOpts = append(valueOpts, "--"+f.Name)
+				if s, ok := c.short[f.Name]; ok {
+					valueOpts = append(valueOpts, "-"+s)
+				}
+			}
+		}
+	}
+	slices.Sort(valueOpts)
+	valueOpts = slices.Compact(valueOpts)
+
+	fn := completionFunc(prog)
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s, generated by \"%s completion bash\".\n", prog, prog)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("    local cur prev cmd words i\n")
+	b.WriteString("    cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	if len(valueOpts) > 0 {
+		// The value of a flag: fall back to file names.
+		fmt.Fprintf(&b, "    case \"$prev\" in\n    %s) return ;;\n    esac\n", strings.Join(valueOpts, "|"))
+	}
+	b.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	fmt.Fprintf(&b, "        case \"${COMP_WORDS[i]}\" in\n        %s|help) cmd=\"${COMP_WORDS[i]}\"; break ;;\n        esac\n", strings.Join(names, "|"))
+	b.WriteString("    done\n")
+	b.WriteString("    case \"$cmd\" in\n")
+	fmt.Fprintf(&b, "    \"\")\n        words=\"%s\"\n", strings.Join(commands[0].options(), " "))
+	fmt.Fprintf(&b, "        [[ $cur == -* ]] || words=\"%s help\" ;;\n", strings.Join(names, " "))
+	fmt.Fprintf(&b, "    help) words=\"%s\" ;;\n", strings.Join(names, " "))
+	for _, c := range commands {
+		words := append(c.options(), c.args...)
+		fmt.Fprintf(&b, "    %s) words=\"%s\" ;;\n", c.name, strings.Join(words, " "))
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("    COMPREPLY=($(compgen -W \"$words --help\" -- \"$cur\"))\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -o default -F %s %s\n", fn, prog)
+	return b.String()
+}
+
+// zshQuote quotes s for a single-quoted zsh word; in an _arguments spec the
+// brackets and colons are escaped too.
+func zshQuote(s string, spec bool) string {
+	if spec {
+		s = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+	}
+	return strings.ReplaceAll(s, "'", `'\''`)
+}
+
+// zshCompletion returns the zsh completion script of prog.
+func zshCompletion(prog string, commands []*command) string {
+	fn := completionFunc(prog)
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n\n", prog)
+	fmt.Fprintf(&b, "# zsh completion for %s, generated by \"%s completion zsh\".\n", prog, prog)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("    local -a commands\n    commands=(\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "        '%s:%s'\n", c.name, zshQuote(c.summary, false))
+	}
+	b.WriteString("        'help:Show the help of a command'\n    )\n")
+	b.WriteString("    if (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then\n")
+	b.WriteString("        _describe -t commands command commands\n        return\n    fi\n")
+	fmt.Fprintf(&b, "    local cmd=%s\n", commands[0].name)
+	b.WriteString("    if [[ $words[2] != -* ]]; then\n        cmd=$words[2]\n        shift words\n        (( CURRENT-- ))\n    fi\n")
+	b.WriteString("    case $cmd in\n")
+	b.WriteString("    help)\n        _describe -t commands command commands ;;\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "    %s)\n        _arguments \\\n", c.name)
+		for _, f := range c.visibleFlags() {
+			spec := "'--" + f.Name
+			if s, ok := c.short[f.Name]; ok {
+				spec = "'(-" + s + " --" + f.Name + ")'{-" + s + ",--" + f.Name + "}'"
+			}
+			_, usage := flag.UnquoteUsage(f)
+			spec += "[" + zshQuote(usage, true) + "]"
+			if !c.isBool(f.Name) {
+				spec += ":value:_files"
+			}
+			fmt.Fprintf(&b, "            %s' \\\n", spec)
+		}
+		if args := c.args; len(args) > 0 {
+			fmt.Fprintf(&b, "            '1:argument:(%s)' \\\n", strings.Join(args, " "))
+		}
+		b.WriteString("            '(-h --help)'{-h,--help}'[Show this help message]' ;;\n")
+	}
+	b.WriteString("    esac\n}\n\n")
+	fmt.Fprintf(&b, "if [ \"$funcstack[1]\" = \"%s\" ]; then\n    %s \"$@\"\nelse\n    compdef %s %s\nfi\n", fn, fn, fn, prog)
+	return b.String()
+}
+
+// fishQuote quotes s as a single-quoted fish word.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// fishCompletion returns the fish completion script of prog.
+func fishCompletion(prog string, commands []*command) string {
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s, generated by \"%s completion fish\".\n", prog, prog)
+	fmt.Fprintf(&b, "complete -c %s -f\n", prog)
+	for _, c := range commands {
+		fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n", prog, c.name, fishQuote(c.summary))
+	}
+	fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -a help -d %s\n", prog, fishQuote("Show the help of a command"))
+	fmt.Fprintf(&b, "complete -c %s -n '__fish_seen_subcommand_from help' -a %s\n", prog, fishQuote(strings.Join(names, " ")))
+	for _, c := range commands {
+		cond := "__fish_seen_subcommand_from " + c.name
+		if c == commands[0] {
+			// The flags of the default command also come without it.
+			cond = "__fish_use_subcommand; or " + cond
+		}
+		b.WriteString("\n")
+		if args := c.args; len(args) > 0 {
+			fmt.Fprintf(&b, "complete -c %s -n %s -a %s\n", prog, fishQuote(cond), fishQuote(strings.Join(args, " ")))
+		}
+		for _, f := range c.visibleFlags() {
+			line := fmt.Sprintf("complete -c %s -n %s -l %s", prog, fishQuote(cond), f.Name)
+			if s, ok := c.short[f.Name]; ok {
+				line += " -s " + s
+			}
+			if !c.isBool(f.Name) {
+				line += " -r -F"
+			}
+			_, usage := flag.UnquoteUsage(f)
+			fmt.Fprintf(&b, "%s -d %s\n", line, fishQuote(usage))
+		}
+	}
+	return b.String()
+}
+
+// roffEscape escapes text for a troff man page.
+func roffEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// runMan prints the manual page, generated from the commands and their flags.
+// View it with "man -l <(prog man)" or install it in a man1 directory.
+func runMan(args []string) {
+	prog := filepath.Base(os.Args[0])
+	date := buildDate
+	if len(date) > 10 {
+		date = date[:10]
+	}
+	dash := strings.NewReplacer("-", `\-`)
+	fmt.Printf(".TH %s 1 %q %q \"User Commands\"\n", strings.ToUpper(dash.Replace(prog)), date, prog+" "+version)
+	fmt.Printf(".SH NAME\n%s \\- %s\n", dash.Replace(prog), roffEscape("EuroMillions results updater"))
+	fmt.Printf(".SH SYNOPSIS\n.B %s\n[\\fIcommand\\fR] [\\fIoptions\\fR]\n", dash.Replace(prog))
+	fmt.Printf(".SH DESCRIPTION\nWithout a command, %s runs \\fB%s\\fR.\n", dash.Replace(prog), commands[0].name)
+	fmt.Printf(".B %s help\n.I command\nshows the help of a command.\n", dash.Replace(prog))
+	fmt.Println(".SH COMMANDS")
+	for _, c := range commands {
+		fmt.Printf(".SS %s\n%s\n", dash.Replace(c.name), roffEscape(c.summary))
+		for _, f := range c.visibleFlags() {
+			name := `\fB\-\-` + dash.Replace(f.Name) + `\fR`
+			if s, ok := c.short[f.Name]; ok {
+				name = `\fB\-` + s + `\fR, ` + name
+			}
+			typ, usage := flag.UnquoteUsage(f)
+			if typ != "" {
+				name += ` \fI` + typ + `\fR`
+			}
+			switch f.DefValue {
+			case "", "false", "0", "0s":
+			default:
+				usage += fmt.Sprintf(" (default %s)", f.DefValue)
+			}
+			fmt.Printf(".TP\n%s\n%s\n", name, roffEscape(usage))
+		}
+		fmt.Printf(".TP\n\\fB\\-h\\fR, \\fB\\-\\-help\\fR\nShow this help message\n")
+	}
+}
+
+// setup applies the flags shared by the commands: it redirects the log, looks up
+// the game and opens and migrates the database. It exits on failure.
+func setup() (context.Context, *game, *sql.DB) {
+	if outputFile != "" {
+		logFile, err := os.OpenFile(outputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fatal(exitUsage, "Failed to open log file: %v", err)
+		}
+		log.SetOutput(logFile)
+	}
+
+	var err error
+	drawLocation, err = time.LoadLocation("Europe/Paris")
+	if err != nil {
+		fatal(exitUsage, "Failed to load draw time zone: %v", err)
+	}
+
+	if err := loadSites(); err != nil {
+		fatal(exitUsage, "Invalid site configuration: %v", err)
+	}
+
+	g := findGame(gameID)
+	if g == nil {
+		fatal(exitUsage, "Unknown game: %s", gameID)
+	}
+
+	if err := loadUserAgents(); err != nil {
+		fatal(exitUsage, "Failed to load User-Agents: %v", err)
+	}
+
+	if sentryDSN != "" {
+		if tracker, err = newSentryClient(sentryDSN); err != nil {
+			fatal(exitUsage, "Invalid --sentry-dsn: %v", err)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%s?_busy_timeout=%d", databasePath, busyTimeout.Milliseconds()))
+	if err != nil {
+		fatal(exitDB, "%v", err)
+	}
+
+	if err := migrateDB(db); err != nil {
+		fatal(exitDB, "Error migrating database: %v", err)
+	}
+	fetchLog = db
+
+	// Ctrl-C and SIGTERM cancel the context: fetches and database writes stop,
+	// and pending transactions roll back.
+	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	return ctx, g, db
+}
+
+// lockUpdates makes sure that a single update, daemon or mirror fetches the
+// game into the database at a time: two crons, or a cron next to the daemon,
+// would fetch twice and send every notification twice. The lock is an flock
+// on DATABASE.GAME.lock, which holds the PID and the command of its owner and
+// is released when the process exits. Without --takeover a second updater
+// exits with exitLocked; with it, the owner gets a SIGTERM, which rolls back
+// its transactions, and the lock is taken once it is gone.
+func lockUpdates(g *game) {
+	path := databasePath + "." + g.id + ".lock"
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		fatal(exitDB, "Failed to open the lock file: %v", err)
+	}
+	if tryLock(f) != nil {
+		owner, _ := os.ReadFile(path)
+		var pid int
+		var command string
+		fmt.Sscanf(string(owner), "%d %s", &pid, &command)
+		if !takeover || pid <= 0 {
+			fatal(exitLocked, "Another updater (PID %d, %s) is already fetching %s into %s; stop it, or use --takeover to replace it", pid, command, g.name, databasePath)
+		}
+		log.Printf("Stopping the updater with PID %d (%s)", pid, command)
+		if err := terminate(pid); err != nil {
+			fatal(exitLocked, "Failed to stop the updater with PID %d: %v", pid, err)
+		}
+		deadline := time.Now().Add(takeoverTimeout)
+		for tryLock(f) != nil {
+			if time.Now().After(deadline) {
+				fatal(exitLocked, "The updater with PID %d did not stop within %s", pid, takeoverTimeout)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	f.Truncate(0)
+	f.WriteAt([]byte(fmt.Sprintf("%d %s\n", os.Getpid(), auditSource)), 0)
+	lockFile = f
+}
+
+// siteIDs returns the sites selected with --site. It exits on an invalid ID.
+func siteIDs(g *game) []int {
+	if siteIDStr == "all" {
+		return g.siteIDList()
+	}
+	siteID, err := strconv.Atoi(siteIDStr)
+	if err != nil {
+		fatal(exitUsage, "Invalid site ID: %v", err)
+	}
+	return []int{siteID}
+}
+
+// runSites runs an update from each site in turn and returns the summary and
+// the exit code of the run. A new draw from any site wins; otherwise the run is
+// up to date as long as one site answered, and fails with the worst error when
+// none did.
+func runSites(ctx context.Context, db *sql.DB, g *game, sites []int) (updateSummary, int) {
+	summary := updateSummary{Game: g.id}
+	summary.Inserted = drainSpool(ctx, db, g) > 0
+	failures := 0
+	worst := exitUpToDate
+	for i, id := range sites {
+		if i > 0 && sleep(ctx, 1*time.Second) != nil {
+			break
+		}
+		var run siteRun
+		siteCtx, cancel := withTimeout(ctx)
+		err := runUpdate(siteCtx, db, g, id, &run)
+		cancel()
+		if err != nil {
+			log.Printf("Error processing site %d: %v", id, err)
+			run.Error = err.Error()
+			failures++
+			worst = max(worst, exitCode(err))
+			reportScrapeFailure(ctx, g, id, run, err)
+		}
+		logScrape(ctx, db, g, run)
+		summary.Runs = append(summary.Runs, run)
+		summary.Inserted = summary.Inserted || run.Inserted
+	}
+	sendConfirmations(ctx, db)
+	if summary.Inserted {
+		notifySubscribers(ctx, db, g)
+	}
+
+	switch {
+	case summary.Inserted:
+		return summary, exitInserted
+	case failures == len(sites):
+		return summary, worst
+	default:
+		return summary, exitUpToDate
+	}
+}
+
+// reportScrapeFailure sends a site that could not be fetched, parsed or
+// validated to the error tracker, with the site and the draw date it got.
+func reportScrapeFailure(ctx context.Context, g *game, id int, run siteRun, err error) {
+	if tracker == nil || exitCode(err) == exitDB {
+		return
+	}
+	tags := map[string]string{"game": g.id, "site": strconv.Itoa(id), "exit_code": strconv.Itoa(exitCode(err))}
+	extra := map[string]any{"numbers": run.Numbers}
+	if s := g.site(id); s != nil {
+		tags["site_url"] = s.URL
+	}
+	if run.Date != "" {
+		tags["date"] = run.Date
+	}
+	tracker.capture(ctx, "error", fmt.Sprintf("%s site %d: %v", g.id, id, err), tags, extra)
+}
+
+// sentryClient sends events to Sentry, or any tracker implementing its store
+// API, as the official SDK would, without depending on it.
+type sentryClient struct {
+	storeURL string
+	key      string
+}
+
+// newSentryClient parses a DSN of the form https://KEY@HOST/PROJECT.
+func newSentryClient(dsn string) (*sentryClient, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || u.Host == "" || i < 0 || u.Path[i+1:] == "" {
+		return nil, fmt.Errorf("%q is not a DSN (https://KEY@HOST/PROJECT)", dsn)
+	}
+	store := url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path[:i] + "/api/" + u.Path[i+1:] + "/store/"}
+	return &sentryClient{storeURL: store.String(), key: u.User.Username()}, nil
+}
+
+// capture sends one event. Failures are only logged: reporting an error must
+// not fail the run.
+func (c *sentryClient) capture(ctx context.Context, level, message string, tags map[string]string, extra map[string]any) {
+	hostname, _ := os.Hostname()
+	event := map[string]any{
+		"event_id":    fmt.Sprintf("%016x%016x", rand.Uint64(), rand.Uint64()),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"level":       level,
+		"platform":    "go",
+		"logger":      "go-euromillions-api-update",
+		"server_name": hostname,
+		"release":     version,
+		"message":     message,
+		"tags":        tags,
+		"extra":       extra,
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=go-euromillions-api-update/%s, sentry_key=%s", version, c.key)
+	if err := postMessage(ctx, c.storeURL, "application/json", event, map[string]string{"X-Sentry-Auth": auth}); err != nil {
+		log.Printf("Failed to send error report: %v", err)
+	}
+}
+
+// logScrape records the outcome of a site in the scrape_log table.
+func logScrape(ctx context.Context, db *sql.DB, g *game, run siteRun) {
+	var date, runErr any
+	if run.Date != "" {
+		date = run.Date
+	}
+	if run.Error != "" {
+		runErr = run.Error
+	}
+	_, err := db.ExecContext(ctx, "INSERT INTO scrape_log (time, game, source, draw_date, inserted, error) VALUES (?, ?, ?, ?, ?, ?)",
+		time.Now().UTC().Format(time.RFC3339), g.id, run.Source, date, run.Inserted, runErr)
+	if err != nil {
+		log.Printf("Failed to record the run of site %d: %v", run.Source, err)
+	}
+}
+
+// cmdUpdate runs the update command.
+func cmdUpdate(args []string) {
+	if versionFlag {
+		fmt.Printf("EuroMillions updater %s\n", versionString())
+		return
+	}
 
 	if databasePath == "" || siteIDStr == "" {
-		flag.Usage()
-		os.Exit(1)
+		updateCmd.printHelp()
+		os.Exit(exitUsage)
+	}
+
+	ctx, g, db := setup()
+	lockUpdates(g)
+	start := time.Now()
+	summary, code := runSites(ctx, db, g, siteIDs(g))
+	pushMetrics(g, summary, time.Since(start))
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(summary); err != nil {
+			log.Printf("Failed to write JSON summary: %v", err)
+		}
+	}
+	db.Close()
+	os.Exit(code)
+}
+
+// verifySource is what one archive reports for the verified draw.
+type verifySource struct {
+	Source  string `json:"source"`
+	Numbers []int  `json:"numbers,omitempty"`
+	Special bool   `json:"special"`
+	Matches bool   `json:"matches"`
+	Error   string `json:"error,omitempty"`
+
+	drawnOrder any
+}
+
+// verifyReport is the outcome of the verify command.
+type verifyReport struct {
+	Game      string         `json:"game"`
+	Date      string         `json:"date"`
+	Stored    []int          `json:"stored,omitempty"`
+	Sources   []verifySource `json:"sources"`
+	Consensus []int          `json:"consensus,omitempty"`
+	// Status is ok, mismatch (the archives agree on another draw), missing (the
+	// draw is not stored) or unverified (not enough archives agree).
+	Status   string `json:"status"`
+	Repaired bool   `json:"repaired"`
+}
+
+// cmdVerify fetches a draw from every archive of the game, compares it with the
+// stored row and, with --repair, stores the draw the archives agree on.
+func cmdVerify(args []string) {
+	if databasePath == "" || verifyDate == "" {
+		verifyCmd.printHelp()
+		os.Exit(exitUsage)
+	}
+	if _, err := time.Parse("2006-01-02", verifyDate); err != nil {
+		fatal(exitUsage, "Invalid date: %s (use YYYY-MM-DD)", verifyDate)
+	}
+
+	ctx, g, db := setup()
+	defer db.Close()
+
+	report := verifyReport{Game: g.id, Date: verifyDate}
+
+	stored := make([]int, g.numbers+g.stars)
+	dest := []any{}
+	for i := range stored {
+		dest = append(dest, &stored[i])
+	}
+	err := db.QueryRowContext(ctx, "SELECT "+strings.Join(g.ballColumns(), ", ")+" FROM "+g.table+" WHERE date = ?", verifyDate).Scan(dest...)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		fatal(exitDB, "Database query error: %v", err)
+	default:
+		slices.Sort(stored[:g.numbers])
+		slices.Sort(stored[g.numbers:])
+		report.Stored = stored
+	}
+
+	// Count the archives agreeing on each draw.
+	votes := map[string]int{}
+	failures := 0
+	for _, a := range g.archives {
+		src := verifySource{Source: a.name}
+		archiveCtx, cancel := withTimeout(ctx)
+		d, err := a.fetch(archiveCtx, verifyDate)
+		cancel()
+		if err == nil && d.Date != verifyDate {
+			err = fmt.Errorf("archive returned the %s draw", d.Date)
+		}
+		if err == nil && len(d.Numbers) != g.numbers+g.stars {
+			err = fmt.Errorf("expected %d numbers, got %d", g.numbers+g.stars, len(d.Numbers))
+		}
+		if err == nil {
+			src.Numbers, src.drawnOrder, err = g.normalizeBalls(d.Numbers)
+		}
+		if err != nil {
+			log.Printf("%s: %v", a.name, err)
+			src.Error = err.Error()
+			failures++
+		} else {
+			src.Special = d.Special
+			src.Matches = slices.Equal(src.Numbers, report.Stored)
+			votes[fmt.Sprint(src.Numbers)]++
+		}
+		report.Sources = append(report.Sources, src)
+	}
+
+	var consensus *verifySource
+	best, tie := 0, false
+	for i, src := range report.Sources {
+		if src.Error != "" {
+			continue
+		}
+		switch n := votes[fmt.Sprint(src.Numbers)]; {
+		case n > best:
+			best, tie, consensus = n, false, &report.Sources[i]
+		case n == best && !slices.Equal(src.Numbers, consensus.Numbers):
+			tie = true
+		}
+	}
+	if tie || best < minAgree {
+		consensus = nil
+	}
+
+	switch {
+	case consensus == nil:
+		report.Status = "unverified"
+	case report.Stored == nil:
+		report.Status = "missing"
+	case !consensus.Matches:
+		report.Status = "mismatch"
+	default:
+		report.Status = "ok"
+	}
+	if consensus != nil {
+		report.Consensus = consensus.Numbers
+	}
+
+	if repairFlag && (report.Status == "missing" || report.Status == "mismatch") {
+		repair := pendingDraw{date: verifyDate, exists: report.Stored != nil, balls: consensus.Numbers, special: consensus.Special, drawnOrder: consensus.drawnOrder}
+		if err := storeDraws(ctx, db, g, []pendingDraw{repair}, nil); err != nil {
+			fatal(exitDB, "Failed to repair the %s draw: %v", verifyDate, err)
+		}
+		report.Repaired = true
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Printf("Failed to write JSON report: %v", err)
+		}
+	} else {
+		fmt.Printf("%s draw of %s\n", g.name, verifyDate)
+		if report.Stored != nil {
+			fmt.Printf("  %-24s %s\n", "stored", joinInts(report.Stored))
+		} else {
+			fmt.Printf("  %-24s %s\n", "stored", "(missing)")
+		}
+		for _, src := range report.Sources {
+			if src.Error != "" {
+				fmt.Printf("  %-24s error: %s\n", src.Source, src.Error)
+				continue
+			}
+			mark := "differs"
+			if src.Matches {
+				mark = "matches"
+			}
+			fmt.Printf("  %-24s %s (%s)\n", src.Source, joinInts(src.Numbers), mark)
+		}
+		fmt.Printf("Status: %s", report.Status)
+		if report.Repaired {
+			fmt.Print(" (repaired)")
+		}
+		fmt.Println()
+	}
+
+	switch {
+	case report.Repaired:
+		os.Exit(exitInserted)
+	case report.Status == "ok":
+		os.Exit(exitUpToDate)
+	case failures == len(g.archives):
+		os.Exit(exitScrape)
+	default:
+		os.Exit(exitValidation)
+	}
+}
+
+// execer is implemented by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// inTransaction runs fn in a transaction. It commits when fn succeeds and rolls
+// back when fn fails, so a multi-row write is stored completely or not at all.
+func inTransaction(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// pendingDraw is a draw waiting to be written by storeDraws.
+type pendingDraw struct {
+	date       string
+	exists     bool
+	balls      []int
+	special    bool
+	drawnOrder any
+}
+
+// storeDraws writes draws and updates the statistics in a single transaction.
+// progress, if not nil, is called after each draw with the number of draws
+// written so far.
+func storeDraws(ctx context.Context, db *sql.DB, g *game, draws []pendingDraw, progress func(done, total int)) error {
+	return inTransaction(ctx, db, func(tx *sql.Tx) error {
+		return writeDraws(ctx, tx, g, draws, progress)
+	})
+}
+
+// writeDraws is storeDraws within the transaction tx, for callers that write
+// more in the same transaction.
+func writeDraws(ctx context.Context, tx *sql.Tx, g *game, draws []pendingDraw, progress func(done, total int)) error {
+	replaced := false
+	for i, d := range draws {
+		action, before := "insert", any(nil)
+		if d.exists {
+			action = "update"
+			var err error
+			if before, err = storedDrawJSON(ctx, tx, g, d.date); err != nil {
+				return fmt.Errorf("draw of %s: %v", d.date, err)
+			}
+		}
+		if err := storeDraw(ctx, tx, g, d.date, d.exists, d.balls, d.special, d.drawnOrder); err != nil {
+			return fmt.Errorf("draw of %s: %v", d.date, err)
+		}
+		after := drawJSON(g, d.balls, d.special)
+		if err := recordAudit(ctx, tx, g, d.date, action, before, after); err != nil {
+			return fmt.Errorf("audit of the %s draw: %v", d.date, err)
+		}
+		if before != nil && before != after {
+			if err := keepSuperseded(ctx, tx, g, d.date, before, "update"); err != nil {
+				return fmt.Errorf("history of the %s draw: %v", d.date, err)
+			}
+		}
+		// A new draw only adds to the statistics; a replaced one is
+		// accounted for by recomputing them once, below.
+		if d.exists {
+			replaced = true
+		} else if err := addToStats(ctx, tx, g, d.date, d.balls); err != nil {
+			return fmt.Errorf("statistics of the %s draw: %v", d.date, err)
+		}
+		if progress != nil {
+			progress(i+1, len(draws))
+		}
+	}
+	if replaced {
+		if err := rebuildStats(ctx, tx, g); err != nil {
+			return fmt.Errorf("statistics: %v", err)
+		}
+	}
+	return nil
+}
+
+// auditDraw is a draw as recorded in the audit log.
+type auditDraw struct {
+	Numbers []int `json:"numbers"`
+	Stars   []int `json:"stars"`
+	Special bool  `json:"special"`
+}
+
+// drawJSON returns the audit log form of a draw.
+func drawJSON(g *game, balls []int, special bool) string {
+	b, _ := json.Marshal(auditDraw{Numbers: balls[:g.numbers], Stars: balls[g.numbers:], Special: special})
+	return string(b)
+}
+
+// storedDrawJSON returns the audit log form of the stored draw of date, or nil
+// when there is none.
+func storedDrawJSON(ctx context.Context, tx *sql.Tx, g *game, date string) (any, error) {
+	balls := make([]int, g.numbers+g.stars)
+	dest := []any{}
+	for i := range balls {
+		dest = append(dest, &balls[i])
+	}
+	var special bool
+	dest = append(dest, &special)
+	err := tx.QueryRowContext(ctx, "SELECT "+strings.Join(g.ballColumns(), ", ")+", special FROM "+g.table+" WHERE date = ?", date).Scan(dest...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return drawJSON(g, balls, special), nil
+}
+
+// recordAudit adds a change of the draw of date to the audit log. before and
+// after are the JSON forms of the draw, nil for none.
+func recordAudit(ctx context.Context, db execer, g *game, date, action string, before, after any) error {
+	var actor any
+	if auditActor != "" {
+		actor = auditActor
+	}
+	_, err := db.ExecContext(ctx, "INSERT INTO audit_log (time, game, date, action, source, actor, before, after) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		time.Now().UTC().Format(time.RFC3339), g.id, date, action, auditSource, actor, before, after)
+	return err
+}
+
+// keepSuperseded keeps the earlier value of a corrected (reason update) or
+// deleted (reason delete) draw in results_history.
+func keepSuperseded(ctx context.Context, db execer, g *game, date string, draw any, reason string) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO results_history (game, date, draw, superseded, reason) VALUES (?, ?, ?, ?, ?)",
+		g.id, date, draw, time.Now().UTC().Format(time.RFC3339), reason)
+	return err
+}
+
+// addToStats counts a new draw in the stats_balls and stats_pairs tables.
+// balls are the sorted numbers followed by the sorted stars.
+func addToStats(ctx context.Context, db execer, g *game, date string, balls []int) error {
+	for i, n := range balls {
+		kind := "number"
+		if i >= g.numbers {
+			kind = "star"
+		}
+		_, err := db.ExecContext(ctx, `INSERT INTO stats_balls (game, kind, ball, draws, last_seen) VALUES (?, ?, ?, 1, ?)
+			ON CONFLICT (game, kind, ball) DO UPDATE SET draws = draws + 1, last_seen = max(last_seen, excluded.last_seen)`,
+			g.id, kind, n, date)
+		if err != nil {
+			return err
+		}
+	}
+	numbers := balls[:g.numbers]
+	for i, first := range numbers {
+		for _, second := range numbers[i+1:] {
+			_, err := db.ExecContext(ctx, `INSERT INTO stats_pairs (game, first, second, draws) VALUES (?, ?, ?, 1)
+				ON CONFLICT (game, first, second) DO UPDATE SET draws = draws + 1`,
+				g.id, first, second)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rebuildStats recomputes the statistics of g from its table, after draws
+// were replaced. (The same queries fill the tables in the migrations.)
+func rebuildStats(ctx context.Context, db execer, g *game) error {
+	var balls, numbers []string
+	for i, column := range g.ballColumns() {
+		kind := "number"
+		if i >= g.numbers {
+			kind = "star"
+		} else {
+			numbers = append(numbers, fmt.Sprintf("SELECT date, %s FROM %s", column, g.table))
+		}
+		balls = append(balls, fmt.Sprintf("SELECT date, '%s', %s FROM %s", kind, column, g.table))
+	}
+	statements := []string{
+		"DELETE FROM stats_balls WHERE game = ?",
+		"DELETE FROM stats_pairs WHERE game = ?",
+		`WITH balls (date, kind, ball) AS (` + strings.Join(balls, " UNION ALL ") + `)
+		INSERT INTO stats_balls (game, kind, ball, draws, last_seen)
+		SELECT ?, kind, ball, COUNT(*), MAX(date) FROM balls GROUP BY kind, ball`,
+		`WITH balls (date, ball) AS (` + strings.Join(numbers, " UNION ALL ") + `)
+		INSERT INTO stats_pairs (game, first, second, draws)
+		SELECT ?, a.ball, b.ball, COUNT(*) FROM balls a JOIN balls b ON a.date = b.date AND a.ball < b.ball
+		GROUP BY a.ball, b.ball`,
+	}
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement, g.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logProgress is a storeDraws progress callback that logs every tenth of the work.
+func logProgress(done, total int) {
+	if step := max(total/10, 1); done%step == 0 || done == total {
+		log.Printf("Stored %d/%d draws", done, total)
+	}
+}
+
+// storeDraw stores the draw of date, replacing the stored row when exists is set.
+// balls are normalized, see normalizeBalls.
+func storeDraw(ctx context.Context, db execer, g *game, date string, exists bool, balls []int, special bool, drawnOrder any) error {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return fmt.Errorf("invalid draw date %q: %v", date, err)
+	}
+
+	columns := append(g.ballColumns(), "special", "drawn_order", "year", "month")
+	var args []any
+	for _, n := range balls {
+		args = append(args, n)
+	}
+	args = append(args, special, drawnOrder, t.Year(), int(t.Month()), date)
+
+	var query string
+	if exists {
+		query = "UPDATE " + g.table + " SET " + strings.Join(columns, " = ?, ") + " = ? WHERE date = ?"
+	} else {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)+1), ", ")
+		query = "INSERT INTO " + g.table + " (" + strings.Join(columns, ", ") + ", date) VALUES (" + placeholders + ")"
+	}
+	_, err = db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// editOutcome is the JSON output of the set and delete commands. Action is
+// inserted, updated, unchanged, deleted or missing.
+type editOutcome struct {
+	Game   string `json:"game"`
+	Date   string `json:"date"`
+	Action string `json:"action"`
+}
+
+// printOutcome prints the outcome of an edit with --json.
+func printOutcome(outcome editOutcome) {
+	if !jsonOutput {
+		return
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(outcome); err != nil {
+		log.Printf("Failed to write JSON outcome: %v", err)
+	}
+}
+
+// validateBalls checks the sorted numbers and stars of a draw entered by
+// hand: their count, their range and that none is repeated.
+func (g *game) validateBalls(balls []int) error {
+	if len(balls) != g.numbers+g.stars {
+		return fmt.Errorf("expected %d numbers and %d stars", g.numbers, g.stars)
+	}
+	for i, n := range balls {
+		limit := g.maxNumber
+		if i >= g.numbers {
+			limit = g.maxStar
+		}
+		if n < 1 || n > limit {
+			return fmt.Errorf("%d is out of range 1-%d", n, limit)
+		}
+	}
+	// The numbers and the stars are sorted: a repeated ball follows itself.
+	for i := 1; i < len(balls); i++ {
+		if i != g.numbers && balls[i] == balls[i-1] {
+			return fmt.Errorf("%d is repeated", balls[i])
+		}
+	}
+	return nil
+}
+
+// cmdSet runs the set command: it stores the given draw, inserting it or
+// replacing the stored one. Exit codes: 0 when the stored draw was already
+// identical, 1 when it was stored, 3 for invalid input.
+func cmdSet(args []string) {
+	if databasePath == "" || drawDate == "" || setNumbers == "" || setStars == "" {
+		setCmd.printHelp()
+		os.Exit(exitUsage)
+	}
+	if _, err := time.Parse("2006-01-02", drawDate); err != nil {
+		fatal(exitUsage, "Invalid date: %s (use YYYY-MM-DD)", drawDate)
+	}
+
+	ctx, g, db := setup()
+	defer db.Close()
+
+	scraped := append(strings.Split(setNumbers, ","), strings.Split(setStars, ",")...)
+	if len(scraped) != g.numbers+g.stars {
+		fatal(exitValidation, "Invalid draw: expected %d numbers and %d stars", g.numbers, g.stars)
+	}
+	balls, drawnOrder, err := g.normalizeBalls(scraped)
+	if err == nil {
+		err = g.validateBalls(balls)
+	}
+	if err != nil {
+		fatal(exitValidation, "Invalid draw: %v", err)
+	}
+
+	stored := make([]int, g.numbers+g.stars)
+	dest := []any{}
+	for i := range stored {
+		dest = append(dest, &stored[i])
+	}
+	var storedSpecial bool
+	dest = append(dest, &storedSpecial)
+	err = db.QueryRowContext(ctx, "SELECT "+strings.Join(g.ballColumns(), ", ")+", special FROM "+g.table+" WHERE date = ?", drawDate).Scan(dest...)
+	if err != nil && err != sql.ErrNoRows {
+		fatal(exitDB, "Database query error: %v", err)
+	}
+	exists := err == nil
+
+	outcome := editOutcome{Game: g.id, Date: drawDate, Action: "inserted"}
+	if exists {
+		outcome.Action = "updated"
+		if slices.Equal(stored, balls) && storedSpecial == specialFlag {
+			outcome.Action = "unchanged"
+			log.Printf("The %s draw of %s is already stored", g.name, drawDate)
+			printOutcome(outcome)
+			return
+		}
+	}
+
+	draw := pendingDraw{date: drawDate, exists: exists, balls: balls, special: specialFlag, drawnOrder: drawnOrder}
+	if err := storeDraws(ctx, db, g, []pendingDraw{draw}, nil); err != nil {
+		fatal(exitDB, "Failed to store the %s draw: %v", drawDate, err)
+	}
+	log.Printf("Stored the %s draw of %s (%s): %s", g.name, drawDate, outcome.Action, joinInts(balls))
+	printOutcome(outcome)
+	os.Exit(exitInserted)
+}
+
+// cmdDelete runs the delete command. Exit codes: 0 when there was no such
+// draw, 1 when it was deleted.
+func cmdDelete(args []string) {
+	if databasePath == "" || drawDate == "" {
+		deleteCmd.printHelp()
+		os.Exit(exitUsage)
+	}
+	if _, err := time.Parse("2006-01-02", drawDate); err != nil {
+		fatal(exitUsage, "Invalid date: %s (use YYYY-MM-DD)", drawDate)
+	}
+
+	ctx, g, db := setup()
+	defer db.Close()
+
+	deleted, err := deleteDraw(ctx, db, g, drawDate)
+	if err != nil {
+		fatal(exitDB, "Failed to delete the %s draw: %v", drawDate, err)
+	}
+	outcome := editOutcome{Game: g.id, Date: drawDate, Action: "missing"}
+	if !deleted {
+		log.Printf("No %s draw is stored for %s", g.name, drawDate)
+		printOutcome(outcome)
+		return
+	}
+	outcome.Action = "deleted"
+	log.Printf("Deleted the %s draw of %s", g.name, drawDate)
+	printOutcome(outcome)
+	os.Exit(exitInserted)
+}
+
+// deleteDraw deletes the draw of date, keeping it in results_history, and
+// recomputes the statistics, in one transaction. It reports whether there was a draw to delete.
+func deleteDraw(ctx context.Context, db *sql.DB, g *game, date string) (bool, error) {
+	deleted := false
+	err := inTransaction(ctx, db, func(tx *sql.Tx) error {
+		var err error
+		if deleted, err = removeDraw(ctx, tx, g, date); err != nil || !deleted {
+			return err
+		}
+		return rebuildStats(ctx, tx, g)
+	})
+	return deleted, err
+}
+
+// removeDraw deletes the draw of date within the transaction tx, keeping it
+// in results_history, and reports whether there was one. The caller
+// recomputes the statistics.
+func removeDraw(ctx context.Context, tx *sql.Tx, g *game, date string) (bool, error) {
+	before, err := storedDrawJSON(ctx, tx, g, date)
+	if err != nil || before == nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+g.table+" WHERE date = ?", date); err != nil {
+		return false, err
+	}
+	if err := recordAudit(ctx, tx, g, date, "delete", before, nil); err != nil {
+		return false, err
+	}
+	if err := keepSuperseded(ctx, tx, g, date, before, "delete"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// joinInts formats a list of balls as "1, 2, 3".
+func joinInts(list []int) string {
+	parts := make([]string, len(list))
+	for i, n := range list {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// cmdDaemon runs the update every --interval. After each run the watchdog
+// checks that the last expected draw was stored, and raises an alert once per
+// missing draw when it is still missing --alert-after its draw time.
+func cmdDaemon(args []string) {
+	if databasePath == "" {
+		daemonCmd.printHelp()
+		os.Exit(exitUsage)
+	}
+
+	ctx, g, db := setup()
+	defer db.Close()
+	lockUpdates(g)
+	sites := siteIDs(g)
+
+	log.Printf("Updating %s every %s", g.name, updateInterval)
+	alerted := ""
+	for {
+		start := time.Now()
+		summary, code := runSites(ctx, db, g, sites)
+		pushMetrics(g, summary, time.Since(start))
+		if code > exitInserted {
+			log.Printf("Update run failed on every site (exit code %d)", code)
+		}
+		if missing, latest, err := missingDraw(ctx, db, g, time.Now()); err != nil {
+			log.Printf("Watchdog: %v", err)
+		} else if missing != "" && missing != alerted {
+			alert(g, missing, latest)
+			alerted = missing
+		}
+		if sleep(ctx, updateInterval) != nil {
+			log.Println("Stopping")
+			return
+		}
+	}
+}
+
+// lastExpectedDraw returns the date of the last draw of g held at least
+// --alert-after before now, or "" when the game has no draw days.
+func lastExpectedDraw(g *game, now time.Time) string {
+	if len(g.drawDays) == 0 {
+		return ""
+	}
+	day := now.In(drawLocation)
+	for i := 0; i < 8; i++ {
+		date := day.AddDate(0, 0, -i).Format("2006-01-02")
+		t, _ := drawTime(date)
+		if slices.Contains(g.drawDays, t.Weekday()) && !t.Add(alertAfter).After(now) {
+			return date
+		}
+	}
+	return ""
+}
+
+// missingDraw returns the date of the last expected draw when it is newer than
+// the latest stored one, together with that latest stored date.
+func missingDraw(ctx context.Context, db *sql.DB, g *game, now time.Time) (missing, latest string, err error) {
+	expected := lastExpectedDraw(g, now)
+	if expected == "" {
+		return "", "", nil
+	}
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(MAX(date), '') FROM "+g.table).Scan(&latest)
+	if err != nil {
+		return "", "", fmt.Errorf("database query error: %v", err)
+	}
+	if latest >= expected {
+		return "", latest, nil
+	}
+	return expected, latest, nil
+}
+
+// alert reports that the draw of date is missing: in the log, to the webhook
+// and by email, depending on the configuration.
+func alert(g *game, date, latest string) {
+	message := fmt.Sprintf("No %s draw stored for %s, %s after the draw; the latest stored draw is %s. Check the scrapers.", g.name, date, alertAfter, latest)
+	log.Printf("ALERT: %s", message)
+
+	if alertWebhook != "" {
+		err := postMessage(context.Background(), alertWebhook, "application/json", map[string]string{
+			"game":          g.id,
+			"expected_date": date,
+			"latest_date":   latest,
+			"message":       message,
+		}, nil)
+		if err != nil {
+			log.Printf("Failed to send alert webhook: %v", err)
+		}
+	}
+
+	if smtpServer != "" && alertTo != "" {
+		if err := sendMail(context.Background(), strings.Split(alertTo, ","), g.name+" draw of "+date+" is missing", message); err != nil {
+			log.Printf("Failed to send alert email: %v", err)
+		}
+	}
+}
+
+// postMessage POSTs body, JSON-encoded unless it is a string, to a webhook
+// or ntfy URL with the given extra headers.
+func postMessage(ctx context.Context, url, contentType string, body any, headers map[string]string) error {
+	return postMessageWith(ctx, &http.Client{Timeout: 30 * time.Second}, url, contentType, body, headers)
+}
+
+// postMessageWith is postMessage with the given client.
+func postMessageWith(ctx context.Context, client *http.Client, url, contentType string, body any, headers map[string]string) error {
+	data, ok := body.(string)
+	if !ok {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		data = string(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
+
+// sendMail emails a plain text message through --smtp-server, as
+// smtp.SendMail does (STARTTLS when offered), but stopping with ctx.
+func sendMail(ctx context.Context, to []string, subject, message string) error {
+	msg := "From: " + alertFrom + "\r\n" +
+		"To: " + strings.Join(to, ",") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"\r\n" + message + "\r\n"
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", smtpServer)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	host, _, _ := strings.Cut(smtpServer, ":")
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if smtpUser != "" {
+		if err := c.Auth(smtp.PlainAuth("", smtpUser, os.Getenv("SMTP_PASSWORD"), host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(alertFrom); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// subscription is a row of the subscriptions table, managed by the server's
+// /subscriptions endpoints.
+type subscription struct {
+	id, channel, target string
+	events              []string
+	line                []int
+}
+
+// drawNotification is the JSON body POSTed to webhook subscriptions. Line
+// and Tier are set for the win event.
+type drawNotification struct {
+	Event   string `json:"event"`
+	Game    string `json:"game"`
+	Date    string `json:"date"`
+	Numbers []int  `json:"numbers"`
+	Stars   []int  `json:"stars"`
+	Special bool   `json:"special"`
+	Line    []int  `json:"line,omitempty"`
+	Tier    string `json:"tier,omitempty"`
+	Message string `json:"message"`
+}
+
+// notifySubscribers sends the latest draw of g, just stored, to its
+// subscriptions: to all of those with the draw event, and to those with the
+// win event whose line won a prize. Failures are logged and do not fail the run.
+func notifySubscribers(ctx context.Context, db *sql.DB, g *game) {
+	balls := make([]int, g.numbers+g.stars)
+	var date string
+	var special bool
+	dest := []any{&date}
+	for i := range balls {
+		dest = append(dest, &balls[i])
+	}
+	dest = append(dest, &special)
+	err := db.QueryRowContext(ctx, "SELECT date, "+strings.Join(g.ballColumns(), ", ")+", special FROM "+g.table+" ORDER BY date DESC LIMIT 1").Scan(dest...)
+	if err != nil {
+		log.Printf("Failed to read the draw to notify: %v", err)
+		return
+	}
+	numbers, stars := balls[:g.numbers], balls[g.numbers:]
+
+	subs, err := loadSubscriptions(ctx, db, g)
+	if err != nil {
+		log.Printf("Failed to read the subscriptions: %v", err)
+	}
+
+	drawText := fmt.Sprintf("%s draw of %s: %s + %s", g.name, date, joinInts(numbers), joinInts(stars))
+	var deliveries []delivery
+	for _, s := range subs {
+		var notifications []drawNotification
+		if slices.Contains(s.events, "draw") {
+			notifications = append(notifications, drawNotification{Event: "draw", Message: drawText})
+		}
+		if slices.Contains(s.events, "win") && len(s.line) == g.numbers+g.stars {
+			if tier := g.tier(s.line, balls); tier != "" {
+				notifications = append(notifications, drawNotification{
+					Event: "win", Line: s.line, Tier: tier,
+					Message: fmt.Sprintf("Your line %s + %s matched %s in the %s", joinInts(s.line[:g.numbers]), joinInts(s.line[g.numbers:]), tier, drawText),
+				})
+			}
+		}
+		for _, n := range notifications {
+			n.Game, n.Date, n.Numbers, n.Stars, n.Special = g.id, date, numbers, stars, special
+			title := g.name + " draw of " + n.Date
+			if n.Event == "win" {
+				title = "Your line won " + n.Tier + " in the " + title
+			}
+			deliveries = append(deliveries, delivery{sub: s, title: title, message: n.Message, body: n})
+		}
+	}
+	if sent := deliverAll(ctx, deliveries); sent > 0 {
+		log.Printf("Sent %d notifications of the %s draw of %s", sent, g.name, date)
+	}
+}
+
+// loadSubscriptions returns the confirmed subscriptions of the game, with
+// their lines.
+func loadSubscriptions(ctx context.Context, db *sql.DB, g *game) ([]subscription, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, channel, target, events, COALESCE(numbers, ''), COALESCE(stars, '') FROM subscriptions WHERE game = ? AND confirmed IS NOT NULL", g.id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var subs []subscription
+	for rows.Next() {
+		var s subscription
+		var events, lineNumbers, lineStars string
+		if err := rows.Scan(&s.id, &s.channel, &s.target, &events, &lineNumbers, &lineStars); err != nil {
+			return subs, err
+		}
+		s.events = strings.Split(events, ",")
+		for _, part := range strings.FieldsFunc(lineNumbers+","+lineStars, func(r rune) bool { return r == ',' }) {
+			if n, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+				s.line = append(s.line, n)
+			}
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+// tier returns the prize tier ("3+1") that a line won in a draw, both as
+// sorted numbers followed by sorted stars, or "" when it won nothing.
+func (g *game) tier(line, draw []int) string {
+	numbers, stars := 0, 0
+	for _, n := range line[:g.numbers] {
+		if slices.Contains(draw[:g.numbers], n) {
+			numbers++
+		}
+	}
+	for _, s := range line[g.numbers:] {
+		if slices.Contains(draw[g.numbers:], s) {
+			stars++
+		}
+	}
+	if !slices.Contains(g.tiers, [2]int{numbers, stars}) {
+		return ""
+	}
+	return fmt.Sprintf("%d+%d", numbers, stars)
+}
+
+// deliver sends a notification through the channel of a subscription: body,
+// as JSON, to a webhook, and the message with its title to ntfy or by email.
+// Webhooks and ntfy topics are only reached on public addresses.
+func deliver(ctx context.Context, s subscription, title, message string, body any) error {
+	switch s.channel {
+	case "webhook":
+		return postMessageWith(ctx, subscriptionClient, s.target, "application/json", body, nil)
+	case "ntfy":
+		return postMessageWith(ctx, subscriptionClient, s.target, "text/plain", message, map[string]string{"Title": title})
+	case "email":
+		if smtpServer == "" {
+			return fmt.Errorf("no --smtp-server")
+		}
+		return sendMail(ctx, []string{s.target}, title, message)
+	}
+	return fmt.Errorf("unknown channel %q", s.channel)
+}
+
+// delivery is a notification to send to a subscription.
+type delivery struct {
+	sub            subscription
+	title, message string
+	body           any
+}
+
+const (
+	// deliveryWorkers is how many notifications are sent at a time.
+	deliveryWorkers = 8
+	// deliveryTimeout bounds all the notifications of a run together, so
+	// that slow or unreachable targets cannot hold up the updates.
+	deliveryTimeout = 2 * time.Minute
+)
+
+// deliverAll sends the deliveries concurrently and returns how many were
+// sent. Failures are logged and do not fail the run.
+func deliverAll(ctx context.Context, deliveries []delivery) int {
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	queue := make(chan delivery)
+	var sent atomic.Int64
+	var wg sync.WaitGroup
+	for range min(deliveryWorkers, len(deliveries)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range queue {
+				if err := deliver(ctx, d.sub, d.title, d.message, d.body); err != nil {
+					log.Printf("Failed to notify subscription %s (%s): %v", d.sub.id, d.sub.channel, err)
+					continue
+				}
+				sent.Add(1)
+			}
+		}()
+	}
+	for _, d := range deliveries {
+		queue <- d
+	}
+	close(queue)
+	wg.Wait()
+	return int(sent.Load())
+}
+
+// subscriptionClient sends the webhook and ntfy notifications. The targets
+// are set by API key holders, so it only connects to public addresses,
+// checked on every connection, redirects and DNS changes included, and
+// ignores the proxy settings, which would hide the address.
+var subscriptionClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				addr, err := netip.ParseAddrPort(address)
+				if err != nil || !publicIP(addr.Addr()) {
+					return fmt.Errorf("%s is not a public address", address)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// publicIP reports whether ip is a public unicast address, as the server
+// requires of the targets of subscriptions: not loopback, private,
+// link-local (cloud metadata), shared or reserved.
+func publicIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// nonPublicPrefixes are the ranges that IsGlobalUnicast and IsPrivate let
+// through but that are not reachable on the Internet, or translate to
+// addresses that may not be.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// sendConfirmations emails the confirmation link of the email subscriptions
+// created or changed since the last run, once per address. Without
+// --smtp-server or --confirm-url they wait, and get no notification.
+func sendConfirmations(ctx context.Context, db *sql.DB) {
+	if smtpServer == "" || confirmURL == "" {
+		return
+	}
+	type pending struct{ id, target, token string }
+	var list []pending
+	rows, err := db.QueryContext(ctx, "SELECT id, target, confirm_token FROM subscriptions WHERE channel = 'email' AND confirmed IS NULL AND confirm_sent IS NULL AND confirm_token IS NOT NULL")
+	if err != nil {
+		log.Printf("Failed to read the subscriptions to confirm: %v", err)
+		return
+	}
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.target, &p.token); err != nil {
+			log.Printf("Failed to read the subscriptions to confirm: %v", err)
+			break
+		}
+		list = append(list, p)
+	}
+	rows.Close()
+
+	for _, p := range list {
+		link := strings.TrimSuffix(confirmURL, "/") + "/subscriptions/confirm/" + p.token
+		message := "Someone subscribed this address to lottery draw notifications. Open this link to confirm and start receiving them:\r\n\r\n" + link +
+			"\r\n\r\nIf it was not you, ignore this email: nothing more will be sent."
+		mailCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := sendMail(mailCtx, []string{p.target}, "Confirm your draw notifications", message)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to send the confirmation of subscription %s: %v", p.id, err)
+			continue
+		}
+		_, err = db.ExecContext(ctx, "UPDATE subscriptions SET confirm_sent = ? WHERE id = ? AND confirm_token = ?", time.Now().UTC().Format(time.RFC3339), p.id, p.token)
+		if err != nil {
+			log.Printf("Failed to record the confirmation of subscription %s: %v", p.id, err)
+		}
+	}
+}
+
+// digestDraw is a draw of the digest's period; Gap is the longest absence,
+// in draws, that one of its balls ended.
+type digestDraw struct {
+	Date    string `json:"date"`
+	Numbers []int  `json:"numbers"`
+	Stars   []int  `json:"stars"`
+	Special bool   `json:"special"`
+	Gap     int    `json:"gap"`
+}
+
+// ballTrend is one of the most drawn balls at the end of the digest's
+// period: its draws and rank then, how many of the draws were in the
+// period, and its rank before it.
+type ballTrend struct {
+	Ball         int `json:"ball"`
+	Draws        int `json:"draws"`
+	Added        int `json:"added"`
+	Rank         int `json:"rank"`
+	PreviousRank int `json:"previous_rank"`
+}
+
+// digestNotification is the JSON body POSTed to webhook subscriptions with
+// the weekly or monthly event. From and To are the first and last days of
+// the period.
+type digestNotification struct {
+	Event   string       `json:"event"`
+	Game    string       `json:"game"`
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Draws   []digestDraw `json:"draws"`
+	Numbers []ballTrend  `json:"numbers"`
+	Stars   []ballTrend  `json:"stars"`
+	Notable []string     `json:"notable"`
+	Message string       `json:"message"`
+}
+
+// digestTop is how many of the most drawn numbers and stars a digest lists.
+const digestTop = 5
+
+// digestPeriodRange returns the first day and the day after the last day of
+// the period ending before today: the 7 previous days, or the previous month.
+func digestPeriodRange(period string, today time.Time) (from, to time.Time, err error) {
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case "weekly":
+		return today.AddDate(0, 0, -7), today, nil
+	case "monthly":
+		to = today.AddDate(0, 0, 1-today.Day())
+		return to.AddDate(0, -1, 0), to, nil
+	}
+	return from, to, fmt.Errorf("invalid period %q (use weekly or monthly)", period)
+}
+
+// buildDigest summarizes the draws of [from, to): the draws with the longest
+// absence each ended, the most drawn numbers and stars with their movement,
+// and the notable draws, special ones and those ending a long absence.
+func buildDigest(g *game, stored map[string]storedDraw, event string, from, to time.Time) digestNotification {
+	d := digestNotification{
+		Event:   event,
+		Game:    g.id,
+		From:    from.Format("2006-01-02"),
+		To:      to.AddDate(0, 0, -1).Format("2006-01-02"),
+		Draws:   []digestDraw{},
+		Notable: []string{},
+	}
+	dates := slices.Sorted(maps.Keys(stored))
+	numberDraws, starDraws := make([]int, g.maxNumber+1), make([]int, g.maxStar+1)
+	var numbersBefore, starsBefore []int
+	lastSeen := map[int]int{} // ball (stars offset by maxNumber) -> index of its last draw
+	for i, date := range dates {
+		if date >= d.From && numbersBefore == nil {
+			numbersBefore, starsBefore = slices.Clone(numberDraws), slices.Clone(starDraws)
+		}
+		if date > d.To {
+			break
+		}
+		draw := stored[date]
+		gap := 0
+		for j, ball := range draw.balls {
+			key := ball
+			if j >= g.numbers {
+				key += g.maxNumber
+				if ball < len(starDraws) {
+					starDraws[ball]++
+				}
+			} else if ball < len(numberDraws) {
+				numberDraws[ball]++
+			}
+			if last, ok := lastSeen[key]; ok {
+				gap = max(gap, i-last-1)
+			}
+			lastSeen[key] = i
+		}
+		if date >= d.From {
+			d.Draws = append(d.Draws, digestDraw{
+				Date: date, Numbers: draw.balls[:g.numbers], Stars: draw.balls[g.numbers:],
+				Special: draw.special, Gap: gap,
+			})
+		}
+	}
+	if numbersBefore == nil {
+		numbersBefore, starsBefore = slices.Clone(numberDraws), slices.Clone(starDraws)
+	}
+	d.Numbers = ballTrends(numberDraws, numbersBefore)
+	d.Stars = ballTrends(starDraws, starsBefore)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s %s digest, %s to %s\n\n", g.name, event, d.From, d.To)
+	if len(d.Draws) == 0 {
+		text.WriteString("No draws.\n")
+	}
+	longest := 0
+	for _, draw := range d.Draws {
+		longest = max(longest, draw.Gap)
+	}
+	for _, draw := range d.Draws {
+		fmt.Fprintf(&text, "%s: %s + %s\n", draw.Date, joinInts(draw.Numbers), joinInts(draw.Stars))
+		if draw.Special {
+			d.Notable = append(d.Notable, fmt.Sprintf("The draw of %s was a special draw.", draw.Date))
+		}
+		if longest > 0 && draw.Gap == longest {
+			d.Notable = append(d.Notable, fmt.Sprintf("The draw of %s brought back a ball absent for %d draws.", draw.Date, draw.Gap))
+		}
+	}
+	for _, list := range []struct {
+		label  string
+		trends []ballTrend
+	}{{"Most drawn numbers", d.Numbers}, {"Most drawn stars", d.Stars}} {
+		fmt.Fprintf(&text, "\n%s:\n", list.label)
+		for _, t := range list.trends {
+			fmt.Fprintf(&text, "  #%d %d: %d draws (+%d, was #%d)\n", t.Rank, t.Ball, t.Draws, t.Added, t.PreviousRank)
+		}
+	}
+	if len(d.Notable) > 0 {
+		text.WriteString("\n" + strings.Join(d.Notable, "\n") + "\n")
+	}
+	d.Message = text.String()
+	return d
+}
+
+// ballTrends returns the digestTop balls drawn the most often, given the
+// draws of each ball (indexed by ball) at the end and at the start of the
+// period. Ties are ranked by ball.
+func ballTrends(after, before []int) []ballTrend {
+	rank := func(counts []int) []int {
+		balls := make([]int, 0, len(counts))
+		for ball := 1; ball < len(counts); ball++ {
+			balls = append(balls, ball)
+		}
+		slices.SortStableFunc(balls, func(a, b int) int { return counts[b] - counts[a] })
+		ranks := make([]int, len(counts))
+		for i, ball := range balls {
+			ranks[ball] = i + 1
+		}
+		return ranks
+	}
+	ranks, previous := rank(after), rank(before)
+	trends := []ballTrend{}
+	for ball := 1; ball < len(after); ball++ {
+		if ranks[ball] <= digestTop {
+			trends = append(trends, ballTrend{
+				Ball: ball, Draws: after[ball], Added: after[ball] - before[ball],
+				Rank: ranks[ball], PreviousRank: previous[ball],
+			})
+		}
+	}
+	slices.SortFunc(trends, func(a, b ballTrend) int { return a.Rank - b.Rank })
+	return trends
+}
+
+// cmdDigest sends the --period digest of the game to its subscriptions with
+// the weekly or monthly event, or prints it with --dry-run. Run it from cron
+// after the period, e.g. on Monday mornings for weekly. Failed deliveries are
+// logged and do not fail the run.
+func cmdDigest(args []string) {
+	if databasePath == "" {
+		digestCmd.printHelp()
+		os.Exit(exitUsage)
+	}
+	from, to, err := digestPeriodRange(digestPeriod, time.Now().UTC())
+	if err != nil {
+		fatal(exitUsage, "Invalid --period: %v", err)
+	}
+
+	ctx, g, db := setup()
+	defer db.Close()
+	if !dryRun {
+		sendConfirmations(ctx, db)
+	}
+
+	stored, err := loadStoredDraws(ctx, db, g)
+	if err != nil {
+		fatal(exitDB, "Failed to read the stored draws: %v", err)
+	}
+	digest := buildDigest(g, stored, digestPeriod, from, to)
+	if dryRun {
+		fmt.Print(digest.Message)
+		return
+	}
+
+	subs, err := loadSubscriptions(ctx, db, g)
+	if err != nil {
+		fatal(exitDB, "Failed to read the subscriptions: %v", err)
+	}
+	title := fmt.Sprintf("%s %s digest, %s to %s", g.name, digestPeriod, digest.From, digest.To)
+	var deliveries []delivery
+	for _, s := range subs {
+		if slices.Contains(s.events, digestPeriod) {
+			deliveries = append(deliveries, delivery{sub: s, title: title, message: digest.Message, body: digest})
+		}
 	}
+	sent := deliverAll(ctx, deliveries)
+	log.Printf("Sent the %s %s digest to %d subscriptions", g.name, digestPeriod, sent)
+}
 
-	if outputFile != "" {
-		logFile, err := os.OpenFile(outputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// parseYears parses the --years flag: a year, a range such as 2004-2012, or a
+// comma-separated list of those.
+func parseYears(value string, first, last int) ([]int, error) {
+	if value == "" {
+		value = fmt.Sprintf("%d-%d", first, last)
+	}
+	var years []int
+	for _, part := range strings.Split(value, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		a, err := strconv.Atoi(from)
 		if err != nil {
-			log.Fatalf("Failed to open log file: %v", err)
+			return nil, fmt.Errorf("invalid year %q", part)
 		}
-		defer logFile.Close()
-		log.SetOutput(logFile)
+		b := a
+		if isRange {
+			if b, err = strconv.Atoi(to); err != nil || b < a {
+				return nil, fmt.Errorf("invalid year range %q", part)
+			}
+		}
+		for y := a; y <= b; y++ {
+			years = append(years, y)
+		}
+	}
+	return years, nil
+}
+
+// cmdBackfill inserts the draws of the yearly archives that are missing from
+// the database. Stored draws that differ from the archive are only reported:
+// use verify --repair to fix them.
+func cmdBackfill(args []string) {
+	if databasePath == "" {
+		backfillCmd.printHelp()
+		os.Exit(exitUsage)
+	}
+
+	ctx, g, db := setup()
+	defer db.Close()
+	if g.history == nil {
+		fatal(exitUsage, "%s has no yearly archive to backfill from", g.name)
+	}
+	years, err := parseYears(backfillYears, g.firstYear, time.Now().In(drawLocation).Year())
+	if err != nil {
+		fatal(exitUsage, "Invalid --years: %v", err)
+	}
+
+	stored := map[string][]int{}
+	rows, err := db.QueryContext(ctx, "SELECT date, "+strings.Join(g.ballColumns(), ", ")+" FROM "+g.table)
+	if err != nil {
+		fatal(exitDB, "Database query error: %v", err)
+	}
+	for rows.Next() {
+		var date string
+		balls := make([]int, g.numbers+g.stars)
+		dest := []any{&date}
+		for i := range balls {
+			dest = append(dest, &balls[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			fatal(exitDB, "Database query error: %v", err)
+		}
+		slices.Sort(balls[:g.numbers])
+		slices.Sort(balls[g.numbers:])
+		stored[date] = balls
+	}
+	rows.Close()
+
+	var pending []pendingDraw
+	differing, failures := 0, 0
+	for _, year := range years {
+		yearCtx, cancel := withTimeout(ctx)
+		draws, err := g.history(yearCtx, year)
+		cancel()
+		if ctx.Err() != nil {
+			fatal(exitScrape, "Backfill interrupted")
+		}
+		if err != nil {
+			log.Printf("Year %d: %v", year, err)
+			failures++
+			continue
+		}
+		for _, d := range draws {
+			if len(d.Numbers) != g.numbers+g.stars {
+				log.Printf("Skipping %s: expected %d numbers, got %d", d.Date, g.numbers+g.stars, len(d.Numbers))
+				continue
+			}
+			balls, drawnOrder, err := g.normalizeBalls(d.Numbers)
+			if err != nil {
+				log.Printf("Skipping %s: %v", d.Date, err)
+				continue
+			}
+			if err := g.validateBalls(balls); err != nil {
+				log.Printf("Skipping %s: %v", d.Date, err)
+				continue
+			}
+			if old, ok := stored[d.Date]; ok {
+				if !slices.Equal(old, balls) {
+					log.Printf("Stored draw of %s (%s) differs from the archive (%s); check it with verify --date %s", d.Date, joinInts(old), joinInts(balls), d.Date)
+					differing++
+				}
+				continue
+			}
+			if dryRun || verboseFlag {
+				log.Printf("Missing draw of %s: %s", d.Date, joinInts(balls))
+			}
+			pending = append(pending, pendingDraw{date: d.Date, balls: balls, special: d.Special, drawnOrder: drawnOrder})
+			stored[d.Date] = balls
+		}
+	}
+	inserted := len(pending)
+
+	// All the years are written at once: an interrupted backfill leaves the
+	// database as it was.
+	if !dryRun && inserted > 0 {
+		if err := storeDraws(ctx, db, g, pending, logProgress); err != nil {
+			fatal(exitDB, "Backfill rolled back: %v", err)
+		}
+	}
+
+	verb := "Inserted"
+	if dryRun {
+		verb = "Found"
+	}
+	log.Printf("%s %d missing draws; %d stored draws differ from the archive", verb, inserted, differing)
+
+	switch {
+	case failures == len(years):
+		os.Exit(exitScrape)
+	case inserted > 0 && !dryRun:
+		os.Exit(exitInserted)
+	default:
+		os.Exit(exitUpToDate)
+	}
+}
+
+// remoteDraw is a draw in the JSON of another instance of the API.
+type remoteDraw struct {
+	Date         string `json:"date"`
+	Numbers      []int  `json:"numbers"`
+	Stars        []int  `json:"stars"`
+	Special      bool   `json:"special"`
+	DrawnNumbers []int  `json:"drawn_numbers"`
+	DrawnStars   []int  `json:"drawn_stars"`
+}
+
+// syncDraws is the response of the upstream's /sync endpoint.
+type syncDraws struct {
+	Version string       `json:"version"`
+	Results []remoteDraw `json:"results"`
+}
+
+// fetchJSON gets a JSON document from another instance of the API, with
+// --upstream-key.
+func fetchJSON(ctx context.Context, rawURL string, v any) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	headers := map[string]string{
+		"Accept":     "application/json",
+		"User-Agent": robotsAgent + "/" + version,
+	}
+	if upstreamKey != "" {
+		headers["X-API-Key"] = upstreamKey
+	}
+	body, status, err := httpGet(ctx, rawURL, headers)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("HTTP %d: %s", status, strings.TrimSpace(body))
+	}
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	return nil
+}
+
+// fetchSync gets a /sync response from the upstream.
+func fetchSync(ctx context.Context, rawURL string) (*syncDraws, error) {
+	var sync syncDraws
+	if err := fetchJSON(ctx, rawURL, &sync); err != nil {
+		return nil, err
+	}
+	if sync.Version == "" {
+		return nil, fmt.Errorf("invalid response: no dataset version")
+	}
+	return &sync, nil
+}
+
+// remoteBalls checks a draw of another instance and returns its balls, see
+// normalizeBalls.
+func (g *game) remoteBalls(r remoteDraw) (balls []int, drawnOrder any, err error) {
+	if _, err := time.Parse("2006-01-02", r.Date); err != nil {
+		return nil, nil, fmt.Errorf("%q is not a date", r.Date)
+	}
+	numbers, stars := r.Numbers, r.Stars
+	if len(r.DrawnNumbers) == len(numbers) && len(r.DrawnStars) == len(stars) {
+		numbers, stars = r.DrawnNumbers, r.DrawnStars
+	}
+	if len(numbers) != g.numbers || len(stars) != g.stars {
+		return nil, nil, fmt.Errorf("expected %d numbers and %d stars, got %d and %d", g.numbers, g.stars, len(numbers), len(stars))
+	}
+	var scraped []string
+	for _, n := range append(slices.Clone(numbers), stars...) {
+		scraped = append(scraped, strconv.Itoa(n))
 	}
+	if balls, drawnOrder, err = g.normalizeBalls(scraped); err != nil {
+		return nil, nil, err
+	}
+	if err := g.validateBalls(balls); err != nil {
+		return nil, nil, err
+	}
+	return balls, drawnOrder, nil
+}
+
+// mirrorSummary is the --json summary of a mirror run.
+type mirrorSummary struct {
+	Game     string `json:"game"`
+	Upstream string `json:"upstream"`
+	Version  string `json:"version"`
+	Inserted int    `json:"inserted"`
+	Updated  int    `json:"updated"`
+	Deleted  int    `json:"deleted"`
+	Skipped  int    `json:"skipped"`
+}
+
+// storedDraw is a stored draw, as compared with another source.
+type storedDraw struct {
+	balls   []int
+	special bool
+}
 
-	db, err := sql.Open("sqlite3", databasePath)
+// loadStoredDraws returns the stored draws of the game by date, with their
+// balls sorted.
+func loadStoredDraws(ctx context.Context, db *sql.DB, g *game) (map[string]storedDraw, error) {
+	rows, err := db.QueryContext(ctx, "SELECT date, "+strings.Join(g.ballColumns(), ", ")+", special FROM "+g.table)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	defer rows.Close()
+	stored := map[string]storedDraw{}
+	for rows.Next() {
+		var date string
+		var d storedDraw
+		d.balls = make([]int, g.numbers+g.stars)
+		dest := []any{&date}
+		for i := range d.balls {
+			dest = append(dest, &d.balls[i])
+		}
+		dest = append(dest, &d.special)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		slices.Sort(d.balls[:g.numbers])
+		slices.Sort(d.balls[g.numbers:])
+		stored[date] = d
+	}
+	return stored, rows.Err()
+}
+
+// cmdMirror makes the game's draws a copy of the upstream's. The upstream's
+// dataset version is checked first, so an unchanged upstream costs a single
+// small request; otherwise all its draws are fetched, and the local draws that
+// are missing, differ or are gone upstream are inserted, corrected or deleted
+// in the usual way, with the audit log and the statistics. Exit codes: 0 when
+// nothing changed, 1 when draws were written, 2 when the upstream could not be
+// read, 4 on database errors.
+func cmdMirror(args []string) {
+	if databasePath == "" || upstreamURL == "" {
+		mirrorCmd.printHelp()
+		os.Exit(exitUsage)
+	}
+	base, err := url.Parse(strings.TrimRight(upstreamURL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		fatal(exitUsage, "Invalid --upstream: %s", upstreamURL)
 	}
+
+	ctx, g, db := setup()
 	defer db.Close()
-	
-	if siteIDStr == "all" {
-		sitesToUpdate := []int{1, 2, 3, 4, 5}
-		for _, id := range sitesToUpdate {
-			if err := runUpdate(db, id); err != nil {
-				log.Printf("Error processing site %d: %v", id, err)
+	lockUpdates(g)
+
+	syncURL := base.String() + "/games/" + g.id + "/sync?format=json"
+	probe, err := fetchSync(ctx, syncURL+"&since=9999-12-31")
+	if err != nil {
+		fatal(exitScrape, "Failed to reach the upstream %s: %v", base, err)
+	}
+	var synced string
+	err = db.QueryRowContext(ctx, "SELECT version FROM mirror_state WHERE game = ? AND upstream = ?", g.id, base.String()).Scan(&synced)
+	if err != nil && err != sql.ErrNoRows {
+		fatal(exitDB, "Database query error: %v", err)
+	}
+	summary := mirrorSummary{Game: g.id, Upstream: base.String(), Version: probe.Version}
+	if synced == probe.Version {
+		log.Printf("The %s draws are up to date with %s (version %s)", g.name, base, probe.Version)
+		printMirrorSummary(summary)
+		os.Exit(exitUpToDate)
+	}
+
+	upstream, err := fetchSync(ctx, syncURL)
+	if err != nil {
+		fatal(exitScrape, "Failed to fetch the draws of %s: %v", base, err)
+	}
+	// An empty upstream is more likely a broken one than a reset; copying it
+	// would delete every draw.
+	if len(upstream.Results) == 0 {
+		fatal(exitScrape, "The upstream %s has no %s draws", base, g.name)
+	}
+	summary.Version = upstream.Version
+
+	stored, err := loadStoredDraws(ctx, db, g)
+	if err != nil {
+		fatal(exitDB, "Database query error: %v", err)
+	}
+
+	var pending []pendingDraw
+	seen := map[string]bool{}
+	for _, r := range upstream.Results {
+		if seen[r.Date] {
+			log.Printf("Skipping %s: listed twice", r.Date)
+			summary.Skipped++
+			continue
+		}
+		seen[r.Date] = true
+		// A date the upstream lists is not deleted here, even when its draw
+		// is skipped: a malformed draw must not cost the good local copy.
+		old, exists := stored[r.Date]
+		delete(stored, r.Date)
+
+		balls, drawnOrder, err := g.remoteBalls(r)
+		if err != nil {
+			log.Printf("Skipping %s: %v", r.Date, err)
+			summary.Skipped++
+			continue
+		}
+		if exists && slices.Equal(old.balls, balls) && old.special == r.Special {
+			continue
+		}
+		if exists {
+			log.Printf("Correcting %s: %s -> %s", r.Date, joinInts(old.balls), joinInts(balls))
+			summary.Updated++
+		} else {
+			if verboseFlag {
+				log.Printf("New draw of %s: %s", r.Date, joinInts(balls))
+			}
+			summary.Inserted++
+		}
+		pending = append(pending, pendingDraw{date: r.Date, exists: exists, balls: balls, special: r.Special, drawnOrder: drawnOrder})
+	}
+
+	// The inserts, corrections, deletions and the version copied are written
+	// together: an interrupted mirror leaves the database as it was.
+	err = inTransaction(ctx, db, func(tx *sql.Tx) error {
+		// What is left was deleted upstream.
+		for date := range stored {
+			if _, err := removeDraw(ctx, tx, g, date); err != nil {
+				return fmt.Errorf("draw of %s: %v", date, err)
+			}
+			log.Printf("Deleting %s, gone from the upstream", date)
+			summary.Deleted++
+		}
+		if err := writeDraws(ctx, tx, g, pending, logProgress); err != nil {
+			return err
+		}
+		// writeDraws recomputes the statistics after a correction only.
+		if summary.Deleted > 0 && summary.Updated == 0 {
+			if err := rebuildStats(ctx, tx, g); err != nil {
+				return fmt.Errorf("statistics: %v", err)
+			}
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO mirror_state (game, upstream, version, synced) VALUES (?, ?, ?, ?)
+			ON CONFLICT (game) DO UPDATE SET upstream = excluded.upstream, version = excluded.version, synced = excluded.synced`,
+			g.id, base.String(), upstream.Version, time.Now().UTC().Format(time.RFC3339))
+		return err
+	})
+	if err != nil {
+		fatal(exitDB, "Mirror rolled back: %v", err)
+	}
+
+	log.Printf("Mirrored %s from %s: %d inserted, %d corrected, %d deleted, %d skipped", g.name, base, summary.Inserted, summary.Updated, summary.Deleted, summary.Skipped)
+	printMirrorSummary(summary)
+	if summary.Inserted+summary.Updated+summary.Deleted > 0 {
+		os.Exit(exitInserted)
+	}
+	os.Exit(exitUpToDate)
+}
+
+// printMirrorSummary prints the summary of a mirror run with --json.
+func printMirrorSummary(summary mirrorSummary) {
+	if !jsonOutput {
+		return
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(summary); err != nil {
+		log.Printf("Failed to write JSON summary: %v", err)
+	}
+}
+
+// drawBalls is a draw in an import report.
+type drawBalls struct {
+	Numbers []int `json:"numbers"`
+	Stars   []int `json:"stars"`
+	Special bool  `json:"special"`
+}
+
+// importConflict is a stored draw that differs from the imported one.
+type importConflict struct {
+	Date     string    `json:"date"`
+	Stored   drawBalls `json:"stored"`
+	Imported drawBalls `json:"imported"`
+	Replaced bool      `json:"replaced"`
+}
+
+// importReport is the --json report of an import.
+type importReport struct {
+	Game      string           `json:"game"`
+	Source    string           `json:"source"`
+	DryRun    bool             `json:"dry_run"`
+	Draws     int              `json:"draws"`
+	Inserted  int              `json:"inserted"`
+	Replaced  int              `json:"replaced"`
+	Unchanged int              `json:"unchanged"`
+	Skipped   int              `json:"skipped"`
+	LocalOnly int              `json:"local_only"`
+	Conflicts []importConflict `json:"conflicts"`
+}
+
+// cmdImport merges the draws served by another instance of the API, its
+// /results or /sync JSON: missing draws are inserted, and stored draws that
+// differ, in their balls or their special flag, are reported as conflicts and
+// overwritten with --replace. Stored draws the other instance lacks are kept.
+// Exit codes: 0 when nothing changed, 1 when draws were written, 2 when the
+// URL could not be read, 3 when conflicts were left as they were, 4 on
+// database errors.
+func cmdImport(args []string) {
+	if databasePath == "" || fromURL == "" {
+		importCmd.printHelp()
+		os.Exit(exitUsage)
+	}
+	if u, err := url.Parse(fromURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fatal(exitUsage, "Invalid --from-url: %s", fromURL)
+	}
+
+	ctx, g, db := setup()
+	defer db.Close()
+
+	// The results come as a list, or wrapped in an object (?envelope=true, /sync).
+	var raw json.RawMessage
+	if err := fetchJSON(ctx, fromURL, &raw); err != nil {
+		fatal(exitScrape, "Failed to fetch %s: %v", fromURL, err)
+	}
+	var draws []remoteDraw
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		if err := json.Unmarshal(raw, &draws); err != nil {
+			fatal(exitScrape, "Invalid results at %s: %v", fromURL, err)
+		}
+	} else {
+		var wrapped struct {
+			Results []remoteDraw `json:"results"`
+		}
+		if err := json.Unmarshal(raw, &wrapped); err != nil {
+			fatal(exitScrape, "Invalid results at %s: %v", fromURL, err)
+		}
+		draws = wrapped.Results
+	}
+	log.Printf("%s: %d draws", fromURL, len(draws))
+
+	stored, err := loadStoredDraws(ctx, db, g)
+	if err != nil {
+		fatal(exitDB, "Database query error: %v", err)
+	}
+
+	report := importReport{Game: g.id, Source: fromURL, DryRun: dryRun, Draws: len(draws), Conflicts: []importConflict{}}
+	var pending []pendingDraw
+	seen := map[string]bool{}
+	for _, r := range draws {
+		balls, drawnOrder, err := g.remoteBalls(r)
+		if err != nil {
+			log.Printf("Skipping %s: %v", r.Date, err)
+			report.Skipped++
+			continue
+		}
+		if seen[r.Date] {
+			log.Printf("Skipping %s: listed twice", r.Date)
+			report.Skipped++
+			continue
+		}
+		seen[r.Date] = true
+
+		old, exists := stored[r.Date]
+		switch {
+		case exists && slices.Equal(old.balls, balls) && old.special == r.Special:
+			report.Unchanged++
+			continue
+		case exists:
+			conflict := importConflict{
+				Date:     r.Date,
+				Stored:   drawBalls{Numbers: old.balls[:g.numbers], Stars: old.balls[g.numbers:], Special: old.special},
+				Imported: drawBalls{Numbers: balls[:g.numbers], Stars: balls[g.numbers:], Special: r.Special},
+				Replaced: replaceFlag,
+			}
+			report.Conflicts = append(report.Conflicts, conflict)
+			if !replaceFlag {
+				log.Printf("Conflict on %s: stored %s, imported %s", r.Date, joinInts(old.balls), joinInts(balls))
+				continue
+			}
+			log.Printf("Replacing draw of %s (%s) with the imported one (%s)", r.Date, joinInts(old.balls), joinInts(balls))
+			report.Replaced++
+		default:
+			if verboseFlag {
+				log.Printf("Inserting draw of %s: %s", r.Date, joinInts(balls))
+			}
+			report.Inserted++
+		}
+		pending = append(pending, pendingDraw{date: r.Date, exists: exists, balls: balls, special: r.Special, drawnOrder: drawnOrder})
+	}
+	for date := range stored {
+		if !seen[date] {
+			report.LocalOnly++
+		}
+	}
+
+	// The whole import is one transaction: an interrupted import leaves the
+	// database as it was.
+	if !dryRun && len(pending) > 0 {
+		if err := storeDraws(ctx, db, g, pending, logProgress); err != nil {
+			fatal(exitDB, "Import rolled back: %v", err)
+		}
+	}
+
+	if dryRun {
+		log.Printf("Would insert %d draws and replace %d; %d conflicts, %d unchanged, %d skipped, %d only stored here",
+			report.Inserted, report.Replaced, len(report.Conflicts), report.Unchanged, report.Skipped, report.LocalOnly)
+	} else {
+		log.Printf("Inserted %d draws and replaced %d; %d conflicts, %d unchanged, %d skipped, %d only stored here",
+			report.Inserted, report.Replaced, len(report.Conflicts), report.Unchanged, report.Skipped, report.LocalOnly)
+	}
+	if jsonOutput {
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			log.Printf("Failed to write JSON report: %v", err)
+		}
+	}
+	switch {
+	case len(report.Conflicts) > 0 && !replaceFlag:
+		os.Exit(exitValidation)
+	case len(pending) > 0 && !dryRun:
+		os.Exit(exitInserted)
+	default:
+		os.Exit(exitUpToDate)
+	}
+}
+
+// fdjDateFormats are the date layouts used by the successive FDJ archives.
+var fdjDateFormats = []string{"02/01/2006", "20060102", "02/01/06", "2006-01-02"}
+
+// readFDJFile reads the draws of an FDJ archive: a ZIP holding one or more CSV
+// files, or a CSV file.
+func readFDJFile(path string) ([]scrapedDraw, error) {
+	if !strings.EqualFold(filepath.Ext(path), ".zip") {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return readFDJCSV(f)
+	}
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var draws []scrapedDraw
+	for _, f := range zr.File {
+		if !strings.EqualFold(filepath.Ext(f.Name), ".csv") {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		d, err := readFDJCSV(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		draws = append(draws, d...)
+	}
+	return draws, nil
+}
+
+// readFDJCSV reads an FDJ history CSV. The archives of the different eras
+// order their columns differently, so the columns are found by name in the
+// header: date_de_tirage, boule_1 to boule_5 and etoile_1 to etoile_2, the
+// balls in the order they were drawn.
+func readFDJCSV(r io.Reader) ([]scrapedDraw, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = ';'
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	names := []string{"date_de_tirage", "boule_1", "boule_2", "boule_3", "boule_4", "boule_5", "etoile_1", "etoile_2"}
+	cols := make([]int, len(names))
+	for i, name := range names {
+		col, ok := index[name]
+		if !ok {
+			return nil, fmt.Errorf("missing column %s", name)
+		}
+		cols[i] = col
+	}
+
+	var draws []scrapedDraw
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV record: %v", err)
+		}
+		if len(record) <= slices.Max(cols) {
+			continue
+		}
+
+		var t time.Time
+		value := strings.TrimSpace(record[cols[0]])
+		for _, layout := range fdjDateFormats {
+			if t, err = time.Parse(layout, value); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("date parsing error: %q", value)
+		}
+		d := scrapedDraw{Date: t.Format("2006-01-02")}
+		for _, col := range cols[1:] {
+			d.Numbers = append(d.Numbers, strings.TrimSpace(record[col]))
+		}
+		draws = append(draws, d)
+	}
+	return draws, nil
+}
+
+// cmdImportFDJ imports the FDJ archives given as arguments. Missing draws are
+// inserted; stored draws that differ are reported, and overwritten with --replace.
+func cmdImportFDJ(args []string) {
+	if databasePath == "" || len(args) == 0 {
+		importFDJCmd.flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	gameID = "euromillions"
+	ctx, g, db := setup()
+	defer db.Close()
+
+	stored, err := loadStoredDraws(ctx, db, g)
+	if err != nil {
+		fatal(exitDB, "Database query error: %v", err)
+	}
+
+	var pending []pendingDraw
+	replaced, differing := 0, 0
+	for _, path := range args {
+		draws, err := readFDJFile(path)
+		if err != nil {
+			fatal(exitValidation, "%s: %v", path, err)
+		}
+		log.Printf("%s: %d draws", path, len(draws))
+
+		for _, d := range draws {
+			balls, drawnOrder, err := g.normalizeBalls(d.Numbers)
+			if err != nil {
+				log.Printf("Skipping %s: %v", d.Date, err)
+				continue
+			}
+			if err := g.validateBalls(balls); err != nil {
+				log.Printf("Skipping %s: %v", d.Date, err)
+				continue
 			}
-			time.Sleep(1 * time.Second)
+			old, exists := stored[d.Date]
+			switch {
+			case exists && slices.Equal(old.balls, balls):
+				// Already stored; still record the drawn order the archive provides.
+				if drawnOrder != nil {
+					pending = append(pending, pendingDraw{date: d.Date, exists: true, balls: balls, special: old.special, drawnOrder: drawnOrder})
+				}
+				continue
+			case exists && !replaceFlag:
+				log.Printf("Stored draw of %s (%s) differs from the FDJ archive (%s)", d.Date, joinInts(old.balls), joinInts(balls))
+				differing++
+				continue
+			case exists:
+				log.Printf("Replacing draw of %s (%s) with the FDJ archive (%s)", d.Date, joinInts(old.balls), joinInts(balls))
+				replaced++
+			default:
+				if verboseFlag {
+					log.Printf("Inserting draw of %s: %s", d.Date, joinInts(balls))
+				}
+			}
+			pending = append(pending, pendingDraw{date: d.Date, exists: exists, balls: balls, special: old.special, drawnOrder: drawnOrder})
+			stored[d.Date] = storedDraw{balls: balls, special: old.special}
+		}
+	}
+	inserted := 0
+	for _, d := range pending {
+		if !d.exists {
+			inserted++
+		}
+	}
+
+	// The whole import is one transaction: an interrupted import leaves the
+	// database as it was.
+	if !dryRun && len(pending) > 0 {
+		if err := storeDraws(ctx, db, g, pending, logProgress); err != nil {
+			fatal(exitDB, "Import rolled back: %v", err)
 		}
+	}
+
+	if dryRun {
+		log.Printf("Would insert %d draws and replace %d; %d stored draws differ from the FDJ archives", inserted, replaced, differing)
 	} else {
-		siteID, err := strconv.Atoi(siteIDStr)
+		log.Printf("Inserted %d draws and replaced %d; %d stored draws differ from the FDJ archives", inserted, replaced, differing)
+	}
+	if (inserted > 0 || replaced > 0) && !dryRun {
+		os.Exit(exitInserted)
+	}
+	os.Exit(exitUpToDate)
+}
+
+// pushMetrics pushes the metrics of an update run to the Pushgateway, grouped
+// by job and game, in the Prometheus text format. Failures are only logged.
+func pushMetrics(g *game, summary updateSummary, duration time.Duration) {
+	if pushgatewayURL == "" {
+		return
+	}
+
+	var buf bytes.Buffer
+	inserted := 0
+	fmt.Fprintln(&buf, "# HELP euromillions_updater_run_duration_seconds Duration of the last update run.")
+	fmt.Fprintln(&buf, "# TYPE euromillions_updater_run_duration_seconds gauge")
+	fmt.Fprintf(&buf, "euromillions_updater_run_duration_seconds %g\n", duration.Seconds())
+	fmt.Fprintln(&buf, "# HELP euromillions_updater_source_success Whether the last fetch from a site succeeded (1) or failed (0).")
+	fmt.Fprintln(&buf, "# TYPE euromillions_updater_source_success gauge")
+	for _, run := range summary.Runs {
+		success := 0
+		if run.Error == "" {
+			success = 1
+		}
+		fmt.Fprintf(&buf, "euromillions_updater_source_success{site=\"%d\"} %d\n", run.Source, success)
+		if run.Inserted {
+			inserted++
+		}
+	}
+	fmt.Fprintln(&buf, "# HELP euromillions_updater_rows_inserted Rows inserted by the last update run.")
+	fmt.Fprintln(&buf, "# TYPE euromillions_updater_rows_inserted gauge")
+	fmt.Fprintf(&buf, "euromillions_updater_rows_inserted %d\n", inserted)
+	fmt.Fprintln(&buf, "# HELP euromillions_updater_last_run_timestamp_seconds Time of the last update run.")
+	fmt.Fprintln(&buf, "# TYPE euromillions_updater_last_run_timestamp_seconds gauge")
+	fmt.Fprintf(&buf, "euromillions_updater_last_run_timestamp_seconds %d\n", time.Now().Unix())
+
+	// PUT replaces all the metrics of the group, so sites that are no longer
+	// fetched do not linger.
+	target := strings.TrimSuffix(pushgatewayURL, "/") + "/metrics/job/euromillions_updater/game/" + url.PathEscape(g.id)
+	req, err := http.NewRequest(http.MethodPut, target, &buf)
+	if err != nil {
+		log.Printf("Failed to push metrics: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Failed to push metrics: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Pushgateway answered %s", resp.Status)
+	}
+}
+
+// spoolEntry is a validated draw that could not be stored, kept in the spool
+// directory until a later run stores it.
+type spoolEntry struct {
+	Game       string    `json:"game"`
+	Date       string    `json:"date"`
+	Balls      []int     `json:"balls"`
+	Special    bool      `json:"special"`
+	DrawnOrder any       `json:"drawn_order"`
+	Source     int       `json:"source"`
+	Spooled    time.Time `json:"spooled"`
+}
+
+// spoolPath returns the spool directory.
+func spoolPath() string {
+	if spoolDir != "" {
+		return spoolDir
+	}
+	return databasePath + ".spool"
+}
+
+// spoolDraw writes a draw to the spool directory.
+func spoolDraw(entry spoolEntry) error {
+	entry.Spooled = time.Now().UTC()
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(spoolPath(), 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(spoolPath(), entry.Game+"-"+entry.Date+".json")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// drainSpool stores the spooled draws of g that are not in the database yet,
+// removing each entry once it is stored or found already stored. It returns
+// the number of draws inserted.
+func drainSpool(ctx context.Context, db *sql.DB, g *game) int {
+	paths, _ := filepath.Glob(filepath.Join(spoolPath(), g.id+"-*.json"))
+	inserted := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
 		if err != nil {
-			log.Fatalf("Invalid site ID: %v", err)
+			log.Printf("Failed to read spooled draw %s: %v", path, err)
+			continue
+		}
+		var entry spoolEntry
+		if err := json.Unmarshal(data, &entry); err != nil || len(entry.Balls) != g.numbers+g.stars {
+			log.Printf("Ignoring invalid spooled draw %s", path)
+			continue
+		}
+
+		var count int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+g.table+" WHERE date = ?", entry.Date).Scan(&count); err != nil {
+			log.Printf("Spooled draw of %s is still pending: %v", entry.Date, err)
+			continue
+		}
+		if count == 0 {
+			spooled := pendingDraw{date: entry.Date, balls: entry.Balls, special: entry.Special, drawnOrder: entry.DrawnOrder}
+			if err := storeDraws(ctx, db, g, []pendingDraw{spooled}, nil); err != nil {
+				log.Printf("Spooled draw of %s is still pending: %v", entry.Date, err)
+				continue
+			}
+			log.Printf("Inserted the spooled %s draw of %s (site %d)", g.name, entry.Date, entry.Source)
+			inserted++
 		}
-		if err := runUpdate(db, siteID); err != nil {
-			log.Fatal(err)
+		if err := os.Remove(path); err != nil {
+			log.Printf("Failed to remove spooled draw %s: %v", path, err)
 		}
 	}
+	return inserted
 }
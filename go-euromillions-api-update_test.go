@@ -0,0 +1,674 @@
+package main
+
+// Tests of the updater's parsers and commands. The updater is a single file
+// next to the server, so the files of the package are listed:
+//
+//	go test go-euromillions-api-update.go instance_unix.go go-euromillions-api-update_test.go
+//
+// The commands exit when they are done, so the tests run them in a child
+// process: the test binary itself, started with UPDATER_ARGS.
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadFDJCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []scrapedDraw
+		wantErr string
+	}{{
+		name: "2004 archive",
+		csv: "annee_numero_de_tirage;jour_de_tirage;date_de_tirage;date_de_forclusion;boule_1;boule_2;boule_3;boule_4;boule_5;etoile_1;etoile_2;nombre_de_gagnant_au_rang1\n" +
+			"2004007;VE;20040213;20040413;16;29;32;36;41;7;9;0\n",
+		want: []scrapedDraw{{Date: "2004-02-13", Numbers: []string{"16", "29", "32", "36", "41", "7", "9"}}},
+	}, {
+		name: "2011 archive with a byte order mark",
+		csv: "\ufeffannee_numero_de_tirage;jour_de_tirage;date_de_tirage;date_de_forclusion;boule_1;boule_2;boule_3;boule_4;boule_5;etoile_1;etoile_2\n" +
+			"2011038;VE;13/05/2011;12/07/2011;31;4;23;49;17;3;8\n",
+		want: []scrapedDraw{{Date: "2011-05-13", Numbers: []string{"31", "4", "23", "49", "17", "3", "8"}}},
+	}, {
+		name: "2016 archive with two-digit years",
+		csv: "annee_numero_de_tirage;jour_de_tirage;date_de_tirage;date_de_forclusion;boule_1;boule_2;boule_3;boule_4;boule_5;etoile_1;etoile_2\n" +
+			"2016040;VE;20/05/16;19/07/16; 3 ; 12 ;20;33;48;02;11\n",
+		want: []scrapedDraw{{Date: "2016-05-20", Numbers: []string{"3", "12", "20", "33", "48", "02", "11"}}},
+	}, {
+		name: "2020 archive with reordered, upper-case columns",
+		csv: "ANNEE_NUMERO_DE_TIRAGE;DATE_DE_TIRAGE;ETOILE_1;ETOILE_2;BOULE_1;BOULE_2;BOULE_3;BOULE_4;BOULE_5;BOULES_GAGNANTES_EN_ORDRE_CROISSANT\n" +
+			"2020010;2020-02-04;12;5;50;1;27;8;44;1-8-27-44-50\n" +
+			"2020011;2020-02-07;3;4\n",
+		want: []scrapedDraw{{Date: "2020-02-04", Numbers: []string{"50", "1", "27", "8", "44", "12", "5"}}},
+	}, {
+		name:    "missing column",
+		csv:     "date_de_tirage;boule_1;boule_2;boule_3;boule_4;boule_5;etoile_1\n",
+		wantErr: "missing column etoile_2",
+	}, {
+		name: "invalid date",
+		csv: "date_de_tirage;boule_1;boule_2;boule_3;boule_4;boule_5;etoile_1;etoile_2\n" +
+			"2020/02/04;1;2;3;4;5;6;7\n",
+		wantErr: `date parsing error: "2020/02/04"`,
+	}, {
+		name:    "empty file",
+		wantErr: "failed to read CSV header: EOF",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readFDJCSV(strings.NewReader(tt.csv))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFDJFile(t *testing.T) {
+	header := "date_de_tirage;boule_1;boule_2;boule_3;boule_4;boule_5;etoile_1;etoile_2\n"
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "euromillions_202002.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, body := range map[string]string{
+		"euromillions_2019.csv": header + "03/01/2020;1;2;3;4;5;1;2\n",
+		"LISEZMOI.txt":          "Not a draw.\n",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	csvPath := filepath.Join(dir, "euromillions_202002.CSV")
+	if err := os.WriteFile(csvPath, []byte(header+"07/01/2020;6;7;8;9;10;3;4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want []scrapedDraw
+	}{
+		{zipPath, []scrapedDraw{{Date: "2020-01-03", Numbers: []string{"1", "2", "3", "4", "5", "1", "2"}}}},
+		{csvPath, []scrapedDraw{{Date: "2020-01-07", Numbers: []string{"6", "7", "8", "9", "10", "3", "4"}}}},
+	}
+	for _, tt := range tests {
+		got, err := readFDJFile(tt.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseYears(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []int
+		wantErr bool
+	}{
+		{"", []int{2004, 2005, 2006}, false},
+		{"2010", []int{2010}, false},
+		{"2010-2012", []int{2010, 2011, 2012}, false},
+		{"2004, 2010-2011", []int{2004, 2010, 2011}, false},
+		{"2012-2010", nil, true},
+		{"2010-", nil, true},
+		{"twenty", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseYears(tt.value, 2004, 2006)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseYears(%q): error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseYears(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeBalls(t *testing.T) {
+	tests := []struct {
+		name      string
+		game      *game
+		scraped   []string
+		want      []int
+		wantOrder any
+		wantErr   bool
+	}{
+		{"sorted", findGame("euromillions"), []string{"1", "2", "3", "4", "5", "1", "2"}, []int{1, 2, 3, 4, 5, 1, 2}, nil, false},
+		{"drawn order", findGame("euromillions"), []string{"50", "1", "27", "8", "44", "12", "5"}, []int{1, 8, 27, 44, 50, 5, 12}, "50,1,27,8,44,12,5", false},
+		{"leading zeros and spaces", findGame("euromillions"), []string{" 03", "12", "20", "33", "48", "02 ", "11"}, []int{3, 12, 20, 33, 48, 2, 11}, nil, false},
+		{"one star", findGame("thunderball"), []string{"39", "2", "3", "4", "5", "14"}, []int{2, 3, 4, 5, 39, 14}, "39,2,3,4,5,14", false},
+		{"not a number", findGame("euromillions"), []string{"1", "2", "3", "4", "five", "1", "2"}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			balls, drawnOrder, err := tt.game.normalizeBalls(tt.scraped)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(balls, tt.want) || drawnOrder != tt.wantOrder {
+				t.Errorf("got %v (drawn %v), want %v (drawn %v)", balls, drawnOrder, tt.want, tt.wantOrder)
+			}
+		})
+	}
+}
+
+func TestRemoteBalls(t *testing.T) {
+	g := findGame("euromillions")
+	tests := []struct {
+		name      string
+		draw      remoteDraw
+		want      []int
+		wantOrder any
+		wantErr   string
+	}{{
+		name: "sorted",
+		draw: remoteDraw{Date: "2020-02-04", Numbers: []int{1, 8, 27, 44, 50}, Stars: []int{5, 12}},
+		want: []int{1, 8, 27, 44, 50, 5, 12},
+	}, {
+		name:      "drawn order",
+		draw:      remoteDraw{Date: "2020-02-04", Numbers: []int{1, 8, 27, 44, 50}, Stars: []int{5, 12}, DrawnNumbers: []int{50, 1, 27, 8, 44}, DrawnStars: []int{12, 5}},
+		want:      []int{1, 8, 27, 44, 50, 5, 12},
+		wantOrder: "50,1,27,8,44,12,5",
+	}, {
+		name: "incomplete drawn order",
+		draw: remoteDraw{Date: "2020-02-04", Numbers: []int{1, 8, 27, 44, 50}, Stars: []int{5, 12}, DrawnNumbers: []int{50, 1}},
+		want: []int{1, 8, 27, 44, 50, 5, 12},
+	}, {
+		name:    "invalid date",
+		draw:    remoteDraw{Date: "04/02/2020", Numbers: []int{1, 8, 27, 44, 50}, Stars: []int{5, 12}},
+		wantErr: `"04/02/2020" is not a date`,
+	}, {
+		name:    "missing star",
+		draw:    remoteDraw{Date: "2020-02-04", Numbers: []int{1, 8, 27, 44, 50}, Stars: []int{5}},
+		wantErr: "expected 5 numbers and 2 stars, got 5 and 1",
+	}, {
+		name:    "star out of range",
+		draw:    remoteDraw{Date: "2020-02-04", Numbers: []int{1, 8, 27, 44, 50}, Stars: []int{5, 13}},
+		wantErr: "13 is out of range 1-12",
+	}, {
+		name:    "number out of range",
+		draw:    remoteDraw{Date: "2020-02-04", Numbers: []int{0, 8, 27, 44, 50}, Stars: []int{5, 12}},
+		wantErr: "0 is out of range 1-50",
+	}, {
+		name:    "repeated number",
+		draw:    remoteDraw{Date: "2020-02-04", Numbers: []int{8, 8, 27, 44, 50}, Stars: []int{5, 12}},
+		wantErr: "8 is repeated",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			balls, drawnOrder, err := g.remoteBalls(tt.draw)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(balls, tt.want) || drawnOrder != tt.wantOrder {
+				t.Errorf("got %v (drawn %v), want %v (drawn %v)", balls, drawnOrder, tt.want, tt.wantOrder)
+			}
+		})
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    *robotsRules
+		allowed map[string]bool
+	}{{
+		name: "own group",
+		body: "User-agent: *\nDisallow: /\n\nUser-agent: go-euromillions-api\nDisallow: /private # not the results\nAllow: /private/results\nCrawl-delay: 1.5\n",
+		want: &robotsRules{allow: []string{"/private/results"}, disallow: []string{"/private"}, crawlDelay: 1500 * time.Millisecond},
+		allowed: map[string]bool{
+			"/results":               true,
+			"/private/accounts":      false,
+			"/private/results/2020":  true,
+			"/private/results-draft": true,
+		},
+	}, {
+		name:    "wildcard group",
+		body:    "user-agent: otherbot\ndisallow: /\n\nuser-agent: *\ndisallow: /*.csv$\ndisallow:\n",
+		want:    &robotsRules{disallow: []string{"/*.csv$"}},
+		allowed: map[string]bool{"/results.csv": false, "/results.csv?year=2020": true, "/results": true},
+	}, {
+		name:    "shared group",
+		body:    "User-agent: otherbot\nUser-agent: Go-EuroMillions-API\nDisallow: /archive\n",
+		want:    &robotsRules{disallow: []string{"/archive"}},
+		allowed: map[string]bool{"/archive/2004": false, "/": true},
+	}, {
+		name:    "no group",
+		body:    "Sitemap: https://example.com/sitemap.xml\n",
+		want:    &robotsRules{},
+		allowed: map[string]bool{"/": true},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseRobots(tt.body)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			for path, want := range tt.allowed {
+				if got.allowed(path) != want {
+					t.Errorf("allowed(%s) = %v, want %v", path, !want, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBallTrends(t *testing.T) {
+	tests := []struct {
+		name          string
+		after, before []int
+		want          []ballTrend
+	}{{
+		name:   "ties ranked by ball",
+		after:  []int{0, 3, 5, 3, 1, 2, 4, 0},
+		before: []int{0, 3, 2, 1, 1, 2, 4, 0},
+		want: []ballTrend{
+			{Ball: 2, Draws: 5, Added: 3, Rank: 1, PreviousRank: 3},
+			{Ball: 6, Draws: 4, Added: 0, Rank: 2, PreviousRank: 1},
+			{Ball: 1, Draws: 3, Added: 0, Rank: 3, PreviousRank: 2},
+			{Ball: 3, Draws: 3, Added: 2, Rank: 4, PreviousRank: 5},
+			{Ball: 5, Draws: 2, Added: 0, Rank: 5, PreviousRank: 4},
+		},
+	}, {
+		name:   "fewer balls than the top",
+		after:  []int{0, 1, 2},
+		before: []int{0, 1, 0},
+		want: []ballTrend{
+			{Ball: 2, Draws: 2, Added: 2, Rank: 1, PreviousRank: 2},
+			{Ball: 1, Draws: 1, Added: 0, Rank: 2, PreviousRank: 1},
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ballTrends(tt.after, tt.before); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildDigest(t *testing.T) {
+	g := findGame("euromillions")
+	stored := map[string]storedDraw{
+		"2026-01-02": {balls: []int{1, 2, 3, 4, 5, 1, 2}},
+		"2026-01-06": {balls: []int{6, 7, 8, 9, 10, 3, 4}},
+		"2026-01-09": {balls: []int{1, 7, 8, 9, 10, 3, 4}},
+		"2026-01-13": {balls: []int{11, 12, 13, 14, 15, 5, 6}, special: true},
+		"2026-01-16": {balls: []int{16, 17, 18, 19, 20, 7, 8}},
+	}
+	tests := []struct {
+		name        string
+		from, to    time.Time
+		wantDraws   []digestDraw
+		wantNotable []string
+		wantNumbers []int // the balls of the trends, by rank
+		wantMessage string
+	}{{
+		name: "week with draws",
+		from: time.Date(2026, 1, 9, 0, 0, 0, 0, time.UTC),
+		to:   time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC),
+		wantDraws: []digestDraw{
+			{Date: "2026-01-09", Numbers: []int{1, 7, 8, 9, 10}, Stars: []int{3, 4}, Gap: 1},
+			{Date: "2026-01-13", Numbers: []int{11, 12, 13, 14, 15}, Stars: []int{5, 6}, Special: true},
+		},
+		wantNotable: []string{
+			"The draw of 2026-01-09 brought back a ball absent for 1 draws.",
+			"The draw of 2026-01-13 was a special draw.",
+		},
+		wantNumbers: []int{1, 7, 8, 9, 10},
+		wantMessage: "EuroMillions weekly digest, 2026-01-09 to 2026-01-15\n\n2026-01-09: 1, 7, 8, 9, 10 + 3, 4\n",
+	}, {
+		name:        "week without draws",
+		from:        time.Date(2026, 2, 2, 0, 0, 0, 0, time.UTC),
+		to:          time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC),
+		wantDraws:   []digestDraw{},
+		wantNotable: []string{},
+		wantNumbers: []int{1, 7, 8, 9, 10},
+		wantMessage: "EuroMillions weekly digest, 2026-02-02 to 2026-02-08\n\nNo draws.\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := buildDigest(g, stored, "weekly", tt.from, tt.to)
+			if !reflect.DeepEqual(d.Draws, tt.wantDraws) {
+				t.Errorf("draws = %+v, want %+v", d.Draws, tt.wantDraws)
+			}
+			if !reflect.DeepEqual(d.Notable, tt.wantNotable) {
+				t.Errorf("notable = %q, want %q", d.Notable, tt.wantNotable)
+			}
+			var numbers []int
+			for _, trend := range d.Numbers {
+				numbers = append(numbers, trend.Ball)
+			}
+			if !reflect.DeepEqual(numbers, tt.wantNumbers) {
+				t.Errorf("most drawn numbers = %v, want %v", numbers, tt.wantNumbers)
+			}
+			if !strings.HasPrefix(d.Message, tt.wantMessage) {
+				t.Errorf("message = %q, want it to start with %q", d.Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+// syncResponse is the /sync response of a test upstream.
+type syncResponse struct {
+	Version string       `json:"version"`
+	Results []remoteDraw `json:"results"`
+}
+
+func TestMirror(t *testing.T) {
+	tests := []struct {
+		name        string
+		local       map[string]string
+		upstream    syncResponse
+		wantCode    int
+		wantSummary mirrorSummary
+		want        map[string]string
+	}{{
+		name:  "new, corrected and deleted draws",
+		local: map[string]string{"2020-01-03": "1,2,3,4,5,1,2", "2020-01-07": "6,7,8,9,10,3,4", "2020-01-10": "11,12,13,14,15,5,6"},
+		upstream: syncResponse{Version: "v2", Results: []remoteDraw{
+			{Date: "2020-01-03", Numbers: []int{1, 2, 3, 4, 5}, Stars: []int{1, 2}},
+			{Date: "2020-01-07", Numbers: []int{6, 7, 8, 9, 11}, Stars: []int{3, 4}},
+			{Date: "2020-01-14", Numbers: []int{16, 17, 18, 19, 20}, Stars: []int{7, 8}},
+		}},
+		wantCode:    exitInserted,
+		wantSummary: mirrorSummary{Version: "v2", Inserted: 1, Updated: 1, Deleted: 1},
+		want:        map[string]string{"2020-01-03": "1,2,3,4,5,1,2", "2020-01-07": "6,7,8,9,11,3,4", "2020-01-14": "16,17,18,19,20,7,8"},
+	}, {
+		name:  "malformed and repeated draws are skipped",
+		local: map[string]string{"2020-01-03": "1,2,3,4,5,1,2", "2020-01-07": "6,7,8,9,10,3,4"},
+		upstream: syncResponse{Version: "v3", Results: []remoteDraw{
+			{Date: "2020-01-03", Numbers: []int{1, 2, 3, 4, 5}, Stars: []int{1, 13}},
+			{Date: "2020-01-07", Numbers: []int{6, 7, 8, 9, 10}, Stars: []int{3, 4}},
+			{Date: "2020-01-10", Numbers: []int{11, 12, 13, 14, 15}, Stars: []int{5, 6}},
+			{Date: "2020-01-10", Numbers: []int{21, 22, 23, 24, 25}, Stars: []int{9, 10}},
+		}},
+		wantCode:    exitInserted,
+		wantSummary: mirrorSummary{Version: "v3", Inserted: 1, Skipped: 2},
+		want:        map[string]string{"2020-01-03": "1,2,3,4,5,1,2", "2020-01-07": "6,7,8,9,10,3,4", "2020-01-10": "11,12,13,14,15,5,6"},
+	}, {
+		name:     "empty upstream",
+		local:    map[string]string{"2020-01-03": "1,2,3,4,5,1,2"},
+		upstream: syncResponse{Version: "v4", Results: []remoteDraw{}},
+		wantCode: exitScrape,
+		want:     map[string]string{"2020-01-03": "1,2,3,4,5,1,2"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/games/euromillions/sync" {
+					http.NotFound(w, r)
+					return
+				}
+				resp := tt.upstream
+				if r.URL.Query().Get("since") != "" {
+					resp.Results = []remoteDraw{}
+				}
+				json.NewEncoder(w).Encode(resp)
+			}))
+			defer upstream.Close()
+
+			path := testDB(t, tt.local)
+			code, stdout := runUpdater(t, "mirror", "--database", path, "--upstream", upstream.URL, "--json")
+			if code != tt.wantCode {
+				t.Fatalf("exit code %d, want %d", code, tt.wantCode)
+			}
+			if tt.wantCode != exitScrape {
+				var summary mirrorSummary
+				if err := json.Unmarshal(stdout, &summary); err != nil {
+					t.Fatalf("summary %q: %v", stdout, err)
+				}
+				tt.wantSummary.Game, tt.wantSummary.Upstream = "euromillions", upstream.URL
+				if summary != tt.wantSummary {
+					t.Errorf("summary %+v, want %+v", summary, tt.wantSummary)
+				}
+			}
+			if got := storedBalls(t, path); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stored %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	local := map[string]string{"2020-01-03": "1,2,3,4,5,1,2", "2020-01-07": "6,7,8,9,10,3,4"}
+	draws := `[
+		{"date": "2020-01-03", "numbers": [1, 2, 3, 4, 5], "stars": [1, 2]},
+		{"date": "2020-01-07", "numbers": [6, 7, 8, 9, 11], "stars": [3, 4]},
+		{"date": "2020-01-10", "numbers": [11, 12, 13, 14, 15], "stars": [5, 6]},
+		{"date": "2020-01-14", "numbers": [16, 17, 18, 19, 20], "stars": [7, 13]}
+	]`
+	tests := []struct {
+		name       string
+		body       string
+		args       []string
+		wantCode   int
+		wantReport importReport
+		want       map[string]string
+	}{{
+		name:       "conflicts are reported",
+		body:       draws,
+		wantCode:   exitValidation,
+		wantReport: importReport{Draws: 4, Inserted: 1, Unchanged: 1, Skipped: 1},
+		want:       map[string]string{"2020-01-03": "1,2,3,4,5,1,2", "2020-01-07": "6,7,8,9,10,3,4", "2020-01-10": "11,12,13,14,15,5,6"},
+	}, {
+		name:       "conflicts are replaced",
+		body:       `{"results": ` + draws + `}`,
+		args:       []string{"--replace"},
+		wantCode:   exitInserted,
+		wantReport: importReport{Draws: 4, Inserted: 1, Replaced: 1, Unchanged: 1, Skipped: 1},
+		want:       map[string]string{"2020-01-03": "1,2,3,4,5,1,2", "2020-01-07": "6,7,8,9,11,3,4", "2020-01-10": "11,12,13,14,15,5,6"},
+	}, {
+		name:       "dry run",
+		body:       draws,
+		args:       []string{"--replace", "--dry-run"},
+		wantCode:   exitUpToDate,
+		wantReport: importReport{DryRun: true, Draws: 4, Inserted: 1, Replaced: 1, Unchanged: 1, Skipped: 1},
+		want:       local,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, tt.body)
+			}))
+			defer source.Close()
+
+			path := testDB(t, local)
+			args := append([]string{"import", "--database", path, "--from-url", source.URL + "/results?format=json", "--json"}, tt.args...)
+			code, stdout := runUpdater(t, args...)
+			if code != tt.wantCode {
+				t.Fatalf("exit code %d, want %d", code, tt.wantCode)
+			}
+			var report importReport
+			if err := json.Unmarshal(stdout, &report); err != nil {
+				t.Fatalf("report %q: %v", stdout, err)
+			}
+			if len(report.Conflicts) != 1 || report.Conflicts[0].Date != "2020-01-07" {
+				t.Errorf("conflicts %+v, want the draw of 2020-01-07", report.Conflicts)
+			}
+			report.Game, report.Source, report.Conflicts = "", "", nil
+			if !reflect.DeepEqual(report, tt.wantReport) {
+				t.Errorf("report %+v, want %+v", report, tt.wantReport)
+			}
+			if got := storedBalls(t, path); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stored %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportFDJ(t *testing.T) {
+	header := "annee_numero_de_tirage;date_de_tirage;boule_1;boule_2;boule_3;boule_4;boule_5;etoile_1;etoile_2\n"
+	local := map[string]string{"2020-01-03": "1,2,3,4,5,1,2", "2020-01-07": "6,7,8,9,10,3,4"}
+	tests := []struct {
+		name     string
+		csv      string
+		args     []string
+		wantCode int
+		want     map[string]string
+	}{{
+		name: "missing draws are inserted",
+		csv: header + "2020001;03/01/2020;1;2;3;4;5;1;2\n" +
+			"2020002;07/01/2020;6;7;8;9;11;3;4\n" +
+			"2020003;10/01/2020;15;11;12;13;14;6;5\n",
+		wantCode: exitInserted,
+		want:     map[string]string{"2020-01-03": "1,2,3,4,5,1,2", "2020-01-07": "6,7,8,9,10,3,4", "2020-01-10": "11,12,13,14,15,5,6"},
+	}, {
+		name:     "differing draws are replaced",
+		csv:      header + "2020002;07/01/2020;6;7;8;9;11;3;4\n",
+		args:     []string{"--replace"},
+		wantCode: exitInserted,
+		want:     map[string]string{"2020-01-03": "1,2,3,4,5,1,2", "2020-01-07": "6,7,8,9,11,3,4"},
+	}, {
+		name: "invalid rows are skipped",
+		csv: header + "2020003;10/01/2020;11;12;13;14;15;5;13\n" +
+			"2020004;14/01/2020;16;16;18;19;20;7;8\n" +
+			"2020005;17/01/2020;51;17;18;19;20;7;8\n",
+		args:     []string{"--replace"},
+		wantCode: exitUpToDate,
+		want:     local,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := testDB(t, local)
+			archive := filepath.Join(t.TempDir(), "euromillions_202002.csv")
+			if err := os.WriteFile(archive, []byte(tt.csv), 0644); err != nil {
+				t.Fatal(err)
+			}
+			code, _ := runUpdater(t, append(append([]string{"import-fdj", "--database", path}, tt.args...), archive)...)
+			if code != tt.wantCode {
+				t.Fatalf("exit code %d, want %d", code, tt.wantCode)
+			}
+			if got := storedBalls(t, path); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stored %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testDB creates a migrated database holding the EuroMillions draws, given as
+// date -> "numbers,stars", and returns its path.
+func testDB(t *testing.T, draws map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "euromillions.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE results (date TEXT PRIMARY KEY, number_1 INTEGER, number_2 INTEGER, number_3 INTEGER,
+		number_4 INTEGER, number_5 INTEGER, star_1 INTEGER, star_2 INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateDB(db); err != nil {
+		t.Fatal(err)
+	}
+	for date, balls := range draws {
+		args := []any{date}
+		for _, b := range strings.Split(balls, ",") {
+			args = append(args, b)
+		}
+		if _, err := db.Exec("INSERT INTO results (date, number_1, number_2, number_3, number_4, number_5, star_1, star_2) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+// storedBalls returns the stored EuroMillions draws as testDB takes them.
+func storedBalls(t *testing.T, path string) map[string]string {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	stored, err := loadStoredDraws(context.Background(), db, findGame("euromillions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	draws := map[string]string{}
+	for date, d := range stored {
+		draws[date] = strings.ReplaceAll(joinInts(d.balls), " ", "")
+	}
+	return draws
+}
+
+// runUpdater runs the updater with args in a child process and returns its
+// exit code and standard output. Its log is only shown when the test fails.
+func runUpdater(t *testing.T, args ...string) (int, []byte) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), "UPDATER_ARGS="+strings.Join(args, "\n"))
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.Output()
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("updater %s:\n%s", strings.Join(args, " "), stderr.String())
+		}
+	})
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return 0, stdout
+	case errors.As(err, &exit):
+		return exit.ExitCode(), stdout
+	default:
+		t.Fatal(err)
+		return 0, nil
+	}
+}
+
+// TestMain runs the updater with the arguments of runUpdater, and otherwise
+// silences the log of the migrations.
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv("UPDATER_ARGS"); ok {
+		os.Args = append([]string{"go-euromillions-api-update"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(exitUpToDate)
+	}
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
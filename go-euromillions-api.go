@@ -1,27 +1,82 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	_ "embed"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
+	"html/template"
+	"io"
 	"log"
+	"maps"
+	"math"
+	"math/big"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/mail"
+	"net/netip"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	// Europe/Paris must load on hosts without a zone database (scratch
+	// containers, Windows): the zone data is built into the binary.
+	_ "time/tzdata"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Result struct represents a single EuroMillions drawing result.
 // It includes JSON and XML tags for serialization.
 type Result struct {
-	Date    string `json:"date" xml:"date"`
-	Numbers []int  `json:"numbers" xml:"numbers>number"`
-	Stars   []int  `json:"stars" xml:"stars>star"` // This line has been corrected
+	Date      string `json:"date" xml:"date"`
+	Timestamp string `json:"timestamp" xml:"timestamp"`
+	Numbers   []int  `json:"numbers" xml:"numbers>number"`
+	Stars     []int  `json:"stars" xml:"stars>star"` // This line has been corrected
+	Special   bool   `json:"special" xml:"special"`
+
+	// DrawnNumbers and DrawnStars hold the balls in the order they were drawn,
+	// when the source published it. Numbers and Stars are always ascending.
+	DrawnNumbers []int `json:"drawn_numbers,omitempty" xml:"drawn_numbers>number,omitempty"`
+	DrawnStars   []int `json:"drawn_stars,omitempty" xml:"drawn_stars>star,omitempty"`
+
+	// Notes are the admins' annotations of the draw, with ?include=notes.
+	Notes []Note `json:"notes,omitempty" xml:"notes>note,omitempty"`
 }
 
 // AllResults is a helper struct for XML output with a root element.
@@ -30,54 +85,786 @@ type AllResults struct {
 	Results []Result `xml:"result"`
 }
 
+// Envelope is the consistent response shape selected with ?envelope=true:
+// always a list with its count, whether the endpoint returns one result or many.
+type Envelope struct {
+	XMLName xml.Name `json:"-" xml:"results"`
+	Count   int      `json:"count" xml:"count,attr"`
+	Results []Result `json:"results" xml:"result"`
+}
+
+// ResultSet is the XML of the draws in the documented schema, selected with
+// ?xml=schema or ?xml=compact: always a list with its count, in the namespace
+// of /schema.xsd, with a result element per draw or, compact, a draw element
+// with attributes.
+type ResultSet struct {
+	XMLName xml.Name      `xml:"https://github.com/nfcg/Go-EuroMillions-API/xml/results/1 results"`
+	Count   int           `xml:"count,attr"`
+	Results []Result      `xml:"result"`
+	Draws   []CompactDraw `xml:"draw"`
+}
+
+// CompactDraw is a draw with ?xml=compact; the balls are space-separated.
+type CompactDraw struct {
+	Date         string `xml:"date,attr"`
+	Timestamp    string `xml:"timestamp,attr"`
+	Numbers      string `xml:"numbers,attr"`
+	Stars        string `xml:"stars,attr"`
+	Special      bool   `xml:"special,attr"`
+	DrawnNumbers string `xml:"drawn_numbers,attr,omitempty"`
+	DrawnStars   string `xml:"drawn_stars,attr,omitempty"`
+	Notes        []Note `xml:"note"`
+}
+
+// newResultSet returns the results in the form of ?xml=, schema or compact.
+func newResultSet(results []Result, form string) ResultSet {
+	set := ResultSet{Count: len(results)}
+	if form == "schema" {
+		set.Results = results
+		return set
+	}
+	list := func(balls []int) string {
+		parts := make([]string, len(balls))
+		for i, n := range balls {
+			parts[i] = strconv.Itoa(n)
+		}
+		return strings.Join(parts, " ")
+	}
+	for _, result := range results {
+		set.Draws = append(set.Draws, CompactDraw{
+			Date:         result.Date,
+			Timestamp:    result.Timestamp,
+			Numbers:      list(result.Numbers),
+			Stars:        list(result.Stars),
+			Special:      result.Special,
+			DrawnNumbers: list(result.DrawnNumbers),
+			DrawnStars:   list(result.DrawnStars),
+			Notes:        result.Notes,
+		})
+	}
+	return set
+}
+
+// CalendarMonth summarizes the draws of one month.
+type CalendarMonth struct {
+	Month string   `json:"month" xml:"month,attr"`
+	Draws int      `json:"draws" xml:"draws,attr"`
+	Dates []string `json:"dates" xml:"date"`
+}
+
+// Calendar is the month-by-month summary of a year.
+type Calendar struct {
+	XMLName xml.Name        `json:"-" xml:"calendar"`
+	Year    int             `json:"year" xml:"year,attr"`
+	Draws   int             `json:"draws" xml:"draws,attr"`
+	Months  []CalendarMonth `json:"months" xml:"month"`
+}
+
 var (
-	db          *sql.DB
-	dbPath      string
-	showHelp    bool
+	db     *sql.DB
+	dbPath string
+
+	// queryDB is a read-only connection pool to the same database, for the
+	// ad-hoc queries of /admin/query.
+	queryDB     *sql.DB
 	versionFlag bool
 	verbose     bool
 	logFilePath string
+
+	adminUser         string
+	adminPasswordHash string
+
+	// adminMux holds the routes of the admin area. Every route registered on it
+	// is served under /admin/ and guarded by adminAuth.
+	adminMux = http.NewServeMux()
+
+	authMode    string
+	jwtSecret   string
+	jwksURL     string
+	jwtIssuer   string
+	jwtAudience string
+
+	basePath string
+
+	trustedProxiesFlag string
+	trustedProxies     []*net.IPNet
+
+	acmeEnabled  bool
+	acmeDomains  string
+	acmeCacheDir string
+	acmeEmail    string
+
+	cacheEnabled bool
+	cacheEntries int
+
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	busyTimeout     time.Duration
+	busyRetries     int
+
+	updaterPath string
+
+	maxInFlight      int
+	maxInFlightRoute int
+	maxWaiting       int
+
+	lenientDates bool
+
+	sentryDSN string
+	tracker   *sentryClient
+
+	selfTestFlag bool
+	strictStart  bool
+
+	tuiGame string
+
+	queryGame   string
+	queryFormat string
+	queryNDJSON bool
+	queryLang   string
+
+	exportGame   string
+	exportFormat string
+	exportOutput string
+
+	// maintenance is set by --maintenance and toggled in the admin area.
+	maintenanceFlag bool
+	maintenance     atomic.Bool
+
+	mirrorURL      string
+	mirrorInterval time.Duration
+
+	pidFile      string
+	takeover     bool
+	drainTimeout time.Duration
+
+	replicateURL       string
+	replicateEndpoint  string
+	replicateRegion    string
+	replicateInterval  time.Duration
+	snapshotInterval   time.Duration
+	replicateRetention time.Duration
+	restoreTimestamp   string
+	restoreOutput      string
+
+	// role is primary or replica; a replica does not write the database and
+	// forwards the changes made through it to primaryProxy, when set.
+	role         string
+	primaryURL   string
+	primaryProxy *httputil.ReverseProxy
 )
 
+// Version metadata. Release builds set them with -ldflags, e.g.
+// -X main.version=1.3 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%FT%TZ);
+// otherwise the commit and date come from the VCS stamp of go build, if any.
+var (
+	version   = "1.2"
+	commit    = ""
+	buildDate = ""
+)
+
+// releasesURL is the GitHub API URL of the latest release, for check-update.
+const releasesURL = "https://api.github.com/repos/nfcg/Go-EuroMillions-API/releases/latest"
+
 const (
-	version = "1.2"
+	// drawHour is the local hour (Europe/Paris) at which EuroMillions draws take place.
+	drawHour = 21
+
+	// overloadRetryAfter is the Retry-After, in seconds, of the 503 responses
+	// sent when a concurrency limit is reached.
+	overloadRetryAfter = 5
+
+	// maintenanceRetryAfter is the Retry-After, in seconds, of the 503
+	// responses sent in maintenance mode.
+	maintenanceRetryAfter = 300
 )
 
-// init is called before main. It sets up command-line flags with both long and short versions.
+// drawLocation is the time zone of the draw, loaded in main.
+var drawLocation *time.Location
+
+// serveCmd starts the HTTP server. It is the default command.
+var serveCmd = newCommand("serve", "Start the HTTP server (default)")
+
+// checkUpdateCmd compares the version with the latest release.
+var checkUpdateCmd = newCommand("check-update", "Check whether a newer release is available on GitHub")
+
+// tuiCmd browses the database in the terminal, without the HTTP server.
+var tuiCmd = newCommand("tui", "Browse the draws and their statistics in the terminal")
+
+// queryCmd answers queries from the command line, without the server.
+var queryCmd = newCommand("query", "Print draws or check a line straight from the database")
+
+// exportCmd dumps the draw history for analytics tools.
+var exportCmd = newCommand("export", "Write the full draw history to a CSV, NDJSON or Parquet file")
+
+// restoreCmd rebuilds the database from a --replicate replica.
+var restoreCmd = newCommand("restore", "Restore the database from an S3 replica, at a point in time")
+
+// completionCmd and manCmd are generated from the commands, so they list
+// exactly the commands and flags the binary has.
+var completionCmd = newCommand("completion", "Print a shell completion script: bash, zsh or fish")
+var manCmd = newCommand("man", "Print the manual page (troff)")
+
+// commands are the subcommands of the server binary; the first one is the default.
+var commands = []*command{serveCmd, queryCmd, tuiCmd, exportCmd, restoreCmd, checkUpdateCmd, completionCmd, manCmd}
+
+// init is called before main. It sets up the command-line flags of each command.
 func init() {
-	// Long and short flags for database path
-	flag.StringVar(&dbPath, "db", "./euromillions.db", "Path to the SQLite database file")
-	flag.StringVar(&dbPath, "d", "./euromillions.db", "Path to the SQLite database file (shorthand)")
-
-	// Long and short flags for showing help
-	flag.BoolVar(&showHelp, "help", false, "Show the application help message")
-	flag.BoolVar(&showHelp, "h", false, "Show the application help message (shorthand)")
-
-	// Long and short flags for showing version
-	flag.BoolVar(&versionFlag, "version", false, "Show the application version")
-	flag.BoolVar(&versionFlag, "v", false, "Show the application version (shorthand)")
-
-	// New: Long and short flags for verbose logging
-	flag.BoolVar(&verbose, "verbose", false, "Enable verbose logging for requests")
-	// The -v flag is already used for version, so we must choose a different short flag for verbose.
-	// We will omit the short flag for verbose to avoid conflicts.
-	
-	// New: Long and short flags for log file path
-	flag.StringVar(&logFilePath, "log-file", "", "Path to a file to write logs to")
-	flag.StringVar(&logFilePath, "l", "", "Path to a file to write logs to (shorthand)")
+	serveCmd.run = runServe
+	checkUpdateCmd.run = runCheckUpdate
+	queryCmd.run = runQuery
+	queryCmd.flags.Usage = printQueryHelp
+	queryCmd.flags.StringVar(&dbPath, "database", "./euromillions.db", "Path to the SQLite database file")
+	queryCmd.alias("database", "d")
+	queryCmd.flags.StringVar(&queryGame, "game", "euromillions", "Game to query: euromillions or thunderball")
+	queryCmd.alias("game", "g")
+	queryCmd.flags.StringVar(&queryFormat, "format", "plaintext", "Output format: json, ndjson, xml, csv or plaintext")
+	queryCmd.alias("format", "f")
+	queryCmd.flags.BoolVar(&queryNDJSON, "ndjson", false, "Print one JSON object per line, for jq and data pipelines (same as --format ndjson)")
+	queryCmd.flags.StringVar(&queryLang, "lang", "en", "Language of the plaintext labels and dates: en, pt, fr or es")
+
+	exportCmd.run = runExport
+	exportCmd.flags.StringVar(&dbPath, "database", "./euromillions.db", "Path to the SQLite database file")
+	exportCmd.alias("database", "d")
+	exportCmd.flags.StringVar(&exportGame, "game", "euromillions", "Game to export: euromillions or thunderball")
+	exportCmd.alias("game", "g")
+	exportCmd.flags.StringVar(&exportFormat, "format", "csv", "Output format: csv, ndjson or parquet")
+	exportCmd.alias("format", "f")
+	exportCmd.flags.StringVar(&exportOutput, "output", "", "File to write (default: standard output)")
+	exportCmd.alias("output", "o")
+
+	restoreCmd.run = runRestore
+	restoreCmd.flags.StringVar(&replicateURL, "from", "", "The replica, s3://BUCKET/PREFIX as given to --replicate")
+	restoreCmd.flags.StringVar(&replicateEndpoint, "endpoint", "", "S3 endpoint URL, for stores other than AWS (e.g. http://localhost:9000)")
+	restoreCmd.flags.StringVar(&replicateRegion, "region", cmp.Or(os.Getenv("AWS_REGION"), "us-east-1"), "S3 region ($AWS_REGION when set)")
+	restoreCmd.flags.StringVar(&restoreTimestamp, "timestamp", "", "Restore the database as it was at this time, RFC 3339 (default: the latest state)")
+	restoreCmd.flags.StringVar(&restoreOutput, "output", "", "Path of the database to create; it must not exist")
+	restoreCmd.alias("output", "o")
+
+	tuiCmd.run = runTUI
+	tuiCmd.flags.StringVar(&dbPath, "database", "./euromillions.db", "Path to the SQLite database file")
+	tuiCmd.alias("database", "d")
+	tuiCmd.flags.StringVar(&tuiGame, "game", "euromillions", "Game to browse: euromillions or thunderball")
+	tuiCmd.alias("game", "g")
+
+	completionCmd.run = runCompletion
+	completionCmd.flags.Usage = printCompletionHelp
+	completionCmd.args = shells
+	manCmd.run = runMan
+	serveCmd.flags.Usage = printHelp
+	fs := serveCmd.flags
+
+	// Database path. --db is the former name of the flag.
+	fs.StringVar(&dbPath, "database", "./euromillions.db", "Path to the SQLite database file")
+	serveCmd.alias("database", "d")
+	serveCmd.alias("database", "db")
+
+	// -v is verbose and -V the version, as in the updater.
+	fs.BoolVar(&versionFlag, "version", false, "Show the application version")
+	serveCmd.alias("version", "V")
+	fs.BoolVar(&verbose, "verbose", false, "Enable verbose logging for requests")
+	serveCmd.alias("verbose", "v")
+
+	fs.StringVar(&logFilePath, "log-file", "", "Path to a file to write logs to")
+	serveCmd.alias("log-file", "l")
+
+	// Credentials for the admin area (HTTP Basic authentication).
+	// The admin area is disabled unless both are set.
+	fs.StringVar(&adminUser, "admin-user", "", "Username for the /admin/ area")
+	fs.StringVar(&adminPasswordHash, "admin-password-hash", "", "Bcrypt hash of the password for the /admin/ area")
+
+	// Authentication for the read endpoints.
+	fs.StringVar(&authMode, "auth", "none", "Authentication mode for the read endpoints: none, jwt or apikey")
+	fs.StringVar(&jwtSecret, "jwt-secret", "", "Shared secret used to validate HS256 JWT bearer tokens")
+	fs.StringVar(&jwksURL, "jwks-url", "", "JWKS URL used to validate RS256 JWT bearer tokens")
+	fs.StringVar(&jwtIssuer, "jwt-issuer", "", "Required 'iss' claim of JWT bearer tokens (optional)")
+	fs.StringVar(&jwtAudience, "jwt-audience", "", "Required 'aud' claim of JWT bearer tokens (optional)")
+
+	// Path prefix for all routes, for mounting the API behind a reverse proxy.
+	fs.StringVar(&basePath, "base-path", "", "Serve all routes under this path prefix (e.g., /euromillions)")
+
+	// Reverse proxies allowed to report the client address.
+	fs.StringVar(&trustedProxiesFlag, "trusted-proxies", "", "Comma-separated IPs or CIDRs of proxies whose X-Forwarded-For/X-Real-IP headers are trusted")
+
+	// Automatic HTTPS with Let's Encrypt.
+	fs.BoolVar(&acmeEnabled, "acme", false, "Serve HTTPS on :443 with certificates obtained automatically from Let's Encrypt")
+	fs.StringVar(&acmeDomains, "domain", "", "Comma-separated domain names to obtain certificates for (required with --acme)")
+	fs.StringVar(&acmeCacheDir, "acme-cache-dir", "./acme-cache", "Directory where ACME certificates are stored")
+	fs.StringVar(&acmeEmail, "acme-email", "", "Contact email for the ACME account (optional)")
+
+	// In-process response cache for the list endpoints.
+	fs.BoolVar(&cacheEnabled, "cache", true, "Cache responses of expensive endpoints in memory (use --cache=false to disable)")
+	fs.IntVar(&cacheEntries, "cache-entries", 1000, "Maximum number of responses kept in the cache; the least recently used are dropped first (0 = unlimited)")
+
+	// Connection pool tuning. A busy timeout lets readers wait for the updater's
+	// write lock instead of failing with "database is locked".
+	fs.IntVar(&maxOpenConns, "max-open-conns", 0, "Maximum number of open database connections (0 = unlimited)")
+	fs.IntVar(&maxIdleConns, "max-idle-conns", 2, "Maximum number of idle database connections")
+	fs.DurationVar(&connMaxLifetime, "conn-max-lifetime", 0, "Maximum time a database connection may be reused (0 = forever)")
+	fs.DurationVar(&busyTimeout, "busy-timeout", 5*time.Second, "How long SQLite waits for a locked database before failing")
+	fs.IntVar(&busyRetries, "busy-retries", 3, "How many times a read that still finds the database busy or locked is retried")
+
+	// The admin re-scrape endpoint runs the updater's verify command.
+	fs.StringVar(&updaterPath, "updater", "./go-euromillions-api-update", "Path to the updater executable, used by the admin area to re-scrape and edit draws")
+
+	// Concurrency limits. Requests over a limit get 503 instead of queueing
+	// until the process runs out of memory.
+	fs.IntVar(&maxInFlight, "max-in-flight", 256, "Maximum number of requests served at once (0 = unlimited)")
+	fs.IntVar(&maxInFlightRoute, "max-in-flight-route", 8, "Maximum number of requests served at once by each expensive route: the full list, the wheel and the simulation (0 = unlimited)")
+	fs.IntVar(&maxWaiting, "max-waiting", 1024, "Maximum number of /results/wait requests held open at once, outside --max-in-flight (0 = unlimited)")
+
+	// Local date formats on the date endpoints, for users pasting European dates.
+	fs.BoolVar(&lenientDates, "lenient-dates", false, "Also accept DD-MM-YYYY, DD/MM/YYYY, DD.MM.YYYY and YYYYMMDD dates on the date endpoints")
+
+	// Startup checks.
+	fs.BoolVar(&selfTestFlag, "self-test", true, "Check the schema, the latest draws, the output formats and the clock on startup and log what fails")
+	fs.BoolVar(&strictStart, "strict-start", false, "Refuse to start when a startup self-test check fails")
+
+	// Maintenance mode, for data repairs and migrations.
+	fs.BoolVar(&maintenanceFlag, "maintenance", false, "Start in maintenance mode: every endpoint but the admin area answers 503 until it is turned off in the admin area")
+
+	// Mirror mode: the draws are copied from another instance with the
+	// updater's mirror command instead of being scraped.
+	fs.StringVar(&mirrorURL, "mirror", "", "Base URL of an upstream instance of the API to copy the draws from; the draws cannot be edited here")
+	fs.DurationVar(&mirrorInterval, "mirror-interval", 15*time.Minute, "How often the draws are synced from the --mirror upstream")
+
+	// A single server per database and port: a second one exits, or replaces
+	// the first with --takeover.
+	fs.StringVar(&pidFile, "pid-file", "", "File holding the PID of the server, to detect a second server on the database (default: the database path with .pid appended)")
+	fs.BoolVar(&takeover, "takeover", false, "When another server runs on the database, stop it and start once its requests have drained, instead of exiting")
+	fs.DurationVar(&drainTimeout, "drain-timeout", 30*time.Second, "How long the requests in flight may take to finish when the server stops (SIGTERM or Ctrl-C)")
+
+	// Continuous replication of the database to S3, for point-in-time recovery
+	// with the restore command.
+	fs.StringVar(&replicateURL, "replicate", "", "Stream the database to S3 or a compatible store, s3://BUCKET/PREFIX; credentials from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY")
+	fs.StringVar(&replicateEndpoint, "replicate-endpoint", "", "S3 endpoint URL, for stores other than AWS (e.g. http://localhost:9000)")
+	fs.StringVar(&replicateRegion, "replicate-region", cmp.Or(os.Getenv("AWS_REGION"), "us-east-1"), "S3 region ($AWS_REGION when set)")
+	fs.DurationVar(&replicateInterval, "replicate-interval", 10*time.Second, "How often the new transactions are sent to the replica")
+	fs.DurationVar(&snapshotInterval, "snapshot-interval", 24*time.Hour, "How often a full copy of the database starts a new replica generation")
+	fs.DurationVar(&replicateRetention, "replicate-retention", 7*24*time.Hour, "How far back the replica can restore; older generations are deleted")
+
+	// Several instances sharing one database (on a network filesystem or
+	// replicated with litestream): only the primary writes it.
+	fs.StringVar(&role, "role", "primary", "primary, or replica: the database is not written, and the requests changing it are refused or sent to --primary")
+	fs.StringVar(&primaryURL, "primary", "", "Base URL of the primary instance that a replica sends the admin changes and the subscription changes to")
+
+	// Error reporting to Sentry or a compatible tracker (e.g. GlitchTip).
+	fs.StringVar(&sentryDSN, "sentry-dsn", os.Getenv("SENTRY_DSN"), "Sentry DSN that panics and 5xx responses are reported to (default: $SENTRY_DSN)")
 }
 
 // main is the entry point of the application.
 func main() {
-	flag.Parse()
+	runCommand(commands, os.Args[1:])
+}
 
-	if showHelp {
-		printHelp()
-		return
+// command is a subcommand of the tool with its own flag set and help.
+type command struct {
+	name    string
+	summary string
+	flags   *flag.FlagSet
+	short   map[string]string // long flag name -> one-letter shorthand
+	aliases map[string]bool   // flag names hidden from the help
+	args    []string          // the arguments offered by the shell completion
+	run     func(args []string)
+}
+
+func newCommand(name, summary string) *command {
+	c := &command{
+		name:    name,
+		summary: summary,
+		flags:   flag.NewFlagSet(name, flag.ExitOnError),
+		short:   map[string]string{},
+		aliases: map[string]bool{},
+	}
+	c.flags.Usage = c.printHelp
+	return c
+}
+
+// alias registers another name for the flag long. One-letter aliases are
+// listed as its shorthand.
+func (c *command) alias(long, name string) {
+	f := c.flags.Lookup(long)
+	c.flags.Var(f.Value, name, f.Usage)
+	c.aliases[name] = true
+	if len(name) == 1 {
+		c.short[long] = name
+	}
+}
+
+// printHelp is the default help of a command: its summary and its flags.
+func (c *command) printHelp() {
+	fmt.Printf("%s\n\nUsage:\n  %s %s [options]\n\nOptions:\n", c.summary, filepath.Base(os.Args[0]), c.name)
+	c.printFlags()
+}
+
+// printFlags lists the flags of the command as "-d, --database string".
+func (c *command) printFlags() {
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if c.aliases[f.Name] {
+			return
+		}
+		name := "    --" + f.Name
+		if s, ok := c.short[f.Name]; ok {
+			name = "-" + s + ", --" + f.Name
+		}
+		typ, usage := flag.UnquoteUsage(f)
+		if typ != "" {
+			name += " " + typ
+		}
+		switch f.DefValue {
+		case "", "false", "0", "0s":
+		default:
+			usage += fmt.Sprintf(" (default %s)", f.DefValue)
+		}
+		fmt.Printf("  %-34s %s\n", name, usage)
+	})
+	fmt.Printf("  %-34s %s\n", "-h, --help", "Show this help message")
+}
+
+// isBool reports whether the flag name is a boolean flag of the command.
+func (c *command) isBool(name string) bool {
+	f := c.flags.Lookup(name)
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// expandShorthands splits grouped one-letter flags such as -vV into -v -V.
+// Every letter but the last must be a boolean flag; the last one may take a
+// value from the next argument. Arguments after "--" are left untouched.
+func (c *command) expandShorthands(args []string) []string {
+	var out []string
+	for i, arg := range args {
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		if len(arg) < 3 || arg[0] != '-' || arg[1] == '-' || strings.Contains(arg, "=") || c.flags.Lookup(arg[1:]) != nil {
+			out = append(out, arg)
+			continue
+		}
+		letters := arg[1:]
+		group := true
+		for j := range letters {
+			name := letters[j : j+1]
+			if c.flags.Lookup(name) == nil || (j < len(letters)-1 && !c.isBool(name)) {
+				group = false
+				break
+			}
+		}
+		if !group {
+			out = append(out, arg)
+			continue
+		}
+		for j := range letters {
+			out = append(out, "-"+letters[j:j+1])
+		}
+	}
+	return out
+}
+
+// runCommand runs the command named by the first argument, or the first
+// command when the arguments start with a flag. "help [command]" shows the
+// help of a command.
+func runCommand(commands []*command, args []string) {
+	c := commands[0]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name := args[0]
+		args = args[1:]
+		if name == "help" {
+			if len(args) > 0 {
+				name = args[0]
+			} else {
+				name = c.name
+			}
+			args = []string{"--help"}
+		}
+		c = nil
+		for _, cmd := range commands {
+			if cmd.name == name {
+				c = cmd
+			}
+		}
+		if c == nil {
+			fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", name)
+			printCommands(commands)
+			os.Exit(2)
+		}
+	}
+	c.flags.Parse(c.expandShorthands(args))
+	c.run(c.flags.Args())
+}
+
+// printCommands lists the available commands.
+func printCommands(commands []*command) {
+	fmt.Println("Commands:")
+	for _, c := range commands {
+		fmt.Printf("  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Printf("  %-12s %s\n", "help", "Show the help of a command (e.g., help "+commands[0].name+")")
+}
+
+// shells are the shells the completion command writes scripts for.
+var shells = []string{"bash", "zsh", "fish"}
+
+// printCompletionHelp is the help of the completion command.
+func printCompletionHelp() {
+	prog := filepath.Base(os.Args[0])
+	fmt.Printf("%s\n\nUsage:\n  %s completion %s\n\n", completionCmd.summary, prog, strings.Join(shells, "|"))
+	fmt.Println("To load the completions:")
+	fmt.Printf("  bash: source <(%s completion bash)\n", prog)
+	fmt.Printf("  zsh:  %s completion zsh > \"${fpath[1]}/_%s\"\n", prog, prog)
+	fmt.Printf("  fish: %s completion fish > ~/.config/fish/completions/%s.fish\n", prog, prog)
+}
+
+// runCompletion prints the completion script of a shell, generated from the
+// commands and their flags.
+func runCompletion(args []string) {
+	if len(args) != 1 {
+		printCompletionHelp()
+		os.Exit(2)
+	}
+	prog := filepath.Base(os.Args[0])
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion(prog, commands))
+	case "zsh":
+		fmt.Print(zshCompletion(prog, commands))
+	case "fish":
+		fmt.Print(fishCompletion(prog, commands))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown shell: %s (use %s)\n", args[0], strings.Join(shells, ", "))
+		os.Exit(2)
+	}
+}
+
+// visibleFlags returns the flags of the command listed in its help, without
+// the aliases.
+func (c *command) visibleFlags() []*flag.Flag {
+	var flags []*flag.Flag
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if !c.aliases[f.Name] {
+			flags = append(flags, f)
+		}
+	})
+	return flags
+}
+
+// options returns the spellings of the flags of the command, --long and -s.
+func (c *command) options() []string {
+	var opts []string
+	for _, f := range c.visibleFlags() {
+		opts = append(opts, "--"+f.Name)
+		if s, ok := c.short[f.Name]; ok {
+			opts = append(opts, "-"+s)
+		}
+	}
+	return opts
+}
+
+// completionFunc is the name of the shell function completing prog.
+func completionFunc(prog string) string {
+	return "_" + strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, prog)
+}
+
+// bashCompletion returns the bash completion script of prog.
+func bashCompletion(prog string, commands []*command) string {
+	var names, valueOpts []string
+	for _, c := range commands {
+		names = append(names, c.name)
+		for _, f := range c.visibleFlags() {
+			if !c.isBool(f.Name) {
+				valueOpts = append(valueOpts, "--"+f.Name)
+				if s, ok := c.short[f.Name]; ok {
+					valueOpts = append(valueOpts, "-"+s)
+				}
+			}
+		}
+	}
+	slices.Sort(valueOpts)
+	valueOpts = slices.Compact(valueOpts)
+
+	fn := completionFunc(prog)
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s, generated by \"%s completion bash\".\n", prog, prog)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("    local cur prev cmd words i\n")
+	b.WriteString("    cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	if len(valueOpts) > 0 {
+		// The value of a flag: fall back to file names.
+		fmt.Fprintf(&b, "    case \"$prev\" in\n    %s) return ;;\n    esac\n", strings.Join(valueOpts, "|"))
+	}
+	b.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	fmt.Fprintf(&b, "        case \"${COMP_WORDS[i]}\" in\n        %s|help) cmd=\"${COMP_WORDS[i]}\"; break ;;\n        esac\n", strings.Join(names, "|"))
+	b.WriteString("    done\n")
+	b.WriteString("    case \"$cmd\" in\n")
+	fmt.Fprintf(&b, "    \"\")\n        words=\"%s\"\n", strings.Join(commands[0].options(), " "))
+	fmt.Fprintf(&b, "        [[ $cur == -* ]] || words=\"%s help\" ;;\n", strings.Join(names, " "))
+	fmt.Fprintf(&b, "    help) words=\"%s\" ;;\n", strings.Join(names, " "))
+	for _, c := range commands {
+		words := append(c.options(), c.args...)
+		fmt.Fprintf(&b, "    %s) words=\"%s\" ;;\n", c.name, strings.Join(words, " "))
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("    COMPREPLY=($(compgen -W \"$words --help\" -- \"$cur\"))\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -o default -F %s %s\n", fn, prog)
+	return b.String()
+}
+
+// zshQuote quotes s for a single-quoted zsh word; in an _arguments spec the
+// brackets and colons are escaped too.
+func zshQuote(s string, spec bool) string {
+	if spec {
+		s = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+	}
+	return strings.ReplaceAll(s, "'", `'\''`)
+}
+
+// zshCompletion returns the zsh completion script of prog.
+func zshCompletion(prog string, commands []*command) string {
+	fn := completionFunc(prog)
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n\n", prog)
+	fmt.Fprintf(&b, "# zsh completion for %s, generated by \"%s completion zsh\".\n", prog, prog)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("    local -a commands\n    commands=(\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "        '%s:%s'\n", c.name, zshQuote(c.summary, false))
+	}
+	b.WriteString("        'help:Show the help of a command'\n    )\n")
+	b.WriteString("    if (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then\n")
+	b.WriteString("        _describe -t commands command commands\n        return\n    fi\n")
+	fmt.Fprintf(&b, "    local cmd=%s\n", commands[0].name)
+	b.WriteString("    if [[ $words[2] != -* ]]; then\n        cmd=$words[2]\n        shift words\n        (( CURRENT-- ))\n    fi\n")
+	b.WriteString("    case $cmd in\n")
+	b.WriteString("    help)\n        _describe -t commands command commands ;;\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "    %s)\n        _arguments \\\n", c.name)
+		for _, f := range c.visibleFlags() {
+			spec := "'--" + f.Name
+			if s, ok := c.short[f.Name]; ok {
+				spec = "'(-" + s + " --" + f.Name + ")'{-" + s + ",--" + f.Name + "}'"
+			}
+			_, usage := flag.UnquoteUsage(f)
+			spec += "[" + zshQuote(usage, true) + "]"
+			if !c.isBool(f.Name) {
+				spec += ":value:_files"
+			}
+			fmt.Fprintf(&b, "            %s' \\\n", spec)
+		}
+		if args := c.args; len(args) > 0 {
+			fmt.Fprintf(&b, "            '1:argument:(%s)' \\\n", strings.Join(args, " "))
+		}
+		b.WriteString("            '(-h --help)'{-h,--help}'[Show this help message]' ;;\n")
+	}
+	b.WriteString("    esac\n}\n\n")
+	fmt.Fprintf(&b, "if [ \"$funcstack[1]\" = \"%s\" ]; then\n    %s \"$@\"\nelse\n    compdef %s %s\nfi\n", fn, fn, fn, prog)
+	return b.String()
+}
+
+// fishQuote quotes s as a single-quoted fish word.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// fishCompletion returns the fish completion script of prog.
+func fishCompletion(prog string, commands []*command) string {
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
 	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s, generated by \"%s completion fish\".\n", prog, prog)
+	fmt.Fprintf(&b, "complete -c %s -f\n", prog)
+	for _, c := range commands {
+		fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n", prog, c.name, fishQuote(c.summary))
+	}
+	fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -a help -d %s\n", prog, fishQuote("Show the help of a command"))
+	fmt.Fprintf(&b, "complete -c %s -n '__fish_seen_subcommand_from help' -a %s\n", prog, fishQuote(strings.Join(names, " ")))
+	for _, c := range commands {
+		cond := "__fish_seen_subcommand_from " + c.name
+		if c == commands[0] {
+			// The flags of the default command also come without it.
+			cond = "__fish_use_subcommand; or " + cond
+		}
+		b.WriteString("\n")
+		if args := c.args; len(args) > 0 {
+			fmt.Fprintf(&b, "complete -c %s -n %s -a %s\n", prog, fishQuote(cond), fishQuote(strings.Join(args, " ")))
+		}
+		for _, f := range c.visibleFlags() {
+			line := fmt.Sprintf("complete -c %s -n %s -l %s", prog, fishQuote(cond), f.Name)
+			if s, ok := c.short[f.Name]; ok {
+				line += " -s " + s
+			}
+			if !c.isBool(f.Name) {
+				line += " -r -F"
+			}
+			_, usage := flag.UnquoteUsage(f)
+			fmt.Fprintf(&b, "%s -d %s\n", line, fishQuote(usage))
+		}
+	}
+	return b.String()
+}
+
+// roffEscape escapes text for a troff man page.
+func roffEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// runMan prints the manual page, generated from the commands and their flags.
+// View it with "man -l <(prog man)" or install it in a man1 directory.
+func runMan(args []string) {
+	prog := filepath.Base(os.Args[0])
+	date := buildDate
+	if len(date) > 10 {
+		date = date[:10]
+	}
+	dash := strings.NewReplacer("-", `\-`)
+	fmt.Printf(".TH %s 1 %q %q \"User Commands\"\n", strings.ToUpper(dash.Replace(prog)), date, prog+" "+version)
+	fmt.Printf(".SH NAME\n%s \\- %s\n", dash.Replace(prog), roffEscape("EuroMillions API - Results Server"))
+	fmt.Printf(".SH SYNOPSIS\n.B %s\n[\\fIcommand\\fR] [\\fIoptions\\fR]\n", dash.Replace(prog))
+	fmt.Printf(".SH DESCRIPTION\nWithout a command, %s runs \\fB%s\\fR.\n", dash.Replace(prog), commands[0].name)
+	fmt.Printf(".B %s help\n.I command\nshows the help of a command.\n", dash.Replace(prog))
+	fmt.Println(".SH COMMANDS")
+	for _, c := range commands {
+		fmt.Printf(".SS %s\n%s\n", dash.Replace(c.name), roffEscape(c.summary))
+		for _, f := range c.visibleFlags() {
+			name := `\fB\-\-` + dash.Replace(f.Name) + `\fR`
+			if s, ok := c.short[f.Name]; ok {
+				name = `\fB\-` + s + `\fR, ` + name
+			}
+			typ, usage := flag.UnquoteUsage(f)
+			if typ != "" {
+				name += ` \fI` + typ + `\fR`
+			}
+			switch f.DefValue {
+			case "", "false", "0", "0s":
+			default:
+				usage += fmt.Sprintf(" (default %s)", f.DefValue)
+			}
+			fmt.Printf(".TP\n%s\n%s\n", name, roffEscape(usage))
+		}
+		fmt.Printf(".TP\n\\fB\\-h\\fR, \\fB\\-\\-help\\fR\nShow this help message\n")
+	}
+}
 
+// runServe starts the HTTP server.
+func runServe(args []string) {
 	if versionFlag {
-		fmt.Printf("EuroMillions API v%s\n", version)
+		fmt.Printf("EuroMillions API %s\n", versionString())
 		return
 	}
 	
@@ -91,385 +878,8607 @@ func main() {
 		log.SetOutput(logFile)
 	}
 
-	// Initialize the database connection and apply optimizations.
-	if err := initDB(); err != nil {
-		log.Fatalf("Error initializing database: %v", err)
+	switch authMode {
+	case "none":
+	case "jwt":
+		if jwtSecret == "" && jwksURL == "" {
+			log.Fatalf("JWT authentication requires --jwt-secret or --jwks-url")
+		}
+	case "apikey":
+	default:
+		log.Fatalf("Invalid authentication mode: %s (use none, jwt or apikey)", authMode)
 	}
-	defer db.Close()
 
-	// Configure HTTP handlers for different endpoints.
-	http.HandleFunc("/", defaultHandler)
-	http.HandleFunc("/results", resultsHandler)
-	http.HandleFunc("/results/latest", latestHandler)
-	http.HandleFunc("/results/date/", dateHandler)
-	http.HandleFunc("/results/year/", yearHandler)
-	http.HandleFunc("/results/month/", monthYearHandler)
-
-	log.Printf("Server started on port 8080 (Database: %s)", dbPath)
-	log.Fatal(http.ListenAndServe(":8080", nil))
-}
+	proxies, err := parseTrustedProxies(trustedProxiesFlag)
+	if err != nil {
+		log.Fatalf("Invalid --trusted-proxies: %v", err)
+	}
+	trustedProxies = proxies
 
-// printHelp displays a detailed help message, including usage, flags, and available endpoints.
-func printHelp() {
-	fmt.Println("EuroMillions API - Results Server")
-	fmt.Println("---------------------------------")
-	fmt.Println("\nUsage:")
-	fmt.Println("  ./euromillions-api [options]")
-	fmt.Println("\nOptions:")
-	flag.PrintDefaults()
-	fmt.Println("\nAvailable Endpoints:")
-	fmt.Println("  GET /                        - Returns the latest drawing result (default).")
-	fmt.Println("  GET /results                 - Returns all drawing results.")
-	fmt.Println("  GET /results/latest          - Returns the latest drawing result.")
-	fmt.Println("  GET /results/date/{date}     - Search by a specific date (e.g., /results/date/2024-01-15).")
-	fmt.Println("  GET /results/year/{year}     - Search by year (e.g., /results/year/2023).")
-	fmt.Println("  GET /results/month/{month}   - Search by month and year (e.g., /results/month/2024-03).")
-	fmt.Println("\nURL Query Parameters for Output Format:")
-	fmt.Println("  ?format=json                 - Returns the response in JSON format (default).")
-	fmt.Println("  ?format=xml                  - Returns the response in XML format.")
-	fmt.Println("  ?format=plaintext            - Returns the response in plain text format.")
-}
+	drawLocation, err = time.LoadLocation("Europe/Paris")
+	if err != nil {
+		log.Fatalf("Failed to load draw time zone: %v", err)
+	}
 
-// defaultHandler redirects the root path to the latest result handler.
-func defaultHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" || r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
+	if sentryDSN != "" {
+		if tracker, err = newSentryClient(sentryDSN); err != nil {
+			log.Fatalf("Invalid --sentry-dsn: %v", err)
+		}
+		panicReporters = append(panicReporters, func(r *http.Request, value any, stack []byte) {
+			go tracker.capture("fatal", fmt.Sprintf("panic: %v", value), requestTags(r), map[string]any{"url": r.URL.RequestURI(), "stack": string(stack)})
+		})
 	}
-	if verbose {
-		log.Printf("GET request for / from %s", r.RemoteAddr)
+
+	// Normalize the base path to a leading slash and no trailing slash.
+	basePath = strings.TrimRight(basePath, "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
 	}
-	latestHandler(w, r)
-}
 
-// setPragmas applies SQLite PRAGMA settings for optimal performance.
-func setPragmas() error {
-	// PRAGMA journal_mode: Use WAL for better concurrency and speed.
-	if _, err := db.Exec("PRAGMA journal_mode = WAL;"); err != nil {
-		return fmt.Errorf("error setting PRAGMA journal_mode: %v", err)
+	switch role {
+	case "primary":
+	case "replica":
+		if mirrorURL != "" {
+			log.Fatalf("--mirror writes the database and cannot be used with --role=replica")
+		}
+		if replicateURL != "" {
+			log.Fatalf("--replicate belongs on the primary and cannot be used with --role=replica")
+		}
+		if primaryURL != "" {
+			primary, err := url.Parse(strings.TrimRight(primaryURL, "/"))
+			if err != nil || (primary.Scheme != "http" && primary.Scheme != "https") || primary.Host == "" {
+				log.Fatalf("Invalid --primary: %s", primaryURL)
+			}
+			primaryProxy = &httputil.ReverseProxy{Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(primary)
+				pr.SetXForwarded()
+			}}
+		}
+	default:
+		log.Fatalf("Invalid --role %q: use primary or replica", role)
 	}
 
-	// PRAGMA synchronous: Set to NORMAL for a good balance of speed and safety.
-	if _, err := db.Exec("PRAGMA synchronous = NORMAL;"); err != nil {
-		return fmt.Errorf("error setting PRAGMA synchronous: %v", err)
+	// The ports are taken before the database is opened, so a second server
+	// neither migrates it nor runs the background jobs.
+	addrs := []string{":8080"}
+	if acmeEnabled {
+		addrs = []string{":443", ":80"}
 	}
-	return nil
-}
+	listeners := claimInstance(addrs)
 
-// initDB initializes the database connection and performs basic validation.
-func initDB() error {
-	// Check if the database file exists.
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		return fmt.Errorf("database file not found at: %s", dbPath)
+	// Initialize the database connection and apply optimizations.
+	if err := initDB(); err != nil {
+		log.Fatalf("Error initializing database: %v", err)
 	}
+	defer db.Close()
 
-	// Get the absolute path for consistency.
-	absPath, err := filepath.Abs(dbPath)
+	// /admin/query reads through its own read-only connections.
+	queryDB, err = sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&_busy_timeout=%d", dbPath, busyTimeout.Milliseconds()))
 	if err != nil {
-		return fmt.Errorf("error getting absolute database path: %v", err)
+		log.Fatalf("Error opening database read-only: %v", err)
 	}
-	dbPath = absPath
+	defer queryDB.Close()
 
-	// Open the SQLite database connection.
-	var errOpen error
-	db, errOpen = sql.Open("sqlite3", dbPath)
-	if errOpen != nil {
-		return fmt.Errorf("error opening database: %v", errOpen)
+	// Prepare the hot queries once; they are reused by every request.
+	if err := prepareStatements(); err != nil {
+		log.Fatalf("Error preparing statements: %v", err)
 	}
+	defer closeStatements()
 
-	// Apply PRAGMA settings for performance.
-	if err := setPragmas(); err != nil {
-		db.Close()
-		return err
+	if selfTestFlag || strictStart {
+		problems := selfTest()
+		for _, p := range problems {
+			log.Printf("SELF-TEST FAILED: %s", p)
+		}
+		if len(problems) > 0 && strictStart {
+			log.Fatalf("Refusing to start: %d self-test checks failed (--strict-start)", len(problems))
+		}
+		if len(problems) == 0 && verbose {
+			log.Printf("Self-test passed")
+		}
 	}
 
-	// Verify that the 'results' table exists.
+	// Watch the database for commits made by other processes (e.g. the updater)
+	// so in-memory data is dropped as soon as the data changes.
+	if err := startChangeWatcher(); err != nil {
+		log.Fatalf("Error starting database change watcher: %v", err)
+	}
+	// A replica counts the requests of the API keys for their quotas, but
+	// does not store them.
+	if authMode == "apikey" && role == "primary" {
+		go usage.flushEvery(30 * time.Second)
+	}
+	if mirrorURL != "" {
+		go mirrorEvery(mirrorInterval)
+	}
+	if replicateURL != "" {
+		rep, err := newReplicator()
+		if err != nil {
+			log.Fatalf("Invalid --replicate: %v", err)
+		}
+		go rep.run(replicateInterval)
+	}
+
+	if cacheEnabled {
+		go cache.sweep(time.Minute)
+	}
+
+	// The expensive routes have their own limits, shared with their per-game variants.
+	resultsLimit := newLimiter(maxInFlightRoute)
+	wheelLimit := newLimiter(maxInFlightRoute)
+	simulateLimit := newLimiter(maxInFlightRoute)
+	waitLimit := newLimiter(maxWaiting)
+
+	// Configure HTTP handlers for different endpoints.
+	// Method-aware patterns: other methods get an automatic 405 with an Allow header.
+	http.HandleFunc("GET /{$}", requireAuth(defaultHandler))
+	http.HandleFunc("GET /results", requireAuth(cached(10*time.Minute, limited(resultsLimit, resultsHandler))))
+	http.HandleFunc("GET /results/latest", requireAuth(latestHandler))
+	http.HandleFunc("GET /results/wait", requireAuth(limited(waitLimit, waitHandler)))
+	http.HandleFunc("GET /results/compare", requireAuth(compareHandler))
+	http.HandleFunc("GET /results/date/{date}", requireAuth(dateHandler))
+	http.HandleFunc("GET /results/date/{date}/history", requireAuth(historyHandler))
+	http.HandleFunc("GET /results/year/{year}", requireAuth(cached(10*time.Minute, yearHandler)))
+	http.HandleFunc("GET /results/month/{month}", requireAuth(cached(10*time.Minute, monthYearHandler)))
+	http.HandleFunc("GET /results/calendar/{year}", requireAuth(cached(10*time.Minute, calendarHandler)))
+
+	http.HandleFunc("GET /generate/wheel", requireAuth(limited(wheelLimit, wheelHandler)))
+	http.HandleFunc("GET /stats/simulate", requireAuth(limited(simulateLimit, simulateHandler)))
+	http.HandleFunc("POST /check/batch", requireAuth(checkBatchHandler))
+	http.HandleFunc("GET /stats", requireAuth(cached(10*time.Minute, statsSummaryHandler)))
+	http.HandleFunc("GET /stats/numbers", requireAuth(cached(10*time.Minute, numberStatsHandler)))
+	http.HandleFunc("GET /stats/year/{year}", requireAuth(cached(10*time.Minute, yearStatsHandler)))
+	http.HandleFunc("GET /stats/heatmap", requireAuth(cached(10*time.Minute, heatmapHandler)))
+	http.HandleFunc("GET /stats/repeats", requireAuth(cached(10*time.Minute, repeatsHandler)))
+	http.HandleFunc("GET /stats/probability", requireAuth(probabilityHandler))
+
+	// The same routes for every supported game.
+	http.HandleFunc("GET /sync", requireAuth(syncHandler))
+	http.HandleFunc("GET /changes", requireAuth(cached(10*time.Minute, changesHandler)))
+	http.HandleFunc("GET /games", requireAuth(gamesHandler))
+	http.HandleFunc("GET /version", requireAuth(versionHandler))
+	http.HandleFunc("GET /schema.xsd", schemaHandler)
+	http.HandleFunc("GET /results.proto", protoSchemaHandler)
+	http.HandleFunc("GET /version/data", requireAuth(dataVersionHandler))
+	http.HandleFunc("GET /status", requireAuth(statusHandler))
+	http.HandleFunc("GET /games/{game}/sync", requireAuth(syncHandler))
+	http.HandleFunc("GET /games/{game}/changes", requireAuth(cached(10*time.Minute, changesHandler)))
+	http.HandleFunc("GET /games/{game}/results", requireAuth(cached(10*time.Minute, limited(resultsLimit, resultsHandler))))
+	http.HandleFunc("GET /games/{game}/results/latest", requireAuth(latestHandler))
+	http.HandleFunc("GET /games/{game}/results/wait", requireAuth(limited(waitLimit, waitHandler)))
+	http.HandleFunc("GET /games/{game}/results/compare", requireAuth(compareHandler))
+	http.HandleFunc("GET /games/{game}/results/date/{date}", requireAuth(dateHandler))
+	http.HandleFunc("GET /games/{game}/results/date/{date}/history", requireAuth(historyHandler))
+	http.HandleFunc("GET /games/{game}/results/year/{year}", requireAuth(cached(10*time.Minute, yearHandler)))
+	http.HandleFunc("GET /games/{game}/results/month/{month}", requireAuth(cached(10*time.Minute, monthYearHandler)))
+	http.HandleFunc("GET /games/{game}/results/calendar/{year}", requireAuth(cached(10*time.Minute, calendarHandler)))
+	http.HandleFunc("GET /games/{game}/stats/numbers", requireAuth(cached(10*time.Minute, numberStatsHandler)))
+	http.HandleFunc("POST /games/{game}/check/batch", requireAuth(checkBatchHandler))
+	http.HandleFunc("GET /games/{game}/stats/year/{year}", requireAuth(cached(10*time.Minute, yearStatsHandler)))
+	http.HandleFunc("GET /games/{game}/stats/heatmap", requireAuth(cached(10*time.Minute, heatmapHandler)))
+	http.HandleFunc("GET /games/{game}/stats/repeats", requireAuth(cached(10*time.Minute, repeatsHandler)))
+	http.HandleFunc("GET /games/{game}/stats", requireAuth(cached(10*time.Minute, statsSummaryHandler)))
+	http.HandleFunc("GET /games/{game}/stats/probability", requireAuth(probabilityHandler))
+	adminMux.HandleFunc("GET /admin/{$}", adminPageHandler)
+	adminMux.HandleFunc("GET /admin/results", adminResultsHandler)
+	adminMux.HandleFunc("PUT /admin/results/{date}", onPrimary(notMirrored(setResultHandler)))
+	adminMux.HandleFunc("DELETE /admin/results/{date}", onPrimary(notMirrored(deleteResultHandler)))
+	adminMux.HandleFunc("GET /admin/scrapes", scrapeLogHandler)
+	adminMux.HandleFunc("GET /admin/audit", auditLogHandler)
+	adminMux.HandleFunc("GET /admin/notes", listNotesHandler)
+	adminMux.HandleFunc("POST /admin/notes/{date}", onPrimary(addNoteHandler))
+	adminMux.HandleFunc("DELETE /admin/notes/{id}", onPrimary(deleteNoteHandler))
+	adminMux.HandleFunc("GET /admin/query", adminQueryHandler)
+	adminMux.HandleFunc("POST /admin/query", adminQueryHandler)
+	adminMux.HandleFunc("GET /admin/maintenance", maintenanceHandler)
+	adminMux.HandleFunc("PUT /admin/maintenance", setMaintenanceHandler)
+	adminMux.HandleFunc("POST /admin/rescrape/{date}", onPrimary(notMirrored(rescrapeHandler)))
+	adminMux.HandleFunc("GET /admin/keys", listKeysHandler)
+	adminMux.HandleFunc("POST /admin/keys", onPrimary(createKeyHandler))
+	adminMux.HandleFunc("DELETE /admin/keys/{id}", onPrimary(revokeKeyHandler))
+	adminMux.HandleFunc("GET /admin/keys/{id}/usage", keyUsageHandler)
+
+	// Subscriptions belong to the API key that created them.
+	if authMode == "apikey" {
+		http.HandleFunc("GET /subscriptions", requireAuth(listSubscriptionsHandler))
+		http.HandleFunc("POST /subscriptions", requireAuth(onPrimary(saveSubscriptionHandler)))
+		http.HandleFunc("GET /subscriptions/{id}", requireAuth(getSubscriptionHandler))
+		http.HandleFunc("PUT /subscriptions/{id}", requireAuth(onPrimary(saveSubscriptionHandler)))
+		http.HandleFunc("DELETE /subscriptions/{id}", requireAuth(onPrimary(deleteSubscriptionHandler)))
+		http.HandleFunc("GET /subscriptions/confirm/{token}", onPrimary(confirmSubscriptionHandler))
+	}
+	http.Handle("/admin/", adminAuth(adminMux))
+
+	var handler http.Handler = withCanonicalPaths(http.DefaultServeMux, withOptions(http.DefaultServeMux))
+	if basePath != "" {
+		root := http.NewServeMux()
+		root.Handle(basePath+"/", http.StripPrefix(basePath, handler))
+		handler = root
+	}
+	if tracker != nil {
+		handler = withErrorReports(handler)
+	}
+	maintenance.Store(maintenanceFlag)
+	handler = withMaintenance(withRevision(handler))
+	handler = withRecovery(exceptLongPolls(limited(newLimiter(maxInFlight), handler.ServeHTTP), handler))
+
+	if acmeEnabled {
+		server, err := acmeServer(handler, listeners[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		serve(server, func() error { return server.ServeTLS(listeners[0], "", "") })
+		return
+	}
+
+	server := &http.Server{Handler: handler}
+	log.Printf("Server started on port 8080 (Database: %s, Base path: %s)", dbPath, appURL("/"))
+	serve(server, func() error { return server.Serve(listeners[0]) })
+}
+
+// instancePIDFile returns the path of the PID file of the server. A replica
+// has none by default: its database may be shared with the primary's host.
+func instancePIDFile() string {
+	if pidFile == "" && role == "replica" {
+		return ""
+	}
+	return cmp.Or(pidFile, dbPath+".pid")
+}
+
+// instanceLock is the PID file, locked for as long as the server runs.
+var instanceLock *os.File
+
+// clearPIDFile empties the PID file of the server, if it has one. The file
+// itself stays: a server waiting for its lock has it open already.
+func clearPIDFile() {
+	if instanceLock != nil {
+		instanceLock.Truncate(0)
+	}
+}
+
+// claimInstance makes sure this is the only server on the database and its
+// ports, and returns the listeners of addrs. The PID file is locked while the
+// server runs, like the updater's lock file, so a PID left by a crash is
+// ignored. When the lock is held, the server named in the file is stopped
+// with --takeover, and this one waits for it to drain and exit; otherwise
+// the start fails with an error naming it, as it does when a port is held
+// by another process.
+func claimInstance(addrs []string) []net.Listener {
+	path := instancePIDFile()
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatalf("Error opening the PID file: %v", err)
+		}
+		if tryLock(f) != nil {
+			data, _ := os.ReadFile(path)
+			pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+			if !takeover || pid <= 0 {
+				log.Fatalf("Another server (PID %d, see %s) is already running on %s; stop it, or start with --takeover to replace it", pid, path, dbPath)
+			}
+			log.Printf("Stopping the server with PID %d and waiting for its requests to drain", pid)
+			if err := terminate(pid); err != nil {
+				log.Fatalf("Failed to stop the server with PID %d: %v", pid, err)
+			}
+			deadline := time.Now().Add(drainTimeout + 10*time.Second)
+			for tryLock(f) != nil {
+				if time.Now().After(deadline) {
+					log.Fatalf("The server with PID %d did not stop within %s", pid, drainTimeout+10*time.Second)
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+		instanceLock = f
+	}
+
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalf("Port %s is already in use by another process: %v", strings.TrimPrefix(addr, ":"), err)
+		}
+		listeners = append(listeners, ln)
+	}
+	if instanceLock == nil {
+		return listeners
+	}
+	instanceLock.Truncate(0)
+	if _, err := instanceLock.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		log.Fatalf("Error writing the PID file: %v", err)
+	}
+	return listeners
+}
+
+// serve runs the server until SIGTERM or Ctrl-C, then stops accepting
+// connections, waits up to --drain-timeout for the requests in flight and
+// clears the PID file.
+func serve(server *http.Server, listen func() error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 1)
+	go func() { errs <- listen() }()
+	select {
+	case err := <-errs:
+		clearPIDFile()
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("Stopping: waiting up to %s for the requests in flight", drainTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Requests still in flight were cut: %v", err)
+	}
+	clearPIDFile()
+	log.Printf("Stopped")
+}
+
+// acmeServer returns the HTTPS server using certificates obtained and renewed
+// by autocert. The challenge listener, port 80, answers the ACME HTTP-01
+// challenges and redirects everything else to HTTPS.
+func acmeServer(handler http.Handler, challenge net.Listener) (*http.Server, error) {
+	var domains []string
+	for _, domain := range strings.Split(acmeDomains, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("--acme requires at least one --domain")
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(acmeCacheDir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      acmeEmail,
+	}
+
+	go func() {
+		log.Printf("ACME challenge server started on port 80")
+		if err := http.Serve(challenge, manager.HTTPHandler(nil)); err != nil {
+			log.Printf("ACME challenge server error: %v", err)
+		}
+	}()
+
+	server := &http.Server{
+		Handler:   handler,
+		TLSConfig: manager.TLSConfig(),
+	}
+	log.Printf("Server started on port 443 for %s (Database: %s, Base path: %s)", strings.Join(domains, ", "), dbPath, appURL("/"))
+	return server, nil
+}
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and CIDR ranges.
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// isTrustedProxy reports whether ip belongs to one of the --trusted-proxies ranges.
+func isTrustedProxy(ip net.IP) bool {
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that made the request.
+// X-Forwarded-For and X-Real-IP are only honoured when the direct peer is a trusted proxy;
+// X-Forwarded-For is walked from the right, skipping trusted hops.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || !isTrustedProxy(peer) {
+		return host
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			if i == 0 || !isTrustedProxy(ip) {
+				return ip.String()
+			}
+		}
+	}
+	if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+		return realIP.String()
+	}
+	return host
+}
+
+// appURL returns the public path of a route, including the configured base path.
+// It must be used for every link the server generates.
+func appURL(path string) string {
+	return basePath + path
+}
+
+// buildMetadata returns the commit and build date of the binary: the ones set
+// with -ldflags, or else those of the VCS stamp of go build, which a build of
+// listed files, as the README documents, does not have.
+func buildMetadata() (rev, date string) {
+	rev, date = commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && rev == "":
+				rev = s.Value[:min(len(s.Value), 12)]
+			case s.Key == "vcs.time" && date == "":
+				date = s.Value
+			}
+		}
+	}
+	return rev, date
+}
+
+// versionString formats the version with the commit and build date, when known.
+func versionString() string {
+	s := "v" + version
+	rev, date := buildMetadata()
+	if rev != "" {
+		s += " (commit " + rev
+		if date != "" {
+			s += ", built " + date
+		}
+		s += ")"
+	} else if date != "" {
+		s += " (built " + date + ")"
+	}
+	return s
+}
+
+// printQueryHelp is the help of the query command.
+func printQueryHelp() {
+	fmt.Println(queryCmd.summary)
+	fmt.Println("\nUsage:")
+	name := filepath.Base(os.Args[0])
+	fmt.Printf("  %s query [options] latest\n", name)
+	fmt.Printf("  %s query [options] date YYYY-MM-DD\n", name)
+	fmt.Printf("  %s query [options] year YYYY\n", name)
+	fmt.Printf("  %s query [options] check NUMBERS STARS [FROM [TO]]\n", name)
+	fmt.Println("\ncheck matches a line (e.g. 3,15,22,38,47 2,9) against the latest draw, or the draws from FROM to TO.")
+	fmt.Println("\nOptions:")
+	queryCmd.printFlags()
+}
+
+// runQuery answers a query straight from the database. It runs the handler
+// of the matching endpoint in-process, so the output is exactly that of the
+// API, and exits with 1 and the error message when the handler fails.
+func runQuery(args []string) {
+	if len(args) == 0 {
+		printQueryHelp()
+		os.Exit(2)
+	}
+	g := findGame(strings.ToLower(queryGame))
+	if g == nil {
+		log.Fatalf("Unknown game: %s", queryGame)
+	}
+	format := strings.ToLower(queryFormat)
+	if queryNDJSON {
+		format = "ndjson"
+	}
+	if !slices.Contains([]string{"json", "ndjson", "xml", "csv", "plaintext"}, format) {
+		log.Fatalf("Invalid format: %s (use json, ndjson, xml, csv or plaintext)", queryFormat)
+	}
+
+	query := url.Values{"format": {format}, "lang": {queryLang}}
+	method, path := http.MethodGet, "/games/"+g.ID+"/results/"
+	pathValues := map[string]string{"game": g.ID}
+	var body io.Reader
+	var handler http.HandlerFunc
+	switch args[0] {
+	case "latest":
+		handler, path = latestHandler, path+"latest"
+	case "date", "year":
+		if len(args) != 2 {
+			printQueryHelp()
+			os.Exit(2)
+		}
+		handler = dateHandler
+		if args[0] == "year" {
+			handler = yearHandler
+		}
+		path += args[0] + "/" + args[1]
+		pathValues[args[0]] = args[1]
+	case "check":
+		if len(args) < 3 || len(args) > 5 {
+			printQueryHelp()
+			os.Exit(2)
+		}
+		if format == "csv" {
+			log.Fatalf("The csv format is only available for draws")
+		}
+		numbers, err1 := parseIntList(args[1])
+		stars, err2 := parseIntList(args[2])
+		if err1 != nil || err2 != nil {
+			log.Fatalf("Invalid line: %s %s", args[1], args[2])
+		}
+		req := CheckRequest{Lines: []WheelLine{{Numbers: numbers, Stars: stars}}}
+		if len(args) > 3 {
+			req.From = args[3]
+			req.To = req.From
+		}
+		if len(args) > 4 {
+			req.To = args[4]
+		}
+		data, err := json.Marshal(req)
+		if err != nil {
+			log.Fatalf("Error encoding the line: %v", err)
+		}
+		handler, method, path, body = checkBatchHandler, http.MethodPost, "/games/"+g.ID+"/check/batch", bytes.NewReader(data)
+	default:
+		printQueryHelp()
+		os.Exit(2)
+	}
+
+	var err error
+	if drawLocation, err = time.LoadLocation("Europe/Paris"); err != nil {
+		log.Fatalf("Failed to load draw time zone: %v", err)
+	}
+	if err := initDB(); err != nil {
+		log.Fatalf("Error initializing database: %v", err)
+	}
+	defer db.Close()
+	if err := prepareStatements(); err != nil {
+		log.Fatalf("Error preparing statements: %v", err)
+	}
+	defer closeStatements()
+
+	r, err := http.NewRequestWithContext(context.Background(), method, path+"?"+query.Encode(), body)
+	if err != nil {
+		log.Fatalf("Invalid query: %v", err)
+	}
+	for name, value := range pathValues {
+		r.SetPathValue(name, value)
+	}
+	capture := &captureWriter{header: make(http.Header)}
+	handler(capture, r)
+	if capture.status >= 400 {
+		fmt.Fprint(os.Stderr, capture.body.String())
+		db.Close()
+		os.Exit(1)
+	}
+	os.Stdout.Write(capture.body.Bytes())
+}
+
+// runExport writes the whole draw history of a game, oldest first, to a file
+// or to the standard output.
+func runExport(args []string) {
+	g := findGame(strings.ToLower(exportGame))
+	if g == nil {
+		log.Fatalf("Unknown game: %s", exportGame)
+	}
+	format := strings.ToLower(exportFormat)
+	if !slices.Contains([]string{"csv", "ndjson", "parquet"}, format) {
+		log.Fatalf("Invalid format: %s (use csv, ndjson or parquet)", exportFormat)
+	}
+
+	var err error
+	if drawLocation, err = time.LoadLocation("Europe/Paris"); err != nil {
+		log.Fatalf("Failed to load draw time zone: %v", err)
+	}
+	if err := initDB(); err != nil {
+		log.Fatalf("Error initializing database: %v", err)
+	}
+	defer db.Close()
+	if err := prepareStatements(); err != nil {
+		log.Fatalf("Error preparing statements: %v", err)
+	}
+	defer closeStatements()
+
+	results, err := queryResults(g, g.stmts.all)
+	if err != nil {
+		log.Fatalf("Error fetching results: %v", err)
+	}
+	slices.Reverse(results)
+	for i := range results {
+		if t, err := drawTime(results[i].Date); err == nil {
+			results[i].Timestamp = t.Format(time.RFC3339)
+		}
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "csv":
+		writeResultsCSV(&buf, results)
+	case "ndjson":
+		err = writeNDJSON(&buf, results)
+	case "parquet":
+		err = writeParquet(&buf, g, results)
+	}
+	if err != nil {
+		log.Fatalf("Error encoding the draws: %v", err)
+	}
+	if exportOutput == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	if err := os.WriteFile(exportOutput, buf.Bytes(), 0644); err != nil {
+		log.Fatalf("Error writing %s: %v", exportOutput, err)
+	}
+	log.Printf("Exported %d %s draws to %s", len(results), g.Name, exportOutput)
+}
+
+// runTUI opens the database and browses it in the terminal.
+func runTUI(args []string) {
+	var err error
+	if drawLocation, err = time.LoadLocation("Europe/Paris"); err != nil {
+		log.Fatalf("Failed to load draw time zone: %v", err)
+	}
+	g := findGame(strings.ToLower(tuiGame))
+	if g == nil {
+		log.Fatalf("Unknown game: %s", tuiGame)
+	}
+	if err := initDB(); err != nil {
+		log.Fatalf("Error initializing database: %v", err)
+	}
+	defer db.Close()
+	if err := prepareStatements(); err != nil {
+		log.Fatalf("Error preparing statements: %v", err)
+	}
+	defer closeStatements()
+
+	b := &browser{out: os.Stdout, clear: isTerminal(os.Stdout), pageSize: 20}
+	if err := b.load(g); err != nil {
+		log.Fatalf("Error fetching results: %v", err)
+	}
+	b.run(bufio.NewScanner(os.Stdin))
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or a file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// browser is the state of the terminal browser: the draws of a game, newest
+// first, shown a page at a time or as frequency charts.
+type browser struct {
+	out      io.Writer
+	clear    bool
+	game     *Game
+	results  []Result
+	page     int
+	pageSize int
+	view     string // "list", "numbers" or "stars"
+	selected string
+	message  string
+}
+
+// load reads every draw of a game and shows the first page.
+func (b *browser) load(g *Game) error {
+	results, err := queryResults(g, g.stmts.all)
+	if err != nil {
+		return err
+	}
+	b.game, b.results, b.page, b.view, b.selected = g, results, 0, "list", ""
+	return nil
+}
+
+// run reads commands until "q" or the end of the input.
+func (b *browser) run(in *bufio.Scanner) {
+	for {
+		b.render()
+		fmt.Fprint(b.out, "> ")
+		if !in.Scan() {
+			fmt.Fprintln(b.out)
+			return
+		}
+		fields := strings.Fields(in.Text())
+		b.message = ""
+		if len(fields) == 0 {
+			fields = []string{"n"}
+		}
+		arg := ""
+		if len(fields) > 1 {
+			arg = fields[1]
+		}
+		switch strings.ToLower(fields[0]) {
+		case "n", "next":
+			b.view = "list"
+			b.page = min(b.page+1, b.lastPage())
+		case "p", "prev":
+			b.view = "list"
+			b.page = max(b.page-1, 0)
+		case "d", "date":
+			b.jump(arg, true)
+		case "y", "year":
+			b.jump(arg+"-12-31", false)
+		case "f", "freq":
+			b.view = "numbers"
+			if strings.HasPrefix(arg, "s") {
+				b.view = "stars"
+			}
+		case "l", "list":
+			b.view = "list"
+		case "g", "game":
+			g := findGame(strings.ToLower(arg))
+			if g == nil {
+				b.message = fmt.Sprintf("Unknown game %q: euromillions or thunderball", arg)
+			} else if err := b.load(g); err != nil {
+				b.message = fmt.Sprintf("Error fetching results: %v", err)
+			}
+		case "q", "quit", "exit":
+			return
+		default:
+			b.message = fmt.Sprintf("Unknown command %q", fields[0])
+		}
+	}
+}
+
+// lastPage returns the index of the last page of the list.
+func (b *browser) lastPage() int {
+	return max((len(b.results)-1)/b.pageSize, 0)
+}
+
+// jump shows the page of the draw of a date, or of the closest earlier draw,
+// which is reported when exact is set.
+func (b *browser) jump(date string, exact bool) {
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		b.message = "Dates are YYYY-MM-DD and years YYYY"
+		return
+	}
+	// The draws are newest first: find the first one on or before date.
+	i := sort.Search(len(b.results), func(i int) bool { return b.results[i].Date <= date })
+	if i == len(b.results) {
+		b.message = "No draw on or before " + date
+		return
+	}
+	b.view, b.page, b.selected = "list", i/b.pageSize, b.results[i].Date
+	if exact && b.selected != date {
+		b.message = "No draw on " + date + ", showing the closest earlier one"
+	}
+}
+
+// render draws the current view.
+func (b *browser) render() {
+	if b.clear {
+		fmt.Fprint(b.out, "\033[H\033[2J")
+	}
+	fmt.Fprintf(b.out, "%s - %d draws\n\n", b.game.Name, len(b.results))
+	switch b.view {
+	case "numbers", "stars":
+		b.renderChart()
+	default:
+		b.renderList()
+	}
+	if b.message != "" {
+		fmt.Fprintf(b.out, "\n%s\n", b.message)
+	}
+	fmt.Fprintln(b.out, "\n[n]ext [p]rev [d]ate YYYY-MM-DD [y]ear YYYY [f]req numbers|stars [l]ist [g]ame NAME [q]uit")
+}
+
+// renderList prints a page of draws, marking the one searched for.
+func (b *browser) renderList() {
+	start := b.page * b.pageSize
+	end := min(start+b.pageSize, len(b.results))
+	fmt.Fprintf(b.out, "  %-10s  %-20s  %s\n", "Date", "Numbers", "Stars")
+	for _, res := range b.results[start:end] {
+		mark := " "
+		if res.Date == b.selected {
+			mark = ">"
+		}
+		special := ""
+		if res.Special {
+			special = "  special"
+		}
+		fmt.Fprintf(b.out, "%s %-10s  %-20s  %s%s\n", mark, res.Date, joinInts(res.Numbers), joinInts(res.Stars), special)
+	}
+	fmt.Fprintf(b.out, "\nPage %d of %d\n", b.page+1, b.lastPage()+1)
+}
+
+// renderChart prints how often each number or star was drawn as bars.
+func (b *browser) renderChart() {
+	size := b.game.MaxNumber
+	if b.view == "stars" {
+		size = b.game.MaxStar
+	}
+	counts := make([]int, size+1)
+	for _, res := range b.results {
+		balls := res.Numbers
+		if b.view == "stars" {
+			balls = res.Stars
+		}
+		for _, n := range balls {
+			if n >= 1 && n <= size {
+				counts[n]++
+			}
+		}
+	}
+	top := max(slices.Max(counts), 1)
+	fmt.Fprintf(b.out, "Draws per %s:\n", strings.TrimSuffix(b.view, "s"))
+	for n := 1; n <= size; n++ {
+		fmt.Fprintf(b.out, "%3d %-40s %d\n", n, strings.Repeat("█", counts[n]*40/top), counts[n])
+	}
+}
+
+// runCheckUpdate reports whether the latest GitHub release is newer than the
+// running version. It exits with 1 when it cannot tell.
+func runCheckUpdate(args []string) {
+	req, err := http.NewRequest(http.MethodGet, releasesURL, nil)
+	if err != nil {
+		log.Fatalf("Error checking for updates: %v", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "go-euromillions-api/"+version)
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatalf("Error checking for updates: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Error checking for updates: GitHub answered %s", resp.Status)
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		log.Fatalf("Error reading the latest release: %v", err)
+	}
+
+	fmt.Printf("Running:        %s\n", versionString())
+	fmt.Printf("Latest release: %s\n", release.TagName)
+	if compareVersions(release.TagName, version) > 0 {
+		fmt.Printf("A newer version is available: %s\n", release.HTMLURL)
+	} else {
+		fmt.Println("Up to date.")
+	}
+}
+
+// compareVersions compares two dotted versions such as v1.10.2 numerically,
+// returning -1, 0 or 1. A leading v and any -suffix are ignored.
+func compareVersions(a, b string) int {
+	parse := func(v string) []int {
+		v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+		var parts []int
+		for _, p := range strings.Split(v, ".") {
+			n, _ := strconv.Atoi(p)
+			parts = append(parts, n)
+		}
+		return parts
+	}
+	pa, pb := parse(a), parse(b)
+	for i := range max(len(pa), len(pb)) {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			return cmp.Compare(x, y)
+		}
+	}
+	return 0
+}
+
+// printHelp displays a detailed help message, including usage, flags, and available endpoints.
+func printHelp() {
+	fmt.Println("EuroMillions API - Results Server")
+	fmt.Println("---------------------------------")
+	fmt.Println("\nUsage:")
+	fmt.Println("  ./euromillions-api [command] [options]")
+	fmt.Println()
+	printCommands(commands)
+	fmt.Println("\nOptions:")
+	serveCmd.printFlags()
+	fmt.Println("\nAvailable Endpoints:")
+	fmt.Println("  GET /                        - Returns the latest drawing result (default).")
+	fmt.Println("  GET /results                 - Returns all drawing results.")
+	fmt.Println("  GET /results/latest          - Returns the latest drawing result.")
+	fmt.Println("  GET /results/wait            - Waits for a draw newer than ?after= (long polling, ?timeout=60s).")
+	fmt.Println("  GET /results/date/{date}     - Search by a specific date (e.g., /results/date/2024-01-15).")
+	fmt.Println("  GET /results/date/{date}/history - Every version of a draw, including corrected and deleted ones.")
+	fmt.Println("  GET /results/compare         - Shared balls and positional differences of two draws (?d1=&d2=).")
+	fmt.Println("  GET /results/year/{year}     - Search by year (e.g., /results/year/2023).")
+	fmt.Println("  GET /results/month/{month}   - Search by month and year (e.g., /results/month/2024-03).")
+	fmt.Println("  GET /results/calendar/{year} - Month-by-month summary of a year (e.g., /results/calendar/2023).")
+	fmt.Println("  GET /generate/wheel          - Abbreviated wheel from a pool (e.g., /generate/wheel?numbers=1,5,9,14,22,31,40&stars=2,5,9).")
+	fmt.Println("  GET /stats/simulate          - Monte Carlo simulation of playing random lines (e.g., /stats/simulate?lines=2&draws=104).")
+	fmt.Println("  GET /stats                   - Digest for dashboards: draws, latest draw, hot and cold balls, last update.")
+	fmt.Println("  GET /stats/numbers           - How often each number and star was drawn, when it was last drawn, and the most frequent pairs.")
+	fmt.Println("  GET /stats/year/{year}       - Summary of a year: draws, most and least frequent balls, average sums.")
+	fmt.Println("  GET /stats/heatmap           - Appearances of each ball per month or year (?granularity=month|year).")
+	fmt.Println("  GET /stats/repeats           - How often balls repeat from the preceding draw.")
+	fmt.Println("  GET /stats/probability       - Exact odds of matching ?numbers= and ?stars= with one line.")
+	fmt.Println("  POST /check/batch            - Check up to 100 lines against the draws of a date range (JSON body).")
+	fmt.Println("  GET /sync?since={date}       - Draws newer than a date plus a dataset version token, for mirrors.")
+	fmt.Println("  GET /changes                 - Recent new, backfilled, corrected and deleted draws (?since={id}, ?format=rss).")
+	fmt.Println("  GET /games                   - Lists the supported games.")
+	fmt.Println("  GET /version                 - Versions of the server, Go, SQLite, the schema and the dataset.")
+	fmt.Println("  GET /version/data            - The dataset revision, also sent as X-Dataset-Revision on every response.")
+	fmt.Println("  GET /status                  - Whether the data is fresh: newest draws, the updater's last runs, database size.")
+	fmt.Println("  GET /schema.xsd              - XML schema of the results with ?format=xml&xml=schema or &xml=compact.")
+	fmt.Println("  GET /results.proto           - Protocol Buffers schema of the results with ?format=proto.")
+	fmt.Println("  GET /games/{game}/results... - The results endpoints above for a game (e.g., /games/thunderball/results/latest).")
+	fmt.Println("\nURL Query Parameters for Output Format:")
+	fmt.Println("  ?format=json                 - Returns the response in JSON format (default).")
+	fmt.Println("  ?format=xml                  - Returns the response in XML format.")
+	fmt.Println("  ?format=plaintext            - Returns the response in plain text format.")
+	fmt.Println("  ?format=ndjson               - Returns one JSON object per line (newline-delimited JSON).")
+	fmt.Println("  ?special=true|false          - Only special draws (Superdraws) or only regular draws, on list endpoints.")
+	fmt.Println("  ?envelope=true               - Wrap JSON/XML results in {\"count\": n, \"results\": [...]}, even for single results.")
+	fmt.Println("  ?include=notes               - Add the admins' notes of each draw.")
+	fmt.Println("  ?page=N&per_page=M           - Paginate list endpoints (Link and X-Total-Count headers).")
+	fmt.Println("  ?tz=Europe/Lisbon            - Time zone of the draw timestamps (default Europe/Paris).")
+	fmt.Println("  ?lang=en|pt|fr|es            - Language of plain text labels and error messages (default en).")
+	fmt.Println("  ?nearest=before|after        - On the date endpoint, the closest draw to a day without one.")
+}
+
+// defaultHandler redirects the root path to the latest result handler.
+func defaultHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for / from %s", clientIP(r))
+	}
+	latestHandler(w, r)
+}
+
+// adminAuth guards the admin area with HTTP Basic authentication.
+// The password is checked against the bcrypt hash given by --admin-password-hash.
+// If no credentials are configured the admin area is disabled and answers 404.
+func adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminUser == "" || adminPasswordHash == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		user, password, ok := r.BasicAuth()
+		if ok {
+			userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(adminUser)) == 1
+			passwordMatch := bcrypt.CompareHashAndPassword([]byte(adminPasswordHash), []byte(password)) == nil
+			ok = userMatch && passwordMatch
+		}
+		if !ok {
+			if verbose {
+				log.Printf("Unauthorized admin request for %s from %s", r.URL.Path, clientIP(r))
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="EuroMillions API Admin", charset="UTF-8"`)
+			http.Error(w, tr(r, "unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAuth guards a read endpoint with the configured authentication mode.
+// With --auth=jwt the request must carry a valid "Authorization: Bearer <token>" header.
+func requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authMode == "jwt" {
+			token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || token == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="EuroMillions API"`)
+				http.Error(w, tr(r, "unauthorized"), http.StatusUnauthorized)
+				return
+			}
+			if err := validateJWT(token); err != nil {
+				if verbose {
+					log.Printf("Rejected bearer token from %s: %v", clientIP(r), err)
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer realm="EuroMillions API", error="invalid_token"`)
+				http.Error(w, tr(r, "unauthorized"), http.StatusUnauthorized)
+				return
+			}
+		}
+		if authMode == "apikey" {
+			id, ok := checkAPIKey(w, r)
+			if !ok {
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyIDKey{}, id))
+		}
+		next(w, r)
+	}
+}
+
+// jwtHeader holds the JOSE header fields used for validation.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims holds the registered claims checked by validateJWT.
+// The audience may be a single string or a list of strings, and the times
+// are NumericDates, which may have a fractional part.
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+}
+
+// validateJWT verifies the signature and the time, issuer and audience claims of a compact JWT.
+// HS256 tokens are checked against --jwt-secret, RS256 tokens against the keys published at --jwks-url.
+func validateJWT(token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed token")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("error decoding header: %v", err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return fmt.Errorf("error parsing header: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("error decoding signature: %v", err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case "HS256":
+		if jwtSecret == "" {
+			return fmt.Errorf("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, []byte(jwtSecret))
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return fmt.Errorf("invalid signature")
+		}
+	case "RS256":
+		if jwksURL == "" {
+			return fmt.Errorf("RS256 tokens are not accepted")
+		}
+		key, err := jwks.key(header.Kid)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm: %q", header.Alg)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("error decoding payload: %v", err)
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return fmt.Errorf("error parsing claims: %v", err)
+	}
+
+	now := float64(time.Now().UnixMilli()) / 1000
+	if claims.ExpiresAt != nil && now >= *claims.ExpiresAt {
+		return fmt.Errorf("token expired")
+	}
+	if claims.NotBefore != nil && now < *claims.NotBefore {
+		return fmt.Errorf("token not valid yet")
+	}
+	if jwtIssuer != "" && claims.Issuer != jwtIssuer {
+		return fmt.Errorf("unexpected issuer: %q", claims.Issuer)
+	}
+	if jwtAudience != "" {
+		var audiences []string
+		var single string
+		if err := json.Unmarshal(claims.Audience, &single); err == nil {
+			audiences = []string{single}
+		} else if err := json.Unmarshal(claims.Audience, &audiences); err != nil {
+			return fmt.Errorf("invalid audience claim")
+		}
+		found := false
+		for _, aud := range audiences {
+			if aud == jwtAudience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("token not issued for this audience")
+		}
+	}
+	return nil
+}
+
+// jwksCache keeps the RSA keys fetched from --jwks-url.
+// The set is refreshed when an unknown key ID is seen, at most once per minute.
+// The fetch runs outside the lock, so the known keys are served meanwhile,
+// and the requests needing the new set share it.
+type jwksCache struct {
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	// fetching is closed when the fetch in flight, if any, is done.
+	fetching chan struct{}
+}
+
+var jwks = &jwksCache{}
+
+// key returns the RSA public key with the given key ID, refreshing the key set if needed.
+func (c *jwksCache) key(kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	if key, ok := c.keys[kid]; ok {
+		c.mu.Unlock()
+		return key, nil
+	}
+	if done := c.fetching; done != nil {
+		c.mu.Unlock()
+		<-done
+		return c.known(kid)
+	}
+	if time.Since(c.fetchedAt) < time.Minute {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("unknown key ID: %q", kid)
+	}
+	done := make(chan struct{})
+	c.fetching, c.fetchedAt = done, time.Now()
+	c.mu.Unlock()
+
+	keys, err := fetchJWKS(jwksURL)
+	if err != nil {
+		log.Printf("Error fetching JWKS from %s: %v", jwksURL, err)
+	}
+
+	c.mu.Lock()
+	if err == nil {
+		c.keys = keys
+	}
+	c.fetching, c.fetchedAt = nil, time.Now()
+	c.mu.Unlock()
+	close(done)
+	return c.known(kid)
+}
+
+// known returns the RSA public key with the given key ID from the current set.
+func (c *jwksCache) known(kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key ID: %q", kid)
+}
+
+// fetchJWKS downloads a JSON Web Key Set and returns its RSA keys indexed by key ID.
+func fetchJWKS(url string) (map[string]*rsa.PublicKey, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("error parsing JWKS: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// apiKey is a valid key, as needed to authorize a request.
+type apiKey struct {
+	id    string
+	quota int64
+}
+
+// keyCache maps the hashes of valid keys to their key. It is dropped whenever
+// a key is created or revoked, and whenever the database changes, which is how
+// a replica learns of a key revoked on the primary.
+var keyCache = struct {
+	sync.Mutex
+	keys map[string]apiKey
+}{keys: make(map[string]apiKey)}
+
+// hashAPIKey returns the hash under which a key is stored.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookupAPIKey returns the valid key matching key, if any.
+func lookupAPIKey(key string) (apiKey, bool, error) {
+	hash := hashAPIKey(key)
+	keyCache.Lock()
+	k, ok := keyCache.keys[hash]
+	keyCache.Unlock()
+	if ok {
+		return k, true, nil
+	}
+
+	err := retryBusy(func() error {
+		return db.QueryRow("SELECT id, daily_quota FROM api_keys WHERE key_hash = ? AND revoked IS NULL", hash).Scan(&k.id, &k.quota)
+	})
+	if err == sql.ErrNoRows {
+		return apiKey{}, false, nil
+	}
+	if err != nil {
+		return apiKey{}, false, err
+	}
+	keyCache.Lock()
+	keyCache.keys[hash] = k
+	keyCache.Unlock()
+	return k, true, nil
+}
+
+func forgetAPIKeys() {
+	keyCache.Lock()
+	defer keyCache.Unlock()
+	keyCache.keys = make(map[string]apiKey)
+}
+
+// checkAPIKey authorizes a request of the apikey mode, from its X-API-Key
+// header or api_key query parameter, and counts it against the key's daily
+// quota. It returns the ID of the key, or writes the error response and
+// returns false when the request must not be served.
+func checkAPIKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = r.URL.Query().Get("api_key")
+	}
+	if key == "" {
+		http.Error(w, tr(r, "unauthorized"), http.StatusUnauthorized)
+		return "", false
+	}
+
+	k, ok, err := lookupAPIKey(key)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error looking up API key: %v", err)
+		return "", false
+	}
+	if !ok {
+		if verbose {
+			log.Printf("Rejected API key from %s", clientIP(r))
+		}
+		http.Error(w, tr(r, "unauthorized"), http.StatusUnauthorized)
+		return "", false
+	}
+
+	count, err := usage.add(k.id)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error reading usage of API key %s: %v", k.id, err)
+		return "", false
+	}
+	if k.quota > 0 {
+		w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(k.quota, 10))
+		w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(max(k.quota-count, 0), 10))
+		if count > k.quota {
+			now := time.Now().UTC()
+			reset := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+			w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+			http.Error(w, tr(r, "quota_exceeded", k.quota), http.StatusTooManyRequests)
+			return "", false
+		}
+	}
+	return k.id, true
+}
+
+// apiKeyIDKey is the request context key of the ID of the caller's API key.
+type apiKeyIDKey struct{}
+
+// requestKeyID returns the ID of the API key of a request authorized by
+// requireAuth in the apikey mode.
+func requestKeyID(r *http.Request) string {
+	id, _ := r.Context().Value(apiKeyIDKey{}).(string)
+	return id
+}
+
+// keyUsage counts the requests of each API key per UTC day. Counts are kept
+// in memory and added to the api_key_usage table periodically rather than
+// written on every request.
+type keyUsage struct {
+	mu  sync.Mutex
+	day string
+	// today holds the requests of the day, stored and pending, of the keys
+	// seen since the day started; pending those not yet stored.
+	today   map[string]int64
+	pending map[usageKey]int64
+}
+
+type usageKey struct {
+	id, day string
+}
+
+var usage = &keyUsage{today: make(map[string]int64), pending: make(map[usageKey]int64)}
+
+// add counts a request of key id and returns its number of requests today,
+// including this one. Over-quota requests are counted too.
+func (u *keyUsage) add(id string) (int64, error) {
+	day := time.Now().UTC().Format(time.DateOnly)
+
+	u.mu.Lock()
+	if day != u.day {
+		u.day = day
+		u.today = make(map[string]int64)
+	}
+	count, ok := u.today[id]
+	u.mu.Unlock()
+
+	if !ok {
+		// First request of the key since the day started or the server
+		// restarted: resume from the stored count.
+		err := retryBusy(func() error {
+			return db.QueryRow("SELECT requests FROM api_key_usage WHERE key_id = ? AND day = ?", id, day).Scan(&count)
+		})
+		if err != nil && err != sql.ErrNoRows {
+			return 0, err
+		}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if day == u.day {
+		if current, ok := u.today[id]; ok {
+			count = current
+		}
+		count++
+		u.today[id] = count
+	}
+	u.pending[usageKey{id, day}]++
+	return count, nil
+}
+
+// requestsToday returns the requests of key id today, including those not
+// stored yet.
+func (u *keyUsage) requestsToday(id string) (int64, error) {
+	day := time.Now().UTC().Format(time.DateOnly)
+	u.mu.Lock()
+	count, ok := u.today[id]
+	if day != u.day {
+		ok = false
+	}
+	u.mu.Unlock()
+	if ok {
+		return count, nil
+	}
+	err := retryBusy(func() error {
+		return db.QueryRow("SELECT requests FROM api_key_usage WHERE key_id = ? AND day = ?", id, day).Scan(&count)
+	})
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return count, err
+}
+
+// pendingFor returns the requests of key id counted but not stored yet, by day.
+func (u *keyUsage) pendingFor(id string) map[string]int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	days := make(map[string]int64)
+	for k, n := range u.pending {
+		if k.id == id {
+			days[k.day] += n
+		}
+	}
+	return days
+}
+
+// flush adds the pending counts to the api_key_usage table. They are kept
+// for the next flush if the write fails.
+func (u *keyUsage) flush() error {
+	u.mu.Lock()
+	pending := u.pending
+	u.pending = make(map[usageKey]int64)
+	u.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := withServerConn(func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		for k, n := range pending {
+			_, err := tx.Exec(`INSERT INTO api_key_usage (key_id, day, requests) VALUES (?, ?, ?)
+				ON CONFLICT (key_id, day) DO UPDATE SET requests = requests + excluded.requests`, k.id, k.day, n)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		u.mu.Lock()
+		for k, n := range pending {
+			u.pending[k] += n
+		}
+		u.mu.Unlock()
+	}
+	return err
+}
+
+// flushEvery flushes the usage counts at the given interval, forever.
+func (u *keyUsage) flushEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := u.flush(); err != nil {
+			log.Printf("Error storing API key usage: %v", err)
+		}
+	}
+}
+
+// APIKey describes an API key in the admin responses. Key, the secret, is
+// only returned when the key is created.
+type APIKey struct {
+	ID            string `json:"id" xml:"id,attr"`
+	Name          string `json:"name" xml:"name"`
+	Key           string `json:"key,omitempty" xml:"key,omitempty"`
+	DailyQuota    int64  `json:"daily_quota" xml:"daily_quota"`
+	Created       string `json:"created" xml:"created"`
+	Revoked       string `json:"revoked,omitempty" xml:"revoked,omitempty"`
+	RequestsToday int64  `json:"requests_today" xml:"requests_today"`
+}
+
+// APIKeyList is the response of GET /admin/keys.
+type APIKeyList struct {
+	XMLName xml.Name `json:"-" xml:"keys"`
+	Keys    []APIKey `json:"keys" xml:"key"`
+}
+
+// KeyUsageDay is the number of requests of a key on a UTC day.
+type KeyUsageDay struct {
+	Day      string `json:"day" xml:"day,attr"`
+	Requests int64  `json:"requests" xml:"requests,attr"`
+}
+
+// KeyUsage is the response of GET /admin/keys/{id}/usage.
+type KeyUsage struct {
+	XMLName    xml.Name      `json:"-" xml:"usage"`
+	ID         string        `json:"id" xml:"id,attr"`
+	Name       string        `json:"name" xml:"name"`
+	DailyQuota int64         `json:"daily_quota" xml:"daily_quota"`
+	Total      int64         `json:"total" xml:"total"`
+	Days       []KeyUsageDay `json:"days" xml:"day"`
+}
+
+// createKeyHandler creates an API key named ?name= with a daily quota of
+// ?quota= requests (0, the default, for none). The key is only shown in
+// this response.
+func createKeyHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	var quota int64
+	if value := r.URL.Query().Get("quota"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, tr(r, "invalid_quota"), http.StatusBadRequest)
+			return
+		}
+		quota = n
+	}
+
+	secret := make([]byte, 24)
+	if _, err := cryptorand.Read(secret); err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error generating API key: %v", err)
+		return
+	}
+	id := hex.EncodeToString(secret[:4])
+	key := APIKey{
+		ID:         id,
+		Name:       name,
+		Key:        "em_" + id + "_" + hex.EncodeToString(secret[4:]),
+		DailyQuota: quota,
+		Created:    time.Now().UTC().Format(time.RFC3339),
+	}
+	err := withServerConn(func(conn *sql.Conn) error {
+		_, err := conn.ExecContext(r.Context(), "INSERT INTO api_keys (id, key_hash, name, daily_quota, created) VALUES (?, ?, ?, ?, ?)",
+			key.ID, hashAPIKey(key.Key), key.Name, key.DailyQuota, key.Created)
+		return err
+	})
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error creating API key: %v", err)
+		return
+	}
+	forgetAPIKeys()
+	log.Printf("Created API key %s (%q, daily quota %d)", key.ID, key.Name, key.DailyQuota)
+
+	sendValue(w, r, key, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "%s\n", key.Key)
+	})
+}
+
+// listKeysHandler lists the API keys, revoked ones included.
+func listKeysHandler(w http.ResponseWriter, r *http.Request) {
+	list := APIKeyList{Keys: []APIKey{}}
+	err := retryBusy(func() error {
+		list.Keys = list.Keys[:0]
+		rows, err := db.Query("SELECT id, name, daily_quota, created, COALESCE(revoked, '') FROM api_keys ORDER BY created")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var k APIKey
+			if err := rows.Scan(&k.ID, &k.Name, &k.DailyQuota, &k.Created, &k.Revoked); err != nil {
+				return err
+			}
+			list.Keys = append(list.Keys, k)
+		}
+		return rows.Err()
+	})
+	if err == nil {
+		for i := range list.Keys {
+			if list.Keys[i].RequestsToday, err = usage.requestsToday(list.Keys[i].ID); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error listing API keys: %v", err)
+		return
+	}
+
+	sendValue(w, r, list, func(buf *bytes.Buffer) {
+		for _, k := range list.Keys {
+			fmt.Fprintf(buf, "%s %q quota=%d today=%d created=%s revoked=%s\n", k.ID, k.Name, k.DailyQuota, k.RequestsToday, k.Created, k.Revoked)
+		}
+	})
+}
+
+// revokeKeyHandler revokes an API key. Its usage history is kept.
+func revokeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var revoked int64
+	err := withServerConn(func(conn *sql.Conn) error {
+		res, err := conn.ExecContext(r.Context(), "UPDATE api_keys SET revoked = ? WHERE id = ? AND revoked IS NULL", time.Now().UTC().Format(time.RFC3339), id)
+		if err != nil {
+			return err
+		}
+		revoked, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error revoking API key %s: %v", id, err)
+		return
+	}
+	if revoked == 0 {
+		http.Error(w, tr(r, "key_not_found", id), http.StatusNotFound)
+		return
+	}
+	forgetAPIKeys()
+	log.Printf("Revoked API key %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// keyUsageHandler reports the requests of an API key per day, over the last
+// ?days= days (30 by default).
+func keyUsageHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	days := 30
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, tr(r, "invalid_days"), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	since := time.Now().UTC().AddDate(0, 0, 1-days).Format(time.DateOnly)
+
+	report := KeyUsage{ID: id, Days: []KeyUsageDay{}}
+	counts := usage.pendingFor(id)
+	err := retryBusy(func() error {
+		err := db.QueryRow("SELECT name, daily_quota FROM api_keys WHERE id = ?", id).Scan(&report.Name, &report.DailyQuota)
+		if err != nil {
+			return err
+		}
+		rows, err := db.Query("SELECT day, requests FROM api_key_usage WHERE key_id = ? AND day >= ?", id, since)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		stored := make(map[string]int64)
+		for rows.Next() {
+			var day string
+			var n int64
+			if err := rows.Scan(&day, &n); err != nil {
+				return err
+			}
+			stored[day] = n
+		}
+		for day, n := range stored {
+			counts[day] += n
+		}
+		return rows.Err()
+	})
+	if err == sql.ErrNoRows {
+		http.Error(w, tr(r, "key_not_found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error reading usage of API key %s: %v", id, err)
+		return
+	}
+
+	for day, n := range counts {
+		if day >= since {
+			report.Days = append(report.Days, KeyUsageDay{Day: day, Requests: n})
+			report.Total += n
+		}
+	}
+	slices.SortFunc(report.Days, func(a, b KeyUsageDay) int { return strings.Compare(b.Day, a.Day) })
+
+	sendValue(w, r, report, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "%s %q quota=%d total=%d\n", report.ID, report.Name, report.DailyQuota, report.Total)
+		for _, d := range report.Days {
+			fmt.Fprintf(buf, "%s: %d\n", d.Day, d.Requests)
+		}
+	})
+}
+
+// Subscription is a notification channel registered by an API key. Channel
+// is webhook (a URL POSTed to as JSON), ntfy (a topic URL) or email (an
+// address). Events are draw (every new draw) and win (the line of Numbers
+// and Stars won a prize in a new draw). An email subscription is only
+// Confirmed once the link the updater emails to the address was followed.
+type Subscription struct {
+	ID        string   `json:"id" xml:"id,attr"`
+	Game      string   `json:"game" xml:"game,attr"`
+	Channel   string   `json:"channel" xml:"channel"`
+	Target    string   `json:"target" xml:"target"`
+	Events    []string `json:"events" xml:"events>event"`
+	Numbers   []int    `json:"numbers,omitempty" xml:"numbers>number,omitempty"`
+	Stars     []int    `json:"stars,omitempty" xml:"stars>star,omitempty"`
+	Confirmed bool     `json:"confirmed" xml:"confirmed"`
+	Created   string   `json:"created" xml:"created"`
+}
+
+// maxSubscriptions is how many subscriptions an API key may have.
+const maxSubscriptions = 10
+
+// publicIP reports whether ip is a public unicast address: subscriptions may
+// not make the updater send requests to loopback, private, link-local
+// (cloud metadata), shared or reserved addresses.
+func publicIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// nonPublicPrefixes are the ranges that IsGlobalUnicast and IsPrivate let
+// through but that are not reachable on the Internet, or translate to
+// addresses that may not be.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// publicHost reports whether every address of host is public. The updater
+// checks the address again when it connects, as DNS may change in between.
+func publicHost(ctx context.Context, host string) bool {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil || len(addrs) == 0 {
+		return false
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// SubscriptionList is the response of GET /subscriptions.
+type SubscriptionList struct {
+	XMLName       xml.Name       `json:"-" xml:"subscriptions"`
+	Subscriptions []Subscription `json:"subscriptions" xml:"subscription"`
+}
+
+// subscriptionEvents are the events a subscription can filter on.
+var subscriptionEvents = []string{"draw", "win", "weekly", "monthly"}
+
+// parseSubscription reads a subscription from the query parameters ?channel=,
+// ?target=, ?events= (draw by default), ?game= and, for the win event,
+// ?numbers= and ?stars=. It writes the error response when they are invalid.
+func parseSubscription(w http.ResponseWriter, r *http.Request) (Subscription, bool) {
+	query := r.URL.Query()
+	s := Subscription{Game: defaultGame.ID, Channel: strings.ToLower(query.Get("channel")), Target: query.Get("target")}
+	if id := query.Get("game"); id != "" {
+		g := findGame(strings.ToLower(id))
+		if g == nil {
+			http.Error(w, tr(r, "unknown_game", id), http.StatusNotFound)
+			return s, false
+		}
+		s.Game = g.ID
+	}
+
+	switch s.Channel {
+	case "webhook", "ntfy":
+		u, err := url.Parse(s.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || !publicHost(r.Context(), u.Hostname()) {
+			http.Error(w, tr(r, "invalid_target"), http.StatusBadRequest)
+			return s, false
+		}
+	case "email":
+		addr, err := mail.ParseAddress(s.Target)
+		if err != nil {
+			http.Error(w, tr(r, "invalid_target"), http.StatusBadRequest)
+			return s, false
+		}
+		s.Target = addr.Address
+	default:
+		http.Error(w, tr(r, "invalid_channel"), http.StatusBadRequest)
+		return s, false
+	}
+
+	events := query.Get("events")
+	if events == "" {
+		events = "draw"
+	}
+	for _, e := range strings.Split(strings.ToLower(events), ",") {
+		e = strings.TrimSpace(e)
+		if !slices.Contains(subscriptionEvents, e) {
+			http.Error(w, tr(r, "invalid_events"), http.StatusBadRequest)
+			return s, false
+		}
+		if !slices.Contains(s.Events, e) {
+			s.Events = append(s.Events, e)
+		}
+	}
+
+	if slices.Contains(s.Events, "win") {
+		g := findGame(s.Game)
+		numbers, err := parseIntList(query.Get("numbers"))
+		if err != nil || len(numbers) != g.Numbers || !validBalls(numbers, g.MaxNumber) {
+			http.Error(w, tr(r, "invalid_draw_numbers", g.Numbers, g.MaxNumber), http.StatusBadRequest)
+			return s, false
+		}
+		stars, err := parseIntList(query.Get("stars"))
+		if err != nil || len(stars) != g.Stars || !validBalls(stars, g.MaxStar) {
+			http.Error(w, tr(r, "invalid_draw_stars", g.Stars, g.MaxStar), http.StatusBadRequest)
+			return s, false
+		}
+		slices.Sort(numbers)
+		slices.Sort(stars)
+		s.Numbers, s.Stars = numbers, stars
+	}
+	return s, true
+}
+
+// readSubscriptions reads the subscriptions of an API key, or only the one
+// with the given ID when id is not empty.
+func readSubscriptions(owner, id string) ([]Subscription, error) {
+	query := "SELECT id, game, channel, target, events, COALESCE(numbers, ''), COALESCE(stars, ''), confirmed IS NOT NULL, created FROM subscriptions WHERE owner = ?"
+	args := []any{owner}
+	if id != "" {
+		query += " AND id = ?"
+		args = append(args, id)
+	}
+	query += " ORDER BY created"
+
+	list := []Subscription{}
+	err := retryBusy(func() error {
+		list = list[:0]
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var s Subscription
+			var events, numbers, stars string
+			if err := rows.Scan(&s.ID, &s.Game, &s.Channel, &s.Target, &events, &numbers, &stars, &s.Confirmed, &s.Created); err != nil {
+				return err
+			}
+			s.Events = strings.Split(events, ",")
+			s.Numbers, _ = parseIntList(numbers)
+			s.Stars, _ = parseIntList(stars)
+			list = append(list, s)
+		}
+		return rows.Err()
+	})
+	return list, err
+}
+
+// writeSubscriptions writes subscriptions in the requested format.
+func writeSubscriptions(w http.ResponseWriter, r *http.Request, v any, list []Subscription) {
+	sendValue(w, r, v, func(buf *bytes.Buffer) {
+		for _, s := range list {
+			fmt.Fprintf(buf, "%s %s %s %s events=%s confirmed=%t", s.ID, s.Game, s.Channel, s.Target, strings.Join(s.Events, ","), s.Confirmed)
+			if len(s.Numbers) > 0 {
+				fmt.Fprintf(buf, " line=%s+%s", joinInts(s.Numbers), joinInts(s.Stars))
+			}
+			buf.WriteString("\n")
+		}
+	})
+}
+
+// listSubscriptionsHandler lists the subscriptions of the caller's API key.
+func listSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := readSubscriptions(requestKeyID(r), "")
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error listing subscriptions: %v", err)
+		return
+	}
+	writeSubscriptions(w, r, SubscriptionList{Subscriptions: list}, list)
+}
+
+// getSubscriptionHandler serves one subscription of the caller's API key.
+func getSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	list, err := readSubscriptions(requestKeyID(r), id)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error reading subscription %s: %v", id, err)
+		return
+	}
+	if len(list) == 0 {
+		http.Error(w, tr(r, "subscription_not_found", id), http.StatusNotFound)
+		return
+	}
+	writeSubscriptions(w, r, list[0], list)
+}
+
+// saveSubscriptionHandler creates a subscription (POST /subscriptions) or
+// replaces one of the caller's API key (PUT /subscriptions/{id}).
+func saveSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := parseSubscription(w, r)
+	if !ok {
+		return
+	}
+	owner := requestKeyID(r)
+	s.ID = r.PathValue("id")
+	s.Created = time.Now().UTC().Format(time.RFC3339)
+	var numbers, stars any
+	if len(s.Numbers) > 0 {
+		numbers, stars = joinInts(s.Numbers), joinInts(s.Stars)
+	}
+
+	// An email address must confirm the subscription before it gets any
+	// notification: the updater emails it a link with this token.
+	secret := make([]byte, 22)
+	if _, err := cryptorand.Read(secret); err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error generating subscription ID: %v", err)
+		return
+	}
+	var token, confirmed any
+	if s.Channel == "email" {
+		token = hex.EncodeToString(secret[6:])
+	} else {
+		confirmed = s.Created
+	}
+	if s.ID == "" {
+		s.ID = hex.EncodeToString(secret[:6])
+	}
+
+	var saved int64
+	tooMany := false
+	err := withServerConn(func(conn *sql.Conn) error {
+		var res sql.Result
+		var err error
+		if r.Method == http.MethodPost {
+			var count int
+			if err := conn.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM subscriptions WHERE owner = ?", owner).Scan(&count); err != nil {
+				return err
+			}
+			if count >= maxSubscriptions {
+				tooMany = true
+				return nil
+			}
+			res, err = conn.ExecContext(r.Context(), "INSERT INTO subscriptions (id, owner, game, channel, target, events, numbers, stars, created, confirm_token, confirmed) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				s.ID, owner, s.Game, s.Channel, s.Target, strings.Join(s.Events, ","), numbers, stars, s.Created, token, confirmed)
+		} else {
+			// A confirmed address stays confirmed; a new address starts over.
+			res, err = conn.ExecContext(r.Context(), `UPDATE subscriptions SET game = ?1, events = ?6, numbers = ?7, stars = ?8,
+				confirm_token = CASE WHEN channel = ?2 AND target = ?3 AND confirm_token IS NOT NULL THEN confirm_token ELSE ?4 END,
+				confirm_sent = CASE WHEN channel = ?2 AND target = ?3 THEN confirm_sent END,
+				confirmed = CASE WHEN ?5 IS NOT NULL THEN COALESCE(confirmed, ?5) WHEN channel = ?2 AND target = ?3 THEN confirmed END,
+				channel = ?2, target = ?3
+				WHERE id = ?9 AND owner = ?10`,
+				s.Game, s.Channel, s.Target, token, confirmed, strings.Join(s.Events, ","), numbers, stars, s.ID, owner)
+		}
+		if err != nil {
+			return err
+		}
+		saved, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error saving subscription %s: %v", s.ID, err)
+		return
+	}
+	if tooMany {
+		http.Error(w, tr(r, "too_many_subscriptions", maxSubscriptions), http.StatusConflict)
+		return
+	}
+	if saved == 0 {
+		http.Error(w, tr(r, "subscription_not_found", s.ID), http.StatusNotFound)
+		return
+	}
+	// The creation time is kept on update, and so is a confirmation.
+	if list, err := readSubscriptions(owner, s.ID); err == nil && len(list) == 1 {
+		s = list[0]
+	}
+	writeSubscriptions(w, r, s, []Subscription{s})
+}
+
+// confirmSubscriptionHandler confirms an email subscription from the link the
+// updater emailed to the address. The token in the link is the credential, so
+// no API key is needed.
+func confirmSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	var confirmed int64
+	err := withServerConn(func(conn *sql.Conn) error {
+		res, err := conn.ExecContext(r.Context(), "UPDATE subscriptions SET confirmed = COALESCE(confirmed, ?) WHERE channel = 'email' AND confirm_token = ?",
+			time.Now().UTC().Format(time.RFC3339), r.PathValue("token"))
+		if err != nil {
+			return err
+		}
+		confirmed, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error confirming a subscription: %v", err)
+		return
+	}
+	if confirmed == 0 {
+		http.Error(w, tr(r, "invalid_confirmation"), http.StatusNotFound)
+		return
+	}
+	writeBody(w, r, "text/plain; charset=utf-8", []byte(tr(r, "subscription_confirmed")+"\n"))
+}
+
+// deleteSubscriptionHandler deletes a subscription of the caller's API key.
+func deleteSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var deleted int64
+	err := withServerConn(func(conn *sql.Conn) error {
+		res, err := conn.ExecContext(r.Context(), "DELETE FROM subscriptions WHERE id = ? AND owner = ?", id, requestKeyID(r))
+		if err != nil {
+			return err
+		}
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error deleting subscription %s: %v", id, err)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, tr(r, "subscription_not_found", id), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setPragmas applies SQLite PRAGMA settings for optimal performance.
+func setPragmas() error {
+	// PRAGMA journal_mode: Use WAL for better concurrency and speed.
+	if _, err := db.Exec("PRAGMA journal_mode = WAL;"); err != nil {
+		return fmt.Errorf("error setting PRAGMA journal_mode: %v", err)
+	}
+
+	// PRAGMA synchronous: Set to NORMAL for a good balance of speed and safety.
+	if _, err := db.Exec("PRAGMA synchronous = NORMAL;"); err != nil {
+		return fmt.Errorf("error setting PRAGMA synchronous: %v", err)
+	}
+	return nil
+}
+
+// initDB initializes the database connection and performs basic validation.
+func initDB() error {
+	// Check if the database file exists.
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return fmt.Errorf("database file not found at: %s", dbPath)
+	}
+
+	// Get the absolute path for consistency.
+	absPath, err := filepath.Abs(dbPath)
+	if err != nil {
+		return fmt.Errorf("error getting absolute database path: %v", err)
+	}
+	dbPath = absPath
+
+	// Open the SQLite database connection.
+	// The busy timeout is part of the DSN so that every pooled connection gets it.
+	var errOpen error
+	db, errOpen = sql.Open("sqlite3", fmt.Sprintf("%s?_busy_timeout=%d", dbPath, busyTimeout.Milliseconds()))
+	if errOpen != nil {
+		return fmt.Errorf("error opening database: %v", errOpen)
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(connMaxLifetime)
+
+	// Apply PRAGMA settings for performance.
+	if err := setPragmas(); err != nil {
+		db.Close()
+		return err
+	}
+
+	// Verify that the 'results' table exists.
 	tableExists := false
 	err = db.QueryRow("SELECT 1 FROM sqlite_master WHERE type='table' AND name='results'").Scan(&tableExists)
 	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("error checking table: %v", err)
+		return fmt.Errorf("error checking table: %v", err)
+	}
+
+	if !tableExists {
+		return fmt.Errorf("table 'results' not found in database")
+	}
+
+	// Verify the table schema by running a simple query.
+	_, err = db.Exec("SELECT date, number_1, number_2, number_3, number_4, number_5, star_1, star_2 FROM results LIMIT 1")
+	if err != nil {
+		return fmt.Errorf("table schema does not match the expected format: %v", err)
+	}
+
+	// Bring the schema up to date. A replica leaves that to the primary.
+	if role == "replica" {
+		var level int
+		if err := db.QueryRow("PRAGMA user_version").Scan(&level); err != nil {
+			return fmt.Errorf("error reading schema version: %v", err)
+		}
+		if level < len(migrations) {
+			log.Printf("The database schema is at level %d of %d: start the primary to migrate it", level, len(migrations))
+		}
+		return nil
+	}
+	if err := migrateDB(); err != nil {
+		return fmt.Errorf("error migrating database: %v", err)
+	}
+
+	return nil
+}
+
+// migrations are the schema changes applied on top of the original 'results' table,
+// in order. The applied level is tracked with PRAGMA user_version.
+// Keep this list identical to the one in go-euromillions-api-update.go: both tools migrate on startup.
+var migrations = []string{
+	// 1: flag Superdraws and other special event draws.
+	"ALTER TABLE results ADD COLUMN special INTEGER NOT NULL DEFAULT 0",
+	// 2: Thunderball draws (5 numbers + 1 Thunderball stored as star_1).
+	`CREATE TABLE IF NOT EXISTS results_thunderball (
+		date TEXT PRIMARY KEY,
+		number_1 INTEGER NOT NULL, number_2 INTEGER NOT NULL, number_3 INTEGER NOT NULL,
+		number_4 INTEGER NOT NULL, number_5 INTEGER NOT NULL,
+		star_1 INTEGER NOT NULL,
+		special INTEGER NOT NULL DEFAULT 0
+	)`,
+	// 3, 4: order in which the balls were drawn, when the source publishes it.
+	"ALTER TABLE results ADD COLUMN drawn_order TEXT",
+	"ALTER TABLE results_thunderball ADD COLUMN drawn_order TEXT",
+	// 5: time of the updater's last request to each host, for rate limiting.
+	"CREATE TABLE IF NOT EXISTS fetch_log (host TEXT PRIMARY KEY, last_fetch TEXT NOT NULL)",
+	// 6: the original 'results' table has no key; every lookup is by date.
+	// (results_thunderball is indexed by its primary key.)
+	"CREATE INDEX IF NOT EXISTS results_date ON results (date)",
+	// 7-12: year and month of each draw, for indexed year and month queries.
+	// The updater sets them on insert; existing rows are filled in here.
+	"ALTER TABLE results ADD COLUMN year INTEGER",
+	"ALTER TABLE results ADD COLUMN month INTEGER",
+	"UPDATE results SET year = CAST(substr(date, 1, 4) AS INTEGER), month = CAST(substr(date, 6, 2) AS INTEGER)",
+	"CREATE INDEX IF NOT EXISTS results_year_month ON results (year, month, date)",
+	"ALTER TABLE results_thunderball ADD COLUMN year INTEGER",
+	"ALTER TABLE results_thunderball ADD COLUMN month INTEGER",
+	"UPDATE results_thunderball SET year = CAST(substr(date, 1, 4) AS INTEGER), month = CAST(substr(date, 6, 2) AS INTEGER)",
+	"CREATE INDEX IF NOT EXISTS results_thunderball_year_month ON results_thunderball (year, month, date)",
+	// 13-18: statistics of every game, kept up to date by the updater:
+	// how many draws each ball (kind 'number' or 'star') appeared in and when
+	// it was last drawn, and how many draws each pair of numbers appeared in.
+	`CREATE TABLE IF NOT EXISTS stats_balls (
+		game TEXT NOT NULL, kind TEXT NOT NULL, ball INTEGER NOT NULL,
+		draws INTEGER NOT NULL, last_seen TEXT NOT NULL,
+		PRIMARY KEY (game, kind, ball)
+	)`,
+	`CREATE TABLE IF NOT EXISTS stats_pairs (
+		game TEXT NOT NULL, first INTEGER NOT NULL, second INTEGER NOT NULL,
+		draws INTEGER NOT NULL,
+		PRIMARY KEY (game, first, second)
+	)`,
+	`INSERT INTO stats_balls (game, kind, ball, draws, last_seen)
+	SELECT 'euromillions', kind, ball, COUNT(*), MAX(date) FROM (
+		SELECT date, 'number' AS kind, number_1 AS ball FROM results UNION ALL
+		SELECT date, 'number', number_2 FROM results UNION ALL
+		SELECT date, 'number', number_3 FROM results UNION ALL
+		SELECT date, 'number', number_4 FROM results UNION ALL
+		SELECT date, 'number', number_5 FROM results UNION ALL
+		SELECT date, 'star', star_1 FROM results UNION ALL
+		SELECT date, 'star', star_2 FROM results
+	) GROUP BY kind, ball`,
+	`WITH balls (date, ball) AS (
+		SELECT date, number_1 FROM results UNION ALL
+		SELECT date, number_2 FROM results UNION ALL
+		SELECT date, number_3 FROM results UNION ALL
+		SELECT date, number_4 FROM results UNION ALL
+		SELECT date, number_5 FROM results
+	)
+	INSERT INTO stats_pairs (game, first, second, draws)
+	SELECT 'euromillions', a.ball, b.ball, COUNT(*) FROM balls a JOIN balls b ON a.date = b.date AND a.ball < b.ball
+	GROUP BY a.ball, b.ball`,
+	`INSERT INTO stats_balls (game, kind, ball, draws, last_seen)
+	SELECT 'thunderball', kind, ball, COUNT(*), MAX(date) FROM (
+		SELECT date, 'number' AS kind, number_1 AS ball FROM results_thunderball UNION ALL
+		SELECT date, 'number', number_2 FROM results_thunderball UNION ALL
+		SELECT date, 'number', number_3 FROM results_thunderball UNION ALL
+		SELECT date, 'number', number_4 FROM results_thunderball UNION ALL
+		SELECT date, 'number', number_5 FROM results_thunderball UNION ALL
+		SELECT date, 'star', star_1 FROM results_thunderball
+	) GROUP BY kind, ball`,
+	`WITH balls (date, ball) AS (
+		SELECT date, number_1 FROM results_thunderball UNION ALL
+		SELECT date, number_2 FROM results_thunderball UNION ALL
+		SELECT date, number_3 FROM results_thunderball UNION ALL
+		SELECT date, number_4 FROM results_thunderball UNION ALL
+		SELECT date, number_5 FROM results_thunderball
+	)
+	INSERT INTO stats_pairs (game, first, second, draws)
+	SELECT 'thunderball', a.ball, b.ball, COUNT(*) FROM balls a JOIN balls b ON a.date = b.date AND a.ball < b.ball
+	GROUP BY a.ball, b.ball`,
+	// 19, 20: API keys of the server's --auth=apikey mode (only a SHA-256
+	// hash of each key is stored) and their requests per UTC day.
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY, key_hash TEXT NOT NULL UNIQUE, name TEXT NOT NULL,
+		daily_quota INTEGER NOT NULL DEFAULT 0, created TEXT NOT NULL, revoked TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_key_usage (
+		key_id TEXT NOT NULL, day TEXT NOT NULL, requests INTEGER NOT NULL,
+		PRIMARY KEY (key_id, day)
+	)`,
+	// 21, 22: outcome of every site fetched by the updater, for the admin area.
+	`CREATE TABLE IF NOT EXISTS scrape_log (
+		time TEXT NOT NULL, game TEXT NOT NULL, source INTEGER NOT NULL,
+		draw_date TEXT, inserted INTEGER NOT NULL DEFAULT 0, error TEXT
+	)`,
+	"CREATE INDEX IF NOT EXISTS scrape_log_time ON scrape_log (time)",
+	// 23: every change to the draws: action insert, update or delete, the
+	// updater command (source) and who ran it (actor), and the draw before
+	// and after as JSON.
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY, time TEXT NOT NULL, game TEXT NOT NULL, date TEXT NOT NULL,
+		action TEXT NOT NULL, source TEXT NOT NULL, actor TEXT, before TEXT, after TEXT
+	)`,
+	// 24-26: earlier values of corrected and deleted draws (the current value
+	// stays in the game's table), with when they were superseded and by what
+	// (update or delete). Corrections already in the audit log are kept.
+	`CREATE TABLE IF NOT EXISTS results_history (
+		id INTEGER PRIMARY KEY, game TEXT NOT NULL, date TEXT NOT NULL,
+		draw TEXT NOT NULL, superseded TEXT NOT NULL, reason TEXT NOT NULL
+	)`,
+	"CREATE INDEX IF NOT EXISTS results_history_date ON results_history (game, date)",
+	`INSERT INTO results_history (game, date, draw, superseded, reason)
+	SELECT game, date, before, time, action FROM audit_log
+	WHERE action IN ('update', 'delete') AND before IS NOT NULL AND before IS NOT after
+	ORDER BY id`,
+	// 27-34: revision of the whole dataset, bumped by triggers on every row
+	// inserted, updated or deleted in the games' tables, whoever writes it.
+	`CREATE TABLE IF NOT EXISTS dataset_revision (
+		id INTEGER PRIMARY KEY CHECK (id = 1), revision INTEGER NOT NULL, updated TEXT NOT NULL
+	)`,
+	"INSERT OR IGNORE INTO dataset_revision (id, revision, updated) VALUES (1, 1, strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))",
+	`CREATE TRIGGER IF NOT EXISTS results_revision_insert AFTER INSERT ON results BEGIN
+		UPDATE dataset_revision SET revision = revision + 1, updated = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS results_revision_update AFTER UPDATE ON results BEGIN
+		UPDATE dataset_revision SET revision = revision + 1, updated = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS results_revision_delete AFTER DELETE ON results BEGIN
+		UPDATE dataset_revision SET revision = revision + 1, updated = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS results_thunderball_revision_insert AFTER INSERT ON results_thunderball BEGIN
+		UPDATE dataset_revision SET revision = revision + 1, updated = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS results_thunderball_revision_update AFTER UPDATE ON results_thunderball BEGIN
+		UPDATE dataset_revision SET revision = revision + 1, updated = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS results_thunderball_revision_delete AFTER DELETE ON results_thunderball BEGIN
+		UPDATE dataset_revision SET revision = revision + 1, updated = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+	END`,
+	// 35, 36: notification subscriptions of the API keys (owner): a channel
+	// (webhook, ntfy or email) and its target, the events (draw, win) and,
+	// for win, the line checked. The updater notifies them of new draws.
+	`CREATE TABLE IF NOT EXISTS subscriptions (
+		id TEXT PRIMARY KEY, owner TEXT NOT NULL, game TEXT NOT NULL,
+		channel TEXT NOT NULL, target TEXT NOT NULL, events TEXT NOT NULL,
+		numbers TEXT, stars TEXT, created TEXT NOT NULL
+	)`,
+	"CREATE INDEX IF NOT EXISTS subscriptions_owner ON subscriptions (owner)",
+	// 37, 38: admins' annotations of draws, e.g. a correction or a Superdraw.
+	`CREATE TABLE IF NOT EXISTS notes (
+		id INTEGER PRIMARY KEY, game TEXT NOT NULL, date TEXT NOT NULL,
+		text TEXT NOT NULL, created TEXT NOT NULL
+	)`,
+	"CREATE INDEX IF NOT EXISTS notes_game_date ON notes (game, date)",
+	// 39: the dataset version of the upstream each game was last mirrored from.
+	`CREATE TABLE IF NOT EXISTS mirror_state (
+		game TEXT PRIMARY KEY, upstream TEXT NOT NULL, version TEXT NOT NULL,
+		synced TEXT NOT NULL
+	)`,
+	// 40-44: double opt-in of email subscriptions: the token of the link the
+	// updater emails to the address, when it sent it and when the link was
+	// followed. Only confirmed subscriptions are notified; the other channels
+	// need no confirmation.
+	"ALTER TABLE subscriptions ADD COLUMN confirm_token TEXT",
+	"ALTER TABLE subscriptions ADD COLUMN confirm_sent TEXT",
+	"ALTER TABLE subscriptions ADD COLUMN confirmed TEXT",
+	"UPDATE subscriptions SET confirmed = created WHERE channel <> 'email'",
+	"UPDATE subscriptions SET confirm_token = lower(hex(randomblob(16))) WHERE channel = 'email'",
+}
+
+// migrateDB applies the pending migrations, each one in its own transaction.
+func migrateDB() error {
+	var level int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&level); err != nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+
+	for i := level; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %v", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error updating schema version: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Applied database migration %d", i+1)
+	}
+	return nil
+}
+
+// schemaObject matches the tables, indexes and triggers created by the migrations.
+var schemaObject = regexp.MustCompile(`CREATE (?:UNIQUE )?(TABLE|INDEX|TRIGGER) IF NOT EXISTS (\w+)`)
+
+// selfTest checks that the server can do its job before it serves anything:
+// the schema has every table, index and trigger of the migrations, the latest
+// draw of every game can be read and rendered in every format, and the clock
+// and the draw time zone are sane. It returns the problems found.
+func selfTest() []string {
+	var problems []string
+
+	var level int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&level); err != nil {
+		problems = append(problems, fmt.Sprintf("cannot read the schema version: %v", err))
+	} else if level < len(migrations) {
+		problems = append(problems, fmt.Sprintf("schema version %d, expected %d", level, len(migrations)))
+	}
+	for _, m := range migrations {
+		for _, match := range schemaObject.FindAllStringSubmatch(m, -1) {
+			kind, name := strings.ToLower(match[1]), match[2]
+			var found int
+			err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = ? AND name = ?", kind, name).Scan(&found)
+			if err != nil || found == 0 {
+				problems = append(problems, fmt.Sprintf("%s %s is missing", kind, name))
+			}
+		}
+	}
+
+	now := time.Now()
+	if now.Year() < 2024 {
+		problems = append(problems, fmt.Sprintf("the clock reads %s", now.Format(time.RFC3339)))
+	}
+	if _, offset := now.In(drawLocation).Zone(); offset != 3600 && offset != 7200 {
+		problems = append(problems, fmt.Sprintf("the draw time zone is UTC%+d, not CET or CEST: check the time zone database", offset/3600))
+	}
+	tomorrow := now.In(drawLocation).AddDate(0, 0, 1).Format("2006-01-02")
+
+	for _, g := range games {
+		latest, err := queryResult(g, g.stmts.latest)
+		if err == sql.ErrNoRows {
+			if g == defaultGame {
+				problems = append(problems, fmt.Sprintf("no %s draws", g.Name))
+			}
+			continue
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("cannot read the latest %s draw: %v", g.Name, err))
+			continue
+		}
+		if latest.Date > tomorrow {
+			problems = append(problems, fmt.Sprintf("the latest %s draw, %s, is in the future: check the clock", g.Name, latest.Date))
+		}
+		for _, format := range []string{"json", "xml", "plaintext"} {
+			r, err := http.NewRequest(http.MethodGet, "/results/latest?format="+format, nil)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("cannot render the latest %s draw as %s: %v", g.Name, format, err))
+				continue
+			}
+			capture := &captureWriter{header: make(http.Header)}
+			sendResponse(capture, r, []Result{latest})
+			var decoded Result
+			switch {
+			case capture.status != http.StatusOK || capture.body.Len() == 0:
+				err = fmt.Errorf("status %d, %d bytes", capture.status, capture.body.Len())
+			case format == "json":
+				err = json.Unmarshal(capture.body.Bytes(), &decoded)
+			case format == "xml":
+				err = xml.Unmarshal(capture.body.Bytes(), &decoded)
+			}
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("cannot render the latest %s draw as %s: %v", g.Name, format, err))
+			}
+		}
+	}
+	return problems
+}
+
+// Game describes a lottery game. Each game keeps its draws in its own table with
+// the same shape as 'results': date, number_1..number_N, star_1..star_M and special.
+type Game struct {
+	ID        string `json:"id" xml:"id,attr"`
+	Name      string `json:"name" xml:"name"`
+	Numbers   int    `json:"numbers" xml:"numbers"`
+	Stars     int    `json:"stars" xml:"stars"`
+	MaxNumber int    `json:"maxNumber" xml:"maxNumber"`
+	MaxStar   int    `json:"maxStar" xml:"maxStar"`
+
+	table string
+	stmts gameStatements
+}
+
+// gameStatements holds a game's hot queries, prepared once at startup and reused by every request.
+type gameStatements struct {
+	all     *sql.Stmt
+	latest  *sql.Stmt
+	byDate  *sql.Stmt
+	before  *sql.Stmt
+	after   *sql.Stmt
+	byYear  *sql.Stmt
+	byMonth *sql.Stmt
+	since   *sql.Stmt
+	between *sql.Stmt
+
+	count     *sql.Stmt
+	ballStats *sql.Stmt
+	pairStats *sql.Stmt
+}
+
+// games lists the supported games. For games other than EuroMillions the
+// "stars" are the game's bonus balls (e.g. the Thunderball).
+var games = []*Game{
+	{ID: "euromillions", Name: "EuroMillions", Numbers: 5, Stars: 2, MaxNumber: 50, MaxStar: 12, table: "results"},
+	{ID: "thunderball", Name: "Thunderball", Numbers: 5, Stars: 1, MaxNumber: 39, MaxStar: 14, table: "results_thunderball"},
+}
+
+// defaultGame is served by the top-level /results routes.
+var defaultGame = games[0]
+
+// AllGames is a helper struct for XML output of the game list.
+type AllGames struct {
+	XMLName xml.Name `xml:"games"`
+	Games   []*Game  `xml:"game"`
+}
+
+// findGame returns the game with the given ID, or nil.
+func findGame(id string) *Game {
+	for _, g := range games {
+		if g.ID == id {
+			return g
+		}
+	}
+	return nil
+}
+
+// requestGame returns the game addressed by the request: the {game} path value
+// under /games/{game}/, or the default game for the top-level routes.
+// It answers 404 and returns false for unknown games.
+func requestGame(w http.ResponseWriter, r *http.Request) (*Game, bool) {
+	id := r.PathValue("game")
+	if id == "" {
+		return defaultGame, true
+	}
+	g := findGame(strings.ToLower(id))
+	if g == nil {
+		http.Error(w, tr(r, "unknown_game", id), http.StatusNotFound)
+		return nil, false
+	}
+	return g, true
+}
+
+// columns returns the column list selected by every results query of the game.
+func (g *Game) columns() string {
+	cols := []string{"date"}
+	for i := 1; i <= g.Numbers; i++ {
+		cols = append(cols, fmt.Sprintf("number_%d", i))
+	}
+	for i := 1; i <= g.Stars; i++ {
+		cols = append(cols, fmt.Sprintf("star_%d", i))
+	}
+	cols = append(cols, "special", "drawn_order")
+	return strings.Join(cols, ", ")
+}
+
+// prepareStatements prepares the queries used by the request handlers for every game.
+func prepareStatements() error {
+	for _, g := range games {
+		selectFrom := "SELECT " + g.columns() + " FROM " + g.table
+		queries := []struct {
+			stmt  **sql.Stmt
+			query string
+		}{
+			{&g.stmts.all, selectFrom + " ORDER BY date DESC"},
+			{&g.stmts.latest, selectFrom + " ORDER BY date DESC LIMIT 1"},
+			{&g.stmts.byDate, selectFrom + " WHERE date = ?"},
+			{&g.stmts.before, selectFrom + " WHERE date <= ? ORDER BY date DESC LIMIT 1"},
+			{&g.stmts.after, selectFrom + " WHERE date >= ? ORDER BY date ASC LIMIT 1"},
+			{&g.stmts.byYear, selectFrom + " WHERE year = ? ORDER BY date DESC"},
+			{&g.stmts.byMonth, selectFrom + " WHERE year = ? AND month = ? ORDER BY date DESC"},
+			{&g.stmts.since, selectFrom + " WHERE date > ? ORDER BY date ASC"},
+			{&g.stmts.between, selectFrom + " WHERE date >= ? AND date <= ? ORDER BY date ASC"},
+			{&g.stmts.count, "SELECT COUNT(*) FROM " + g.table},
+			{&g.stmts.ballStats, "SELECT kind, ball, draws, last_seen FROM stats_balls WHERE game = ?"},
+			{&g.stmts.pairStats, "SELECT first, second, draws FROM stats_pairs WHERE game = ? ORDER BY draws DESC, first, second LIMIT ?"},
+		}
+		for _, q := range queries {
+			stmt, err := db.Prepare(q.query)
+			if err != nil {
+				return fmt.Errorf("error preparing statement %q: %v", q.query, err)
+			}
+			*q.stmt = stmt
+			auditQueryPlan(q.query)
+		}
+	}
+	return nil
+}
+
+// auditQueryPlan logs a warning when SQLite plans to read a whole table
+// without an index for query, e.g. because a migration was not applied.
+func auditQueryPlan(query string) {
+	args := make([]any, strings.Count(query, "?"))
+	rows, err := db.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		log.Printf("Warning: cannot explain %q: %v", query, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			log.Printf("Warning: cannot explain %q: %v", query, err)
+			return
+		}
+		if strings.HasPrefix(detail, "SCAN") && !strings.Contains(detail, "INDEX") {
+			log.Printf("Warning: full table scan (%s) in %q", detail, query)
+		}
+	}
+}
+
+// closeStatements closes the prepared statements.
+func closeStatements() {
+	for _, g := range games {
+		for _, stmt := range []*sql.Stmt{g.stmts.all, g.stmts.latest, g.stmts.byDate, g.stmts.before, g.stmts.after, g.stmts.byYear, g.stmts.byMonth, g.stmts.since, g.stmts.between, g.stmts.count, g.stmts.ballStats, g.stmts.pairStats} {
+			if stmt != nil {
+				stmt.Close()
+			}
+		}
+	}
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanResult reads one row selected with the game's columns.
+func scanResult(g *Game, row rowScanner) (Result, error) {
+	// Numbers and Stars share one allocation, and dest is sized up front:
+	// this runs once per row of every listing.
+	balls := make([]int, g.Numbers+g.Stars)
+	res := Result{
+		Numbers: balls[:g.Numbers:g.Numbers],
+		Stars:   balls[g.Numbers:],
+	}
+	dest := make([]any, 0, len(balls)+3)
+	dest = append(dest, &res.Date)
+	for i := range res.Numbers {
+		dest = append(dest, &res.Numbers[i])
+	}
+	for i := range res.Stars {
+		dest = append(dest, &res.Stars[i])
+	}
+	var drawnOrder sql.NullString
+	dest = append(dest, &res.Special, &drawnOrder)
+	if err := row.Scan(dest...); err != nil {
+		return Result{}, err
+	}
+	// Rows stored before the updater normalized the order may be unsorted.
+	slices.Sort(res.Numbers)
+	slices.Sort(res.Stars)
+	if drawnOrder.Valid {
+		if balls, err := parseIntList(drawnOrder.String); err == nil && len(balls) == g.Numbers+g.Stars {
+			res.DrawnNumbers, res.DrawnStars = balls[:g.Numbers], balls[g.Numbers:]
+		}
+	}
+	return res, nil
+}
+
+// queryResults runs one of the game's prepared statements and reads all returned rows.
+func queryResults(g *Game, stmt *sql.Stmt, args ...any) ([]Result, error) {
+	var results []Result
+	err := retryBusy(func() error {
+		var err error
+		results, err = scanRows(g, stmt, args...)
+		return err
+	})
+	return results, err
+}
+
+// queryResult runs one of the game's prepared statements and reads the first row.
+func queryResult(g *Game, stmt *sql.Stmt, args ...any) (Result, error) {
+	var res Result
+	err := retryBusy(func() error {
+		var err error
+		res, err = scanResult(g, stmt.QueryRow(args...))
+		return err
+	})
+	return res, err
+}
+
+func scanRows(g *Game, stmt *sql.Stmt, args ...any) ([]Result, error) {
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]Result, 0, 64)
+	for rows.Next() {
+		res, err := scanResult(g, rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// isBusy reports whether err is SQLite's SQLITE_BUSY or SQLITE_LOCKED.
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
+
+// retryBusy runs the read fn, retrying it up to --busy-retries times while
+// SQLite reports the database busy or locked. The busy timeout covers most
+// waits, but in WAL mode a reader can still get SQLITE_BUSY straight away,
+// e.g. while the updater's write restarts the log, and a read is cheap to
+// run again.
+func retryBusy(fn func() error) error {
+	err := fn()
+	for attempt := 1; attempt <= busyRetries && isBusy(err); attempt++ {
+		if verbose {
+			log.Printf("Database busy, retrying read (%d/%d): %v", attempt, busyRetries, err)
+		}
+		time.Sleep(time.Duration(attempt) * 50 * time.Millisecond)
+		err = fn()
+	}
+	return err
+}
+
+// resultsHandler serves all available results.
+func resultsHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /results from %s", clientIP(r))
+	}
+	getAllResults(w, r)
+}
+
+// getAllResults queries the database for all results and returns them in the requested format.
+func getAllResults(w http.ResponseWriter, r *http.Request) {
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := queryResults(g, g.stmts.all)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching results: %v", err)
+		return
+	}
+
+	results, err = filterSpecial(r, results)
+	if err != nil {
+		http.Error(w, tr(r, "invalid_special"), http.StatusBadRequest)
+		return
+	}
+
+	results, ok = paginate(w, r, results)
+	if !ok {
+		return
+	}
+
+	if len(results) == 0 {
+		http.Error(w, tr(r, "no_results"), http.StatusNotFound)
+		return
+	}
+
+	sendResponse(w, r, results)
+}
+
+// latestHandler serves the latest result.
+func latestHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /results/latest from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	// Polling clients pass the date of the draw they have: when there is
+	// nothing newer the answer is an empty 204, served from memory.
+	if after := r.URL.Query().Get("after"); after != "" {
+		if _, err := time.Parse("2006-01-02", after); err != nil {
+			http.Error(w, tr(r, "invalid_date"), http.StatusBadRequest)
+			return
+		}
+		result, err := latest.get(g)
+		if err != nil && err != sql.ErrNoRows {
+			http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+			log.Printf("Error fetching latest result: %v", err)
+			return
+		}
+		if err == sql.ErrNoRows || result.Date <= after {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	// The encoded response is kept next to the result, so that the hot path
+	// is a map lookup and a write. An invalid ?xml= must not be answered
+	// from it.
+	if _, ok := xmlForm(r); !ok && strings.EqualFold(r.URL.Query().Get("format"), "xml") {
+		http.Error(w, tr(r, "invalid_xml_form"), http.StatusBadRequest)
+		return
+	}
+	key := latestKey(g, r)
+	if body, ok := latest.rendered(key); ok {
+		w.Header().Set("X-Cache", "HIT")
+		writeTaggedBody(w, r, body.contentType, body.etag, body.data)
+		return
+	}
+	gen := latest.generation()
+
+	result, err := latest.get(g)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, tr(r, "no_results"), http.StatusNotFound)
+		} else {
+			http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+			log.Printf("Error fetching latest result: %v", err)
+		}
+		return
+	}
+
+	if !cacheEnabled || r.Method != http.MethodGet {
+		sendResponse(w, r, []Result{result})
+		return
+	}
+	capture := &captureWriter{header: make(http.Header)}
+	sendResponse(capture, r, []Result{result})
+	if capture.status == 0 {
+		capture.status = http.StatusOK
+	}
+	if capture.status == http.StatusOK {
+		latest.setRendered(key, gen, renderedBody{
+			contentType: capture.header.Get("Content-Type"),
+			etag:        cmp.Or(capture.header.Get("ETag"), bodyETag(capture.body.Bytes())),
+			data:        capture.body.Bytes(),
+		})
+	}
+	replayEntry(w, r, cacheEntry{status: capture.status, header: capture.header, body: capture.body.Bytes()}, "MISS")
+}
+
+// latestKey identifies an encoding of a game's latest result: the parameters
+// that change the response body, normalized so that equivalent requests share it.
+func latestKey(g *Game, r *http.Request) string {
+	q := r.URL.Query()
+	envelope, _ := strconv.ParseBool(q.Get("envelope"))
+	// An explicit ?lang= also switches plaintext to long dates (see plaintextDate).
+	lang := requestLang(r)
+	if q.Get("lang") == "" {
+		lang = ""
+	}
+	format := strings.ToLower(q.Get("format"))
+	form := ""
+	if format == "xml" {
+		form, _ = xmlForm(r)
+	}
+	return g.ID + "|" + format + "|" + form + "|" + strconv.FormatBool(envelope) + "|" + q.Get("tz") + "|" + lang + "|" + strconv.FormatBool(requestIncludes(r, "notes"))
+}
+
+// xmlForm returns the ?xml= form of an XML response, "" for the original
+// shape, and false when it is not schema or compact.
+func xmlForm(r *http.Request) (string, bool) {
+	form := r.URL.Query().Get("xml")
+	return form, form == "" || form == "schema" || form == "compact"
+}
+
+// maxWaitTimeout bounds the ?timeout= of /results/wait.
+const maxWaitTimeout = 5 * time.Minute
+
+// waitHandler holds the request open until a draw newer than ?after= (the
+// latest stored draw by default) is stored, and serves it like
+// /results/latest. When ?timeout= (60s) elapses first, it answers 204.
+func waitHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /results/wait from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	timeout := 60 * time.Second
+	if value := query.Get("timeout"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			// A plain number is a number of seconds.
+			var seconds int
+			seconds, err = strconv.Atoi(value)
+			d = time.Duration(seconds) * time.Second
+		}
+		if err != nil || d <= 0 || d > maxWaitTimeout {
+			http.Error(w, tr(r, "invalid_timeout", maxWaitTimeout), http.StatusBadRequest)
+			return
+		}
+		timeout = d
+	}
+
+	after := query.Get("after")
+	if after != "" {
+		if _, err := time.Parse("2006-01-02", after); err != nil {
+			http.Error(w, tr(r, "invalid_date"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	// Without ?after= the latest draw at the first read is the reference, and
+	// when there is none yet the first draw stored is new.
+	first := after == ""
+	for {
+		// Subscribe before reading, so a draw stored in between is not missed.
+		changed := changes.wait()
+		result, err := latest.get(g)
+		if err != nil && err != sql.ErrNoRows {
+			http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+			log.Printf("Error fetching latest result: %v", err)
+			return
+		}
+		if err == nil {
+			if first {
+				after = result.Date
+			} else if result.Date > after {
+				sendResponse(w, r, []Result{result})
+				return
+			}
+		}
+		first = false
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// lenientDateFormats are the local date formats accepted by the date
+// endpoints with --lenient-dates, besides YYYY-MM-DD.
+var lenientDateFormats = []string{"02-01-2006", "02/01/2006", "02.01.2006", "20060102"}
+
+// parseDateParam parses the {date} of a date endpoint and returns it as
+// YYYY-MM-DD, the form every query uses.
+func parseDateParam(value string) (string, bool) {
+	if _, err := time.Parse("2006-01-02", value); err == nil {
+		return value, true
+	}
+	if lenientDates {
+		for _, layout := range lenientDateFormats {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t.Format("2006-01-02"), true
+			}
+		}
+	}
+	return "", false
+}
+
+// dateHandler serves the result for a specific date.
+func dateHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /results/date/ from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	date, ok := parseDateParam(r.PathValue("date"))
+	if !ok {
+		http.Error(w, tr(r, "invalid_date"), http.StatusBadRequest)
+		return
+	}
+
+	// ?nearest= falls back to the closest draw before or after a day
+	// without one; the result carries the date of the draw found.
+	stmt := g.stmts.byDate
+	switch strings.ToLower(r.URL.Query().Get("nearest")) {
+	case "":
+	case "before":
+		stmt = g.stmts.before
+	case "after":
+		stmt = g.stmts.after
+	default:
+		http.Error(w, tr(r, "invalid_nearest"), http.StatusBadRequest)
+		return
+	}
+
+	result, err := queryResult(g, stmt, date)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, tr(r, "no_results_date"), http.StatusNotFound)
+		} else {
+			http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+			log.Printf("Error fetching result by date (%s): %v", date, err)
+		}
+		return
+	}
+
+	sendResponse(w, r, []Result{result})
+}
+
+// DrawComparison is the response of /results/compare. The deltas are, for
+// each position of the ascending numbers and stars, the ball of the second
+// draw minus the ball of the first.
+type DrawComparison struct {
+	XMLName       xml.Name `json:"-" xml:"comparison"`
+	Game          string   `json:"game" xml:"game,attr"`
+	First         Result   `json:"first" xml:"first"`
+	Second        Result   `json:"second" xml:"second"`
+	SharedNumbers []int    `json:"shared_numbers" xml:"shared_numbers>number"`
+	SharedStars   []int    `json:"shared_stars" xml:"shared_stars>star"`
+	NumberDeltas  []int    `json:"number_deltas" xml:"number_deltas>delta"`
+	StarDeltas    []int    `json:"star_deltas" xml:"star_deltas>delta"`
+}
+
+// compareHandler compares the draws of ?d1= and ?d2=: the numbers and stars
+// they share and how each position moved, for studying repeats.
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /results/compare from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	var draws []Result
+	for _, param := range []string{"d1", "d2"} {
+		date, ok := parseDateParam(r.URL.Query().Get(param))
+		if !ok {
+			http.Error(w, tr(r, "invalid_date"), http.StatusBadRequest)
+			return
+		}
+		result, err := queryResult(g, g.stmts.byDate, date)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, tr(r, "no_results_for", date), http.StatusNotFound)
+			} else {
+				http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+				log.Printf("Error fetching result by date (%s): %v", date, err)
+			}
+			return
+		}
+		draws = append(draws, result)
+	}
+	if err := setTimestamps(r, draws); err != nil {
+		http.Error(w, tr(r, "invalid_tz"), http.StatusBadRequest)
+		return
+	}
+
+	first, second := draws[0], draws[1]
+	c := DrawComparison{
+		Game:          g.ID,
+		First:         first,
+		Second:        second,
+		SharedNumbers: []int{},
+		SharedStars:   []int{},
+		NumberDeltas:  make([]int, len(first.Numbers)),
+		StarDeltas:    make([]int, len(first.Stars)),
+	}
+	for i, n := range first.Numbers {
+		if slices.Contains(second.Numbers, n) {
+			c.SharedNumbers = append(c.SharedNumbers, n)
+		}
+		c.NumberDeltas[i] = second.Numbers[i] - n
+	}
+	for i, s := range first.Stars {
+		if slices.Contains(second.Stars, s) {
+			c.SharedStars = append(c.SharedStars, s)
+		}
+		c.StarDeltas[i] = second.Stars[i] - s
+	}
+
+	sendValue(w, r, c, func(buf *bytes.Buffer) {
+		for _, res := range draws {
+			fmt.Fprintf(buf, "%s: %s, %s: %s, %s: %s\n", tr(r, "label_date"), plaintextDate(r, res.Date), tr(r, "label_numbers"), joinInts(res.Numbers), tr(r, "label_stars"), joinInts(res.Stars))
+		}
+		fmt.Fprintf(buf, "Shared numbers: %s, Shared stars: %s\n", joinInts(c.SharedNumbers), joinInts(c.SharedStars))
+		fmt.Fprintf(buf, "Number deltas: %s, Star deltas: %s\n", joinInts(c.NumberDeltas), joinInts(c.StarDeltas))
+	})
+}
+
+// DrawVersion is one value a draw has had. Superseded versions were replaced
+// by a correction (Reason update) or removed (Reason delete).
+type DrawVersion struct {
+	Numbers      []int  `json:"numbers" xml:"numbers>number"`
+	Stars        []int  `json:"stars" xml:"stars>star"`
+	Special      bool   `json:"special" xml:"special"`
+	Superseded   bool   `json:"superseded" xml:"superseded,attr"`
+	SupersededAt string `json:"superseded_at,omitempty" xml:"superseded_at,attr,omitempty"`
+	Reason       string `json:"reason,omitempty" xml:"reason,attr,omitempty"`
+}
+
+// DrawHistory is the response of /results/date/{date}/history: every version
+// of a draw, oldest first. The current one, if the draw was not deleted, is last.
+type DrawHistory struct {
+	XMLName   xml.Name      `json:"-" xml:"history"`
+	Game      string        `json:"game" xml:"game,attr"`
+	Date      string        `json:"date" xml:"date,attr"`
+	Corrected bool          `json:"corrected" xml:"corrected,attr"`
+	Deleted   bool          `json:"deleted" xml:"deleted,attr"`
+	Versions  []DrawVersion `json:"versions" xml:"version"`
+}
+
+// historyHandler serves the versions of the draw of a date, so clients can
+// tell when published numbers were corrected after the fact.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /results/date/history from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	date, ok := parseDateParam(r.PathValue("date"))
+	if !ok {
+		http.Error(w, tr(r, "invalid_date"), http.StatusBadRequest)
+		return
+	}
+
+	history, err := readDrawHistory(g, date)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching the history of the %s draw: %v", date, err)
+		return
+	}
+	if len(history.Versions) == 0 {
+		http.Error(w, tr(r, "no_results_date"), http.StatusNotFound)
+		return
+	}
+
+	sendValue(w, r, history, func(buf *bytes.Buffer) {
+		for _, v := range history.Versions {
+			fmt.Fprintf(buf, "%s: %s + %s", plaintextDate(r, history.Date), joinInts(v.Numbers), joinInts(v.Stars))
+			if v.Special {
+				buf.WriteString(" (special)")
+			}
+			if v.Superseded {
+				fmt.Fprintf(buf, ", superseded %s (%s)\n", v.SupersededAt, v.Reason)
+			} else {
+				buf.WriteString(", current\n")
+			}
+		}
+	})
+}
+
+// readDrawHistory reads the superseded versions of the draw of date from
+// results_history and appends the current one.
+func readDrawHistory(g *Game, date string) (DrawHistory, error) {
+	history := DrawHistory{Game: g.ID, Date: date, Versions: []DrawVersion{}}
+	err := retryBusy(func() error {
+		history.Versions = history.Versions[:0]
+		rows, err := db.Query("SELECT draw, superseded, reason FROM results_history WHERE game = ? AND date = ? ORDER BY id", g.ID, date)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var draw sql.NullString
+			v := DrawVersion{Superseded: true}
+			if err := rows.Scan(&draw, &v.SupersededAt, &v.Reason); err != nil {
+				return err
+			}
+			if d := parseAuditDraw(draw); d != nil {
+				v.Numbers, v.Stars, v.Special = d.Numbers, d.Stars, d.Special
+			}
+			history.Versions = append(history.Versions, v)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return history, err
+	}
+
+	current, err := storedResult(g, date)
+	if err != nil {
+		return history, err
+	}
+	history.Corrected = slices.ContainsFunc(history.Versions, func(v DrawVersion) bool { return v.Reason == "update" })
+	if current != nil {
+		history.Versions = append(history.Versions, DrawVersion{Numbers: current.Numbers, Stars: current.Stars, Special: current.Special})
+	} else {
+		history.Deleted = len(history.Versions) > 0
+	}
+	return history, nil
+}
+
+// yearHandler serves all results for a specific year.
+func yearHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /results/year/ from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	year := r.PathValue("year")
+	t, err := time.Parse("2006", year)
+	if err != nil {
+		http.Error(w, tr(r, "invalid_year"), http.StatusBadRequest)
+		return
+	}
+
+	results, err := queryResults(g, g.stmts.byYear, t.Year())
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching results by year (%s): %v", year, err)
+		return
+	}
+
+	results, err = filterSpecial(r, results)
+	if err != nil {
+		http.Error(w, tr(r, "invalid_special"), http.StatusBadRequest)
+		return
+	}
+
+	results, ok = paginate(w, r, results)
+	if !ok {
+		return
+	}
+
+	if len(results) == 0 {
+		http.Error(w, tr(r, "no_results_year", year), http.StatusNotFound)
+		return
+	}
+
+	sendResponse(w, r, results)
+}
+
+// monthYearHandler serves all results for a specific month and year.
+func monthYearHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /results/month/ from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	monthYear := r.PathValue("month")
+	if len(strings.Split(monthYear, "-")) != 2 {
+		http.Error(w, tr(r, "invalid_month_format"), http.StatusBadRequest)
+		return
+	}
+
+	t, err := time.Parse("2006-01", monthYear)
+	if err != nil {
+		http.Error(w, tr(r, "invalid_month"), http.StatusBadRequest)
+		return
+	}
+
+	results, err := queryResults(g, g.stmts.byMonth, t.Year(), int(t.Month()))
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching results by month/year (%s): %v", monthYear, err)
+		return
+	}
+
+	results, err = filterSpecial(r, results)
+	if err != nil {
+		http.Error(w, tr(r, "invalid_special"), http.StatusBadRequest)
+		return
+	}
+
+	results, ok = paginate(w, r, results)
+	if !ok {
+		return
+	}
+
+	if len(results) == 0 {
+		http.Error(w, tr(r, "no_results_for", monthYear), http.StatusNotFound)
+		return
+	}
+
+	sendResponse(w, r, results)
+}
+
+// SyncResponse carries the draws newer than a date and the dataset version token.
+type SyncResponse struct {
+	XMLName xml.Name `json:"-" xml:"sync"`
+	Version string   `json:"version" xml:"version,attr"`
+	Since   string   `json:"since,omitempty" xml:"since,attr,omitempty"`
+	Count   int      `json:"count" xml:"count,attr"`
+	Results []Result `json:"results" xml:"result"`
+}
+
+// syncHandler serves the draws newer than the 'since' date (all draws without it),
+// oldest first, together with the dataset version token. Mirrors keep the token and
+// only need to fetch again when it changes.
+func syncHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /sync from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	since := r.URL.Query().Get("since")
+	if since != "" {
+		if _, err := time.Parse("2006-01-02", since); err != nil {
+			http.Error(w, tr(r, "invalid_date"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	version, err := versions.get(g)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error computing dataset version: %v", err)
+		return
+	}
+
+	results, err := queryResults(g, g.stmts.since, since)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching results since (%s): %v", since, err)
+		return
+	}
+	if results == nil {
+		results = []Result{}
+	}
+
+	if err := setTimestamps(r, results); err != nil {
+		http.Error(w, tr(r, "invalid_tz"), http.StatusBadRequest)
+		return
+	}
+
+	sync := SyncResponse{Version: version, Since: since, Count: len(results), Results: results}
+	w.Header().Set("X-Dataset-Version", version)
+	sendValue(w, r, sync, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "Version: %s, Count: %d\n", sync.Version, sync.Count)
+		for _, result := range sync.Results {
+			fmt.Fprintf(buf, "%s: %s, %s: %s, %s: %s\n", tr(r, "label_date"), plaintextDate(r, result.Date), tr(r, "label_numbers"), joinInts(result.Numbers), tr(r, "label_stars"), joinInts(result.Stars))
+		}
+	})
+}
+
+// Change is a change to the dataset, from the audit log. Kind is new (a draw
+// stored as it was published), backfill (an older draw filled in from the
+// archives), correction or deletion.
+type Change struct {
+	ID      int64      `json:"id" xml:"id,attr"`
+	Time    string     `json:"time" xml:"time,attr"`
+	Date    string     `json:"date" xml:"date,attr"`
+	Kind    string     `json:"kind" xml:"kind,attr"`
+	Changes []string   `json:"changes" xml:"changes>change"`
+	Before  *AuditDraw `json:"before" xml:"before,omitempty"`
+	After   *AuditDraw `json:"after" xml:"after,omitempty"`
+}
+
+// ChangeFeed is the response of /changes, newest change first.
+type ChangeFeed struct {
+	XMLName xml.Name `json:"-" xml:"changes"`
+	Game    string   `json:"game" xml:"game,attr"`
+	Changes []Change `json:"changes" xml:"change"`
+}
+
+// RSSFeed is an RSS 2.0 document, the ?format=rss form of /changes.
+type RSSFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel RSSChannel `xml:"channel"`
+}
+
+type RSSChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []RSSItem `xml:"item"`
+}
+
+type RSSItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	GUID        RSSGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type RSSGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// maxChanges bounds ?limit= of /changes.
+const maxChanges = 500
+
+// changeKind names an audit log action for the change feed.
+func changeKind(action, source string) string {
+	switch {
+	case action == "insert" && (source == "backfill" || source == "import-fdj" || source == "verify"):
+		return "backfill"
+	case action == "insert":
+		return "new"
+	case action == "update":
+		return "correction"
+	}
+	return "deletion"
+}
+
+// auditResult turns a draw of the audit log into a result, for resultChanges.
+func auditResult(d *AuditDraw) *Result {
+	if d == nil {
+		return nil
+	}
+	return &Result{Numbers: d.Numbers, Stars: d.Stars, Special: d.Special}
+}
+
+// changesHandler lists the latest ?limit= (50) changes to the draws of a game,
+// newest first, from the audit log. ?since= keeps the changes after the one
+// with that id, so that a downstream copy can catch up with what it missed.
+// ?format=rss serves them as an RSS feed.
+func changesHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /changes from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	limit := 50
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, tr(r, "invalid_limit"), http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxChanges)
+	}
+	var since int64
+	if value := query.Get("since"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, tr(r, "invalid_since"), http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+
+	// Updates that left the draw as it was are not changes.
+	feed := ChangeFeed{Game: g.ID, Changes: []Change{}}
+	err := retryBusy(func() error {
+		feed.Changes = feed.Changes[:0]
+		rows, err := db.QueryContext(r.Context(), `SELECT id, time, date, action, source, before, after FROM audit_log
+			WHERE game = ? AND id > ? AND (action != 'update' OR before IS NOT after)
+			ORDER BY id DESC LIMIT ?`, g.ID, since, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var c Change
+			var action, source string
+			var before, after sql.NullString
+			if err := rows.Scan(&c.ID, &c.Time, &c.Date, &action, &source, &before, &after); err != nil {
+				return err
+			}
+			c.Kind = changeKind(action, source)
+			c.Before, c.After = parseAuditDraw(before), parseAuditDraw(after)
+			c.Changes = resultChanges(auditResult(c.Before), auditResult(c.After))
+			feed.Changes = append(feed.Changes, c)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error reading the changes: %v", err)
+		return
+	}
+
+	if strings.ToLower(query.Get("format")) == "rss" {
+		sendChangesRSS(w, r, g, feed)
+		return
+	}
+	sendValue(w, r, feed, func(buf *bytes.Buffer) {
+		for _, c := range feed.Changes {
+			fmt.Fprintf(buf, "%d %s %s %s: %s\n", c.ID, c.Time, c.Date, c.Kind, strings.Join(c.Changes, "; "))
+		}
+	})
+}
+
+// sendChangesRSS writes the change feed as RSS 2.0. Each item links to the
+// history of the draw it changed.
+func sendChangesRSS(w http.ResponseWriter, r *http.Request, g *Game, feed ChangeFeed) {
+	prefix := ""
+	if r.PathValue("game") != "" {
+		prefix = "/games/" + g.ID
+	}
+	doc := RSSFeed{Version: "2.0", Channel: RSSChannel{
+		Title:       g.Name + " results: changes",
+		Link:        externalURL(r, prefix+"/changes"),
+		Description: "New, backfilled, corrected and deleted " + g.Name + " draws.",
+		Items:       []RSSItem{},
+	}}
+	for _, c := range feed.Changes {
+		item := RSSItem{
+			Title:       fmt.Sprintf("%s %s: %s", g.Name, c.Date, c.Kind),
+			Link:        externalURL(r, prefix+"/results/date/"+c.Date+"/history"),
+			Description: strings.Join(c.Changes, "; ") + " (" + c.Before.String() + " -> " + c.After.String() + ")",
+			GUID:        RSSGUID{Value: fmt.Sprintf("%s-change-%d", g.ID, c.ID)},
+		}
+		if t, err := time.Parse(time.RFC3339, c.Time); err == nil {
+			item.PubDate = t.UTC().Format(time.RFC1123Z)
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(buf).Encode(doc); err != nil {
+		http.Error(w, tr(r, "encode_error"), http.StatusInternalServerError)
+		log.Printf("Error encoding RSS response: %v", err)
+		return
+	}
+	writeBody(w, r, "application/rss+xml; charset=utf-8", buf.Bytes())
+}
+
+// externalURL returns the absolute URL of an application path as the client
+// sees it. Behind a trusted proxy the scheme comes from X-Forwarded-Proto.
+func externalURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil && isTrustedProxy(ip) {
+			if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+				scheme = proto
+			}
+		}
+	}
+	return scheme + "://" + r.Host + appURL(path)
+}
+
+// versionSet keeps the dataset version token of every game. The token is a hash of
+// all the game's rows, so it changes with any insert, correction or deletion.
+// It is computed on first use and dropped whenever the database changes.
+//
+// It is not the dataset revision (X-Dataset-Revision), on purpose: the
+// revision counts the changes of one database to all games, while the token
+// depends on the draws of one game only. Two databases with the same draws
+// have the same token, and a database restored from a backup, whose counter
+// goes back and then counts other changes, never reuses a token for other
+// draws. A mirror compares it with the token of its last copy (see the
+// updater's mirror command); the revision only tells whether anything changed
+// on this instance.
+type versionSet struct {
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+var versions = &versionSet{tokens: make(map[string]string)}
+
+// get returns the dataset version token of a game.
+func (vs *versionSet) get(g *Game) (string, error) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	if token, ok := vs.tokens[g.ID]; ok {
+		return token, nil
+	}
+	results, err := queryResults(g, g.stmts.all)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, res := range results {
+		fmt.Fprintf(h, "%s|%s|%s|%t\n", res.Date, joinInts(res.Numbers), joinInts(res.Stars), res.Special)
+	}
+	token := hex.EncodeToString(h.Sum(nil))[:16]
+	vs.tokens[g.ID] = token
+	return token, nil
+}
+
+// invalidate drops the computed tokens.
+func (vs *versionSet) invalidate() {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	vs.tokens = make(map[string]string)
+}
+
+// AppVersion is the response of /version: what is deployed, for operators
+// and bug reports. SchemaVersion is the migration level of the database and
+// Migrations the level this build knows.
+type AppVersion struct {
+	XMLName         xml.Name `json:"-" xml:"version"`
+	Version         string   `json:"version" xml:"version"`
+	Commit          string   `json:"commit,omitempty" xml:"commit,omitempty"`
+	BuildDate       string   `json:"build_date,omitempty" xml:"build_date,omitempty"`
+	GoVersion       string   `json:"go_version" xml:"go_version"`
+	SQLiteDriver    string   `json:"sqlite_driver,omitempty" xml:"sqlite_driver,omitempty"`
+	SQLiteVersion   string   `json:"sqlite_version" xml:"sqlite_version"`
+	SchemaVersion   int      `json:"schema_version" xml:"schema_version"`
+	Migrations      int      `json:"migrations" xml:"migrations"`
+	DatasetRevision int64    `json:"dataset_revision" xml:"dataset_revision"`
+}
+
+// versionHandler serves the version of the server and of what it runs on.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /version from %s", clientIP(r))
+	}
+
+	v := AppVersion{Version: version, GoVersion: runtime.Version(), Migrations: len(migrations)}
+	v.Commit, v.BuildDate = buildMetadata()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/mattn/go-sqlite3" {
+				v.SQLiteDriver = dep.Path + " " + dep.Version
+			}
+		}
+	}
+	err := retryBusy(func() error {
+		if err := db.QueryRow("SELECT sqlite_version()").Scan(&v.SQLiteVersion); err != nil {
+			return err
+		}
+		return db.QueryRow("PRAGMA user_version").Scan(&v.SchemaVersion)
+	})
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error reading the database versions: %v", err)
+		return
+	}
+	rev, err := revision.get()
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error reading the dataset revision: %v", err)
+		return
+	}
+	v.DatasetRevision = rev.Revision
+
+	sendValue(w, r, v, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "EuroMillions API %s\n", versionString())
+		fmt.Fprintf(buf, "Go: %s\nSQLite: %s (%s)\n", v.GoVersion, v.SQLiteVersion, v.SQLiteDriver)
+		fmt.Fprintf(buf, "Schema: %d (this build: %d)\nDataset revision: %d\n", v.SchemaVersion, v.Migrations, v.DatasetRevision)
+	})
+}
+
+// DataVersion is the response of /version/data.
+type DataVersion struct {
+	XMLName  xml.Name `json:"-" xml:"data"`
+	Revision int64    `json:"revision" xml:"revision,attr"`
+	Updated  string   `json:"updated" xml:"updated,attr"`
+}
+
+// revisionCache holds the dataset revision, which triggers bump on every
+// change to the draws of any game. It is read on first use and dropped
+// whenever the database changes.
+type revisionCache struct {
+	mu    sync.Mutex
+	value *DataVersion
+}
+
+var revision = &revisionCache{}
+
+// get returns the current dataset revision.
+func (c *revisionCache) get() (DataVersion, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.value != nil {
+		return *c.value, nil
+	}
+	var v DataVersion
+	err := retryBusy(func() error {
+		return db.QueryRow("SELECT revision, updated FROM dataset_revision WHERE id = 1").Scan(&v.Revision, &v.Updated)
+	})
+	if err != nil {
+		return v, err
+	}
+	c.value = &v
+	return v, nil
+}
+
+// invalidate drops the cached revision.
+func (c *revisionCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value = nil
+}
+
+// withRevision sets X-Dataset-Revision on every response, so clients can
+// tell whether anything changed from the headers of any call.
+func withRevision(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v, err := revision.get(); err == nil {
+			w.Header().Set("X-Dataset-Revision", strconv.FormatInt(v.Revision, 10))
+		} else if verbose {
+			log.Printf("Error reading the dataset revision: %v", err)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// EndpointParam is one query parameter of an endpoint.
+type EndpointParam struct {
+	Name        string `json:"name" xml:"name,attr"`
+	Description string `json:"description" xml:",chardata"`
+}
+
+// EndpointInfo is the answer to OPTIONS on a route: what a generic client
+// needs to know to call it.
+type EndpointInfo struct {
+	XMLName     xml.Name        `json:"-" xml:"endpoint"`
+	Path        string          `json:"path" xml:"path,attr"`
+	Methods     []string        `json:"methods" xml:"methods>method"`
+	Description string          `json:"description" xml:"description"`
+	Parameters  []EndpointParam `json:"parameters" xml:"parameters>parameter"`
+	Formats     []string        `json:"formats" xml:"formats>format"`
+}
+
+// endpointDoc describes a route for OPTIONS requests.
+type endpointDoc struct {
+	description string
+	params      []EndpointParam
+}
+
+// Parameters shared by many routes.
+var (
+	formatParams = []EndpointParam{
+		{"format", "json (default), ndjson (one JSON object per line), cbor, xml, plaintext, or csv and proto (see /results.proto) for draws"},
+		{"lang", "language of the messages and plaintext dates: en, pt, fr or es"},
+	}
+	resultParams = append([]EndpointParam{
+		{"tz", "IANA time zone of the draw timestamps (default UTC)"},
+		{"envelope", "true wraps the results in an object with their count"},
+		{"include", "notes adds the admins' annotations of each draw"},
+		{"xml", "with format=xml, schema or compact: always a list, in the namespace of /schema.xsd, with elements or attributes"},
+	}, formatParams...)
+	listParams = append([]EndpointParam{
+		{"special", "true keeps only the special draws, false only the others"},
+		{"page", "page number, from 1"},
+		{"per_page", "results per page, 1 to 1000"},
+	}, resultParams...)
+)
+
+// endpointDocs describes the public routes, keyed by path without the
+// /games/{game} prefix of the per-game variants.
+var endpointDocs = map[string]endpointDoc{
+	"/":        {"The latest result (same as /results/latest).", resultParams},
+	"/results": {"All results, newest first.", listParams},
+	"/results/latest": {"The latest result.", append([]EndpointParam{
+		{"after", "YYYY-MM-DD; 204 No Content unless a newer draw exists"},
+	}, resultParams...)},
+	"/results/wait": {"Waits for a draw newer than ?after= and returns it.", append([]EndpointParam{
+		{"after", "YYYY-MM-DD of the latest draw the client knows"},
+		{"timeout", "how long to wait, e.g. 60s (at most 5m)"},
+	}, resultParams...)},
+	"/results/compare": {"Shared balls and positional differences of two draws.", append([]EndpointParam{
+		{"d1", "YYYY-MM-DD of the first draw"},
+		{"d2", "YYYY-MM-DD of the second draw"},
+	}, formatParams...)},
+	"/results/date/{date}": {"The result of a date (YYYY-MM-DD).", append([]EndpointParam{
+		{"nearest", "before or after: the closest draw when none was held that day"},
+	}, resultParams...)},
+	"/results/date/{date}/history": {"Every value the draw of a date has had.", formatParams},
+	"/results/year/{year}":         {"All results of a year.", listParams},
+	"/results/month/{month}":       {"All results of a month (YYYY-MM).", listParams},
+	"/results/calendar/{year}":     {"The draw dates of a year, by month.", formatParams},
+	"/generate/wheel": {"An abbreviated wheeling system for a pool of numbers and stars.", append([]EndpointParam{
+		{"numbers", "comma-separated pool of 5 to 20 numbers"},
+		{"stars", "comma-separated pool of stars"},
+		{"guarantee", "numbers matched by at least one line (default 3)"},
+		{"exclude", "past-winners, numbers:N,... or stars:N,...; repeatable"},
+	}, formatParams...)},
+	"/stats/simulate": {"Monte Carlo simulation of playing random lines.", append([]EndpointParam{
+		{"lines", "lines per draw (default 1)"},
+		{"draws", "draws per trial (default 104)"},
+		{"trials", "number of trials (default 100)"},
+		{"seed", "seed to reproduce a run"},
+	}, formatParams...)},
+	"/check/batch": {"Checks the lines of a JSON body against a range of draws.", formatParams},
+	"/stats": {"Digest of a game: draws, latest draw, hot and cold balls, last update.", append([]EndpointParam{
+		{"tz", "IANA time zone of the latest draw's timestamp (default UTC)"},
+	}, formatParams...)},
+	"/stats/numbers": {"How often each ball and pair was drawn.", append([]EndpointParam{
+		{"pairs", "number of pairs listed (default 10)"},
+	}, formatParams...)},
+	"/stats/year/{year}": {"Summary of a year.", formatParams},
+	"/stats/heatmap": {"Appearances of each ball per period.", append([]EndpointParam{
+		{"granularity", "month (default) or year"},
+		{"year", "limits the heatmap to one year"},
+	}, formatParams...)},
+	"/stats/repeats": {"How often balls repeat from the preceding draw.", formatParams},
+	"/stats/probability": {"The exact odds of a number of matches.", append([]EndpointParam{
+		{"numbers", "matched numbers (default all)"},
+		{"stars", "matched stars (default all)"},
+	}, formatParams...)},
+	"/sync": {"Draws newer than a date with the dataset version.", append([]EndpointParam{
+		{"since", "YYYY-MM-DD; all draws without it"},
+	}, resultParams...)},
+	"/changes": {"Recent changes to the draws, newest first.", append([]EndpointParam{
+		{"since", "id of the last change already seen"},
+		{"limit", "number of changes, 1 to 500 (default 50)"},
+		{"format", "also rss, for an RSS 2.0 feed"},
+	}, formatParams...)},
+	"/games":         {"The supported games.", formatParams},
+	"/version":       {"Versions of the server, Go, SQLite, the schema and the dataset.", formatParams},
+	"/version/data":  {"The dataset revision.", formatParams},
+	"/status":        {"Freshness of the data: newest draws, the updater's last runs, database size.", formatParams},
+	"/schema.xsd":    {"XML schema of ?format=xml&xml=schema and &xml=compact.", []EndpointParam{}},
+	"/results.proto": {"Protocol Buffers schema of ?format=proto.", []EndpointParam{}},
+	"/subscriptions": {"The notification subscriptions of the API key.", append([]EndpointParam{
+		{"channel", "email, webhook or ntfy"},
+		{"target", "address or URL to notify"},
+		{"events", "comma-separated: draw, win, weekly, monthly"},
+		{"numbers", "numbers of the line checked for wins"},
+		{"stars", "stars of the line checked for wins"},
+		{"game", "game of the subscription"},
+	}, formatParams...)},
+	"/subscriptions/{id}": {"One subscription of the API key.", formatParams},
+}
+
+// optionsMethods are the methods probed to answer OPTIONS.
+var optionsMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete}
+
+// withOptions answers OPTIONS on the public routes with an Allow header and
+// an EndpointInfo, so generic clients can discover how to call them. The
+// methods are found by asking the mux which of them the path routes, so they
+// always match the registered patterns. Nothing is authenticated, as it only
+// describes the API. The admin area is left to its own mux.
+func withOptions(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions || r.URL.Path == "/admin" || strings.HasPrefix(r.URL.Path, "/admin/") {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		info := EndpointInfo{Parameters: []EndpointParam{}, Formats: []string{"json", "ndjson", "cbor", "xml", "plaintext"}}
+		for _, method := range optionsMethods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			if _, pattern := mux.Handler(probe); pattern != "" {
+				info.Methods = append(info.Methods, method)
+				if info.Path == "" {
+					_, info.Path, _ = strings.Cut(pattern, " ")
+				}
+			}
+		}
+		if len(info.Methods) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		info.Methods = append(info.Methods, http.MethodOptions)
+		w.Header().Set("Allow", strings.Join(info.Methods, ", "))
+
+		key := strings.TrimSuffix(strings.TrimPrefix(info.Path, "/games/{game}"), "{$}")
+		if doc, ok := endpointDocs[key]; ok {
+			info.Description, info.Parameters = doc.description, doc.params
+		}
+		sendValue(w, r, info, func(buf *bytes.Buffer) {
+			fmt.Fprintf(buf, "%s %s\n%s\n", strings.Join(info.Methods, ", "), info.Path, info.Description)
+			for _, p := range info.Parameters {
+				fmt.Fprintf(buf, "  %s: %s\n", p.Name, p.Description)
+			}
+		})
+	})
+}
+
+// panicReporters are called with every panic recovered from a handler, after
+// it has been logged, e.g. to send it to an error tracker.
+var panicReporters []func(r *http.Request, value any, stack []byte)
+
+// withRecovery turns a panic in a handler into a 500 response and a logged
+// stack trace, so one bad row cannot take the whole server down.
+// http.ErrAbortHandler is passed on: it is how a handler aborts a response.
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			if value == http.ErrAbortHandler {
+				panic(value)
+			}
+			stack := debug.Stack()
+			log.Printf("Panic serving %s %s from %s: %v\n%s", r.Method, r.URL.RequestURI(), clientIP(r), value, stack)
+			for _, report := range panicReporters {
+				report(r, value, stack)
+			}
+			http.Error(w, tr(r, "internal_error"), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Maintenance is the maintenance state: the body of the 503 responses sent
+// in maintenance mode, and the response of /admin/maintenance.
+type Maintenance struct {
+	XMLName     xml.Name `json:"-" xml:"maintenance"`
+	Maintenance bool     `json:"maintenance" xml:"enabled,attr"`
+	Message     string   `json:"message,omitempty" xml:"message,omitempty"`
+	RetryAfter  int      `json:"retry_after,omitempty" xml:"retry_after,attr,omitempty"`
+}
+
+// withMaintenance answers every request but those of the admin area with a
+// 503 and a Retry-After while maintenance mode is on, so clients retry later
+// instead of reading data that is being repaired or migrated.
+func withMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, basePath)
+		if !maintenance.Load() || path == "/admin" || strings.HasPrefix(path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+		w.Header().Set("Cache-Control", "no-store")
+		// sendValue sets the headers and answers 200: capture the body to send
+		// it with the 503.
+		m := Maintenance{Maintenance: true, Message: tr(r, "maintenance"), RetryAfter: maintenanceRetryAfter}
+		capture := &captureWriter{header: w.Header()}
+		sendValue(capture, r, m, func(buf *bytes.Buffer) {
+			fmt.Fprintln(buf, m.Message)
+		})
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(capture.body.Bytes())
+	})
+}
+
+// statusWriter records the status of a response, and the start of its body
+// when it is an error.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	if s.status >= 500 && len(s.body) < 200 {
+		s.body = append(s.body, p[:min(len(p), 200-len(s.body))]...)
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (s *statusWriter) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// withErrorReports reports the 5xx responses to the error tracker, with the
+// route and date they were for. The 503s of the concurrency limits and of
+// maintenance mode are the server working as intended and are not reported. The cause is in the log,
+// as the handlers log it before answering.
+func withErrorReports(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status < 500 || sw.status == http.StatusServiceUnavailable {
+			return
+		}
+		tags := requestTags(r)
+		tags["status"] = strconv.Itoa(sw.status)
+		message := fmt.Sprintf("%d on %s: %s", sw.status, tags["endpoint"], strings.TrimSpace(string(sw.body)))
+		go tracker.capture("error", message, tags, map[string]any{"url": r.URL.RequestURI()})
+	})
+}
+
+// requestTags returns the context of an error report: the method, the route
+// and the draw date the request was about, from the {date} of the route or
+// a date query parameter.
+func requestTags(r *http.Request) map[string]string {
+	path := strings.TrimPrefix(r.URL.Path, basePath)
+	probe := r.Clone(r.Context())
+	probe.URL.Path = path
+	_, pattern := http.DefaultServeMux.Handler(probe)
+	_, route, _ := strings.Cut(pattern, " ")
+	tags := map[string]string{"method": r.Method, "endpoint": route}
+	if route == "" {
+		tags["endpoint"] = path
+	}
+
+	segments := strings.Split(path, "/")
+	for i, s := range strings.Split(route, "/") {
+		if s == "{date}" && i < len(segments) {
+			tags["date"] = segments[i]
+		}
+	}
+	query := r.URL.Query()
+	for _, name := range []string{"date", "d1", "after", "since", "from"} {
+		if value := query.Get(name); value != "" && tags["date"] == "" {
+			tags["date"] = value
+		}
+	}
+	return tags
+}
+
+// sentryClient sends events to Sentry, or any tracker implementing its store
+// API, as the official SDK would, without depending on it.
+type sentryClient struct {
+	storeURL string
+	key      string
+}
+
+// newSentryClient parses a DSN of the form https://KEY@HOST/PROJECT.
+func newSentryClient(dsn string) (*sentryClient, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || u.Host == "" || i < 0 || u.Path[i+1:] == "" {
+		return nil, fmt.Errorf("%q is not a DSN (https://KEY@HOST/PROJECT)", dsn)
+	}
+	store := url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path[:i] + "/api/" + u.Path[i+1:] + "/store/"}
+	return &sentryClient{storeURL: store.String(), key: u.User.Username()}, nil
+}
+
+// capture sends one event. Failures are only logged: reporting an error must
+// not cause another.
+func (c *sentryClient) capture(level, message string, tags map[string]string, extra map[string]any) {
+	id := make([]byte, 16)
+	cryptorand.Read(id)
+	hostname, _ := os.Hostname()
+	event, err := json.Marshal(map[string]any{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"level":       level,
+		"platform":    "go",
+		"logger":      "go-euromillions-api",
+		"server_name": hostname,
+		"release":     version,
+		"message":     message,
+		"tags":        tags,
+		"extra":       extra,
+	})
+	if err != nil {
+		log.Printf("Error encoding error report: %v", err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.storeURL, bytes.NewReader(event))
+	if err != nil {
+		log.Printf("Error sending error report: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=go-euromillions-api/%s, sentry_key=%s", version, c.key))
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Error sending error report: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Error sending error report: the tracker answered %s", resp.Status)
+	}
+}
+
+// s3Client sends requests to S3 or a compatible store (MinIO, R2, B2...),
+// signed with AWS Signature Version 4. It uses path-style URLs,
+// ENDPOINT/BUCKET/KEY, which every such store accepts.
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	token     string
+	client    *http.Client
+}
+
+// newS3Client parses a replica URL of the form s3://BUCKET/PREFIX and returns
+// the client and the prefix. The endpoint defaults to the AWS one of the
+// region. The credentials come from $AWS_ACCESS_KEY_ID and
+// $AWS_SECRET_ACCESS_KEY, with $AWS_SESSION_TOKEN for temporary ones.
+func newS3Client(replicaURL, endpoint, region string) (*s3Client, string, error) {
+	u, err := url.Parse(replicaURL)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, "", fmt.Errorf("%q is not an S3 URL (s3://BUCKET/PREFIX)", replicaURL)
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	e, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil || (e.Scheme != "http" && e.Scheme != "https") || e.Host == "" {
+		return nil, "", fmt.Errorf("%q is not an endpoint URL", endpoint)
+	}
+	c := &s3Client{
+		endpoint:  e,
+		region:    region,
+		bucket:    u.Host,
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		client:    &http.Client{Timeout: 5 * time.Minute},
+	}
+	if c.accessKey == "" || c.secretKey == "" {
+		return nil, "", fmt.Errorf("set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return c, strings.Trim(u.Path, "/"), nil
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// do sends a signed request for an object, or for the bucket when key is
+// empty, and returns the response body. A status other than 2xx is an error.
+// Keys are made of letters, digits and "/-._" only, so they need no escaping.
+func (c *s3Client) do(method, key string, query url.Values, body []byte) ([]byte, error) {
+	u := *c.endpoint
+	u.Path += "/" + c.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Format("20060102T150405Z")
+	day := now[:8]
+	digest := sha256.Sum256(body)
+	payload := hex.EncodeToString(digest[:])
+	req.Header.Set("X-Amz-Date", now)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	headers := "host:" + u.Host + "\nx-amz-content-sha256:" + payload + "\nx-amz-date:" + now + "\n"
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	if c.token != "" {
+		req.Header.Set("X-Amz-Security-Token", c.token)
+		headers += "x-amz-security-token:" + c.token + "\n"
+		signed += ";x-amz-security-token"
+	}
+	canonical := strings.Join([]string{method, u.EscapedPath(), u.RawQuery, headers, signed, payload}, "\n")
+	canonicalDigest := sha256.Sum256([]byte(canonical))
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + now + "\n" + scope + "\n" + hex.EncodeToString(canonicalDigest[:])
+	signingKey := hmacSHA256(hmacSHA256(hmacSHA256(hmacSHA256([]byte("AWS4"+c.secretKey), day), c.region), "s3"), "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signed, hex.EncodeToString(hmacSHA256(signingKey, toSign))))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s %s", method, u.Path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// put stores data gzip-compressed under key.
+func (c *s3Client) put(key string, data []byte) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	_, err := c.do(http.MethodPut, key, nil, buf.Bytes())
+	return err
+}
+
+// get returns the uncompressed object stored by put under key.
+func (c *s3Client) get(key string) ([]byte, error) {
+	body, err := c.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", key, err)
+	}
+	return io.ReadAll(zr)
+}
+
+// list returns the keys starting with prefix, in ascending order.
+func (c *s3Client) list(prefix string) ([]string, error) {
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		body, err := c.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("invalid listing: %v", err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !page.IsTruncated {
+			slices.Sort(keys)
+			return keys, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// walHeaderSize and walFrameHeaderSize are the sizes of the header of a
+// SQLite write-ahead log and of the header of each of its frames, see
+// https://www.sqlite.org/fileformat.html#the_write_ahead_log.
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+)
+
+// replicaTime is the layout of the times in the keys of a replica: the
+// generations and the WAL segments sort by name in time order.
+const replicaTime = "20060102T150405Z"
+
+// walChecksum continues a WAL checksum over data, a multiple of 8 bytes.
+func walChecksum(s [2]uint32, data []byte, order binary.ByteOrder) [2]uint32 {
+	for i := 0; i+8 <= len(data); i += 8 {
+		s[0] += order.Uint32(data[i:]) + s[1]
+		s[1] += order.Uint32(data[i+4:]) + s[0]
+	}
+	return s
+}
+
+// walOrder checks the header of a WAL and returns the byte order of its
+// checksums, or nil when the header is incomplete or invalid.
+func walOrder(wal []byte) binary.ByteOrder {
+	if len(wal) < walHeaderSize {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch binary.BigEndian.Uint32(wal) {
+	case 0x377f0682:
+		order = binary.LittleEndian
+	case 0x377f0683:
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+	s := walChecksum([2]uint32{}, wal[:24], order)
+	if s[0] != binary.BigEndian.Uint32(wal[24:]) || s[1] != binary.BigEndian.Uint32(wal[28:]) {
+		return nil
+	}
+	return order
+}
+
+// replicator streams the database to S3 the way litestream does. A
+// generation starts with a copy of the database file; then the transactions
+// committed to the write-ahead log are shipped every --replicate-interval as
+// segments, byte ranges of the WAL. Restoring the copy and replaying the
+// segments up to a time gives the database as it was then.
+//
+// When the WAL is restarted by a checkpoint, frames written since the last
+// sync may be gone, so a new generation is started; that also happens every
+// --snapshot-interval, to keep restores short.
+type replicator struct {
+	s3     *s3Client
+	prefix string
+	conn   *sql.Conn
+
+	generation string
+	started    time.Time
+	salt       []byte
+	offset     int
+	checksum   [2]uint32
+}
+
+// newReplicator connects to the --replicate store.
+func newReplicator() (*replicator, error) {
+	s3, prefix, err := newS3Client(replicateURL, replicateEndpoint, replicateRegion)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &replicator{s3: s3, prefix: prefix, conn: conn}, nil
+}
+
+// key returns the key of a file of the replica.
+func (rep *replicator) key(parts ...string) string {
+	return strings.TrimPrefix(rep.prefix+"/"+strings.Join(parts, "/"), "/")
+}
+
+// run syncs at the given interval, forever. Failures are logged and the sync
+// is tried again at the next tick.
+func (rep *replicator) run(interval time.Duration) {
+	for {
+		if err := rep.sync(context.Background()); err != nil {
+			log.Printf("Error replicating the database: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// sync ships the transactions committed since the last sync, starting a new
+// generation first when needed.
+func (rep *replicator) sync(ctx context.Context) error {
+	// The read transaction keeps the other connections from checkpointing
+	// past it while the files are read.
+	tx, err := rep.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var n int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master").Scan(&n); err != nil {
+		return err
+	}
+
+	wal, err := os.ReadFile(dbPath + "-wal")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var salt []byte
+	if walOrder(wal) != nil {
+		salt = wal[16:24]
+	}
+	switch {
+	case rep.generation == "":
+	case !bytes.Equal(salt, rep.salt):
+		log.Printf("The WAL was restarted: starting a new replication generation")
+	case time.Since(rep.started) >= snapshotInterval:
+	default:
+		return rep.ship(wal, time.Now().UTC())
+	}
+
+	data, err := os.ReadFile(dbPath)
+	if err != nil {
+		return err
+	}
+	started := time.Now().UTC()
+	generation := started.Format(replicaTime)
+	if err := rep.s3.put(rep.key("generations", generation, "snapshot.db.gz"), data); err != nil {
+		return err
+	}
+	rep.generation, rep.started, rep.salt, rep.offset = generation, started, salt, 0
+	if verbose {
+		log.Printf("Started replication generation %s (%d bytes)", generation, len(data))
+	}
+	// The frames already in the WAL belong to the snapshot: their segment
+	// carries its time.
+	if err := rep.ship(wal, started); err != nil {
+		return err
+	}
+	return rep.prune()
+}
+
+// ship uploads the frames of the WAL after the last shipped one, up to the
+// last complete transaction, as a segment named after its offset and the
+// time of its state.
+func (rep *replicator) ship(wal []byte, at time.Time) error {
+	order := walOrder(wal)
+	if order == nil || !bytes.Equal(wal[16:24], rep.salt) {
+		return nil
+	}
+	frameSize := walFrameHeaderSize + int(binary.BigEndian.Uint32(wal[8:]))
+
+	start, s := rep.offset, rep.checksum
+	if start == 0 {
+		s = [2]uint32{binary.BigEndian.Uint32(wal[24:]), binary.BigEndian.Uint32(wal[28:])}
+	}
+	end, committed := start, s
+	for pos := max(start, walHeaderSize); pos+frameSize <= len(wal); pos += frameSize {
+		frame := wal[pos : pos+frameSize]
+		if !bytes.Equal(frame[8:16], rep.salt) {
+			break
+		}
+		s = walChecksum(s, frame[:8], order)
+		s = walChecksum(s, frame[walFrameHeaderSize:], order)
+		if s[0] != binary.BigEndian.Uint32(frame[16:]) || s[1] != binary.BigEndian.Uint32(frame[20:]) {
+			break
+		}
+		// A frame with a database size ends a transaction.
+		if binary.BigEndian.Uint32(frame[4:]) != 0 {
+			end, committed = pos+frameSize, s
+		}
+	}
+	if end == start {
+		return nil
+	}
+
+	name := fmt.Sprintf("%016x-%s.wal.gz", start, at.Format(replicaTime))
+	if err := rep.s3.put(rep.key("generations", rep.generation, "wal", name), wal[start:end]); err != nil {
+		return err
+	}
+	rep.offset, rep.checksum = end, committed
+	if verbose {
+		log.Printf("Replicated %d bytes of WAL", end-start)
+	}
+	return nil
+}
+
+// prune deletes the generations that are not needed to restore any time of
+// the last --replicate-retention.
+func (rep *replicator) prune() error {
+	keys, err := rep.s3.list(rep.key("generations") + "/")
+	if err != nil {
+		return err
+	}
+	generations := replicaGenerations(keys, rep.key("generations")+"/")
+	cutoff := time.Now().UTC().Add(-replicateRetention).Format(replicaTime)
+	// The newest generation started before the cutoff is kept: it covers the
+	// cutoff itself.
+	keep := ""
+	for _, g := range generations {
+		if g <= cutoff {
+			keep = g
+		}
+	}
+	for _, key := range keys {
+		g := strings.SplitN(strings.TrimPrefix(key, rep.key("generations")+"/"), "/", 2)[0]
+		if g < keep {
+			if _, err := rep.s3.do(http.MethodDelete, key, nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replicaGenerations returns the generations that have a snapshot, oldest first.
+func replicaGenerations(keys []string, prefix string) []string {
+	var generations []string
+	for _, key := range keys {
+		g, file, _ := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+		if file == "snapshot.db.gz" {
+			generations = append(generations, g)
+		}
+	}
+	return generations
+}
+
+// runRestore writes the database as it was at --timestamp (the latest
+// replicated state by default) from the --from replica: the snapshot of the
+// last generation started by then, with its WAL segments replayed.
+func runRestore(args []string) {
+	if replicateURL == "" || restoreOutput == "" {
+		restoreCmd.printHelp()
+		os.Exit(2)
+	}
+	target := time.Now().UTC()
+	if restoreTimestamp != "" {
+		t, err := time.Parse(time.RFC3339, restoreTimestamp)
+		if err != nil {
+			log.Fatalf("Invalid --timestamp: %s (use RFC 3339, e.g. 2025-06-01T12:00:00Z)", restoreTimestamp)
+		}
+		target = t.UTC()
+	}
+	if _, err := os.Stat(restoreOutput); err == nil {
+		log.Fatalf("%s already exists", restoreOutput)
+	}
+	s3, prefix, err := newS3Client(replicateURL, replicateEndpoint, replicateRegion)
+	if err != nil {
+		log.Fatalf("Invalid replica: %v", err)
+	}
+	rep := &replicator{s3: s3, prefix: prefix}
+
+	root := rep.key("generations") + "/"
+	keys, err := s3.list(root)
+	if err != nil {
+		log.Fatalf("Error listing the replica: %v", err)
+	}
+	generation := ""
+	for _, g := range replicaGenerations(keys, root) {
+		if g <= target.Format(replicaTime) {
+			generation = g
+		}
+	}
+	if generation == "" {
+		log.Fatalf("The replica has no generation started by %s", target.Format(time.RFC3339))
+	}
+
+	data, err := s3.get(rep.key("generations", generation, "snapshot.db.gz"))
+	if err != nil {
+		log.Fatalf("Error fetching the snapshot: %v", err)
+	}
+	// Segments are replayed up to the target time. The segment shipped with
+	// the snapshot has its time, so it is always replayed.
+	var wal []byte
+	segments := rep.key("generations", generation, "wal") + "/"
+	for _, key := range keys {
+		if !strings.HasPrefix(key, segments) {
+			continue
+		}
+		offset, shipped, _ := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(key, segments), ".wal.gz"), "-")
+		if shipped > target.Format(replicaTime) {
+			break
+		}
+		if fmt.Sprintf("%016x", len(wal)) != offset {
+			log.Printf("Segment %s does not follow the previous one; stopping there", key)
+			break
+		}
+		segment, err := s3.get(key)
+		if err != nil {
+			log.Fatalf("Error fetching a WAL segment: %v", err)
+		}
+		wal = append(wal, segment...)
+	}
+
+	if err := os.WriteFile(restoreOutput, data, 0644); err != nil {
+		log.Fatalf("Error writing the database: %v", err)
+	}
+	if len(wal) > 0 {
+		if err := os.WriteFile(restoreOutput+"-wal", wal, 0644); err != nil {
+			log.Fatalf("Error writing the database: %v", err)
+		}
+	}
+	// Opening the database replays the WAL; the checkpoint writes it into the
+	// database file.
+	restored, err := sql.Open("sqlite3", restoreOutput)
+	if err != nil {
+		log.Fatalf("Error opening the restored database: %v", err)
+	}
+	defer restored.Close()
+	var check string
+	if err := restored.QueryRow("PRAGMA integrity_check").Scan(&check); err != nil || check != "ok" {
+		log.Fatalf("The restored database is damaged: %v %s", err, check)
+	}
+	if _, err := restored.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		log.Fatalf("Error checkpointing the restored database: %v", err)
+	}
+	log.Printf("Restored generation %s with %d bytes of WAL to %s", generation, len(wal), restoreOutput)
+}
+
+// withCanonicalPaths redirects paths that only miss a route by their case or
+// a trailing slash, e.g. /Results/Latest/, to the canonical path, so such
+// client URLs work and the HTML views have a single URL each. GET and HEAD
+// get a 301; other methods a 308, which keeps the method and body.
+func withCanonicalPaths(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern == "" {
+			path := strings.ToLower(strings.TrimRight(r.URL.Path, "/"))
+			if path == "" {
+				path = "/"
+			}
+			probe := r.Clone(r.Context())
+			probe.URL.Path, probe.URL.RawPath = path, ""
+			if _, pattern := mux.Handler(probe); pattern != "" && path != r.URL.Path {
+				target := appURL(path)
+				if r.URL.RawQuery != "" {
+					target += "?" + r.URL.RawQuery
+				}
+				code := http.StatusPermanentRedirect
+				if r.Method == http.MethodGet || r.Method == http.MethodHead {
+					code = http.StatusMovedPermanently
+				}
+				http.Redirect(w, r, target, code)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// dataVersionHandler serves the dataset revision and when it last changed.
+func dataVersionHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /version/data from %s", clientIP(r))
+	}
+
+	v, err := revision.get()
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error reading the dataset revision: %v", err)
+		return
+	}
+	sendValue(w, r, v, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "Revision: %d, Updated: %s\n", v.Revision, v.Updated)
+	})
+}
+
+// GameStatus is the freshness of a game's draws: its newest draw, and the
+// last site the updater fetched for it and the last one that succeeded.
+type GameStatus struct {
+	Game        string       `json:"game" xml:"game,attr"`
+	NewestDraw  string       `json:"newest_draw,omitempty" xml:"newest_draw,attr,omitempty"`
+	LastRun     *ScrapeEntry `json:"last_run,omitempty" xml:"last_run,omitempty"`
+	LastSuccess *ScrapeEntry `json:"last_success,omitempty" xml:"last_success,omitempty"`
+}
+
+// Status is the response of /status.
+type Status struct {
+	XMLName      xml.Name     `json:"-" xml:"status"`
+	DatabaseSize int64        `json:"database_size" xml:"database_size,attr"`
+	Updated      string       `json:"updated" xml:"updated,attr"`
+	Games        []GameStatus `json:"games" xml:"game"`
+}
+
+// statusHandler tells operators whether the data is fresh, without reading
+// the logs: per game the newest draw and the updater's last run and last
+// successful site from the scrape log, with when the dataset last changed
+// and the size of the database file and its WAL.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /status from %s", clientIP(r))
+	}
+
+	v, err := revision.get()
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error reading the dataset revision: %v", err)
+		return
+	}
+	status := Status{Updated: v.Updated, Games: []GameStatus{}}
+	for _, suffix := range []string{"", "-wal"} {
+		if info, err := os.Stat(dbPath + suffix); err == nil {
+			status.DatabaseSize += info.Size()
+		}
+	}
+
+	for _, g := range games {
+		gs := GameStatus{Game: g.ID}
+		result, err := latest.get(g)
+		if err != nil && err != sql.ErrNoRows {
+			http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+			log.Printf("Error fetching latest result: %v", err)
+			return
+		}
+		gs.NewestDraw = result.Date
+
+		for _, q := range []struct {
+			entry **ScrapeEntry
+			where string
+		}{
+			{&gs.LastRun, "game = ?"},
+			{&gs.LastSuccess, "game = ? AND error IS NULL"},
+		} {
+			var e ScrapeEntry
+			err := retryBusy(func() error {
+				return db.QueryRow("SELECT time, game, source, COALESCE(draw_date, ''), inserted, COALESCE(error, '') FROM scrape_log WHERE "+q.where+" ORDER BY time DESC LIMIT 1", g.ID).
+					Scan(&e.Time, &e.Game, &e.Source, &e.Date, &e.Inserted, &e.Error)
+			})
+			if err != nil && err != sql.ErrNoRows {
+				http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+				log.Printf("Error reading the scrape log: %v", err)
+				return
+			}
+			if err == nil {
+				*q.entry = &e
+			}
+		}
+		status.Games = append(status.Games, gs)
+	}
+
+	sendValue(w, r, status, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "Database size: %d bytes, Updated: %s\n", status.DatabaseSize, status.Updated)
+		for _, gs := range status.Games {
+			fmt.Fprintf(buf, "%s: newest draw %s", gs.Game, cmp.Or(gs.NewestDraw, "-"))
+			if e := gs.LastRun; e != nil {
+				fmt.Fprintf(buf, ", last run %s (site %d) %s", e.Time, e.Source, e.Error)
+			}
+			if e := gs.LastSuccess; e != nil {
+				fmt.Fprintf(buf, ", last success %s (site %d, %s)", e.Time, e.Source, e.Date)
+			}
+			buf.WriteString("\n")
+		}
+	})
+}
+
+// gamesHandler lists the supported games.
+func gamesHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /games from %s", clientIP(r))
+	}
+
+	var v any = games
+	if strings.ToLower(r.URL.Query().Get("format")) == "xml" {
+		v = AllGames{Games: games}
+	}
+	sendValue(w, r, v, func(buf *bytes.Buffer) {
+		for _, g := range games {
+			fmt.Fprintf(buf, "%s: %s (%d+%d)\n", g.ID, g.Name, g.Numbers, g.Stars)
+		}
+	})
+}
+
+// WheelLine is one line of a wheeling system.
+type WheelLine struct {
+	Numbers []int `json:"numbers" xml:"numbers>number"`
+	Stars   []int `json:"stars" xml:"stars>star"`
+}
+
+// Wheel is an abbreviated wheeling system built from a pool of chosen numbers and stars.
+// Dropped counts the lines left out by exclude=past-winners because every star
+// combination made them a past jackpot; the guarantee does not hold without them.
+type Wheel struct {
+	XMLName   xml.Name    `json:"-" xml:"wheel"`
+	Numbers   []int       `json:"numbers" xml:"pool>number"`
+	Stars     []int       `json:"stars" xml:"pool>star"`
+	Guarantee int         `json:"guarantee" xml:"guarantee,attr"`
+	Dropped   int         `json:"dropped" xml:"dropped,attr"`
+	Lines     []WheelLine `json:"lines" xml:"line"`
+}
+
+// maxWheelTargets bounds the number of number combinations a wheel must cover,
+// which keeps the greedy search and the response size reasonable.
+const maxWheelTargets = 2000
+
+// wheelHandler builds an abbreviated wheel: a set of lines such that, if any
+// 'guarantee' of the drawn numbers are in the pool, at least one line matches them all.
+// Every combination of the chosen stars is played at least once.
+func wheelHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /generate/wheel from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	numbers, err := parseIntList(query.Get("numbers"))
+	if err != nil || len(numbers) < g.Numbers || len(numbers) > 20 || !validBalls(numbers, g.MaxNumber) {
+		http.Error(w, tr(r, "invalid_wheel_numbers", g.Numbers, g.MaxNumber), http.StatusBadRequest)
+		return
+	}
+	stars, err := parseIntList(query.Get("stars"))
+	if err != nil || len(stars) < g.Stars || !validBalls(stars, g.MaxStar) {
+		http.Error(w, tr(r, "invalid_wheel_stars", g.Stars, g.MaxStar), http.StatusBadRequest)
+		return
+	}
+
+	guarantee := 3
+	if value := query.Get("guarantee"); value != "" {
+		guarantee, err = strconv.Atoi(value)
+		if err != nil || guarantee < 1 || guarantee > g.Numbers {
+			http.Error(w, tr(r, "invalid_guarantee", g.Numbers), http.StatusBadRequest)
+			return
+		}
+	}
+	exclusions, err := parseExclusions(query["exclude"])
+	if err != nil {
+		http.Error(w, tr(r, "invalid_exclude"), http.StatusBadRequest)
+		return
+	}
+	numbers = withoutBalls(numbers, exclusions.numbers)
+	stars = withoutBalls(stars, exclusions.stars)
+	if len(numbers) < g.Numbers || len(stars) < g.Stars {
+		http.Error(w, tr(r, "pool_too_small"), http.StatusBadRequest)
+		return
+	}
+
+	if binomial(len(numbers), guarantee) > maxWheelTargets {
+		http.Error(w, tr(r, "wheel_too_large"), http.StatusBadRequest)
+		return
+	}
+
+	var past map[string]bool
+	if exclusions.pastWinners {
+		past, err = winners.get(g)
+		if err != nil {
+			http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+			log.Printf("Error loading past winning combinations: %v", err)
+			return
+		}
+	}
+
+	sort.Ints(numbers)
+	sort.Ints(stars)
+	mainLines := wheelLines(numbers, g.Numbers, guarantee)
+	var starLines [][]int
+	combinations(len(stars), g.Stars, func(idx []int) {
+		line := make([]int, len(idx))
+		for i, j := range idx {
+			line[i] = stars[j]
+		}
+		starLines = append(starLines, line)
+	})
+
+	wheel := Wheel{Numbers: numbers, Stars: stars, Guarantee: guarantee}
+	for i := 0; i < max(len(mainLines), len(starLines)); i++ {
+		line := WheelLine{Numbers: mainLines[i%len(mainLines)]}
+		// Pair the line with the next star combination that does not
+		// recreate a past jackpot; the line is dropped if none is left.
+		for j := 0; j < len(starLines); j++ {
+			stars := starLines[(i+j)%len(starLines)]
+			if !past[comboKey(line.Numbers, stars)] {
+				line.Stars = stars
+				break
+			}
+		}
+		if line.Stars != nil {
+			wheel.Lines = append(wheel.Lines, line)
+		} else {
+			wheel.Dropped++
+		}
+	}
+
+	sendValue(w, r, wheel, func(buf *bytes.Buffer) {
+		for _, line := range wheel.Lines {
+			fmt.Fprintf(buf, "%s: %s, %s: %s\n", tr(r, "label_numbers"), joinInts(line.Numbers), tr(r, "label_stars"), joinInts(line.Stars))
+		}
+		if wheel.Dropped > 0 {
+			fmt.Fprintln(buf, tr(r, "wheel_dropped", wheel.Dropped))
+		}
+	})
+}
+
+// CheckRequest is the body of POST /check/batch: the lines of a play slip and
+// the draws to check them against, from From to To (both optional, the latest
+// draw when neither is given).
+type CheckRequest struct {
+	Lines []WheelLine `json:"lines"`
+	From  string      `json:"from"`
+	To    string      `json:"to"`
+}
+
+// CheckMatch is how one line fared in one draw. Tier and Prize (the average
+// prize of the tier, in EUR) are only set for EuroMillions winning lines.
+type CheckMatch struct {
+	Date           string  `json:"date" xml:"date,attr"`
+	MatchedNumbers []int   `json:"matched_numbers" xml:"matched_numbers>number"`
+	MatchedStars   []int   `json:"matched_stars" xml:"matched_stars>star"`
+	Tier           string  `json:"tier,omitempty" xml:"tier,attr,omitempty"`
+	Prize          float64 `json:"prize,omitempty" xml:"prize,attr,omitempty"`
+}
+
+// CheckLine is the result of one line over every checked draw.
+type CheckLine struct {
+	Line     int          `json:"line" xml:"line,attr"`
+	Numbers  []int        `json:"numbers" xml:"numbers>number"`
+	Stars    []int        `json:"stars" xml:"stars>star"`
+	Wins     int          `json:"wins" xml:"wins,attr"`
+	Winnings float64      `json:"winnings" xml:"winnings,attr"`
+	Draws    []CheckMatch `json:"draws" xml:"draw"`
+}
+
+// CheckTotals sums up a batch check.
+type CheckTotals struct {
+	Lines    int            `json:"lines" xml:"lines,attr"`
+	Draws    int            `json:"draws" xml:"draws,attr"`
+	Wins     int            `json:"wins" xml:"wins,attr"`
+	Winnings float64        `json:"winnings" xml:"winnings,attr"`
+	Cost     float64        `json:"cost,omitempty" xml:"cost,attr,omitempty"`
+	Tiers    map[string]int `json:"tiers" xml:"-"`
+}
+
+// CheckBatch is the response of POST /check/batch. PrizeSource tells where
+// the prizes come from: "average" for the long-run average of each tier,
+// as no per-draw prize breakdown is stored.
+type CheckBatch struct {
+	XMLName     xml.Name    `json:"-" xml:"check"`
+	Game        string      `json:"game" xml:"game,attr"`
+	From        string      `json:"from" xml:"from,attr"`
+	To          string      `json:"to" xml:"to,attr"`
+	Currency    string      `json:"currency,omitempty" xml:"currency,attr,omitempty"`
+	PrizeSource string      `json:"prize_source,omitempty" xml:"prize_source,attr,omitempty"`
+	Lines       []CheckLine `json:"lines" xml:"line"`
+	Totals      CheckTotals `json:"totals" xml:"totals"`
+}
+
+const (
+	// maxCheckLines bounds the lines of a batch check.
+	maxCheckLines = 100
+	// maxCheckResults bounds the lines times draws of a batch check.
+	maxCheckResults = 20000
+)
+
+// checkBatchHandler checks up to maxCheckLines lines, e.g. a syndicate's
+// play slip, against every draw of a date range and reports the matches of
+// each line in each draw, with totals.
+func checkBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("POST request for /check/batch from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	var req CheckRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, tr(r, "invalid_check_body"), http.StatusBadRequest)
+		return
+	}
+	if len(req.Lines) == 0 || len(req.Lines) > maxCheckLines {
+		http.Error(w, tr(r, "invalid_check_lines", maxCheckLines), http.StatusBadRequest)
+		return
+	}
+	for i, line := range req.Lines {
+		if len(line.Numbers) != g.Numbers || !validBalls(line.Numbers, g.MaxNumber) {
+			http.Error(w, tr(r, "invalid_check_numbers", i+1, g.Numbers, g.MaxNumber), http.StatusBadRequest)
+			return
+		}
+		if len(line.Stars) != g.Stars || !validBalls(line.Stars, g.MaxStar) {
+			http.Error(w, tr(r, "invalid_check_stars", i+1, g.Stars, g.MaxStar), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, date := range []string{req.From, req.To} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			http.Error(w, tr(r, "invalid_date"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var draws []Result
+	var err error
+	if req.From == "" && req.To == "" {
+		var res Result
+		res, err = latest.get(g)
+		draws = []Result{res}
+	} else {
+		to := req.To
+		if to == "" {
+			to = "9999-12-31"
+		}
+		draws, err = queryResults(g, g.stmts.between, req.From, to)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching the draws to check: %v", err)
+		return
+	}
+	if err == sql.ErrNoRows || len(draws) == 0 {
+		http.Error(w, tr(r, "no_results"), http.StatusNotFound)
+		return
+	}
+	if len(draws)*len(req.Lines) > maxCheckResults {
+		http.Error(w, tr(r, "check_too_large", maxCheckResults), http.StatusBadRequest)
+		return
+	}
+
+	batch := CheckBatch{
+		Game:   g.ID,
+		From:   draws[0].Date,
+		To:     draws[len(draws)-1].Date,
+		Lines:  make([]CheckLine, len(req.Lines)),
+		Totals: CheckTotals{Lines: len(req.Lines), Draws: len(draws), Tiers: make(map[string]int)},
+	}
+	if g == defaultGame {
+		batch.Currency, batch.PrizeSource = "EUR", "average"
+		batch.Totals.Cost = float64(len(req.Lines)*len(draws)) * linePrice
+	}
+	for i, line := range req.Lines {
+		result := CheckLine{Line: i + 1, Numbers: slices.Clone(line.Numbers), Stars: slices.Clone(line.Stars), Draws: make([]CheckMatch, len(draws))}
+		slices.Sort(result.Numbers)
+		slices.Sort(result.Stars)
+		for j, draw := range draws {
+			m := CheckMatch{Date: draw.Date, MatchedNumbers: []int{}, MatchedStars: []int{}}
+			for _, n := range result.Numbers {
+				if slices.Contains(draw.Numbers, n) {
+					m.MatchedNumbers = append(m.MatchedNumbers, n)
+				}
+			}
+			for _, s := range result.Stars {
+				if slices.Contains(draw.Stars, s) {
+					m.MatchedStars = append(m.MatchedStars, s)
+				}
+			}
+			// Prize tiers are only known for EuroMillions.
+			if tier := findPrizeTier(len(m.MatchedNumbers), len(m.MatchedStars)); g == defaultGame && tier != nil {
+				m.Tier, m.Prize = fmt.Sprintf("%d+%d", tier.numbers, tier.stars), tier.prize
+				result.Wins++
+				result.Winnings += tier.prize
+				batch.Totals.Tiers[m.Tier]++
+			}
+			result.Draws[j] = m
+		}
+		batch.Totals.Wins += result.Wins
+		batch.Totals.Winnings += result.Winnings
+		batch.Lines[i] = result
+	}
+
+	sendValue(w, r, batch, func(buf *bytes.Buffer) {
+		for _, line := range batch.Lines {
+			fmt.Fprintf(buf, "Line %d: %s + %s\n", line.Line, joinInts(line.Numbers), joinInts(line.Stars))
+			for _, m := range line.Draws {
+				fmt.Fprintf(buf, "  %s: %d+%d", m.Date, len(m.MatchedNumbers), len(m.MatchedStars))
+				if m.Tier != "" {
+					fmt.Fprintf(buf, " (%.2f EUR)", m.Prize)
+				}
+				buf.WriteString("\n")
+			}
+		}
+		fmt.Fprintf(buf, "Lines: %d, Draws: %d, Wins: %d, Winnings: %.2f EUR", batch.Totals.Lines, batch.Totals.Draws, batch.Totals.Wins, batch.Totals.Winnings)
+		if batch.PrizeSource == "average" {
+			buf.WriteString(" (average tier prizes)")
+		}
+		buf.WriteString("\n")
+	})
+}
+
+// prizeTier is a EuroMillions prize tier with its average prize in euros.
+type prizeTier struct {
+	numbers int
+	stars   int
+	prize   float64
+}
+
+// prizeTiers are the 13 EuroMillions prize tiers, best first. The amounts are
+// approximate long-run averages per winning line (the 5+2 amount is an average jackpot).
+var prizeTiers = []prizeTier{
+	{5, 2, 50000000}, {5, 1, 300000}, {5, 0, 60000},
+	{4, 2, 3000}, {4, 1, 170}, {3, 2, 80}, {4, 0, 60},
+	{2, 2, 17}, {3, 1, 13}, {3, 0, 11}, {1, 2, 9}, {2, 1, 7}, {2, 0, 4.5},
+}
+
+// linePrice is the price of one EuroMillions line in euros.
+const linePrice = 2.50
+
+// findPrizeTier returns the tier for a number of matched numbers and stars, or nil.
+func findPrizeTier(numbers, stars int) *prizeTier {
+	for i := range prizeTiers {
+		if prizeTiers[i].numbers == numbers && prizeTiers[i].stars == stars {
+			return &prizeTiers[i]
+		}
+	}
+	return nil
+}
+
+// tierProbability returns the exact probability of matching the given numbers and
+// stars with one line of the game.
+func tierProbability(g *Game, numbers, stars int) float64 {
+	pn := float64(binomial(g.Numbers, numbers)*binomial(g.MaxNumber-g.Numbers, g.Numbers-numbers)) / float64(binomial(g.MaxNumber, g.Numbers))
+	ps := float64(binomial(g.Stars, stars)*binomial(g.MaxStar-g.Stars, g.Stars-stars)) / float64(binomial(g.MaxStar, g.Stars))
+	return pn * ps
+}
+
+// Probability is the response of /stats/probability: the exact chance of
+// matching Numbers numbers and Stars stars with one line, as the reduced
+// fraction Outcomes/Combinations, as a probability and as "1 in Odds".
+type Probability struct {
+	XMLName      xml.Name `json:"-" xml:"probability"`
+	Game         string   `json:"game" xml:"game,attr"`
+	Numbers      int      `json:"numbers" xml:"numbers,attr"`
+	Stars        int      `json:"stars" xml:"stars,attr"`
+	Outcomes     int      `json:"outcomes" xml:"outcomes"`
+	Combinations int      `json:"combinations" xml:"combinations"`
+	Probability  float64  `json:"probability" xml:"probability"`
+	Odds         float64  `json:"odds" xml:"odds"`
+}
+
+// probabilityHandler computes the odds of matching exactly ?numbers= numbers
+// and ?stars= stars (all of them, the jackpot, by default) with one line.
+func probabilityHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /stats/probability from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	param := func(name string, def, max int) (int, bool) {
+		value := query.Get(name)
+		if value == "" {
+			return def, true
+		}
+		n, err := strconv.Atoi(value)
+		return n, err == nil && n >= 0 && n <= max
+	}
+	numbers, ok1 := param("numbers", g.Numbers, g.Numbers)
+	stars, ok2 := param("stars", g.Stars, g.Stars)
+	if !ok1 || !ok2 {
+		http.Error(w, tr(r, "invalid_matches", g.Numbers, g.Stars), http.StatusBadRequest)
+		return
+	}
+
+	outcomes := binomial(g.Numbers, numbers) * binomial(g.MaxNumber-g.Numbers, g.Numbers-numbers) *
+		binomial(g.Stars, stars) * binomial(g.MaxStar-g.Stars, g.Stars-stars)
+	combinations := binomial(g.MaxNumber, g.Numbers) * binomial(g.MaxStar, g.Stars)
+	p := Probability{Game: g.ID, Numbers: numbers, Stars: stars}
+	if d := int(new(big.Int).GCD(nil, nil, big.NewInt(int64(outcomes)), big.NewInt(int64(combinations))).Int64()); d > 0 {
+		p.Outcomes, p.Combinations = outcomes/d, combinations/d
+	}
+	p.Probability = tierProbability(g, numbers, stars)
+	if outcomes > 0 {
+		p.Odds = math.Round(float64(combinations)/float64(outcomes)*100) / 100
+	}
+
+	sendValue(w, r, p, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "%d+%d: %d/%d, 1 in %.2f\n", p.Numbers, p.Stars, p.Outcomes, p.Combinations, p.Odds)
+	})
+}
+
+// SimulationTier reports one prize tier of a simulation.
+type SimulationTier struct {
+	Tier         string  `json:"tier" xml:"name,attr"`
+	Odds         float64 `json:"odds" xml:"odds"`
+	AveragePrize float64 `json:"averagePrize" xml:"averagePrize"`
+	Hits         int     `json:"hits" xml:"hits"`
+}
+
+// Percentile is a point of the distribution of winnings across trials.
+type Percentile struct {
+	Percentile int     `json:"percentile" xml:"p,attr"`
+	Winnings   float64 `json:"winnings" xml:",chardata"`
+}
+
+// Simulation is the outcome of a Monte Carlo simulation of playing random lines.
+type Simulation struct {
+	XMLName          xml.Name         `json:"-" xml:"simulation"`
+	Lines            int              `json:"lines" xml:"lines,attr"`
+	Draws            int              `json:"draws" xml:"draws,attr"`
+	Trials           int              `json:"trials" xml:"trials,attr"`
+	Seed             uint64           `json:"seed" xml:"seed,attr"`
+	Currency         string           `json:"currency" xml:"currency"`
+	Cost             float64          `json:"cost" xml:"cost"`
+	ExpectedWinnings float64          `json:"expectedWinnings" xml:"expectedWinnings"`
+	ExpectedReturn   float64          `json:"expectedReturn" xml:"expectedReturn"`
+	MeanWinnings     float64          `json:"meanWinnings" xml:"meanWinnings"`
+	Percentiles      []Percentile     `json:"percentiles" xml:"percentiles>winnings"`
+	Tiers            []SimulationTier `json:"tiers" xml:"tiers>tier"`
+}
+
+// maxSimulatedLines bounds lines*draws*trials so a single request stays cheap.
+const maxSimulatedLines = 2000000
+
+// simulateHandler runs a seeded Monte Carlo simulation of playing 'lines' random lines
+// in each of 'draws' draws, repeated 'trials' times. The expected winnings are computed
+// exactly from the tier odds and average prizes; the simulation gives their distribution.
+func simulateHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /stats/simulate from %s", clientIP(r))
+	}
+
+	g := defaultGame
+	query := r.URL.Query()
+	param := func(name string, def int) (int, bool) {
+		value := query.Get(name)
+		if value == "" {
+			return def, true
+		}
+		n, err := strconv.Atoi(value)
+		return n, err == nil && n > 0
+	}
+	lines, ok1 := param("lines", 1)
+	draws, ok2 := param("draws", 104)
+	trials, ok3 := param("trials", 100)
+	// Each factor is bounded before the next is multiplied in, so the product
+	// cannot overflow.
+	if !ok1 || !ok2 || !ok3 || lines > maxSimulatedLines/draws || trials > maxSimulatedLines/(lines*draws) {
+		http.Error(w, tr(r, "invalid_simulation", maxSimulatedLines), http.StatusBadRequest)
+		return
+	}
+
+	seed := rand.Uint64()
+	if value := query.Get("seed"); value != "" {
+		var err error
+		if seed, err = strconv.ParseUint(value, 10, 64); err != nil {
+			http.Error(w, tr(r, "invalid_seed"), http.StatusBadRequest)
+			return
+		}
+	}
+	rng := rand.New(rand.NewPCG(seed, 0))
+
+	sim := Simulation{
+		Lines:    lines,
+		Draws:    draws,
+		Trials:   trials,
+		Seed:     seed,
+		Currency: "EUR",
+		Cost:     float64(lines*draws) * linePrice,
+	}
+
+	var expectedPerLine float64
+	for _, tier := range prizeTiers {
+		p := tierProbability(g, tier.numbers, tier.stars)
+		expectedPerLine += p * tier.prize
+		sim.Tiers = append(sim.Tiers, SimulationTier{
+			Tier:         fmt.Sprintf("%d+%d", tier.numbers, tier.stars),
+			Odds:         math.Round(1 / p),
+			AveragePrize: tier.prize,
+		})
+	}
+	sim.ExpectedWinnings = expectedPerLine * float64(lines*draws)
+	sim.ExpectedReturn = expectedPerLine / linePrice
+
+	// A random line against a random draw: only the number of matches matters,
+	// so the drawn balls are fixed to the lowest ones and the line is drawn at random.
+	numberPool := make([]int, g.MaxNumber)
+	starPool := make([]int, g.MaxStar)
+	totals := make([]float64, trials)
+	for t := 0; t < trials; t++ {
+		for i := 0; i < lines*draws; i++ {
+			numbers := sampleMatches(rng, numberPool, g.Numbers)
+			stars := sampleMatches(rng, starPool, g.Stars)
+			for k, tier := range prizeTiers {
+				if tier.numbers == numbers && tier.stars == stars {
+					totals[t] += tier.prize
+					sim.Tiers[k].Hits++
+					break
+				}
+			}
+		}
+	}
+
+	sort.Float64s(totals)
+	var sum float64
+	for _, total := range totals {
+		sum += total
+	}
+	sim.MeanWinnings = sum / float64(trials)
+	for _, p := range []int{5, 25, 50, 75, 95} {
+		idx := (p*trials + 99) / 100
+		sim.Percentiles = append(sim.Percentiles, Percentile{Percentile: p, Winnings: totals[max(idx-1, 0)]})
+	}
+
+	sendValue(w, r, sim, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "Lines: %d, Draws: %d, Trials: %d, Seed: %d\n", sim.Lines, sim.Draws, sim.Trials, sim.Seed)
+		fmt.Fprintf(buf, "Cost: %.2f %s, Expected winnings: %.2f %s, Expected return: %.1f%%\n", sim.Cost, sim.Currency, sim.ExpectedWinnings, sim.Currency, sim.ExpectedReturn*100)
+		fmt.Fprintf(buf, "Mean simulated winnings: %.2f %s\n", sim.MeanWinnings, sim.Currency)
+		for _, p := range sim.Percentiles {
+			fmt.Fprintf(buf, "P%d: %.2f %s\n", p.Percentile, p.Winnings, sim.Currency)
+		}
+		for _, tier := range sim.Tiers {
+			fmt.Fprintf(buf, "Tier %s: 1 in %.0f, Average prize: %.2f %s, Hits: %d\n", tier.Tier, tier.Odds, tier.AveragePrize, sim.Currency, tier.Hits)
+		}
+	})
+}
+
+// sampleMatches draws k distinct balls from pool (reused between calls) with a
+// partial Fisher-Yates shuffle and returns how many of them are among the k lowest.
+func sampleMatches(rng *rand.Rand, pool []int, k int) int {
+	for i := range pool {
+		pool[i] = i
+	}
+	matches := 0
+	for i := 0; i < k; i++ {
+		j := i + rng.IntN(len(pool)-i)
+		pool[i], pool[j] = pool[j], pool[i]
+		if pool[i] < k {
+			matches++
+		}
+	}
+	return matches
+}
+
+// exclusions are the generator's 'exclude' options.
+type exclusions struct {
+	pastWinners bool
+	numbers     []int
+	stars       []int
+}
+
+// parseExclusions parses the values of the 'exclude' query parameter:
+// "past-winners", "numbers:13,7" and "stars:1,2". Several values may be given.
+func parseExclusions(values []string) (exclusions, error) {
+	var ex exclusions
+	for _, value := range values {
+		switch kind, list, _ := strings.Cut(value, ":"); kind {
+		case "past-winners":
+			ex.pastWinners = true
+		case "numbers":
+			numbers, err := parseIntList(list)
+			if err != nil {
+				return ex, err
+			}
+			ex.numbers = append(ex.numbers, numbers...)
+		case "stars":
+			stars, err := parseIntList(list)
+			if err != nil {
+				return ex, err
+			}
+			ex.stars = append(ex.stars, stars...)
+		default:
+			return ex, fmt.Errorf("unknown exclusion: %q", value)
+		}
+	}
+	return ex, nil
+}
+
+// withoutBalls returns balls without the excluded ones.
+func withoutBalls(balls, excluded []int) []int {
+	var kept []int
+	for _, b := range balls {
+		if !slices.Contains(excluded, b) {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// comboKey identifies a line by its sorted numbers and stars.
+func comboKey(numbers, stars []int) string {
+	n := slices.Sorted(slices.Values(numbers))
+	s := slices.Sorted(slices.Values(stars))
+	return joinInts(n) + "+" + joinInts(s)
+}
+
+// winnerSet keeps the winning combinations of every past draw in memory, per game.
+// It is loaded on first use and dropped whenever the database changes.
+type winnerSet struct {
+	mu     sync.Mutex
+	combos map[string]map[string]bool
+}
+
+var winners = &winnerSet{combos: make(map[string]map[string]bool)}
+
+// get returns the set of past winning combinations of a game, keyed by comboKey.
+func (ws *winnerSet) get(g *Game) (map[string]bool, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if combos, ok := ws.combos[g.ID]; ok {
+		return combos, nil
+	}
+	results, err := queryResults(g, g.stmts.all)
+	if err != nil {
+		return nil, err
+	}
+	combos := make(map[string]bool, len(results))
+	for _, res := range results {
+		combos[comboKey(res.Numbers, res.Stars)] = true
+	}
+	ws.combos[g.ID] = combos
+	return combos, nil
+}
+
+// invalidate drops the loaded combinations.
+func (ws *winnerSet) invalidate() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.combos = make(map[string]map[string]bool)
+}
+
+// wheelLines greedily picks lines of size k from pool until every t-combination
+// of the pool is contained in at least one line.
+func wheelLines(pool []int, k, t int) [][]int {
+	if len(pool) <= k {
+		return [][]int{pool}
+	}
+
+	mask := func(idx []int) uint32 {
+		var m uint32
+		for _, i := range idx {
+			m |= 1 << i
+		}
+		return m
+	}
+
+	uncovered := make(map[uint32]bool)
+	combinations(len(pool), t, func(idx []int) {
+		uncovered[mask(idx)] = true
+	})
+
+	// Every candidate line with the t-combinations it covers.
+	type candidate struct {
+		line    uint32
+		targets []uint32
+	}
+	var candidates []candidate
+	combinations(len(pool), k, func(idx []int) {
+		c := candidate{line: mask(idx)}
+		combinations(k, t, func(sub []int) {
+			var m uint32
+			for _, i := range sub {
+				m |= 1 << idx[i]
+			}
+			c.targets = append(c.targets, m)
+		})
+		candidates = append(candidates, c)
+	})
+
+	var lines [][]int
+	for len(uncovered) > 0 {
+		best, bestCount := 0, -1
+		for i, c := range candidates {
+			count := 0
+			for _, m := range c.targets {
+				if uncovered[m] {
+					count++
+				}
+			}
+			if count > bestCount {
+				best, bestCount = i, count
+			}
+		}
+		for _, m := range candidates[best].targets {
+			delete(uncovered, m)
+		}
+
+		var line []int
+		for i, n := range pool {
+			if candidates[best].line&(1<<i) != 0 {
+				line = append(line, n)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// combinations calls fn with every k-combination of the indices 0..n-1, in lexicographic order.
+// The slice passed to fn is reused between calls.
+func combinations(n, k int, fn func(idx []int)) {
+	idx := make([]int, k)
+	var walk func(start, depth int)
+	walk = func(start, depth int) {
+		if depth == k {
+			fn(idx)
+			return
+		}
+		for i := start; i <= n-(k-depth); i++ {
+			idx[depth] = i
+			walk(i+1, depth+1)
+		}
+	}
+	walk(0, 0)
+}
+
+// binomial returns the number of k-combinations of n elements.
+func binomial(n, k int) int {
+	if k < 0 || k > n {
+		return 0
+	}
+	result := 1
+	for i := 1; i <= k; i++ {
+		result = result * (n - k + i) / i
+	}
+	return result
+}
+
+// parseIntList parses a comma-separated list of integers.
+func parseIntList(value string) ([]int, error) {
+	if value == "" {
+		return nil, fmt.Errorf("empty list")
+	}
+	var list []int
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// validBalls reports whether all balls are distinct and between 1 and maxBall.
+func validBalls(balls []int, maxBall int) bool {
+	seen := make(map[int]bool)
+	for _, b := range balls {
+		if b < 1 || b > maxBall || seen[b] {
+			return false
+		}
+		seen[b] = true
+	}
+	return true
+}
+
+// joinInts formats numbers as a comma-separated list.
+func joinInts(numbers []int) string {
+	parts := make([]string, len(numbers))
+	for i, n := range numbers {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ",")
+}
+
+// defaultPerPage is the page size used when only 'page' is given.
+const defaultPerPage = 50
+
+// paginate applies the optional 'page' and 'per_page' query parameters to a list
+// and sets the X-Total-Count header. When paginating, RFC 5988 Link headers point
+// to the first, previous, next and last pages. Without either parameter the whole
+// list is returned. It answers 400 and returns false for invalid parameters.
+func paginate(w http.ResponseWriter, r *http.Request, results []Result) ([]Result, bool) {
+	total := len(results)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	if !paginated(r) {
+		return results, true
+	}
+
+	query := r.URL.Query()
+	page, perPage := 1, defaultPerPage
+	var err error
+	if value := query.Get("page"); value != "" {
+		if page, err = strconv.Atoi(value); err != nil || page < 1 {
+			http.Error(w, tr(r, "invalid_page"), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	if value := query.Get("per_page"); value != "" {
+		if perPage, err = strconv.Atoi(value); err != nil || perPage < 1 || perPage > 1000 {
+			http.Error(w, tr(r, "invalid_page"), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	lastPage := max((total+perPage-1)/perPage, 1)
+	link := func(p int, rel string) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(p))
+		q.Set("per_page", strconv.Itoa(perPage))
+		return fmt.Sprintf("<%s?%s>; rel=\"%s\"", appURL(r.URL.Path), q.Encode(), rel)
+	}
+	links := []string{link(1, "first")}
+	if page > 1 {
+		links = append(links, link(min(page-1, lastPage), "prev"))
+	}
+	if page < lastPage {
+		links = append(links, link(page+1, "next"))
+	}
+	links = append(links, link(lastPage, "last"))
+	w.Header().Set("Link", strings.Join(links, ", "))
+
+	start := (page - 1) * perPage
+	if start >= total {
+		return nil, true
+	}
+	return results[start:min(start+perPage, total)], true
+}
+
+// paginated reports whether a list is requested by pages. A page is always
+// sent as a list, even when it holds a single result.
+func paginated(r *http.Request) bool {
+	query := r.URL.Query()
+	return query.Get("page") != "" || query.Get("per_page") != ""
+}
+
+// filterSpecial applies the optional 'special' query parameter,
+// keeping only special draws (special=true) or only regular draws (special=false).
+func filterSpecial(r *http.Request, results []Result) ([]Result, error) {
+	value := r.URL.Query().Get("special")
+	if value == "" {
+		return results, nil
+	}
+	special, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []Result
+	for _, res := range results {
+		if res.Special == special {
+			filtered = append(filtered, res)
+		}
+	}
+	return filtered, nil
+}
+
+// calendarHandler serves a month-by-month summary (draw count and dates) of a year.
+func calendarHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /results/calendar/ from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	year := r.PathValue("year")
+	t, err := time.Parse("2006", year)
+	if err != nil {
+		http.Error(w, tr(r, "invalid_year"), http.StatusBadRequest)
+		return
+	}
+
+	results, err := queryResults(g, g.stmts.byYear, t.Year())
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching calendar for year (%s): %v", year, err)
+		return
+	}
+
+	if len(results) == 0 {
+		http.Error(w, tr(r, "no_results_year", year), http.StatusNotFound)
+		return
+	}
+
+	calendar := Calendar{Year: t.Year(), Draws: len(results)}
+	for m := 1; m <= 12; m++ {
+		calendar.Months = append(calendar.Months, CalendarMonth{
+			Month: fmt.Sprintf("%s-%02d", year, m),
+			Dates: []string{},
+		})
+	}
+	// Results are ordered newest first; walk them backwards so dates are ascending.
+	for i := len(results) - 1; i >= 0; i-- {
+		date, err := time.Parse("2006-01-02", results[i].Date)
+		if err != nil {
+			continue
+		}
+		month := &calendar.Months[date.Month()-1]
+		month.Draws++
+		month.Dates = append(month.Dates, results[i].Date)
+	}
+
+	sendValue(w, r, calendar, func(buf *bytes.Buffer) {
+		for _, month := range calendar.Months {
+			fmt.Fprintf(buf, "%s: %s, %s: %d, %s: %s\n", tr(r, "label_month"), month.Month, tr(r, "label_draws"), month.Draws, tr(r, "label_dates"), strings.Join(month.Dates, ","))
+		}
+	})
+}
+
+// BallStat is how often a ball was drawn.
+type BallStat struct {
+	Ball     int    `json:"ball" xml:"ball,attr"`
+	Draws    int    `json:"draws" xml:"draws,attr"`
+	LastSeen string `json:"last_seen,omitempty" xml:"last_seen,attr,omitempty"`
+}
+
+// PairStat is how often two numbers were drawn together.
+type PairStat struct {
+	First  int `json:"first" xml:"first,attr"`
+	Second int `json:"second" xml:"second,attr"`
+	Draws  int `json:"draws" xml:"draws,attr"`
+}
+
+// NumberStats is the response of /stats/numbers.
+type NumberStats struct {
+	XMLName xml.Name   `json:"-" xml:"stats"`
+	Game    string     `json:"game" xml:"game,attr"`
+	Draws   int        `json:"draws" xml:"draws,attr"`
+	Numbers []BallStat `json:"numbers" xml:"numbers>number"`
+	Stars   []BallStat `json:"stars" xml:"stars>star"`
+	Pairs   []PairStat `json:"pairs" xml:"pairs>pair"`
+}
+
+// numberStatsHandler serves the ball and pair statistics of a game. They are
+// read from the stats tables, which the updater keeps up to date on every
+// insert, instead of being recomputed over the whole history.
+func numberStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /stats/numbers from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	pairs := 10
+	if value := r.URL.Query().Get("pairs"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, tr(r, "invalid_pairs"), http.StatusBadRequest)
+			return
+		}
+		pairs = n
+	}
+
+	stats, err := readNumberStats(g, pairs)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching number statistics: %v", err)
+		return
+	}
+
+	sendValue(w, r, stats, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "Draws: %d\n", stats.Draws)
+		for _, s := range stats.Numbers {
+			fmt.Fprintf(buf, "Number %d: %d draws, last %s\n", s.Ball, s.Draws, s.LastSeen)
+		}
+		for _, s := range stats.Stars {
+			fmt.Fprintf(buf, "Star %d: %d draws, last %s\n", s.Ball, s.Draws, s.LastSeen)
+		}
+		for _, p := range stats.Pairs {
+			fmt.Fprintf(buf, "Pair %d-%d: %d draws\n", p.First, p.Second, p.Draws)
+		}
+	})
+}
+
+// readNumberStats reads the statistics of g with its top pairs. Every ball is
+// listed, including those never drawn.
+func readNumberStats(g *Game, pairs int) (NumberStats, error) {
+	stats := NumberStats{
+		Game:    g.ID,
+		Numbers: make([]BallStat, g.MaxNumber),
+		Stars:   make([]BallStat, g.MaxStar),
+		Pairs:   []PairStat{},
+	}
+	for i := range stats.Numbers {
+		stats.Numbers[i].Ball = i + 1
+	}
+	for i := range stats.Stars {
+		stats.Stars[i].Ball = i + 1
+	}
+
+	err := retryBusy(func() error {
+		if err := g.stmts.count.QueryRow().Scan(&stats.Draws); err != nil {
+			return err
+		}
+
+		rows, err := g.stmts.ballStats.Query(g.ID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var kind string
+			var s BallStat
+			if err := rows.Scan(&kind, &s.Ball, &s.Draws, &s.LastSeen); err != nil {
+				return err
+			}
+			list := stats.Numbers
+			if kind == "star" {
+				list = stats.Stars
+			}
+			if s.Ball >= 1 && s.Ball <= len(list) {
+				list[s.Ball-1] = s
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		stats.Pairs = stats.Pairs[:0]
+		pairRows, err := g.stmts.pairStats.Query(g.ID, pairs)
+		if err != nil {
+			return err
+		}
+		defer pairRows.Close()
+		for pairRows.Next() {
+			var p PairStat
+			if err := pairRows.Scan(&p.First, &p.Second, &p.Draws); err != nil {
+				return err
+			}
+			stats.Pairs = append(stats.Pairs, p)
+		}
+		return pairRows.Err()
+	})
+	return stats, err
+}
+
+// StatsSummary is the response of /stats: what a dashboard widget shows, in
+// one call.
+type StatsSummary struct {
+	XMLName     xml.Name   `json:"-" xml:"summary"`
+	Game        string     `json:"game" xml:"game,attr"`
+	Draws       int        `json:"draws" xml:"draws,attr"`
+	Latest      *Result    `json:"latest" xml:"latest,omitempty"`
+	HotNumbers  []BallStat `json:"hot_numbers" xml:"hot_numbers>number"`
+	ColdNumbers []BallStat `json:"cold_numbers" xml:"cold_numbers>number"`
+	HotStars    []BallStat `json:"hot_stars" xml:"hot_stars>star"`
+	ColdStars   []BallStat `json:"cold_stars" xml:"cold_stars>star"`
+	Updated     string     `json:"updated" xml:"updated,attr"`
+}
+
+// statsSummaryHandler serves the digest of a game: its number of draws, the
+// latest one, the numbers and stars drawn the most (hot) and the least (cold)
+// often, all of them when tied, and when the dataset last changed. The
+// frequencies come from the stats tables, like /stats/numbers.
+func statsSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /stats from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := readNumberStats(g, 0)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching number statistics: %v", err)
+		return
+	}
+	v, err := revision.get()
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error reading the dataset revision: %v", err)
+		return
+	}
+
+	summary := StatsSummary{Game: g.ID, Draws: stats.Draws, Updated: v.Updated}
+	result, err := latest.get(g)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching latest result: %v", err)
+		return
+	}
+	if err == nil {
+		results := []Result{result}
+		if err := setTimestamps(r, results); err != nil {
+			http.Error(w, tr(r, "invalid_tz"), http.StatusBadRequest)
+			return
+		}
+		summary.Latest = &results[0]
+	}
+	if summary.Draws > 0 {
+		summary.HotNumbers, summary.ColdNumbers = frequencyExtremes(stats.Numbers)
+		summary.HotStars, summary.ColdStars = frequencyExtremes(stats.Stars)
+	} else {
+		summary.HotNumbers, summary.ColdNumbers = []BallStat{}, []BallStat{}
+		summary.HotStars, summary.ColdStars = []BallStat{}, []BallStat{}
+	}
+
+	sendValue(w, r, summary, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "%s: %d, Updated: %s\n", tr(r, "label_draws"), summary.Draws, summary.Updated)
+		if summary.Latest != nil {
+			fmt.Fprintf(buf, "Latest: %s, %s: %s, %s: %s\n", plaintextDate(r, summary.Latest.Date), tr(r, "label_numbers"), joinInts(summary.Latest.Numbers), tr(r, "label_stars"), joinInts(summary.Latest.Stars))
+		}
+		for _, line := range []struct {
+			label string
+			balls []BallStat
+		}{
+			{"Hot numbers", summary.HotNumbers},
+			{"Cold numbers", summary.ColdNumbers},
+			{"Hot stars", summary.HotStars},
+			{"Cold stars", summary.ColdStars},
+		} {
+			fmt.Fprintf(buf, "%s:", line.label)
+			for _, s := range line.balls {
+				fmt.Fprintf(buf, " %d (%d)", s.Ball, s.Draws)
+			}
+			buf.WriteString("\n")
+		}
+	})
+}
+
+// YearStats is the response of /stats/year/{year}: a year in review.
+type YearStats struct {
+	XMLName        xml.Name   `json:"-" xml:"year"`
+	Game           string     `json:"game" xml:"game,attr"`
+	Year           int        `json:"year" xml:"year,attr"`
+	Draws          int        `json:"draws" xml:"draws,attr"`
+	SpecialDraws   int        `json:"special_draws" xml:"special_draws,attr"`
+	FirstDraw      string     `json:"first_draw" xml:"first_draw,attr"`
+	LastDraw       string     `json:"last_draw" xml:"last_draw,attr"`
+	MostNumbers    []BallStat `json:"most_frequent_numbers" xml:"most_frequent_numbers>number"`
+	LeastNumbers   []BallStat `json:"least_frequent_numbers" xml:"least_frequent_numbers>number"`
+	MostStars      []BallStat `json:"most_frequent_stars" xml:"most_frequent_stars>star"`
+	LeastStars     []BallStat `json:"least_frequent_stars" xml:"least_frequent_stars>star"`
+	AverageSum     float64    `json:"average_sum" xml:"average_sum"`
+	AverageStarSum float64    `json:"average_star_sum" xml:"average_star_sum"`
+}
+
+// yearStatsHandler summarizes the draws of a year: how many there were, the
+// most and least frequent numbers and stars (all of them when tied, LastSeen
+// being the last draw of the year they appeared in) and the average sum of
+// the numbers and of the stars of a draw.
+func yearStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /stats/year/ from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	year := r.PathValue("year")
+	t, err := time.Parse("2006", year)
+	if err != nil {
+		http.Error(w, tr(r, "invalid_year"), http.StatusBadRequest)
+		return
+	}
+
+	results, err := queryResults(g, g.stmts.byYear, t.Year())
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching results by year (%s): %v", year, err)
+		return
+	}
+	if len(results) == 0 {
+		http.Error(w, tr(r, "no_results_year", year), http.StatusNotFound)
+		return
+	}
+
+	stats := YearStats{Game: g.ID, Year: t.Year(), Draws: len(results)}
+	numbers := make([]BallStat, g.MaxNumber)
+	stars := make([]BallStat, g.MaxStar)
+	for i := range numbers {
+		numbers[i].Ball = i + 1
+	}
+	for i := range stars {
+		stars[i].Ball = i + 1
+	}
+	numberSum, starSum := 0, 0
+	for _, res := range results {
+		if res.Special {
+			stats.SpecialDraws++
+		}
+		if stats.FirstDraw == "" || res.Date < stats.FirstDraw {
+			stats.FirstDraw = res.Date
+		}
+		stats.LastDraw = max(stats.LastDraw, res.Date)
+		for _, n := range res.Numbers {
+			numberSum += n
+			if n >= 1 && n <= len(numbers) {
+				numbers[n-1].Draws++
+				numbers[n-1].LastSeen = max(numbers[n-1].LastSeen, res.Date)
+			}
+		}
+		for _, s := range res.Stars {
+			starSum += s
+			if s >= 1 && s <= len(stars) {
+				stars[s-1].Draws++
+				stars[s-1].LastSeen = max(stars[s-1].LastSeen, res.Date)
+			}
+		}
+	}
+	stats.AverageSum = math.Round(float64(numberSum)/float64(len(results))*100) / 100
+	stats.AverageStarSum = math.Round(float64(starSum)/float64(len(results))*100) / 100
+	stats.MostNumbers, stats.LeastNumbers = frequencyExtremes(numbers)
+	stats.MostStars, stats.LeastStars = frequencyExtremes(stars)
+
+	sendValue(w, r, stats, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "Year: %d, %s: %d (special: %d), %s - %s\n", stats.Year, tr(r, "label_draws"), stats.Draws, stats.SpecialDraws, stats.FirstDraw, stats.LastDraw)
+		for _, line := range []struct {
+			label string
+			balls []BallStat
+		}{
+			{"Most frequent numbers", stats.MostNumbers},
+			{"Least frequent numbers", stats.LeastNumbers},
+			{"Most frequent stars", stats.MostStars},
+			{"Least frequent stars", stats.LeastStars},
+		} {
+			fmt.Fprintf(buf, "%s:", line.label)
+			for _, s := range line.balls {
+				fmt.Fprintf(buf, " %d (%d)", s.Ball, s.Draws)
+			}
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "Average sum: %.2f, average star sum: %.2f\n", stats.AverageSum, stats.AverageStarSum)
+	})
+}
+
+// HeatmapRow is how many times a ball was drawn in each period of a heatmap.
+type HeatmapRow struct {
+	Ball   int   `json:"ball" xml:"ball,attr"`
+	Counts []int `json:"counts" xml:"count"`
+}
+
+// Heatmap is the response of /stats/heatmap: a ball x period matrix of
+// appearances. Counts[i] of every row is for Periods[i].
+type Heatmap struct {
+	XMLName     xml.Name     `json:"-" xml:"heatmap"`
+	Game        string       `json:"game" xml:"game,attr"`
+	Granularity string       `json:"granularity" xml:"granularity,attr"`
+	Periods     []string     `json:"periods" xml:"periods>period"`
+	Numbers     []HeatmapRow `json:"numbers" xml:"numbers>row"`
+	Stars       []HeatmapRow `json:"stars" xml:"stars>row"`
+}
+
+// heatmapHandler counts the appearances of every number and star per
+// ?granularity= month (the default) or year, over all draws or the draws of
+// a ?year=. Every period from the first draw to the last is listed, so the
+// matrix has no gaps.
+func heatmapHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /stats/heatmap from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	granularity := strings.ToLower(query.Get("granularity"))
+	var periodLen int
+	switch granularity {
+	case "", "month":
+		granularity, periodLen = "month", len("2006-01")
+	case "year":
+		periodLen = len("2006")
+	default:
+		http.Error(w, tr(r, "invalid_granularity"), http.StatusBadRequest)
+		return
+	}
+
+	var results []Result
+	var err error
+	if year := query.Get("year"); year != "" {
+		t, perr := time.Parse("2006", year)
+		if perr != nil {
+			http.Error(w, tr(r, "invalid_year"), http.StatusBadRequest)
+			return
+		}
+		results, err = queryResults(g, g.stmts.byYear, t.Year())
+	} else {
+		results, err = queryResults(g, g.stmts.all)
+	}
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching results for the heatmap: %v", err)
+		return
+	}
+	if len(results) == 0 {
+		http.Error(w, tr(r, "no_results"), http.StatusNotFound)
+		return
+	}
+
+	heatmap := Heatmap{Game: g.ID, Granularity: granularity}
+	first, last := results[0].Date, results[0].Date
+	for _, res := range results {
+		first, last = min(first, res.Date), max(last, res.Date)
+	}
+	index := make(map[string]int)
+	for p := first[:periodLen]; p <= last[:periodLen]; p = nextPeriod(p) {
+		index[p] = len(heatmap.Periods)
+		heatmap.Periods = append(heatmap.Periods, p)
+	}
+	heatmap.Numbers = heatmapRows(g.MaxNumber, len(heatmap.Periods))
+	heatmap.Stars = heatmapRows(g.MaxStar, len(heatmap.Periods))
+	for _, res := range results {
+		i := index[res.Date[:periodLen]]
+		for _, n := range res.Numbers {
+			if n >= 1 && n <= len(heatmap.Numbers) {
+				heatmap.Numbers[n-1].Counts[i]++
+			}
+		}
+		for _, s := range res.Stars {
+			if s >= 1 && s <= len(heatmap.Stars) {
+				heatmap.Stars[s-1].Counts[i]++
+			}
+		}
+	}
+
+	sendValue(w, r, heatmap, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "ball,%s\n", strings.Join(heatmap.Periods, ","))
+		for _, rows := range []struct {
+			prefix string
+			rows   []HeatmapRow
+		}{{"", heatmap.Numbers}, {"star ", heatmap.Stars}} {
+			for _, row := range rows.rows {
+				fmt.Fprintf(buf, "%s%d", rows.prefix, row.Ball)
+				for _, c := range row.Counts {
+					fmt.Fprintf(buf, ",%d", c)
+				}
+				buf.WriteString("\n")
+			}
+		}
+	})
+}
+
+// nextPeriod returns the period after a YYYY or YYYY-MM period.
+func nextPeriod(p string) string {
+	if len(p) == len("2006") {
+		year, _ := strconv.Atoi(p)
+		return strconv.Itoa(year + 1)
+	}
+	t, _ := time.Parse("2006-01", p)
+	return t.AddDate(0, 1, 0).Format("2006-01")
+}
+
+// RepeatCount is how many draws repeated exactly Repeats balls of the
+// preceding draw, and the share of draws expected to by chance.
+type RepeatCount struct {
+	Repeats  int     `json:"repeats" xml:"repeats,attr"`
+	Draws    int     `json:"draws" xml:"draws,attr"`
+	Percent  float64 `json:"percent" xml:"percent,attr"`
+	Expected float64 `json:"expected_percent" xml:"expected_percent,attr"`
+}
+
+// RepeatStats is the response of /stats/repeats. Pairs is the number of
+// consecutive draw pairs looked at, one less than the number of draws.
+type RepeatStats struct {
+	XMLName          xml.Name      `json:"-" xml:"repeats"`
+	Game             string        `json:"game" xml:"game,attr"`
+	Pairs            int           `json:"pairs" xml:"pairs,attr"`
+	NumberRepeats    int           `json:"number_repeats" xml:"number_repeats"`
+	NumberPercent    float64       `json:"number_percent" xml:"number_percent"`
+	LastNumberRepeat string        `json:"last_number_repeat,omitempty" xml:"last_number_repeat,omitempty"`
+	Numbers          []RepeatCount `json:"numbers" xml:"numbers>count"`
+	StarRepeats      int           `json:"star_repeats" xml:"star_repeats"`
+	StarPercent      float64       `json:"star_percent" xml:"star_percent"`
+	LastStarRepeat   string        `json:"last_star_repeat,omitempty" xml:"last_star_repeat,omitempty"`
+	Stars            []RepeatCount `json:"stars" xml:"stars>count"`
+}
+
+// repeatsHandler reports how often a draw repeats at least one number (and
+// star) of the draw just before it, with the distribution of the number of
+// repeated balls next to the one expected from the current ball pools.
+func repeatsHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("GET request for /stats/repeats from %s", clientIP(r))
+	}
+
+	g, ok := requestGame(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := queryResults(g, g.stmts.all)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching results for the repeat statistics: %v", err)
+		return
+	}
+	if len(results) < 2 {
+		http.Error(w, tr(r, "no_results"), http.StatusNotFound)
+		return
+	}
+
+	stats := RepeatStats{Game: g.ID, Pairs: len(results) - 1}
+	numbers := make([]int, g.Numbers+1)
+	stars := make([]int, g.Stars+1)
+	// Newest first: results[i+1] is the draw before results[i].
+	for i, res := range results[:len(results)-1] {
+		prev := results[i+1]
+		n := countShared(res.Numbers, prev.Numbers)
+		s := countShared(res.Stars, prev.Stars)
+		numbers[min(n, g.Numbers)]++
+		stars[min(s, g.Stars)]++
+		if n > 0 {
+			stats.NumberRepeats++
+			stats.LastNumberRepeat = max(stats.LastNumberRepeat, res.Date)
+		}
+		if s > 0 {
+			stats.StarRepeats++
+			stats.LastStarRepeat = max(stats.LastStarRepeat, res.Date)
+		}
+	}
+	stats.NumberPercent = percentOf(stats.NumberRepeats, stats.Pairs)
+	stats.StarPercent = percentOf(stats.StarRepeats, stats.Pairs)
+	stats.Numbers = repeatCounts(numbers, stats.Pairs, g.Numbers, g.MaxNumber)
+	stats.Stars = repeatCounts(stars, stats.Pairs, g.Stars, g.MaxStar)
+
+	sendValue(w, r, stats, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "Draws repeating a number of the previous draw: %d of %d (%.2f%%), last %s\n", stats.NumberRepeats, stats.Pairs, stats.NumberPercent, stats.LastNumberRepeat)
+		for _, c := range stats.Numbers {
+			fmt.Fprintf(buf, "  %d repeated: %d (%.2f%%, expected %.2f%%)\n", c.Repeats, c.Draws, c.Percent, c.Expected)
+		}
+		fmt.Fprintf(buf, "Draws repeating a star of the previous draw: %d of %d (%.2f%%), last %s\n", stats.StarRepeats, stats.Pairs, stats.StarPercent, stats.LastStarRepeat)
+		for _, c := range stats.Stars {
+			fmt.Fprintf(buf, "  %d repeated: %d (%.2f%%, expected %.2f%%)\n", c.Repeats, c.Draws, c.Percent, c.Expected)
+		}
+	})
+}
+
+// countShared returns how many of the balls of a are also in b.
+func countShared(a, b []int) int {
+	n := 0
+	for _, x := range a {
+		if slices.Contains(b, x) {
+			n++
+		}
+	}
+	return n
+}
+
+// percentOf returns n as a percentage of total, rounded to two decimals.
+func percentOf(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(n)/float64(total)*10000) / 100
+}
+
+// repeatCounts turns the number of draws per number of repeated balls into
+// a distribution. The expected share of k repeats when picks balls out of
+// pool are drawn is the hypergeometric C(picks, k) C(pool-picks, picks-k) /
+// C(pool, picks).
+func repeatCounts(draws []int, pairs, picks, pool int) []RepeatCount {
+	counts := make([]RepeatCount, len(draws))
+	for k, n := range draws {
+		expected := float64(binomial(picks, k)*binomial(pool-picks, picks-k)) / float64(binomial(pool, picks))
+		counts[k] = RepeatCount{Repeats: k, Draws: n, Percent: percentOf(n, pairs), Expected: math.Round(expected*10000) / 100}
+	}
+	return counts
+}
+
+// heatmapRows returns the zeroed rows of balls 1 to n.
+func heatmapRows(n, periods int) []HeatmapRow {
+	rows := make([]HeatmapRow, n)
+	for i := range rows {
+		rows[i] = HeatmapRow{Ball: i + 1, Counts: make([]int, periods)}
+	}
+	return rows
+}
+
+// frequencyExtremes returns the balls drawn the most and the least often,
+// every one of them when several are tied.
+func frequencyExtremes(balls []BallStat) (most, least []BallStat) {
+	most, least = []BallStat{}, []BallStat{}
+	if len(balls) == 0 {
+		return most, least
+	}
+	high, low := balls[0].Draws, balls[0].Draws
+	for _, s := range balls {
+		high, low = max(high, s.Draws), min(low, s.Draws)
+	}
+	for _, s := range balls {
+		if s.Draws == high {
+			most = append(most, s)
+		}
+		if s.Draws == low {
+			least = append(least, s)
+		}
+	}
+	return most, least
+}
+
+// drawTime returns the timestamp of the draw held on the given YYYY-MM-DD date.
+func drawTime(date string) (time.Time, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, drawLocation)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.Add(drawHour * time.Hour), nil
+}
+
+// setTimestamps fills in the draw timestamps in the time zone selected by the request.
+func setTimestamps(r *http.Request, results []Result) error {
+	loc, err := requestLocation(r)
+	if err != nil {
+		return err
+	}
+	for i := range results {
+		if t, err := drawTime(results[i].Date); err == nil {
+			results[i].Timestamp = t.In(loc).Format(time.RFC3339)
+		}
+	}
+	return nil
+}
+
+// requestLocation returns the time zone selected with the 'tz' query parameter.
+// Timestamps are shown in the draw's own time zone (Europe/Paris) by default.
+func requestLocation(r *http.Request) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return drawLocation, nil
+	}
+	return time.LoadLocation(tz)
+}
+
+// sendValue writes any value in the requested format.
+// JSON and XML use the value's struct tags; plaintext is rendered by writePlain.
+func sendValue(w http.ResponseWriter, r *http.Request, v any, writePlain func(buf *bytes.Buffer)) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	var contentType string
+
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "xml":
+		contentType = "application/xml"
+		if err := xml.NewEncoder(buf).Encode(v); err != nil {
+			http.Error(w, tr(r, "encode_error"), http.StatusInternalServerError)
+			log.Printf("Error encoding XML response: %v", err)
+			return
+		}
+	case "plaintext":
+		contentType = "text/plain"
+		writePlain(buf)
+	case "ndjson":
+		contentType = ndjsonContentType
+		if err := writeNDJSON(buf, v); err != nil {
+			http.Error(w, tr(r, "encode_error"), http.StatusInternalServerError)
+			log.Printf("Error encoding NDJSON response: %v", err)
+			return
+		}
+	case "cbor":
+		contentType = cborContentType
+		if err := writeCBOR(buf, v); err != nil {
+			http.Error(w, tr(r, "encode_error"), http.StatusInternalServerError)
+			log.Printf("Error encoding CBOR response: %v", err)
+			return
+		}
+	default: // Fallback to JSON
+		contentType = "application/json"
+		if err := json.NewEncoder(buf).Encode(v); err != nil {
+			http.Error(w, tr(r, "encode_error"), http.StatusInternalServerError)
+			log.Printf("Error encoding JSON response: %v", err)
+			return
+		}
+	}
+
+	writeBody(w, r, contentType, buf.Bytes())
+}
+
+// sendResponse writes the response in the correct format (XML, Plain Text, or JSON).
+// It prioritizes the 'format' URL query parameter.
+// The body is encoded into a buffer first so Content-Length is always known.
+func sendResponse(w http.ResponseWriter, r *http.Request, results []Result) {
+	format := r.URL.Query().Get("format")
+
+	if err := setTimestamps(r, results); err != nil {
+		http.Error(w, tr(r, "invalid_tz"), http.StatusBadRequest)
+		return
+	}
+	if requestIncludes(r, "notes") {
+		if err := attachNotes(r, results); err != nil {
+			http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+			log.Printf("Error reading notes: %v", err)
+			return
+		}
+	}
+
+	// Without the envelope a single result is sent as a bare object (the original
+	// shape, kept for backward compatibility) and several results as a list.
+	// A page is a list whatever its length.
+	envelope, _ := strconv.ParseBool(r.URL.Query().Get("envelope"))
+	single := len(results) == 1 && !paginated(r)
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	var contentType string
+
+	switch strings.ToLower(format) {
+	case "xml":
+		contentType = "application/xml"
+		var err error
+		form, ok := xmlForm(r)
+		if !ok {
+			http.Error(w, tr(r, "invalid_xml_form"), http.StatusBadRequest)
+			return
+		}
+		if form != "" {
+			err = xml.NewEncoder(buf).Encode(newResultSet(results, form))
+		} else if envelope {
+			err = xml.NewEncoder(buf).Encode(Envelope{Count: len(results), Results: results})
+		} else if single {
+			err = xml.NewEncoder(buf).Encode(results[0])
+		} else {
+			err = xml.NewEncoder(buf).Encode(AllResults{Results: results})
+		}
+		if err != nil {
+			http.Error(w, tr(r, "encode_error"), http.StatusInternalServerError)
+			log.Printf("Error encoding XML response: %v", err)
+			return
+		}
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+		writeResultsCSV(buf, results)
+	case "proto":
+		// Always a DrawList, described by /results.proto.
+		contentType = protoContentType
+		writeResultsProto(buf, results)
+	case "cbor":
+		// The shape of the JSON, envelope included.
+		contentType = cborContentType
+		var err error
+		if envelope {
+			err = writeCBOR(buf, Envelope{Count: len(results), Results: results})
+		} else if single {
+			err = writeCBOR(buf, results[0])
+		} else {
+			err = writeCBOR(buf, results)
+		}
+		if err != nil {
+			http.Error(w, tr(r, "encode_error"), http.StatusInternalServerError)
+			log.Printf("Error encoding CBOR response: %v", err)
+			return
+		}
+	case "ndjson":
+		// One draw per line, with or without the envelope.
+		contentType = ndjsonContentType
+		enc := json.NewEncoder(buf)
+		for _, result := range results {
+			if err := enc.Encode(result); err != nil {
+				http.Error(w, tr(r, "encode_error"), http.StatusInternalServerError)
+				log.Printf("Error encoding NDJSON response: %v", err)
+				return
+			}
+		}
+	case "plaintext":
+		contentType = "text/plain"
+		for _, result := range results {
+			numbers := joinInts(result.Numbers)
+			stars := joinInts(result.Stars)
+			fmt.Fprintf(buf, "%s: %s, %s: %s, %s: %s\n", tr(r, "label_date"), plaintextDate(r, result.Date), tr(r, "label_numbers"), numbers, tr(r, "label_stars"), stars)
+			for _, n := range result.Notes {
+				fmt.Fprintf(buf, "  %s: %s\n", tr(r, "label_note"), n.Text)
+			}
+		}
+	default: // Fallback to JSON
+		contentType = "application/json"
+		var err error
+		if envelope {
+			err = json.NewEncoder(buf).Encode(Envelope{Count: len(results), Results: results})
+		} else if single {
+			err = json.NewEncoder(buf).Encode(results[0])
+		} else {
+			err = json.NewEncoder(buf).Encode(results)
+		}
+		if err != nil {
+			http.Error(w, tr(r, "encode_error"), http.StatusInternalServerError)
+			log.Printf("Error encoding JSON response: %v", err)
+			return
+		}
+	}
+
+	writeBody(w, r, contentType, buf.Bytes())
+}
+
+// bufferPool recycles the response buffers of sendValue and sendResponse.
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns buf to the pool once its bytes have been written. Very
+// large buffers (full listings) are dropped rather than kept alive.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > 1<<20 {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// writeResultsCSV writes results as CSV: a header row, then one row per draw
+// with its date, numbers, stars and special flag.
+func writeResultsCSV(w io.Writer, results []Result) {
+	cw := csv.NewWriter(w)
+	if len(results) > 0 {
+		header := []string{"date"}
+		for i := range results[0].Numbers {
+			header = append(header, fmt.Sprintf("number_%d", i+1))
+		}
+		for i := range results[0].Stars {
+			header = append(header, fmt.Sprintf("star_%d", i+1))
+		}
+		cw.Write(append(header, "special"))
+	}
+	for _, res := range results {
+		row := []string{res.Date}
+		for _, n := range res.Numbers {
+			row = append(row, strconv.Itoa(n))
+		}
+		for _, s := range res.Stars {
+			row = append(row, strconv.Itoa(s))
+		}
+		cw.Write(append(row, strconv.FormatBool(res.Special)))
+	}
+	cw.Flush()
+}
+
+// Parquet physical and converted types, and the Thrift compact protocol types
+// of its metadata (see the parquet-format specification).
+const (
+	parquetBoolean = 0
+	parquetInt32   = 1
+
+	parquetDate = 6  // ConvertedType DATE: days since 1970-01-01
+	parquetInt8 = 15 // ConvertedType INT_8
+
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftByte      = 3
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftStruct    = 12
+)
+
+// parquetColumn is a required column of a Parquet file with its PLAIN-encoded values.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // parquetDate or parquetInt8; unused for booleans
+	values    []byte
+}
+
+// writeParquet writes the draws as a Parquet file: one row group with the date
+// (DATE), the numbers and stars (INT_8) and the special flag (BOOLEAN), one
+// uncompressed PLAIN page per column. pandas, DuckDB and Spark read it as is.
+func writeParquet(w *bytes.Buffer, g *Game, results []Result) error {
+	columns := []*parquetColumn{{name: "date", typ: parquetInt32, converted: parquetDate}}
+	for i := 1; i <= g.Numbers; i++ {
+		columns = append(columns, &parquetColumn{name: fmt.Sprintf("number_%d", i), typ: parquetInt32, converted: parquetInt8})
+	}
+	for i := 1; i <= g.Stars; i++ {
+		columns = append(columns, &parquetColumn{name: fmt.Sprintf("star_%d", i), typ: parquetInt32, converted: parquetInt8})
+	}
+	special := &parquetColumn{name: "special", typ: parquetBoolean, values: make([]byte, (len(results)+7)/8)}
+	columns = append(columns, special)
+
+	for row, res := range results {
+		if len(res.Numbers) != g.Numbers || len(res.Stars) != g.Stars {
+			return fmt.Errorf("draw %s has %d numbers and %d stars", res.Date, len(res.Numbers), len(res.Stars))
+		}
+		day, err := time.Parse("2006-01-02", res.Date)
+		if err != nil {
+			return err
+		}
+		values := append([]int{int(day.Unix() / 86400)}, res.Numbers...)
+		for i, v := range append(values, res.Stars...) {
+			columns[i].values = binary.LittleEndian.AppendUint32(columns[i].values, uint32(int32(v)))
+		}
+		if res.Special {
+			special.values[row/8] |= 1 << (row % 8)
+		}
+	}
+
+	w.WriteString("PAR1")
+	chunks := &thriftWriter{}
+	var total int64
+	for _, c := range columns {
+		page := &thriftWriter{}
+		page.begin()
+		page.i32(1, 0) // DATA_PAGE
+		page.i32(2, int32(len(c.values)))
+		page.i32(3, int32(len(c.values)))
+		page.structField(5) // DataPageHeader
+		page.i32(1, int32(len(results)))
+		page.i32(2, 0) // PLAIN
+		page.i32(3, 3) // RLE definition levels (none: the column is required)
+		page.i32(4, 3) // RLE repetition levels (none)
+		page.end()
+		page.end()
+
+		offset := int64(w.Len())
+		size := int64(page.Len() + len(c.values))
+		w.Write(page.Bytes())
+		w.Write(c.values)
+		total += size
+
+		chunks.begin() // ColumnChunk
+		chunks.i64(2, offset)
+		chunks.structField(3) // ColumnMetaData
+		chunks.i32(1, c.typ)
+		chunks.list(2, thriftI32, 1)
+		chunks.zigzag(0) // PLAIN
+		chunks.list(3, thriftBinary, 1)
+		chunks.binary(c.name)
+		chunks.i32(4, 0) // UNCOMPRESSED
+		chunks.i64(5, int64(len(results)))
+		chunks.i64(6, size)
+		chunks.i64(7, size)
+		chunks.i64(9, offset)
+		chunks.end()
+		chunks.end()
+	}
+
+	meta := &thriftWriter{}
+	meta.begin() // FileMetaData
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(columns)+1)
+	meta.begin()
+	meta.str(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.end()
+	for _, c := range columns {
+		meta.begin() // SchemaElement
+		meta.i32(1, c.typ)
+		meta.i32(3, 0) // REQUIRED
+		meta.str(4, c.name)
+		if c.typ != parquetBoolean {
+			meta.i32(6, c.converted)
+			meta.structField(10) // LogicalType
+			if c.converted == parquetDate {
+				meta.structField(6) // DATE
+			} else {
+				meta.structField(10) // INTEGER
+				meta.byteField(1, 8)
+				meta.boolField(2, true)
+			}
+			meta.end()
+			meta.end()
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(len(results)))
+	meta.list(4, thriftStruct, 1)
+	meta.begin() // RowGroup
+	meta.list(1, thriftStruct, len(columns))
+	meta.Write(chunks.Bytes())
+	meta.i64(2, total)
+	meta.i64(3, int64(len(results)))
+	meta.end()
+	meta.str(6, "go-euromillions-api version "+version)
+	meta.end()
+
+	w.Write(meta.Bytes())
+	w.Write(binary.LittleEndian.AppendUint32(nil, uint32(meta.Len())))
+	w.WriteString("PAR1")
+	return nil
+}
+
+// protoContentType is the media type of ?format=proto.
+const protoContentType = "application/x-protobuf"
+
+// protoWriter encodes messages in the Protocol Buffers wire format. Fields
+// holding their default value are left out, as proto3 does.
+type protoWriter struct {
+	*bytes.Buffer
+}
+
+func (p protoWriter) tag(field, wireType int) {
+	p.Write(binary.AppendUvarint(nil, uint64(field<<3|wireType)))
+}
+
+func (p protoWriter) uint(field int, v uint64) {
+	if v != 0 {
+		p.tag(field, 0)
+		p.Write(binary.AppendUvarint(nil, v))
+	}
+}
+
+func (p protoWriter) bool(field int, v bool) {
+	if v {
+		p.uint(field, 1)
+	}
+}
+
+// bytesField writes a length-delimited field: a string, a packed list or a
+// message.
+func (p protoWriter) bytesField(field int, b []byte) {
+	p.tag(field, 2)
+	p.Write(binary.AppendUvarint(nil, uint64(len(b))))
+	p.Write(b)
+}
+
+func (p protoWriter) str(field int, s string) {
+	if s != "" {
+		p.bytesField(field, []byte(s))
+	}
+}
+
+func (p protoWriter) packed(field int, values []int) {
+	if len(values) == 0 {
+		return
+	}
+	var b []byte
+	for _, v := range values {
+		b = binary.AppendUvarint(b, uint64(v))
+	}
+	p.bytesField(field, b)
+}
+
+// writeResultsProto writes the results as a DrawList of results.proto.
+func writeResultsProto(buf *bytes.Buffer, results []Result) {
+	list := protoWriter{buf}
+	list.uint(1, uint64(len(results)))
+	for _, res := range results {
+		m := protoWriter{new(bytes.Buffer)}
+		m.str(1, res.Date)
+		m.str(2, res.Timestamp)
+		m.packed(3, res.Numbers)
+		m.packed(4, res.Stars)
+		m.bool(5, res.Special)
+		m.packed(6, res.DrawnNumbers)
+		m.packed(7, res.DrawnStars)
+		for _, n := range res.Notes {
+			note := protoWriter{new(bytes.Buffer)}
+			note.uint(1, uint64(n.ID))
+			note.str(2, n.Text)
+			note.str(3, n.Created)
+			m.bytesField(8, note.Bytes())
+		}
+		list.bytesField(2, m.Bytes())
+	}
+}
+
+// thriftWriter encodes structs with the Thrift compact protocol, the encoding
+// of the Parquet metadata. Fields are written in increasing id order.
+type thriftWriter struct {
+	bytes.Buffer
+	ids []int16 // the last field id of each open struct
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64(v<<1 ^ v>>63))
+}
+
+func (t *thriftWriter) binary(s string) {
+	t.varint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.ids[len(t.ids)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.zigzag(int64(id))
+	}
+	*last = id
+}
+
+// begin starts a struct: the top-level one, a list element or, through
+// structField, a field. end closes it.
+func (t *thriftWriter) begin() {
+	t.ids = append(t.ids, 0)
+}
+
+func (t *thriftWriter) end() {
+	t.WriteByte(0)
+	t.ids = t.ids[:len(t.ids)-1]
+}
+
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) byteField(id int16, v byte) {
+	t.field(id, thriftByte)
+	t.WriteByte(v)
+}
+
+func (t *thriftWriter) boolField(id int16, v bool) {
+	if v {
+		t.field(id, thriftBoolTrue)
+	} else {
+		t.field(id, thriftBoolFalse)
+	}
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.binary(s)
+}
+
+// list starts a list field of n elements of type elem; the elements follow.
+func (t *thriftWriter) list(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.WriteByte(0xF0 | elem)
+		t.varint(uint64(n))
+	}
+}
+
+// ndjsonContentType is the media type of newline-delimited JSON.
+const ndjsonContentType = "application/x-ndjson"
+
+// writeNDJSON writes v as newline-delimited JSON: a list is written one
+// element per line, any other value as a single line.
+func writeNDJSON(buf *bytes.Buffer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var items []json.RawMessage
+	if len(data) == 0 || data[0] != '[' || json.Unmarshal(data, &items) != nil {
+		items = []json.RawMessage{data}
+	}
+	for _, item := range items {
+		buf.Write(item)
+		buf.WriteByte('\n')
+	}
+	return nil
+}
+
+// cborContentType is the media type of ?format=cbor.
+const cborContentType = "application/cbor"
+
+// writeCBOR writes v as CBOR (RFC 8949) with the shape of its JSON: objects
+// become maps with sorted keys, and whole numbers integers.
+func writeCBOR(buf *bytes.Buffer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return err
+	}
+	appendCBOR(buf, value)
+	return nil
+}
+
+// cborHead writes the initial bytes of a CBOR item: its major type and
+// argument.
+func cborHead(buf *bytes.Buffer, major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.Write([]byte{major | 24, byte(n)})
+	case n <= math.MaxUint16:
+		buf.Write(binary.BigEndian.AppendUint16([]byte{major | 25}, uint16(n)))
+	case n <= math.MaxUint32:
+		buf.Write(binary.BigEndian.AppendUint32([]byte{major | 26}, uint32(n)))
+	default:
+		buf.Write(binary.BigEndian.AppendUint64([]byte{major | 27}, n))
+	}
+}
+
+// appendCBOR writes a value decoded from JSON with UseNumber.
+func appendCBOR(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xF6)
+	case bool:
+		if v {
+			buf.WriteByte(0xF5)
+		} else {
+			buf.WriteByte(0xF4)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			if n >= 0 {
+				cborHead(buf, 0, uint64(n))
+			} else {
+				cborHead(buf, 1, uint64(-1-n))
+			}
+			return
+		}
+		f, _ := v.Float64()
+		buf.Write(binary.BigEndian.AppendUint64([]byte{0xFB}, math.Float64bits(f)))
+	case string:
+		cborHead(buf, 3, uint64(len(v)))
+		buf.WriteString(v)
+	case []any:
+		cborHead(buf, 4, uint64(len(v)))
+		for _, item := range v {
+			appendCBOR(buf, item)
+		}
+	case map[string]any:
+		cborHead(buf, 5, uint64(len(v)))
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			appendCBOR(buf, key)
+			appendCBOR(buf, v[key])
+		}
+	}
+}
+
+// writeBody writes an encoded response body with its Content-Type, Content-Length
+// and a weak ETag. For HEAD requests only the headers are sent, and a client that
+// already has the body (If-None-Match) gets a 304 without it.
+func writeBody(w http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+	writeTaggedBody(w, r, contentType, bodyETag(body), body)
+}
+
+// bodyETag returns the weak ETag of a response body.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// writeTaggedBody is writeBody for a body whose ETag is already known, e.g.
+// kept with the body so that it is not hashed again for every request.
+func writeTaggedBody(w http.ResponseWriter, r *http.Request, contentType, etag string, body []byte) {
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(body); err != nil && verbose {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header lists etag. The
+// comparison is weak, as If-None-Match requires: W/ prefixes are ignored.
+func etagMatches(header, etag string) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheEntry is a cached response body with the headers needed to replay it.
+type cacheEntry struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// responseCache is an in-process cache of encoded responses, keyed by
+// cacheKey. Entries expire after their route's TTL and are all dropped when
+// the database changes; past --cache-entries the least recently used go first.
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // of *cacheItem, most recently used first
+}
+
+// cacheItem is an entry of the responseCache with its key.
+type cacheItem struct {
+	key   string
+	entry cacheEntry
+}
+
+var cache = &responseCache{entries: make(map[string]*list.Element), order: list.New()}
+
+// get returns the entry for key if it exists and has not expired.
+func (c *responseCache) get(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	item := e.Value.(*cacheItem)
+	if time.Now().After(item.entry.expires) {
+		c.remove(e)
+		return cacheEntry{}, false
+	}
+	c.order.MoveToFront(e)
+	return item.entry, true
+}
+
+// set stores an entry under key, dropping the least recently used entries
+// past the limit.
+func (c *responseCache) set(key string, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*cacheItem).entry = entry
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheItem{key: key, entry: entry})
+	for cacheEntries > 0 && c.order.Len() > cacheEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove drops an element of the cache. The caller holds c.mu.
+func (c *responseCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*cacheItem).key)
+}
+
+// invalidate drops every cached entry.
+func (c *responseCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// sweep drops the expired entries every interval, so the responses of routes
+// that are not requested again do not stay in memory until the next change.
+func (c *responseCache) sweep(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		c.mu.Lock()
+		for e := c.order.Front(); e != nil; {
+			next := e.Next()
+			if now.After(e.Value.(*cacheItem).entry.expires) {
+				c.remove(e)
+			}
+			e = next
+		}
+		c.mu.Unlock()
+	}
+}
+
+// cacheParams are the query parameters of the documented routes. Only they
+// make up a cacheKey.
+var cacheParams = func() map[string]bool {
+	params := make(map[string]bool)
+	for _, doc := range endpointDocs {
+		for _, p := range doc.params {
+			params[p.Name] = true
+		}
+	}
+	return params
+}()
+
+// cacheKey identifies the response to r: its path and the parameters the
+// routes read, in a fixed order, so that a reordered query or an unknown
+// parameter such as a cache buster does not store another copy.
+func cacheKey(r *http.Request) string {
+	query := r.URL.Query()
+	for name := range query {
+		if !cacheParams[name] {
+			delete(query, name)
+		}
+	}
+	if len(query) == 0 {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + query.Encode()
+}
+
+// captureWriter records a response so it can be stored in the cache before being sent.
+type captureWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (c *captureWriter) Header() http.Header { return c.header }
+
+func (c *captureWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	return c.body.Write(p)
+}
+
+// cached serves a handler through the response cache with the given TTL.
+// Only successful GET responses are stored; the X-Cache header reports HIT or MISS.
+func cached(ttl time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cacheEnabled {
+			next(w, r)
+			return
+		}
+
+		key := cacheKey(r)
+		if entry, ok := cache.get(key); ok {
+			replayEntry(w, r, entry, "HIT")
+			return
+		}
+
+		// The full body is stored even for a conditional request; replayEntry
+		// answers the condition.
+		inner := r
+		if r.Header.Get("If-None-Match") != "" {
+			inner = r.Clone(r.Context())
+			inner.Header.Del("If-None-Match")
+		}
+		capture := &captureWriter{header: make(http.Header)}
+		next(capture, inner)
+		if capture.status == 0 {
+			capture.status = http.StatusOK
+		}
+
+		entry := cacheEntry{
+			status:  capture.status,
+			header:  capture.header,
+			body:    capture.body.Bytes(),
+			expires: time.Now().Add(ttl),
+		}
+		if r.Method == http.MethodGet && capture.status == http.StatusOK {
+			cache.set(key, entry)
+		}
+		replayEntry(w, r, entry, "MISS")
+	}
+}
+
+// replayEntry writes a recorded response to the client.
+func replayEntry(w http.ResponseWriter, r *http.Request, entry cacheEntry, cacheStatus string) {
+	for name, values := range entry.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("X-Cache", cacheStatus)
+	if entry.status == http.StatusOK && etagMatches(r.Header.Get("If-None-Match"), entry.header.Get("ETag")) {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(entry.status)
+	if r.Method != http.MethodHead {
+		w.Write(entry.body)
+	}
+}
+
+// limiter bounds the number of requests served at once. A nil limiter does not limit.
+type limiter chan struct{}
+
+// newLimiter returns a limiter of n requests, or nil when n is not positive.
+func newLimiter(n int) limiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(limiter, n)
+}
+
+// limited serves requests with next while l has room, and answers 503 with a
+// Retry-After header otherwise. Requests never wait for a slot: on a small
+// server a queue of slow requests is what exhausts the memory.
+func limited(l limiter, next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l <- struct{}{}:
+			defer func() { <-l }()
+			next(w, r)
+		default:
+			if verbose {
+				log.Printf("Rejected %s %s from %s: too many requests in flight", r.Method, r.URL.Path, clientIP(r))
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(overloadRetryAfter))
+			http.Error(w, tr(r, "overloaded"), http.StatusServiceUnavailable)
+		}
+	}
+}
+
+// exceptLongPolls serves /results/wait with poll and every other request with
+// next. A long poll idles until a draw is stored, so it has its own limit,
+// --max-waiting, instead of holding a --max-in-flight slot.
+func exceptLongPolls(next http.HandlerFunc, poll http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/results/wait") {
+			poll.ServeHTTP(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// latestCache keeps the latest result of every game, the most requested data.
+// Concurrent misses for a game share a single query, and the cache is dropped
+// whenever the database changes.
+type latestCache struct {
+	mu      sync.Mutex
+	results map[string]Result
+	calls   map[string]*latestCall
+	// bodies holds the encoded responses, by latestKey.
+	bodies map[string]renderedBody
+	// gen is bumped by invalidate, so that a query started before a change
+	// does not store its outdated result.
+	gen uint64
+}
+
+// latestCall is a query in flight, shared by the requests waiting for it.
+type latestCall struct {
+	done chan struct{}
+	res  Result
+	err  error
+}
+
+// renderedBody is an encoded response of the latest result.
+type renderedBody struct {
+	contentType string
+	etag        string
+	data        []byte
+}
+
+var latest = &latestCache{
+	results: make(map[string]Result),
+	calls:   make(map[string]*latestCall),
+	bodies:  make(map[string]renderedBody),
+}
+
+// get returns the latest result of a game, querying the database at most once
+// for any number of concurrent callers.
+func (c *latestCache) get(g *Game) (Result, error) {
+	if !cacheEnabled {
+		return queryResult(g, g.stmts.latest)
+	}
+
+	c.mu.Lock()
+	if res, ok := c.results[g.ID]; ok {
+		c.mu.Unlock()
+		return res, nil
+	}
+	if call, ok := c.calls[g.ID]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.res, call.err
+	}
+	call := &latestCall{done: make(chan struct{})}
+	c.calls[g.ID] = call
+	gen := c.gen
+	c.mu.Unlock()
+
+	call.res, call.err = queryResult(g, g.stmts.latest)
+
+	c.mu.Lock()
+	delete(c.calls, g.ID)
+	if call.err == nil && gen == c.gen {
+		c.results[g.ID] = call.res
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.res, call.err
+}
+
+// generation returns the current generation, to be passed to setRendered.
+func (c *latestCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// rendered returns the encoded response stored under key.
+func (c *latestCache) rendered(key string) (renderedBody, bool) {
+	if !cacheEnabled {
+		return renderedBody{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	body, ok := c.bodies[key]
+	return body, ok
+}
+
+// setRendered stores an encoded response, unless the cache was invalidated
+// since gen was read.
+func (c *latestCache) setRendered(key string, gen uint64, body renderedBody) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen == c.gen {
+		c.bodies[key] = body
+	}
+}
+
+// invalidate drops the cached results.
+func (c *latestCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = make(map[string]Result)
+	c.bodies = make(map[string]renderedBody)
+	c.gen++
+}
+
+// dataChangeHooks are called by the change watcher whenever the database changes.
+var dataChangeHooks = []func(){
+	cache.invalidate,
+	latest.invalidate,
+	winners.invalidate,
+	versions.invalidate,
+	revision.invalidate,
+	forgetAPIKeys,
+	changes.notify, // last: the waiting requests read the fresh data
+}
+
+// changeNotifier wakes up the requests waiting for the data to change.
+type changeNotifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+var changes = &changeNotifier{ch: make(chan struct{})}
+
+// wait returns a channel that is closed at the next change.
+func (n *changeNotifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.ch
+}
+
+// notify wakes up everything waiting on the current channel.
+func (n *changeNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
+
+// serverConn is the dedicated connection of the change watcher. The server's
+// own bookkeeping writes, which do not touch the draws, go through it (see
+// withServerConn): a connection's own commits do not change its data_version,
+// so they do not invalidate the in-memory data.
+var (
+	serverConnMu sync.Mutex
+	serverConn   *sql.Conn
+)
+
+// withServerConn runs fn with exclusive use of serverConn.
+func withServerConn(fn func(conn *sql.Conn) error) error {
+	serverConnMu.Lock()
+	defer serverConnMu.Unlock()
+	return fn(serverConn)
+}
+
+// startChangeWatcher polls SQLite's data_version on a dedicated connection.
+// The value changes whenever another connection commits, so inserts made by the
+// updater invalidate in-memory data (see dataChangeHooks) within a second.
+func startChangeWatcher() error {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+
+	var version int64
+	if err := conn.QueryRowContext(context.Background(), "PRAGMA data_version").Scan(&version); err != nil {
+		conn.Close()
+		return fmt.Errorf("error reading PRAGMA data_version: %v", err)
+	}
+	serverConn = conn
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			var current int64
+			err := withServerConn(func(conn *sql.Conn) error {
+				return conn.QueryRowContext(context.Background(), "PRAGMA data_version").Scan(&current)
+			})
+			if err != nil {
+				log.Printf("Error reading PRAGMA data_version: %v", err)
+				continue
+			}
+			if current != version {
+				version = current
+				for _, hook := range dataChangeHooks {
+					hook()
+				}
+				if verbose {
+					log.Printf("Database changed, in-memory data invalidated")
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// translations holds the user-facing strings of every supported language, keyed by message ID.
+// Messages may contain fmt verbs filled in by tr.
+var translations = map[string]map[string]string{
+	"en": {
+		"no_results":             "No results found",
+		"no_results_date":        "No results found for the specified date",
+		"no_results_year":        "No results found for the year %s",
+		"no_results_for":         "No results found for %s",
+		"invalid_date":           "Invalid date format (use YYYY-MM-DD)",
+		"invalid_year":           "Invalid year format (use YYYY)",
+		"invalid_month_format":   "Invalid format (use YYYY-MM)",
+		"invalid_month":          "Invalid month/year format (use YYYY-MM)",
+		"db_error":               "Error querying database",
+		"encode_error":           "Error encoding response",
+		"unauthorized":           "Unauthorized",
+		"invalid_tz":             "Invalid time zone (use an IANA name such as Europe/Lisbon)",
+		"invalid_special":        "Invalid value for special (use true or false)",
+		"unknown_game":           "Unknown game: %s",
+		"label_date":             "Date",
+		"label_numbers":          "Numbers",
+		"label_stars":            "Stars",
+		"label_month":            "Month",
+		"label_draws":            "Draws",
+		"label_dates":            "Dates",
+		"invalid_wheel_numbers":  "Invalid numbers (use %d to 20 distinct numbers between 1 and %d, e.g. numbers=1,5,9,14,22,31,40)",
+		"invalid_wheel_stars":    "Invalid stars (use at least %d distinct stars between 1 and %d, e.g. stars=2,5,9)",
+		"invalid_guarantee":      "Invalid guarantee (use 1 to %d)",
+		"wheel_too_large":        "Wheel too large (reduce the number pool or the guarantee)",
+		"invalid_exclude":        "Invalid exclude (use past-winners, numbers:13,7 or stars:1,2)",
+		"pool_too_small":         "Not enough numbers or stars left after the exclusions",
+		"invalid_simulation":     "Invalid simulation size (lines, draws and trials must be positive and lines*draws*trials at most %d)",
+		"invalid_seed":           "Invalid seed (use a non-negative integer)",
+		"invalid_page":           "Invalid pagination (page must be at least 1 and per_page between 1 and 1000)",
+		"rescrape_failed":        "Re-scrape failed; see the server log",
+		"invalid_min_agree":      "Invalid min_agree. It must be a positive integer",
+		"overloaded":             "The server is busy, please retry later",
+		"invalid_pairs":          "Invalid pairs. It must be a non-negative integer",
+		"quota_exceeded":         "Daily quota of %d requests exceeded",
+		"invalid_quota":          "Invalid quota. It must be a non-negative integer",
+		"invalid_days":           "Invalid days. It must be a positive integer",
+		"key_not_found":          "API key %s not found",
+		"edit_failed":            "Editing the draw failed; see the server log",
+		"invalid_draw_numbers":   "Invalid numbers. Give %d distinct numbers from 1 to %d",
+		"invalid_draw_stars":     "Invalid stars. Give %d distinct stars from 1 to %d",
+		"invalid_limit":          "Invalid limit. It must be a positive integer",
+		"invalid_timeout":        "Invalid timeout (use a duration such as 60s, at most %v)",
+		"invalid_granularity":    "Invalid granularity (use month or year)",
+		"invalid_nearest":        "Invalid nearest (use before or after)",
+		"invalid_check_body":     "Invalid request body. Send JSON like {\"lines\": [{\"numbers\": [1,2,3,4,5], \"stars\": [1,2]}], \"from\": \"2024-03-01\", \"to\": \"2024-03-31\"}",
+		"invalid_check_lines":    "Give between 1 and %d lines",
+		"invalid_check_numbers":  "Line %d: give %d distinct numbers from 1 to %d",
+		"invalid_check_stars":    "Line %d: give %d distinct stars from 1 to %d",
+		"check_too_large":        "Too many checks: lines times draws must not exceed %d",
+		"invalid_channel":        "Invalid channel (use webhook, ntfy or email)",
+		"invalid_target":         "Invalid target: give an http(s) URL for webhook and ntfy, an address for email",
+		"invalid_events":         "Invalid events (use draw, win, weekly or monthly, comma-separated)",
+		"subscription_not_found": "Subscription %s not found",
+		"invalid_matches":        "Invalid matches: numbers must be from 0 to %d and stars from 0 to %d",
+		"internal_error":         "Internal server error",
+		"maintenance":            "The service is under maintenance, please retry later",
+		"invalid_enabled":        "Invalid enabled. It must be true or false",
+		"invalid_query":          "Invalid query: %v",
+		"label_note":             "Note",
+		"invalid_note":           "Invalid note. The text is required, at most %d characters",
+		"note_not_found":         "Note not found: %s",
+		"invalid_since":          "Invalid since. It must be the id of a change",
+		"mirror_read_only":       "This server mirrors %s: edit the draws there",
+		"replica_read_only":      "This server is a read-only replica: make the change on the primary",
+		"invalid_xml_form":       "Invalid xml parameter (use schema or compact)",
+		"too_many_subscriptions": "An API key may have at most %d subscriptions",
+		"invalid_confirmation":   "Unknown confirmation link",
+		"subscription_confirmed": "Subscription confirmed: this address will now receive the notifications.",
+		"wheel_dropped":          "%d lines dropped: every star combination recreated a past jackpot",
+	},
+	"pt": {
+		"no_results":             "Nenhum resultado encontrado",
+		"no_results_date":        "Nenhum resultado encontrado para a data indicada",
+		"no_results_year":        "Nenhum resultado encontrado para o ano %s",
+		"no_results_for":         "Nenhum resultado encontrado para %s",
+		"invalid_date":           "Formato de data inválido (use AAAA-MM-DD)",
+		"invalid_year":           "Formato de ano inválido (use AAAA)",
+		"invalid_month_format":   "Formato inválido (use AAAA-MM)",
+		"invalid_month":          "Formato de mês/ano inválido (use AAAA-MM)",
+		"db_error":               "Erro ao consultar a base de dados",
+		"encode_error":           "Erro ao codificar a resposta",
+		"unauthorized":           "Não autorizado",
+		"invalid_tz":             "Fuso horário inválido (use um nome IANA como Europe/Lisbon)",
+		"invalid_special":        "Valor inválido para special (use true ou false)",
+		"unknown_game":           "Jogo desconhecido: %s",
+		"label_date":             "Data",
+		"label_numbers":          "Números",
+		"label_stars":            "Estrelas",
+		"label_month":            "Mês",
+		"label_draws":            "Sorteios",
+		"label_dates":            "Datas",
+		"invalid_wheel_numbers":  "Números inválidos (use %d a 20 números distintos entre 1 e %d, por exemplo numbers=1,5,9,14,22,31,40)",
+		"invalid_wheel_stars":    "Estrelas inválidas (use pelo menos %d estrelas distintas entre 1 e %d, por exemplo stars=2,5,9)",
+		"invalid_guarantee":      "Garantia inválida (use 1 a %d)",
+		"wheel_too_large":        "Sistema demasiado grande (reduza os números escolhidos ou a garantia)",
+		"invalid_exclude":        "Valor de exclude inválido (use past-winners, numbers:13,7 ou stars:1,2)",
+		"pool_too_small":         "Não restam números ou estrelas suficientes depois das exclusões",
+		"invalid_simulation":     "Tamanho de simulação inválido (lines, draws e trials devem ser positivos e lines*draws*trials no máximo %d)",
+		"invalid_seed":           "Semente inválida (use um número inteiro não negativo)",
+		"invalid_page":           "Paginação inválida (page deve ser pelo menos 1 e per_page entre 1 e 1000)",
+		"rescrape_failed":        "Nova recolha falhou; consulte o registo do servidor",
+		"invalid_min_agree":      "min_agree inválido. Deve ser um inteiro positivo",
+		"overloaded":             "O servidor está ocupado, tente novamente mais tarde",
+		"invalid_pairs":          "pairs inválido. Deve ser um inteiro não negativo",
+		"quota_exceeded":         "Quota diária de %d pedidos excedida",
+		"invalid_quota":          "quota inválida. Deve ser um inteiro não negativo",
+		"invalid_days":           "days inválido. Deve ser um inteiro positivo",
+		"key_not_found":          "Chave de API %s não encontrada",
+		"edit_failed":            "A edição do sorteio falhou; consulte o registo do servidor",
+		"invalid_draw_numbers":   "Números inválidos. Indique %d números distintos de 1 a %d",
+		"invalid_draw_stars":     "Estrelas inválidas. Indique %d estrelas distintas de 1 a %d",
+		"invalid_limit":          "limit inválido. Deve ser um inteiro positivo",
+		"invalid_timeout":        "timeout inválido (use uma duração como 60s, no máximo %v)",
+		"invalid_granularity":    "granularity inválido (use month ou year)",
+		"invalid_nearest":        "nearest inválido (use before ou after)",
+		"invalid_check_body":     "Corpo do pedido inválido. Envie JSON como {\"lines\": [{\"numbers\": [1,2,3,4,5], \"stars\": [1,2]}], \"from\": \"2024-03-01\", \"to\": \"2024-03-31\"}",
+		"invalid_check_lines":    "Indique entre 1 e %d linhas",
+		"invalid_check_numbers":  "Linha %d: indique %d números distintos de 1 a %d",
+		"invalid_check_stars":    "Linha %d: indique %d estrelas distintas de 1 a %d",
+		"check_too_large":        "Demasiadas verificações: linhas vezes sorteios não pode exceder %d",
+		"invalid_channel":        "channel inválido (use webhook, ntfy ou email)",
+		"invalid_target":         "target inválido: indique um URL http(s) para webhook e ntfy, um endereço para email",
+		"invalid_events":         "events inválido (use draw, win, weekly ou monthly, separados por vírgulas)",
+		"subscription_not_found": "Subscrição %s não encontrada",
+		"invalid_matches":        "Acertos inválidos: numbers deve ser de 0 a %d e stars de 0 a %d",
+		"internal_error":         "Erro interno do servidor",
+		"maintenance":            "O serviço está em manutenção, tente novamente mais tarde",
+		"invalid_enabled":        "Valor de enabled inválido. Deve ser true ou false",
+		"invalid_query":          "Consulta inválida: %v",
+		"label_note":             "Nota",
+		"invalid_note":           "Nota inválida. O texto é obrigatório, com %d caracteres no máximo",
+		"note_not_found":         "Nota não encontrada: %s",
+		"invalid_since":          "since inválido. Deve ser o id de uma alteração",
+		"mirror_read_only":       "Este servidor replica %s: edite os sorteios lá",
+		"replica_read_only":      "Este servidor é uma réplica só de leitura: faça a alteração no primário",
+		"invalid_xml_form":       "Parâmetro xml inválido (use schema ou compact)",
+		"too_many_subscriptions": "Uma chave de API pode ter no máximo %d subscrições",
+		"invalid_confirmation":   "Ligação de confirmação desconhecida",
+		"subscription_confirmed": "Subscrição confirmada: este endereço passa a receber as notificações.",
+		"wheel_dropped":          "%d linhas removidas: todas as combinações de estrelas repetiam um jackpot anterior",
+	},
+	"fr": {
+		"no_results":             "Aucun résultat trouvé",
+		"no_results_date":        "Aucun résultat trouvé pour la date indiquée",
+		"no_results_year":        "Aucun résultat trouvé pour l'année %s",
+		"no_results_for":         "Aucun résultat trouvé pour %s",
+		"invalid_date":           "Format de date invalide (utilisez AAAA-MM-JJ)",
+		"invalid_year":           "Format d'année invalide (utilisez AAAA)",
+		"invalid_month_format":   "Format invalide (utilisez AAAA-MM)",
+		"invalid_month":          "Format de mois/année invalide (utilisez AAAA-MM)",
+		"db_error":               "Erreur lors de l'interrogation de la base de données",
+		"encode_error":           "Erreur lors de l'encodage de la réponse",
+		"unauthorized":           "Non autorisé",
+		"invalid_tz":             "Fuseau horaire invalide (utilisez un nom IANA comme Europe/Lisbon)",
+		"invalid_special":        "Valeur invalide pour special (utilisez true ou false)",
+		"unknown_game":           "Jeu inconnu : %s",
+		"label_date":             "Date",
+		"label_numbers":          "Numéros",
+		"label_stars":            "Étoiles",
+		"label_month":            "Mois",
+		"label_draws":            "Tirages",
+		"label_dates":            "Dates",
+		"invalid_wheel_numbers":  "Numéros invalides (utilisez de %d à 20 numéros distincts entre 1 et %d, par exemple numbers=1,5,9,14,22,31,40)",
+		"invalid_wheel_stars":    "Étoiles invalides (utilisez au moins %d étoiles distinctes entre 1 et %d, par exemple stars=2,5,9)",
+		"invalid_guarantee":      "Garantie invalide (utilisez 1 à %d)",
+		"wheel_too_large":        "Système trop grand (réduisez les numéros choisis ou la garantie)",
+		"invalid_exclude":        "Valeur d'exclude invalide (utilisez past-winners, numbers:13,7 ou stars:1,2)",
+		"pool_too_small":         "Il ne reste pas assez de numéros ou d'étoiles après les exclusions",
+		"invalid_simulation":     "Taille de simulation invalide (lines, draws et trials doivent être positifs et lines*draws*trials au plus %d)",
+		"invalid_seed":           "Graine invalide (utilisez un entier positif)",
+		"invalid_page":           "Pagination invalide (page doit valoir au moins 1 et per_page entre 1 et 1000)",
+		"rescrape_failed":        "Échec de la nouvelle collecte ; consultez le journal du serveur",
+		"invalid_min_agree":      "min_agree invalide. Il doit être un entier positif",
+		"overloaded":             "Le serveur est occupé, veuillez réessayer plus tard",
+		"invalid_pairs":          "pairs invalide. Il doit être un entier positif ou nul",
+		"quota_exceeded":         "Quota quotidienne de %d requêtes dépassée",
+		"invalid_quota":          "quota invalide. Elle doit être un entier positif ou nul",
+		"invalid_days":           "days invalide. Il doit être un entier positif",
+		"key_not_found":          "Clé d'API %s introuvable",
+		"edit_failed":            "La modification du tirage a échoué ; consultez le journal du serveur",
+		"invalid_draw_numbers":   "Numéros invalides. Indiquez %d numéros distincts de 1 à %d",
+		"invalid_draw_stars":     "Étoiles invalides. Indiquez %d étoiles distinctes de 1 à %d",
+		"invalid_limit":          "limit invalide. Il doit être un entier positif",
+		"invalid_timeout":        "timeout invalide (utilisez une durée comme 60s, au plus %v)",
+		"invalid_granularity":    "granularity invalide (utilisez month ou year)",
+		"invalid_nearest":        "nearest invalide (utilisez before ou after)",
+		"invalid_check_body":     "Corps de requête invalide. Envoyez du JSON comme {\"lines\": [{\"numbers\": [1,2,3,4,5], \"stars\": [1,2]}], \"from\": \"2024-03-01\", \"to\": \"2024-03-31\"}",
+		"invalid_check_lines":    "Indiquez entre 1 et %d lignes",
+		"invalid_check_numbers":  "Ligne %d : indiquez %d numéros distincts de 1 à %d",
+		"invalid_check_stars":    "Ligne %d : indiquez %d étoiles distinctes de 1 à %d",
+		"check_too_large":        "Trop de vérifications : lignes fois tirages ne doit pas dépasser %d",
+		"invalid_channel":        "channel invalide (utilisez webhook, ntfy ou email)",
+		"invalid_target":         "target invalide : indiquez une URL http(s) pour webhook et ntfy, une adresse pour email",
+		"invalid_events":         "events invalide (utilisez draw, win, weekly ou monthly, séparés par des virgules)",
+		"subscription_not_found": "Abonnement %s introuvable",
+		"invalid_matches":        "Correspondances invalides : numbers doit être de 0 à %d et stars de 0 à %d",
+		"internal_error":         "Erreur interne du serveur",
+		"maintenance":            "Le service est en maintenance, veuillez réessayer plus tard",
+		"invalid_enabled":        "Valeur de enabled invalide. Elle doit être true ou false",
+		"invalid_query":          "Requête invalide : %v",
+		"label_note":             "Note",
+		"invalid_note":           "Note invalide. Le texte est requis, %d caractères au plus",
+		"note_not_found":         "Note introuvable : %s",
+		"invalid_since":          "since invalide. Il doit être l'identifiant d'une modification",
+		"mirror_read_only":       "Ce serveur est un miroir de %s : modifiez les tirages là-bas",
+		"replica_read_only":      "Ce serveur est une réplique en lecture seule : faites la modification sur le primaire",
+		"invalid_xml_form":       "Paramètre xml invalide (utilisez schema ou compact)",
+		"too_many_subscriptions": "Une clé d'API peut avoir au plus %d abonnements",
+		"invalid_confirmation":   "Lien de confirmation inconnu",
+		"subscription_confirmed": "Abonnement confirmé : cette adresse recevra désormais les notifications.",
+		"wheel_dropped":          "%d lignes retirées : chaque combinaison d'étoiles reproduisait un ancien jackpot",
+	},
+	"es": {
+		"no_results":             "No se encontraron resultados",
+		"no_results_date":        "No se encontraron resultados para la fecha indicada",
+		"no_results_year":        "No se encontraron resultados para el año %s",
+		"no_results_for":         "No se encontraron resultados para %s",
+		"invalid_date":           "Formato de fecha no válido (use AAAA-MM-DD)",
+		"invalid_year":           "Formato de año no válido (use AAAA)",
+		"invalid_month_format":   "Formato no válido (use AAAA-MM)",
+		"invalid_month":          "Formato de mes/año no válido (use AAAA-MM)",
+		"db_error":               "Error al consultar la base de datos",
+		"encode_error":           "Error al codificar la respuesta",
+		"unauthorized":           "No autorizado",
+		"invalid_tz":             "Zona horaria no válida (use un nombre IANA como Europe/Lisbon)",
+		"invalid_special":        "Valor no válido para special (use true o false)",
+		"unknown_game":           "Juego desconocido: %s",
+		"label_date":             "Fecha",
+		"label_numbers":          "Números",
+		"label_stars":            "Estrellas",
+		"label_month":            "Mes",
+		"label_draws":            "Sorteos",
+		"label_dates":            "Fechas",
+		"invalid_wheel_numbers":  "Números no válidos (use de %d a 20 números distintos entre 1 y %d, por ejemplo numbers=1,5,9,14,22,31,40)",
+		"invalid_wheel_stars":    "Estrellas no válidas (use al menos %d estrellas distintas entre 1 y %d, por ejemplo stars=2,5,9)",
+		"invalid_guarantee":      "Garantía no válida (use 1 a %d)",
+		"wheel_too_large":        "Sistema demasiado grande (reduzca los números elegidos o la garantía)",
+		"invalid_exclude":        "Valor de exclude no válido (use past-winners, numbers:13,7 o stars:1,2)",
+		"pool_too_small":         "No quedan suficientes números o estrellas tras las exclusiones",
+		"invalid_simulation":     "Tamaño de simulación no válido (lines, draws y trials deben ser positivos y lines*draws*trials como máximo %d)",
+		"invalid_seed":           "Semilla no válida (use un entero no negativo)",
+		"invalid_page":           "Paginación no válida (page debe ser al menos 1 y per_page entre 1 y 1000)",
+		"rescrape_failed":        "Falló la nueva extracción; consulte el registro del servidor",
+		"invalid_min_agree":      "min_agree no válido. Debe ser un entero positivo",
+		"overloaded":             "El servidor está ocupado, inténtelo de nuevo más tarde",
+		"invalid_pairs":          "pairs no válido. Debe ser un entero no negativo",
+		"quota_exceeded":         "Cuota diaria de %d solicitudes superada",
+		"invalid_quota":          "quota no válida. Debe ser un entero no negativo",
+		"invalid_days":           "days no válido. Debe ser un entero positivo",
+		"key_not_found":          "Clave de API %s no encontrada",
+		"edit_failed":            "La edición del sorteo falló; consulte el registro del servidor",
+		"invalid_draw_numbers":   "Números no válidos. Indique %d números distintos del 1 al %d",
+		"invalid_draw_stars":     "Estrellas no válidas. Indique %d estrellas distintas del 1 al %d",
+		"invalid_limit":          "limit no válido. Debe ser un entero positivo",
+		"invalid_timeout":        "timeout no válido (use una duración como 60s, como máximo %v)",
+		"invalid_granularity":    "granularity no válido (use month o year)",
+		"invalid_nearest":        "nearest no válido (use before o after)",
+		"invalid_check_body":     "Cuerpo de la petición no válido. Envíe JSON como {\"lines\": [{\"numbers\": [1,2,3,4,5], \"stars\": [1,2]}], \"from\": \"2024-03-01\", \"to\": \"2024-03-31\"}",
+		"invalid_check_lines":    "Indique entre 1 y %d líneas",
+		"invalid_check_numbers":  "Línea %d: indique %d números distintos del 1 al %d",
+		"invalid_check_stars":    "Línea %d: indique %d estrellas distintas del 1 al %d",
+		"check_too_large":        "Demasiadas comprobaciones: líneas por sorteos no debe superar %d",
+		"invalid_channel":        "channel no válido (use webhook, ntfy o email)",
+		"invalid_target":         "target no válido: indique una URL http(s) para webhook y ntfy, una dirección para email",
+		"invalid_events":         "events no válido (use draw, win, weekly o monthly, separados por comas)",
+		"subscription_not_found": "Suscripción %s no encontrada",
+		"invalid_matches":        "Aciertos no válidos: numbers debe ser de 0 a %d y stars de 0 a %d",
+		"internal_error":         "Error interno del servidor",
+		"maintenance":            "El servicio está en mantenimiento, vuelva a intentarlo más tarde",
+		"invalid_enabled":        "Valor de enabled no válido. Debe ser true o false",
+		"invalid_query":          "Consulta no válida: %v",
+		"label_note":             "Nota",
+		"invalid_note":           "Nota no válida. El texto es obligatorio, con %d caracteres como máximo",
+		"note_not_found":         "Nota no encontrada: %s",
+		"invalid_since":          "since no válido. Debe ser el id de un cambio",
+		"mirror_read_only":       "Este servidor replica %s: edite los sorteos allí",
+		"replica_read_only":      "Este servidor es una réplica de solo lectura: haga el cambio en el primario",
+		"invalid_xml_form":       "Parámetro xml no válido (use schema o compact)",
+		"too_many_subscriptions": "Una clave de API puede tener como máximo %d suscripciones",
+		"invalid_confirmation":   "Enlace de confirmación desconocido",
+		"subscription_confirmed": "Suscripción confirmada: esta dirección recibirá ahora las notificaciones.",
+		"wheel_dropped":          "%d líneas descartadas: todas las combinaciones de estrellas repetían un bote anterior",
+	},
+}
+
+// requestLang returns the language selected with the 'lang' query parameter.
+// Unknown or missing languages fall back to English.
+func requestLang(r *http.Request) string {
+	lang := strings.ToLower(r.URL.Query().Get("lang"))
+	if _, ok := translations[lang]; ok {
+		return lang
+	}
+	return "en"
+}
+
+// tr returns the message with the given ID in the request's language.
+func tr(r *http.Request, id string, args ...any) string {
+	msg, ok := translations[requestLang(r)][id]
+	if !ok {
+		msg = translations["en"][id]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// dateNames holds the weekday (from Sunday) and month names of every
+// supported language, and the layout of a long date: weekday, day, month, year.
+var dateNames = map[string]struct {
+	weekdays [7]string
+	months   [12]string
+	layout   string
+}{
+	"en": {
+		weekdays: [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+		months:   [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		layout:   "%s, %d %s %d",
+	},
+	"pt": {
+		weekdays: [7]string{"Domingo", "Segunda-feira", "Terça-feira", "Quarta-feira", "Quinta-feira", "Sexta-feira", "Sábado"},
+		months:   [12]string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+		layout:   "%s, %d de %s de %d",
+	},
+	"fr": {
+		weekdays: [7]string{"Dimanche", "Lundi", "Mardi", "Mercredi", "Jeudi", "Vendredi", "Samedi"},
+		months:   [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		layout:   "%s %d %s %d",
+	},
+	"es": {
+		weekdays: [7]string{"Domingo", "Lunes", "Martes", "Miércoles", "Jueves", "Viernes", "Sábado"},
+		months:   [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		layout:   "%s, %d de %s de %d",
+	},
+}
+
+// plaintextDate formats a draw date for the plaintext format: the ISO date,
+// or when ?lang= is set the long date in that language, e.g.
+// "Sexta-feira, 3 de maio de 2024".
+func plaintextDate(r *http.Request, date string) string {
+	if r.URL.Query().Get("lang") == "" {
+		return date
+	}
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	names := dateNames[requestLang(r)]
+	return fmt.Sprintf(names.layout, names.weekdays[t.Weekday()], t.Day(), names.months[t.Month()-1], t.Year())
+}
+
+// RescrapeSource is what one archive reported during a re-scrape.
+type RescrapeSource struct {
+	Source  string `json:"source" xml:"source,attr"`
+	Numbers []int  `json:"numbers,omitempty" xml:"number"`
+	Error   string `json:"error,omitempty" xml:"error,omitempty"`
+}
+
+// RescrapeResponse is the outcome of POST /admin/rescrape/{date}: the row
+// before and after, and the fields that changed.
+type RescrapeResponse struct {
+	XMLName  xml.Name         `json:"-" xml:"rescrape"`
+	Date     string           `json:"date" xml:"date,attr"`
+	Status   string           `json:"status" xml:"status,attr"`
+	Repaired bool             `json:"repaired" xml:"repaired,attr"`
+	Before   *Result          `json:"before" xml:"before,omitempty"`
+	After    *Result          `json:"after" xml:"after,omitempty"`
+	Changes  []string         `json:"changes" xml:"change"`
+	Sources  []RescrapeSource `json:"sources" xml:"source"`
+}
+
+// rescrapeHandler fetches the draw of a date from the archives with the
+// updater's verify command and stores the draw they agree on. ?min_agree=N
+// lowers the number of archives that must agree (default 2).
+func rescrapeHandler(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("POST request for /admin/rescrape from %s", clientIP(r))
+	}
+
+	g, ok := adminGame(w, r)
+	if !ok {
+		return
+	}
+	date := r.PathValue("date")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		http.Error(w, tr(r, "invalid_date"), http.StatusBadRequest)
+		return
+	}
+	args := []string{"verify", "--date", date, "--repair", "--json", "--game", g.ID, "--actor", adminActor(r)}
+	if n := r.URL.Query().Get("min_agree"); n != "" {
+		if v, err := strconv.Atoi(n); err != nil || v < 1 {
+			http.Error(w, tr(r, "invalid_min_agree"), http.StatusBadRequest)
+			return
+		}
+		args = append(args, "--min-agree", n)
+	}
+
+	before, err := storedResult(g, date)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching result for date (%s): %v", date, err)
+		return
+	}
+
+	// verify exits with a non-zero code for anything but an intact draw, and
+	// always prints its report; only a missing report is a failure.
+	stdout, runErr := runUpdater(r.Context(), args...)
+	var report struct {
+		Status   string `json:"status"`
+		Repaired bool   `json:"repaired"`
+		Sources  []struct {
+			Source  string `json:"source"`
+			Numbers []int  `json:"numbers"`
+			Error   string `json:"error"`
+		} `json:"sources"`
+	}
+	if err := json.Unmarshal(stdout, &report); err != nil {
+		http.Error(w, tr(r, "rescrape_failed"), http.StatusBadGateway)
+		log.Printf("Re-scrape of %s failed: %v (%v)", date, runErr, err)
+		return
+	}
+
+	after, err := storedResult(g, date)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching result for date (%s): %v", date, err)
+		return
+	}
+
+	resp := RescrapeResponse{Date: date, Status: report.Status, Repaired: report.Repaired, Before: before, After: after, Changes: resultChanges(before, after)}
+	for _, s := range report.Sources {
+		resp.Sources = append(resp.Sources, RescrapeSource{Source: s.Source, Numbers: s.Numbers, Error: s.Error})
+	}
+	if report.Repaired {
+		log.Printf("Admin re-scrape repaired the %s draw of %s: %s", g.Name, date, strings.Join(resp.Changes, "; "))
+	}
+
+	sendValue(w, r, resp, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "%s: %s, Status: %s, Repaired: %t\n", tr(r, "label_date"), resp.Date, resp.Status, resp.Repaired)
+		for _, c := range resp.Changes {
+			fmt.Fprintln(buf, c)
+		}
+	})
+}
+
+// adminGame returns the game selected with ?game= in the admin area
+// (EuroMillions by default). It writes a 404 for an unknown game.
+func adminGame(w http.ResponseWriter, r *http.Request) (*Game, bool) {
+	id := r.URL.Query().Get("game")
+	if id == "" {
+		return defaultGame, true
+	}
+	g := findGame(strings.ToLower(id))
+	if g == nil {
+		http.Error(w, tr(r, "unknown_game", id), http.StatusNotFound)
+		return nil, false
+	}
+	return g, true
+}
+
+// runUpdater runs the updater on the server's database and returns what it
+// printed to stdout. A non-zero exit is returned as an *exec.ExitError: the
+// updater's exit codes tell what happened, not only whether it failed.
+func runUpdater(ctx context.Context, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, updaterPath, append(args, "--database", dbPath)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil && verbose {
+		log.Printf("Updater %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.Bytes(), err
+}
+
+// mirrorEvery copies the draws of every game from the --mirror upstream now
+// and then every interval, with the updater's mirror command. The upstream
+// API key, if it needs one, is passed to the updater in $UPSTREAM_API_KEY.
+func mirrorEvery(interval time.Duration) {
+	for {
+		for _, g := range games {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			_, err := runUpdater(ctx, "mirror", "--upstream", mirrorURL, "--game", g.ID)
+			cancel()
+			// Exit code 1 means draws were written.
+			switch code := updaterExitCode(err); code {
+			case 0:
+			case 1:
+				log.Printf("Mirrored new %s data from %s", g.Name, mirrorURL)
+			default:
+				log.Printf("Failed to mirror %s from %s: %v", g.Name, mirrorURL, err)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// notMirrored refuses the admin edits of draws in mirror mode: the upstream
+// owns the draws, and the next sync would undo them.
+func notMirrored(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if mirrorURL != "" {
+			http.Error(w, tr(r, "mirror_read_only", mirrorURL), http.StatusConflict)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// onPrimary guards a handler that writes the database. On a replica the
+// request is sent on to the --primary, which checks the credentials again,
+// or refused without one.
+func onPrimary(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case role == "primary":
+			next(w, r)
+		case primaryProxy != nil:
+			if verbose {
+				log.Printf("Forwarding %s %s to the primary %s", r.Method, r.URL.Path, primaryURL)
+			}
+			primaryProxy.ServeHTTP(w, r)
+		default:
+			http.Error(w, tr(r, "replica_read_only"), http.StatusConflict)
+		}
+	}
+}
+
+// adminActor identifies the admin making a request, for the audit log.
+func adminActor(r *http.Request) string {
+	user, _, _ := r.BasicAuth()
+	return "admin:" + user
+}
+
+// updaterExitCode returns the exit code of a run of the updater, or -1 when
+// it could not be run.
+func updaterExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+//go:embed schema.xsd
+var schemaXSD []byte
+
+// schemaHandler serves the XML schema of ?xml=schema and ?xml=compact. Like
+// OPTIONS, it only describes the API and needs no authentication.
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	writeBody(w, r, "application/xml", schemaXSD)
+}
+
+//go:embed results.proto
+var resultsProto []byte
+
+// protoSchemaHandler serves the Protocol Buffers schema of ?format=proto,
+// without authentication like /schema.xsd.
+func protoSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	writeBody(w, r, "text/plain; charset=utf-8", resultsProto)
+}
+
+//go:embed admin.html
+var adminHTML string
+
+// adminPage is the admin web UI: a single page working with the admin API.
+var adminPage = template.Must(template.New("admin").Parse(adminHTML))
+
+// adminPageHandler serves the admin web UI.
+func adminPageHandler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	data := struct {
+		Games []*Game
+		Year  int
+	}{games, time.Now().Year()}
+	if err := adminPage.Execute(&buf, data); err != nil {
+		http.Error(w, tr(r, "encode_error"), http.StatusInternalServerError)
+		log.Printf("Error rendering the admin page: %v", err)
+		return
 	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeBody(w, r, "text/html; charset=utf-8", buf.Bytes())
+}
 
-	if !tableExists {
-		return fmt.Errorf("table 'results' not found in database")
+// adminResultsHandler lists the draws of a year (?year=, the current year by
+// default) for the admin UI, whatever the authentication of the read endpoints.
+func adminResultsHandler(w http.ResponseWriter, r *http.Request) {
+	g, ok := adminGame(w, r)
+	if !ok {
+		return
+	}
+	year := time.Now().Year()
+	if value := r.URL.Query().Get("year"); value != "" {
+		t, err := time.Parse("2006", value)
+		if err != nil {
+			http.Error(w, tr(r, "invalid_year"), http.StatusBadRequest)
+			return
+		}
+		year = t.Year()
 	}
 
-	// Verify the table schema by running a simple query.
-	_, err = db.Exec("SELECT date, number_1, number_2, number_3, number_4, number_5, star_1, star_2 FROM results LIMIT 1")
+	results, err := queryResults(g, g.stmts.byYear, year)
 	if err != nil {
-		return fmt.Errorf("table schema does not match the expected format: %v", err)
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching results by year (%d): %v", year, err)
+		return
 	}
+	sendResponse(w, r, results)
+}
 
-	return nil
+// DrawChange is the outcome of editing a draw in the admin area.
+type DrawChange struct {
+	XMLName xml.Name `json:"-" xml:"change"`
+	Date    string   `json:"date" xml:"date,attr"`
+	Action  string   `json:"action" xml:"action,attr"`
+	Before  *Result  `json:"before" xml:"before,omitempty"`
+	After   *Result  `json:"after" xml:"after,omitempty"`
+	Changes []string `json:"changes" xml:"change"`
 }
 
-// resultsHandler serves all available results.
-func resultsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+// editDraw runs an edit command of the updater on the draw of a date and
+// responds with the row before and after. The updater does the writes, so
+// that the statistics are maintained in one place.
+func editDraw(w http.ResponseWriter, r *http.Request, g *Game, date string, args ...string) {
+	before, err := storedResult(g, date)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching result for date (%s): %v", date, err)
 		return
 	}
-	if verbose {
-		log.Printf("GET request for /results from %s", r.RemoteAddr)
+
+	stdout, runErr := runUpdater(r.Context(), append(args, "--date", date, "--game", g.ID, "--json", "--actor", adminActor(r))...)
+	var outcome struct {
+		Action string `json:"action"`
+	}
+	// 0 and 1 are the updater's "unchanged" and "stored" exit codes.
+	if code := updaterExitCode(runErr); (code != 0 && code != 1) || json.Unmarshal(stdout, &outcome) != nil {
+		http.Error(w, tr(r, "edit_failed"), http.StatusBadGateway)
+		log.Printf("Editing the %s draw of %s failed: %v", g.Name, date, runErr)
+		return
 	}
-	getAllResults(w, r)
-}
 
-// getAllResults queries the database for all results and returns them in the requested format.
-func getAllResults(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT date, number_1, number_2, number_3, number_4, number_5, star_1, star_2 FROM results ORDER BY date DESC")
+	after, err := storedResult(g, date)
 	if err != nil {
-		http.Error(w, "Error querying database", http.StatusInternalServerError)
-		log.Printf("Error fetching results: %v", err)
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error fetching result for date (%s): %v", date, err)
 		return
 	}
-	defer rows.Close()
 
-	var results []Result
-	for rows.Next() {
-		var res Result
-		var n1, n2, n3, n4, n5, s1, s2 int
-		err := rows.Scan(&res.Date, &n1, &n2, &n3, &n4, &n5, &s1, &s2)
-		if err != nil {
-			http.Error(w, "Error processing results", http.StatusInternalServerError)
-			log.Printf("Error reading database row: %v", err)
-			return
+	change := DrawChange{Date: date, Action: outcome.Action, Before: before, After: after, Changes: resultChanges(before, after)}
+	if len(change.Changes) > 0 {
+		log.Printf("Admin %s the %s draw of %s: %s", outcome.Action, g.Name, date, strings.Join(change.Changes, "; "))
+	}
+	sendValue(w, r, change, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "%s: %s, Action: %s\n", tr(r, "label_date"), change.Date, change.Action)
+		for _, c := range change.Changes {
+			fmt.Fprintln(buf, c)
 		}
-		res.Numbers = []int{n1, n2, n3, n4, n5}
-		res.Stars = []int{s1, s2}
-		results = append(results, res)
+	})
+}
+
+// setResultHandler inserts or corrects the draw of a date from ?numbers=,
+// ?stars= and ?special=.
+func setResultHandler(w http.ResponseWriter, r *http.Request) {
+	g, ok := adminGame(w, r)
+	if !ok {
+		return
+	}
+	date := r.PathValue("date")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		http.Error(w, tr(r, "invalid_date"), http.StatusBadRequest)
+		return
 	}
 
-	if len(results) == 0 {
-		http.Error(w, "No results found", http.StatusNotFound)
+	query := r.URL.Query()
+	numbers, err := parseIntList(query.Get("numbers"))
+	if err != nil || len(numbers) != g.Numbers || !validBalls(numbers, g.MaxNumber) {
+		http.Error(w, tr(r, "invalid_draw_numbers", g.Numbers, g.MaxNumber), http.StatusBadRequest)
 		return
 	}
+	stars, err := parseIntList(query.Get("stars"))
+	if err != nil || len(stars) != g.Stars || !validBalls(stars, g.MaxStar) {
+		http.Error(w, tr(r, "invalid_draw_stars", g.Stars, g.MaxStar), http.StatusBadRequest)
+		return
+	}
+	special := false
+	if value := query.Get("special"); value != "" {
+		if special, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, tr(r, "invalid_special"), http.StatusBadRequest)
+			return
+		}
+	}
 
-	sendResponse(w, r, results)
+	args := []string{"set", "--numbers", query.Get("numbers"), "--stars", query.Get("stars")}
+	if special {
+		args = append(args, "--special")
+	}
+	editDraw(w, r, g, date, args...)
 }
 
-// latestHandler serves the latest result.
-func latestHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+// deleteResultHandler deletes the draw of a date.
+func deleteResultHandler(w http.ResponseWriter, r *http.Request) {
+	g, ok := adminGame(w, r)
+	if !ok {
 		return
 	}
-	if verbose {
-		log.Printf("GET request for /results/latest from %s", r.RemoteAddr)
+	date := r.PathValue("date")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		http.Error(w, tr(r, "invalid_date"), http.StatusBadRequest)
+		return
 	}
+	editDraw(w, r, g, date, "delete")
+}
 
-	var result Result
-	var n1, n2, n3, n4, n5, s1, s2 int
-	err := db.QueryRow("SELECT date, number_1, number_2, number_3, number_4, number_5, star_1, star_2 FROM results ORDER BY date DESC LIMIT 1").
-		Scan(&result.Date, &n1, &n2, &n3, &n4, &n5, &s1, &s2)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "No results found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Error querying database", http.StatusInternalServerError)
-			log.Printf("Error fetching latest result: %v", err)
+// Note is an annotation an admin attached to a draw, e.g. "results corrected
+// on 2023-06-02". The game and the date are left out when the note is listed
+// with its draw (?include=notes).
+type Note struct {
+	ID      int64  `json:"id" xml:"id,attr"`
+	Game    string `json:"game,omitempty" xml:"game,attr,omitempty"`
+	Date    string `json:"date,omitempty" xml:"date,attr,omitempty"`
+	Text    string `json:"text" xml:",chardata"`
+	Created string `json:"created" xml:"created,attr"`
+}
+
+// NoteList is the response of GET /admin/notes.
+type NoteList struct {
+	XMLName xml.Name `json:"-" xml:"notes"`
+	Notes   []Note   `json:"notes" xml:"note"`
+}
+
+// maxNoteLength bounds the text of a note, in characters.
+const maxNoteLength = 500
+
+// requestIncludes reports whether the comma-separated 'include' query
+// parameter lists name.
+func requestIncludes(r *http.Request, name string) bool {
+	for _, v := range strings.Split(r.URL.Query().Get("include"), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), name) {
+			return true
 		}
-		return
 	}
+	return false
+}
 
-	result.Numbers = []int{n1, n2, n3, n4, n5}
-	result.Stars = []int{s1, s2}
+// readNotes returns the notes of a game, oldest first; date limits them to a draw.
+func readNotes(ctx context.Context, g *Game, date string) ([]Note, error) {
+	query := "SELECT id, game, date, text, created FROM notes WHERE game = ?"
+	args := []any{g.ID}
+	if date != "" {
+		query += " AND date = ?"
+		args = append(args, date)
+	}
+	notes := []Note{}
+	err := retryBusy(func() error {
+		notes = notes[:0]
+		rows, err := db.QueryContext(ctx, query+" ORDER BY date, id", args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var n Note
+			if err := rows.Scan(&n.ID, &n.Game, &n.Date, &n.Text, &n.Created); err != nil {
+				return err
+			}
+			notes = append(notes, n)
+		}
+		return rows.Err()
+	})
+	return notes, err
+}
 
-	sendResponse(w, r, []Result{result})
+// attachNotes sets the notes of each draw. The notes of the whole game are
+// read at once: there are few of them, and a listing can hold every draw.
+func attachNotes(r *http.Request, results []Result) error {
+	g := defaultGame
+	if id := r.PathValue("game"); id != "" {
+		g = findGame(strings.ToLower(id))
+	}
+	if g == nil || len(results) == 0 {
+		return nil
+	}
+	notes, err := readNotes(r.Context(), g, "")
+	if err != nil {
+		return err
+	}
+	byDate := map[string][]Note{}
+	for _, n := range notes {
+		date := n.Date
+		n.Game, n.Date = "", ""
+		byDate[date] = append(byDate[date], n)
+	}
+	for i := range results {
+		results[i].Notes = byDate[results[i].Date]
+	}
+	return nil
 }
 
-// dateHandler serves the result for a specific date.
-func dateHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+// listNotesHandler lists the notes of a game (?game=), or of one draw (?date=).
+func listNotesHandler(w http.ResponseWriter, r *http.Request) {
+	g, ok := adminGame(w, r)
+	if !ok {
 		return
 	}
-	if verbose {
-		log.Printf("GET request for /results/date/ from %s", r.RemoteAddr)
+	date := r.URL.Query().Get("date")
+	if date != "" {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			http.Error(w, tr(r, "invalid_date"), http.StatusBadRequest)
+			return
+		}
 	}
-
-	date := r.URL.Path[len("/results/date/"):]
-	if date == "" {
-		http.Error(w, "Date parameter is required (format YYYY-MM-DD)", http.StatusBadRequest)
+	notes, err := readNotes(r.Context(), g, date)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error reading notes: %v", err)
 		return
 	}
+	sendValue(w, r, NoteList{Notes: notes}, func(buf *bytes.Buffer) {
+		for _, n := range notes {
+			fmt.Fprintf(buf, "%d %s %s: %s\n", n.ID, n.Game, n.Date, n.Text)
+		}
+	})
+}
 
+// addNoteHandler attaches the note ?text= to the draw of a date.
+func addNoteHandler(w http.ResponseWriter, r *http.Request) {
+	g, ok := adminGame(w, r)
+	if !ok {
+		return
+	}
+	date := r.PathValue("date")
 	if _, err := time.Parse("2006-01-02", date); err != nil {
-		http.Error(w, "Invalid date format (use YYYY-MM-DD)", http.StatusBadRequest)
+		http.Error(w, tr(r, "invalid_date"), http.StatusBadRequest)
 		return
 	}
-
-	var result Result
-	var n1, n2, n3, n4, n5, s1, s2 int
-	err := db.QueryRow("SELECT date, number_1, number_2, number_3, number_4, number_5, star_1, star_2 FROM results WHERE date = ?", date).
-		Scan(&result.Date, &n1, &n2, &n3, &n4, &n5, &s1, &s2)
-	if err != nil {
+	text := strings.TrimSpace(r.URL.Query().Get("text"))
+	if text == "" || len([]rune(text)) > maxNoteLength {
+		http.Error(w, tr(r, "invalid_note", maxNoteLength), http.StatusBadRequest)
+		return
+	}
+	if _, err := queryResult(g, g.stmts.byDate, date); err != nil {
 		if err == sql.ErrNoRows {
-			http.Error(w, "No results found for the specified date", http.StatusNotFound)
+			http.Error(w, tr(r, "no_results_date"), http.StatusNotFound)
 		} else {
-			http.Error(w, "Error querying database", http.StatusInternalServerError)
-			log.Printf("Error fetching result by date (%s): %v", date, err)
+			http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+			log.Printf("Error fetching result: %v", err)
 		}
 		return
 	}
 
-	result.Numbers = []int{n1, n2, n3, n4, n5}
-	result.Stars = []int{s1, s2}
+	// Notes are written through the pool rather than serverConn, so that the
+	// change watcher drops the cached responses that could include them.
+	note := Note{Game: g.ID, Date: date, Text: text, Created: time.Now().UTC().Format(time.RFC3339)}
+	res, err := db.ExecContext(r.Context(), "INSERT INTO notes (game, date, text, created) VALUES (?, ?, ?, ?)", note.Game, note.Date, note.Text, note.Created)
+	if err == nil {
+		note.ID, err = res.LastInsertId()
+	}
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error adding a note: %v", err)
+		return
+	}
+	log.Printf("Added note %d to the %s draw of %s", note.ID, g.Name, date)
 
-	sendResponse(w, r, []Result{result})
+	sendValue(w, r, note, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "%d %s %s: %s\n", note.ID, note.Game, note.Date, note.Text)
+	})
 }
 
-// yearHandler serves all results for a specific year.
-func yearHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-		return
+// deleteNoteHandler deletes a note.
+func deleteNoteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	res, err := db.ExecContext(r.Context(), "DELETE FROM notes WHERE id = ?", id)
+	var deleted int64
+	if err == nil {
+		deleted, err = res.RowsAffected()
 	}
-	if verbose {
-		log.Printf("GET request for /results/year/ from %s", r.RemoteAddr)
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error deleting note %s: %v", id, err)
+		return
 	}
-
-	year := r.URL.Path[len("/results/year/"):]
-	if year == "" {
-		http.Error(w, "Year parameter is required (format YYYY)", http.StatusBadRequest)
+	if deleted == 0 {
+		http.Error(w, tr(r, "note_not_found", id), http.StatusNotFound)
 		return
 	}
+	log.Printf("Deleted note %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	if _, err := time.Parse("2006", year); err != nil {
-		http.Error(w, "Invalid year format (use YYYY)", http.StatusBadRequest)
-		return
+// ScrapeEntry is the outcome of one site fetched by the updater.
+type ScrapeEntry struct {
+	Time     string `json:"time" xml:"time,attr"`
+	Game     string `json:"game" xml:"game,attr"`
+	Source   int    `json:"source" xml:"source,attr"`
+	Date     string `json:"date,omitempty" xml:"date,attr,omitempty"`
+	Inserted bool   `json:"inserted" xml:"inserted,attr"`
+	Error    string `json:"error,omitempty" xml:"error,omitempty"`
+}
+
+// ScrapeLog is the response of GET /admin/scrapes.
+type ScrapeLog struct {
+	XMLName xml.Name      `json:"-" xml:"scrapes"`
+	Entries []ScrapeEntry `json:"entries" xml:"scrape"`
+}
+
+// scrapeLogHandler lists the latest ?limit= (100) sites fetched by the
+// updater, newest first.
+func scrapeLogHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, tr(r, "invalid_limit"), http.StatusBadRequest)
+			return
+		}
+		limit = n
 	}
 
-	rows, err := db.Query("SELECT date, number_1, number_2, number_3, number_4, number_5, star_1, star_2 FROM results WHERE strftime('%Y', date) = ? ORDER BY date DESC", year)
+	scrapes := ScrapeLog{Entries: []ScrapeEntry{}}
+	err := retryBusy(func() error {
+		scrapes.Entries = scrapes.Entries[:0]
+		rows, err := db.Query("SELECT time, game, source, COALESCE(draw_date, ''), inserted, COALESCE(error, '') FROM scrape_log ORDER BY time DESC LIMIT ?", limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var e ScrapeEntry
+			if err := rows.Scan(&e.Time, &e.Game, &e.Source, &e.Date, &e.Inserted, &e.Error); err != nil {
+				return err
+			}
+			scrapes.Entries = append(scrapes.Entries, e)
+		}
+		return rows.Err()
+	})
 	if err != nil {
-		http.Error(w, "Error querying database", http.StatusInternalServerError)
-		log.Printf("Error fetching results by year (%s): %v", year, err)
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error reading the scrape log: %v", err)
 		return
 	}
-	defer rows.Close()
 
-	var results []Result
-	for rows.Next() {
-		var res Result
-		var n1, n2, n3, n4, n5, s1, s2 int
-		err := rows.Scan(&res.Date, &n1, &n2, &n3, &n4, &n5, &s1, &s2)
-		if err != nil {
-			http.Error(w, "Error processing results", http.StatusInternalServerError)
-			log.Printf("Error reading database row: %v", err)
+	sendValue(w, r, scrapes, func(buf *bytes.Buffer) {
+		for _, e := range scrapes.Entries {
+			fmt.Fprintf(buf, "%s %s site %d: date=%s inserted=%t %s\n", e.Time, e.Game, e.Source, e.Date, e.Inserted, e.Error)
+		}
+	})
+}
+
+// AuditDraw is a draw as recorded in the audit log.
+type AuditDraw struct {
+	Numbers []int `json:"numbers" xml:"numbers>number"`
+	Stars   []int `json:"stars" xml:"stars>star"`
+	Special bool  `json:"special" xml:"special"`
+}
+
+// AuditEntry is a change to the draws: Action is insert, update or delete,
+// Source the updater command that made it and Actor who ran it, when known.
+type AuditEntry struct {
+	ID     int64      `json:"id" xml:"id,attr"`
+	Time   string     `json:"time" xml:"time,attr"`
+	Game   string     `json:"game" xml:"game,attr"`
+	Date   string     `json:"date" xml:"date,attr"`
+	Action string     `json:"action" xml:"action,attr"`
+	Source string     `json:"source" xml:"source,attr"`
+	Actor  string     `json:"actor,omitempty" xml:"actor,attr,omitempty"`
+	Before *AuditDraw `json:"before" xml:"before,omitempty"`
+	After  *AuditDraw `json:"after" xml:"after,omitempty"`
+}
+
+// AuditLog is the response of GET /admin/audit.
+type AuditLog struct {
+	XMLName xml.Name     `json:"-" xml:"audit"`
+	Entries []AuditEntry `json:"entries" xml:"entry"`
+}
+
+// auditLogHandler lists the latest ?limit= (100) changes to the draws, newest
+// first, optionally only those of a ?game= or of a draw ?date=.
+func auditLogHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, tr(r, "invalid_limit"), http.StatusBadRequest)
 			return
 		}
-		res.Numbers = []int{n1, n2, n3, n4, n5}
-		res.Stars = []int{s1, s2}
-		results = append(results, res)
+		limit = n
+	}
+	var where []string
+	var args []any
+	if id := query.Get("game"); id != "" {
+		g := findGame(strings.ToLower(id))
+		if g == nil {
+			http.Error(w, tr(r, "unknown_game", id), http.StatusNotFound)
+			return
+		}
+		where = append(where, "game = ?")
+		args = append(args, g.ID)
+	}
+	if date := query.Get("date"); date != "" {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			http.Error(w, tr(r, "invalid_date"), http.StatusBadRequest)
+			return
+		}
+		where = append(where, "date = ?")
+		args = append(args, date)
+	}
+	sqlQuery := "SELECT id, time, game, date, action, source, COALESCE(actor, ''), before, after FROM audit_log"
+	if len(where) > 0 {
+		sqlQuery += " WHERE " + strings.Join(where, " AND ")
 	}
+	sqlQuery += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
 
-	if len(results) == 0 {
-		http.Error(w, fmt.Sprintf("No results found for the year %s", year), http.StatusNotFound)
+	audit := AuditLog{Entries: []AuditEntry{}}
+	err := retryBusy(func() error {
+		audit.Entries = audit.Entries[:0]
+		rows, err := db.Query(sqlQuery, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var e AuditEntry
+			var before, after sql.NullString
+			if err := rows.Scan(&e.ID, &e.Time, &e.Game, &e.Date, &e.Action, &e.Source, &e.Actor, &before, &after); err != nil {
+				return err
+			}
+			e.Before, e.After = parseAuditDraw(before), parseAuditDraw(after)
+			audit.Entries = append(audit.Entries, e)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		http.Error(w, tr(r, "db_error"), http.StatusInternalServerError)
+		log.Printf("Error reading the audit log: %v", err)
 		return
 	}
 
-	sendResponse(w, r, results)
+	sendValue(w, r, audit, func(buf *bytes.Buffer) {
+		for _, e := range audit.Entries {
+			fmt.Fprintf(buf, "%d %s %s %s %s by %s %s: %s -> %s\n", e.ID, e.Time, e.Game, e.Date, e.Action, e.Source, e.Actor, e.Before, e.After)
+		}
+	})
 }
 
-// monthYearHandler serves all results for a specific month and year.
-func monthYearHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-		return
+// parseAuditDraw parses a draw stored in the audit log.
+func parseAuditDraw(value sql.NullString) *AuditDraw {
+	if !value.Valid {
+		return nil
 	}
-	if verbose {
-		log.Printf("GET request for /results/month/ from %s", r.RemoteAddr)
+	var d AuditDraw
+	if err := json.Unmarshal([]byte(value.String), &d); err != nil {
+		return nil
 	}
+	return &d
+}
 
-	monthYear := r.URL.Path[len("/results/month/"):]
-	if monthYear == "" {
-		http.Error(w, "Month/Year parameter is required (format YYYY-MM)", http.StatusBadRequest)
-		return
+// String formats a draw for the plain text audit log.
+func (d *AuditDraw) String() string {
+	if d == nil {
+		return "-"
 	}
+	s := joinInts(d.Numbers) + " + " + joinInts(d.Stars)
+	if d.Special {
+		s += " (special)"
+	}
+	return s
+}
 
-	parts := strings.Split(monthYear, "-")
-	if len(parts) != 2 {
-		http.Error(w, "Invalid format (use YYYY-MM)", http.StatusBadRequest)
-		return
+// storedResult returns the stored result of a date, or nil when there is none.
+func storedResult(g *Game, date string) (*Result, error) {
+	res, err := queryResult(g, g.stmts.byDate, date)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t, err := drawTime(res.Date); err == nil {
+		res.Timestamp = t.Format(time.RFC3339)
+	}
+	return &res, nil
+}
+
+// resultChanges describes the differences between two versions of a result.
+func resultChanges(before, after *Result) []string {
+	changes := []string{}
+	switch {
+	case before == nil && after == nil:
+	case before == nil:
+		changes = append(changes, "inserted")
+	case after == nil:
+		changes = append(changes, "deleted")
+	default:
+		if !slices.Equal(before.Numbers, after.Numbers) {
+			changes = append(changes, fmt.Sprintf("numbers: %s -> %s", joinInts(before.Numbers), joinInts(after.Numbers)))
+		}
+		if !slices.Equal(before.Stars, after.Stars) {
+			changes = append(changes, fmt.Sprintf("stars: %s -> %s", joinInts(before.Stars), joinInts(after.Stars)))
+		}
+		if before.Special != after.Special {
+			changes = append(changes, fmt.Sprintf("special: %t -> %t", before.Special, after.Special))
+		}
 	}
+	return changes
+}
 
-	year := parts[0]
-	month := parts[1]
+// QueryResult is the answer of /admin/query: the columns and rows of the query.
+type QueryResult struct {
+	Columns   []string `json:"columns"`
+	Rows      [][]any  `json:"rows"`
+	Truncated bool     `json:"truncated"`
+}
 
-	if _, err := time.Parse("2006-01", monthYear); err != nil {
-		http.Error(w, "Invalid month/year format (use YYYY-MM)", http.StatusBadRequest)
-		return
+const (
+	// adminQueryTimeout interrupts runaway queries, e.g. an unbounded recursive CTE.
+	adminQueryTimeout = 5 * time.Second
+	// maxAdminQueryRows bounds ?limit= of /admin/query.
+	maxAdminQueryRows = 10000
+)
+
+// queryableTables are the tables /admin/query may read: the draws, their
+// history and the statistics. The keys, the logs and the subscriptions are not.
+func queryableTables() []string {
+	tables := []string{"results_history", "stats_balls", "stats_pairs"}
+	for _, g := range games {
+		tables = append(tables, g.table)
+	}
+	return tables
+}
+
+// checkAdminQuery accepts a single SELECT statement that reads only
+// queryableTables. The tables are found in the query plan rather than in the
+// text, so aliases, quoting and subqueries cannot hide them.
+func checkAdminQuery(ctx context.Context, query string) error {
+	upper := strings.ToUpper(strings.TrimSpace(query))
+	if !strings.HasPrefix(upper, "SELECT") && !strings.HasPrefix(upper, "WITH") {
+		return errors.New("only SELECT statements are allowed")
+	}
+	if strings.Contains(query, ";") {
+		return errors.New("only a single statement is allowed")
 	}
 
-	rows, err := db.Query("SELECT date, number_1, number_2, number_3, number_4, number_5, star_1, star_2 FROM results WHERE strftime('%Y', date) = ? AND strftime('%m', date) = ? ORDER BY date DESC", year, month)
+	// Root pages of the allowed tables and of their indexes.
+	allowed := map[int64]bool{}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(queryableTables())), ",")
+	var args []any
+	for _, t := range queryableTables() {
+		args = append(args, t)
+	}
+	rows, err := queryDB.QueryContext(ctx, "SELECT rootpage FROM sqlite_master WHERE tbl_name IN ("+placeholders+") AND rootpage > 0", args...)
 	if err != nil {
-		http.Error(w, "Error querying database", http.StatusInternalServerError)
-		log.Printf("Error fetching results by month/year (%s): %v", monthYear, err)
-		return
+		return err
+	}
+	for rows.Next() {
+		var page int64
+		if err := rows.Scan(&page); err != nil {
+			rows.Close()
+			return err
+		}
+		allowed[page] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
 	}
-	defer rows.Close()
 
-	var results []Result
+	rows, err = queryDB.QueryContext(ctx, "EXPLAIN "+query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 	for rows.Next() {
-		var res Result
-		var n1, n2, n3, n4, n5, s1, s2 int
-		err := rows.Scan(&res.Date, &n1, &n2, &n3, &n4, &n5, &s1, &s2)
+		var addr, p1, p2, p3 int64
+		var opcode string
+		var p4, p5, comment any
+		if err := rows.Scan(&addr, &opcode, &p1, &p2, &p3, &p4, &p5, &comment); err != nil {
+			return err
+		}
+		switch opcode {
+		case "OpenRead", "ReopenIdx":
+			if p3 != 0 || !allowed[p2] {
+				return errors.New("the query reads a table outside the draws and statistics")
+			}
+		case "OpenWrite", "VOpen", "ParseSchema", "Destroy", "Clear":
+			return fmt.Errorf("the query uses %s, which is not allowed", opcode)
+		}
+	}
+	return rows.Err()
+}
+
+// adminQueryHandler runs a read-only SELECT against the draws and statistics
+// tables and returns its rows as JSON, or as CSV with ?format=csv. The query is
+// the 'sql' parameter or the request body.
+func adminQueryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("sql")
+	if query == "" && r.Method == http.MethodPost {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
 		if err != nil {
-			http.Error(w, "Error processing results", http.StatusInternalServerError)
-			log.Printf("Error reading database row: %v", err)
+			http.Error(w, tr(r, "invalid_query", err), http.StatusBadRequest)
 			return
 		}
-		res.Numbers = []int{n1, n2, n3, n4, n5}
-		res.Stars = []int{s1, s2}
-		results = append(results, res)
+		query = string(body)
 	}
-
-	if len(results) == 0 {
-		http.Error(w, fmt.Sprintf("No results found for %s", monthYear), http.StatusNotFound)
+	query = strings.TrimRight(strings.TrimSpace(query), "; \t\r\n")
+	if query == "" {
+		http.Error(w, tr(r, "invalid_query", "missing sql"), http.StatusBadRequest)
 		return
 	}
+	limit := 1000
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, tr(r, "invalid_limit"), http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxAdminQueryRows)
+	}
 
-	sendResponse(w, r, results)
-}
-
-// sendResponse writes the response in the correct format (XML, Plain Text, or JSON).
-// It prioritizes the 'format' URL query parameter.
-func sendResponse(w http.ResponseWriter, r *http.Request, results []Result) {
-	format := r.URL.Query().Get("format")
+	ctx, cancel := context.WithTimeout(r.Context(), adminQueryTimeout)
+	defer cancel()
+	if err := checkAdminQuery(ctx, query); err != nil {
+		http.Error(w, tr(r, "invalid_query", err), http.StatusBadRequest)
+		return
+	}
+	if verbose {
+		log.Printf("Admin query from %s: %s", clientIP(r), query)
+	}
 
-	switch strings.ToLower(format) {
-	case "xml":
-		w.Header().Set("Content-Type", "application/xml")
-		if len(results) == 1 {
-			if err := xml.NewEncoder(w).Encode(results[0]); err != nil {
-				log.Printf("Error encoding XML response: %v", err)
-			}
-		} else {
-			allResults := AllResults{Results: results}
-			if err := xml.NewEncoder(w).Encode(allResults); err != nil {
-				log.Printf("Error encoding XML response: %v", err)
-			}
-		}
+	result := QueryResult{Rows: [][]any{}}
+	rows, err := queryDB.QueryContext(ctx, query)
+	if err != nil {
+		http.Error(w, tr(r, "invalid_query", err), http.StatusBadRequest)
 		return
-	case "plaintext":
-		w.Header().Set("Content-Type", "text/plain")
-		for _, result := range results {
-			numbers := fmt.Sprintf("%d,%d,%d,%d,%d", result.Numbers[0], result.Numbers[1], result.Numbers[2], result.Numbers[3], result.Numbers[4])
-			stars := fmt.Sprintf("%d,%d", result.Stars[0], result.Stars[1])
-			fmt.Fprintf(w, "Date: %s, Numbers: %s, Stars: %s\n", result.Date, numbers, stars)
-		}
+	}
+	defer rows.Close()
+	if result.Columns, err = rows.Columns(); err != nil {
+		http.Error(w, tr(r, "invalid_query", err), http.StatusBadRequest)
 		return
-	default: // Fallback to JSON
-		w.Header().Set("Content-Type", "application/json")
-		if len(results) == 1 {
-			if err := json.NewEncoder(w).Encode(results[0]); err != nil {
-				log.Printf("Error encoding JSON response: %v", err)
+	}
+	for rows.Next() {
+		if len(result.Rows) == limit {
+			result.Truncated = true
+			break
+		}
+		row := make([]any, len(result.Columns))
+		ptrs := make([]any, len(row))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			http.Error(w, tr(r, "invalid_query", err), http.StatusBadRequest)
+			return
+		}
+		for i, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[i] = string(b)
 			}
-		} else {
-			if err := json.NewEncoder(w).Encode(results); err != nil {
-				log.Printf("Error encoding JSON response: %v", err)
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, tr(r, "invalid_query", err), http.StatusBadRequest)
+		return
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if strings.ToLower(r.URL.Query().Get("format")) == "csv" {
+		cw := csv.NewWriter(buf)
+		cw.Write(result.Columns)
+		for _, row := range result.Rows {
+			record := make([]string, len(row))
+			for i, v := range row {
+				if v != nil {
+					record[i] = fmt.Sprint(v)
+				}
 			}
+			cw.Write(record)
 		}
+		cw.Flush()
+		writeBody(w, r, "text/csv; charset=utf-8", buf.Bytes())
+		return
+	}
+	if err := json.NewEncoder(buf).Encode(result); err != nil {
+		http.Error(w, tr(r, "encode_error"), http.StatusInternalServerError)
+		log.Printf("Error encoding JSON response: %v", err)
 		return
 	}
+	writeBody(w, r, "application/json", buf.Bytes())
+}
+
+// maintenanceHandler tells whether maintenance mode is on.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	m := Maintenance{Maintenance: maintenance.Load()}
+	sendValue(w, r, m, func(buf *bytes.Buffer) {
+		fmt.Fprintf(buf, "Maintenance: %t\n", m.Maintenance)
+	})
+}
+
+// setMaintenanceHandler turns maintenance mode on or off (?enabled=true|false).
+// The state is not persisted: a restart goes back to --maintenance.
+func setMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+	if err != nil {
+		http.Error(w, tr(r, "invalid_enabled"), http.StatusBadRequest)
+		return
+	}
+	if maintenance.Swap(enabled) != enabled {
+		log.Printf("Maintenance mode turned %s from the admin area by %s", map[bool]string{true: "on", false: "off"}[enabled], clientIP(r))
+	}
+	maintenanceHandler(w, r)
 }
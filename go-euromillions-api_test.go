@@ -0,0 +1,451 @@
+package main
+
+// Tests of the server's encoders and checks, and benchmarks of the hot queries
+// and handlers. The server is a single file next to the updater, so the files
+// of the package are listed:
+//
+//	go test go-euromillions-api.go instance_unix.go go-euromillions-api_test.go
+//	go test -run '^$' -bench . -benchmem go-euromillions-api.go instance_unix.go go-euromillions-api_test.go
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testDB opens a database holding the EuroMillions draws of twenty years,
+// migrated and with the statements prepared, as the server runs it.
+func testDB(tb testing.TB) {
+	tb.Helper()
+	dbPath = filepath.Join(tb.TempDir(), "euromillions.db")
+	maxIdleConns, busyTimeout = 2, 5*time.Second
+	var err error
+	if drawLocation, err = time.LoadLocation("Europe/Paris"); err != nil {
+		tb.Fatal(err)
+	}
+
+	seed, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer seed.Close()
+	if _, err := seed.Exec(`CREATE TABLE results (date TEXT, number_1 INTEGER, number_2 INTEGER, number_3 INTEGER,
+		number_4 INTEGER, number_5 INTEGER, star_1 INTEGER, star_2 INTEGER)`); err != nil {
+		tb.Fatal(err)
+	}
+	tx, err := seed.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	rng := rand.New(rand.NewPCG(1, 2))
+	for d := time.Date(2006, 1, 3, 0, 0, 0, 0, time.UTC); d.Year() < 2026; d = d.AddDate(0, 0, 1) {
+		if d.Weekday() != time.Tuesday && d.Weekday() != time.Friday {
+			continue
+		}
+		numbers, stars := rng.Perm(50)[:5], rng.Perm(12)[:2]
+		slices.Sort(numbers)
+		slices.Sort(stars)
+		if _, err := tx.Exec("INSERT INTO results VALUES (?, ?, ?, ?, ?, ?, ?, ?)", d.Format("2006-01-02"),
+			numbers[0]+1, numbers[1]+1, numbers[2]+1, numbers[3]+1, numbers[4]+1, stars[0]+1, stars[1]+1); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+
+	if err := initDB(); err != nil {
+		tb.Fatal(err)
+	}
+	if err := prepareStatements(); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		closeStatements()
+		db.Close()
+	})
+}
+
+// BenchmarkLatest reads the latest draw with the prepared statement and
+// with the same query parsed for every read, as before it was prepared.
+func BenchmarkLatest(b *testing.B) {
+	testDB(b)
+	g := defaultGame
+	query := "SELECT " + g.columns() + " FROM " + g.table + " ORDER BY date DESC LIMIT 1"
+
+	b.Run("prepared", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := queryResult(g, g.stmts.latest); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unprepared", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := scanResult(g, db.QueryRow(query)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkByYear reads the hundred or so draws of a year, with and without
+// the prepared statement.
+func BenchmarkByYear(b *testing.B) {
+	testDB(b)
+	g := defaultGame
+	query := "SELECT " + g.columns() + " FROM " + g.table + " WHERE year = ? ORDER BY date DESC"
+
+	b.Run("prepared", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			results, err := queryResults(g, g.stmts.byYear, 2020)
+			if err != nil || len(results) == 0 {
+				b.Fatal(results, err)
+			}
+		}
+	})
+	b.Run("unprepared", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			results, err := queryUnprepared(g, query, 2020)
+			if err != nil || len(results) == 0 {
+				b.Fatal(results, err)
+			}
+		}
+	})
+}
+
+// queryUnprepared is queryResults with the query parsed again.
+func queryUnprepared(g *Game, query string, args ...any) ([]Result, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []Result
+	for rows.Next() {
+		res, err := scanResult(g, rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// BenchmarkLatestHandler serves /results/latest in the main formats, from
+// the pre-encoded bodies (cache) and encoded for every request (nocache).
+func BenchmarkLatestHandler(b *testing.B) {
+	testDB(b)
+	b.Cleanup(func() { cacheEnabled = false })
+	for _, format := range []string{"json", "xml", "plaintext"} {
+		for _, enabled := range []bool{true, false} {
+			name := format + "/nocache"
+			if enabled {
+				name = format + "/cache"
+			}
+			b.Run(name, func(b *testing.B) {
+				cacheEnabled = enabled
+				latest.invalidate()
+				benchHandler(b, latestHandler, httptest.NewRequest(http.MethodGet, "/results/latest?format="+format, nil))
+			})
+		}
+	}
+}
+
+// BenchmarkYearHandler serves the draws of a year, without the response
+// cache in front of the route.
+func BenchmarkYearHandler(b *testing.B) {
+	testDB(b)
+	for _, format := range []string{"json", "xml", "csv"} {
+		b.Run(format, func(b *testing.B) {
+			r := httptest.NewRequest(http.MethodGet, "/results/year/2020?format="+format, nil)
+			r.SetPathValue("year", "2020")
+			benchHandler(b, yearHandler, r)
+		})
+	}
+}
+
+// BenchmarkResultsHandler serves every draw, without the response cache.
+func BenchmarkResultsHandler(b *testing.B) {
+	testDB(b)
+	benchHandler(b, resultsHandler, httptest.NewRequest(http.MethodGet, "/results", nil))
+}
+
+// benchHandler serves r with h b.N times and fails on anything but a 200.
+func benchHandler(b *testing.B, h http.HandlerFunc, r *http.Request) {
+	b.Helper()
+	b.ReportAllocs()
+	for range b.N {
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != http.StatusOK {
+			b.Fatalf("%s: status %d: %s", r.URL, w.Code, w.Body)
+		}
+	}
+}
+
+func TestCheckAdminQuery(t *testing.T) {
+	testDB(t)
+	var err error
+	if queryDB, err = sql.Open("sqlite3", "file:"+dbPath+"?mode=ro"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { queryDB.Close() })
+
+	outside := "the query reads a table outside the draws and statistics"
+	tests := []struct {
+		query   string
+		wantErr string
+	}{
+		{"SELECT date, number_1 FROM results WHERE year = 2020", ""},
+		{"  select n.ball, n.draws from stats_balls n order by n.draws desc", ""},
+		{"WITH recent AS (SELECT * FROM results ORDER BY date DESC LIMIT 10) SELECT * FROM recent", ""},
+		{"SELECT r.date FROM results r JOIN results_history h ON h.date = r.date", ""},
+		{"SELECT * FROM api_keys", outside},
+		{`SELECT * FROM results, "api_keys" AS k`, outside},
+		{"SELECT * FROM results WHERE date IN (SELECT date FROM audit_log)", outside},
+		{"SELECT * FROM sqlite_master", outside},
+		{"SELECT * FROM results; DELETE FROM results", "only a single statement is allowed"},
+		{"DELETE FROM results", "only SELECT statements are allowed"},
+		{"PRAGMA table_info(results)", "only SELECT statements are allowed"},
+		{"WITH d AS (SELECT '2020-01-03') INSERT INTO results (date) SELECT * FROM d", "the query uses OpenWrite, which is not allowed"},
+	}
+	for _, tt := range tests {
+		err := checkAdminQuery(context.Background(), tt.query)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tt.query, err)
+		case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+			t.Errorf("%s: error = %v, want %s", tt.query, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	var results []Result
+	for day := 1; day <= 5; day++ {
+		results = append(results, Result{Date: fmt.Sprintf("2020-01-%02d", day)})
+	}
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantDates  []string
+		wantLink   string
+	}{{
+		query:     "",
+		wantDates: []string{"2020-01-01", "2020-01-02", "2020-01-03", "2020-01-04", "2020-01-05"},
+	}, {
+		query:     "?page=1",
+		wantDates: []string{"2020-01-01", "2020-01-02", "2020-01-03", "2020-01-04", "2020-01-05"},
+		wantLink:  `</results?page=1&per_page=50>; rel="first", </results?page=1&per_page=50>; rel="last"`,
+	}, {
+		query:     "?format=json&page=2&per_page=2",
+		wantDates: []string{"2020-01-03", "2020-01-04"},
+		wantLink: `</results?format=json&page=1&per_page=2>; rel="first", </results?format=json&page=1&per_page=2>; rel="prev", ` +
+			`</results?format=json&page=3&per_page=2>; rel="next", </results?format=json&page=3&per_page=2>; rel="last"`,
+	}, {
+		query:     "?page=3&per_page=2",
+		wantDates: []string{"2020-01-05"},
+		wantLink:  `</results?page=1&per_page=2>; rel="first", </results?page=2&per_page=2>; rel="prev", </results?page=3&per_page=2>; rel="last"`,
+	}, {
+		query:    "?page=9&per_page=2",
+		wantLink: `</results?page=1&per_page=2>; rel="first", </results?page=3&per_page=2>; rel="prev", </results?page=3&per_page=2>; rel="last"`,
+	}, {
+		query:      "?page=0",
+		wantStatus: http.StatusBadRequest,
+	}, {
+		query:      "?per_page=1001",
+		wantStatus: http.StatusBadRequest,
+	}}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		page, ok := paginate(w, httptest.NewRequest(http.MethodGet, "/results"+tt.query, nil), results)
+		if tt.wantStatus != 0 {
+			if ok || w.Code != tt.wantStatus {
+				t.Errorf("%s: status %d, want %d", tt.query, w.Code, tt.wantStatus)
+			}
+			continue
+		}
+		var dates []string
+		for _, res := range page {
+			dates = append(dates, res.Date)
+		}
+		if !ok || !slices.Equal(dates, tt.wantDates) {
+			t.Errorf("%s: page %v, want %v", tt.query, dates, tt.wantDates)
+		}
+		if got := w.Header().Get("X-Total-Count"); got != "5" {
+			t.Errorf("%s: X-Total-Count %s, want 5", tt.query, got)
+		}
+		if got := w.Header().Get("Link"); got != tt.wantLink {
+			t.Errorf("%s: Link %s, want %s", tt.query, got, tt.wantLink)
+		}
+	}
+}
+
+func TestWriteCBOR(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{"small integer", 10, "0a"},
+		{"negative integer", -500, "3901f3"},
+		{"two-byte integer", 500, "1901f4"},
+		{"float", 1.5, "fb3ff8000000000000"},
+		{"string", "é", "62c3a9"},
+		{"null and booleans", []any{nil, true, false}, "83f6f5f4"},
+		{"sorted keys", map[string]any{"stars": []int{1, 12}, "date": "2020"}, "a2" + "6464617465" + "6432303230" + "657374617273" + "82010c"},
+		{"struct as its JSON", struct {
+			Date    string `json:"date"`
+			Special bool   `json:"special,omitempty"`
+		}{Date: "2020"}, "a1" + "6464617465" + "6432303230"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeCBOR(&buf, tt.value); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := hex.EncodeToString(buf.Bytes()); got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProtoWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(p protoWriter)
+		want  string
+	}{
+		{"varint", func(p protoWriter) { p.uint(1, 150) }, "089601"},
+		{"default varint", func(p protoWriter) { p.uint(1, 0) }, ""},
+		{"true", func(p protoWriter) { p.bool(5, true) }, "2801"},
+		{"false", func(p protoWriter) { p.bool(5, false) }, ""},
+		{"string", func(p protoWriter) { p.str(1, "2020") }, "0a0432303230"},
+		{"empty string", func(p protoWriter) { p.str(1, "") }, ""},
+		{"packed", func(p protoWriter) { p.packed(3, []int{1, 300}) }, "1a0301ac02"},
+		{"empty packed", func(p protoWriter) { p.packed(3, nil) }, ""},
+		{"high field", func(p protoWriter) { p.uint(16, 1) }, "800101"},
+		{"draw list", func(p protoWriter) {
+			writeResultsProto(p.Buffer, []Result{{Date: "2020", Numbers: []int{1, 2}, Stars: []int{3}, Special: true}})
+		}, "0801" + "12" + "0f" + "0a0432303230" + "1a020102" + "220103" + "2801"},
+	}
+	for _, tt := range tests {
+		p := protoWriter{new(bytes.Buffer)}
+		tt.write(p)
+		if got := hex.EncodeToString(p.Bytes()); got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	g := defaultGame
+	tests := []struct {
+		name    string
+		results []Result
+		wantErr bool
+	}{
+		{"draws", []Result{
+			{Date: "2020-01-03", Numbers: []int{1, 2, 3, 4, 5}, Stars: []int{1, 2}},
+			{Date: "2020-01-07", Numbers: []int{6, 7, 8, 9, 10}, Stars: []int{3, 4}, Special: true},
+		}, false},
+		{"no draws", nil, false},
+		{"missing star", []Result{{Date: "2020-01-03", Numbers: []int{1, 2, 3, 4, 5}, Stars: []int{1}}}, true},
+		{"invalid date", []Result{{Date: "03/01/2020", Numbers: []int{1, 2, 3, 4, 5}, Stars: []int{1, 2}}}, true},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := writeParquet(&buf, g, tt.results)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		data := buf.Bytes()
+		if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+			t.Errorf("%s: not framed by PAR1", tt.name)
+			continue
+		}
+		// The footer is the metadata followed by its length.
+		footer := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+		if footer <= 0 || footer > len(data)-12 {
+			t.Errorf("%s: footer length %d in a %d-byte file", tt.name, footer, len(data))
+		}
+		metadata := data[len(data)-8-footer : len(data)-8]
+		for _, column := range []string{"date", "number_5", "star_2", "special"} {
+			if !bytes.Contains(metadata, []byte(column)) {
+				t.Errorf("%s: no column %s in the metadata", tt.name, column)
+			}
+		}
+		// The values of each column are stored as is, after the page header.
+		var dates, numbers []byte
+		for _, res := range tt.results {
+			day, _ := time.Parse("2006-01-02", res.Date)
+			dates = binary.LittleEndian.AppendUint32(dates, uint32(day.Unix()/86400))
+			numbers = binary.LittleEndian.AppendUint32(numbers, uint32(res.Numbers[4]))
+		}
+		if !bytes.Contains(data, dates) || !bytes.Contains(data, numbers) {
+			t.Errorf("%s: the dates and the fifth numbers are not stored as INT32 values", tt.name)
+		}
+	}
+}
+
+func TestWheelLines(t *testing.T) {
+	tests := []struct {
+		pool      []int
+		k, t      int
+		wantLines int
+	}{
+		{[]int{3, 14, 15}, 5, 2, 1},
+		{[]int{1, 2, 3, 4}, 2, 2, 6},
+		{[]int{1, 2, 3, 4, 5, 6}, 5, 2, 3},
+		{[]int{1, 2, 3, 4, 5, 6, 7}, 3, 2, 7},
+		{[]int{2, 4, 8, 16, 23, 32, 42, 45}, 5, 3, 9},
+	}
+	for _, tt := range tests {
+		lines := wheelLines(tt.pool, tt.k, tt.t)
+		if len(lines) != tt.wantLines {
+			t.Errorf("wheelLines(%v, %d, %d): %d lines, want %d", tt.pool, tt.k, tt.t, len(lines), tt.wantLines)
+		}
+		for _, line := range lines {
+			if len(line) != min(tt.k, len(tt.pool)) || !slices.IsSorted(line) {
+				t.Errorf("wheelLines(%v, %d, %d): line %v", tt.pool, tt.k, tt.t, line)
+			}
+		}
+		// Every t-combination of the pool is in a line.
+		combinations(len(tt.pool), min(tt.t, len(tt.pool)), func(idx []int) {
+			for _, line := range lines {
+				if !slices.ContainsFunc(idx, func(i int) bool { return !slices.Contains(line, tt.pool[i]) }) {
+					return
+				}
+			}
+			t.Errorf("wheelLines(%v, %d, %d): no line holds the balls at %v", tt.pool, tt.k, tt.t, idx)
+		})
+	}
+}
+
+// TestMain silences the log of the migrations.
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLock takes an exclusive lock on f without waiting, and fails while
+// another process holds it. The lock goes away with the process, even when
+// it crashes, so a leftover PID cannot block a start.
+func tryLock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// terminate asks the process with the PID to stop with SIGTERM, so that a
+// server drains its requests and an updater rolls back its transactions.
+func terminate(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
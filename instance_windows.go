@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+// tryLock takes an exclusive lock on f without waiting, and fails while
+// another process holds it. The lock goes away with the process. Windows
+// locks are mandatory, so the locked byte lies far past the PID written in
+// the file, which stays readable.
+func tryLock(f *os.File) error {
+	const flags = 0x2 | 0x1 // LOCKFILE_EXCLUSIVE_LOCK | LOCKFILE_FAIL_IMMEDIATELY
+	overlapped := syscall.Overlapped{OffsetHigh: 1}
+	ok, _, err := procLockFileEx.Call(f.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
+
+// terminate stops the process with the PID. Windows has no SIGTERM, so it
+// is killed without draining its requests or rolling back its transactions
+// (SQLite rolls them back on the next open).
+func terminate(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}